@@ -22,11 +22,35 @@ var (
 
 	// ErrNoPointer is thrown when you call a method that expects a pointer, e.g. Unmarshal
 	ErrNoPointer = errors.New("expected a pointer")
+
+	// ErrMaxSizeExceeded is returned by MarshalBinaryMaxSize when the encoding is too large.
+	ErrMaxSizeExceeded = errors.New("encoding exceeds max size")
+
+	// ErrChecksumMismatch is returned by UnmarshalBinaryChecksummed when the checksum doesn't match.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrNonCanonicalVarint is returned by binary decoding when a varint isn't
+	// minimally encoded, see SetRejectNonCanonicalVarint.
+	ErrNonCanonicalVarint = errors.New("non-canonical varint")
 )
 
+// ErrUnregisteredConcrete is returned when encoding an interface value whose
+// concrete type isn't registered (and isn't encoded as an Error, see
+// RegisterStdError).
+type ErrUnregisteredConcrete struct {
+	Type reflect.Type
+}
+
+func (e ErrUnregisteredConcrete) Error() string {
+	return fmt.Sprintf("cannot encode unregistered concrete type %v", e.Type)
+}
+
 const (
 	unixEpochStr = "1970-01-01 00:00:00 +0000 UTC"
 	epochFmt     = "2006-01-02 15:04:05 +0000 UTC"
+
+	// The JSON format of `amino:"dateonly"` fields.
+	dateOnlyLayout = "2006-01-02"
 )
 
 func init() {
@@ -166,22 +190,366 @@ func (cdc *Codec) MarshalBinaryLengthPrefixed(o interface{}) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// The minimum length of the byte slice fields which
+// MarshalBinaryLengthPrefixedWriter writes directly from the object.
+const directWriteMinSize = 1024
+
 // MarshalBinaryLengthPrefixedWriter writes the bytes as would be returned from
-// MarshalBinaryLengthPrefixed to the writer w.
+// MarshalBinaryLengthPrefixed to the writer w, and returns the number of
+// bytes written.  The length prefix is computed with SizeBinary, and the
+// body of struct o is then written field by field: large byte slice fields
+// are written directly from o, and nested structs (and lists of them) are
+// written the same way after their own length, so the body is never copied
+// into a single buffer.  Other fields are buffered one at a time.
 func (cdc *Codec) MarshalBinaryLengthPrefixedWriter(w io.Writer, o interface{}) (n int64, err error) {
-	var (
-		bz []byte
-		_n int
-	)
-	bz, err = cdc.MarshalBinaryLengthPrefixed(o)
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	var rv, _, isNilPtr = derefPointers(reflect.ValueOf(o))
+	if isNilPtr {
+		return 0, errors.New("cannot marshal a nil pointer")
+	}
+	info, err := cdc.getTypeInfoWlock(rv.Type())
 	if err != nil {
 		return 0, err
 	}
-	_n, err = w.Write(bz) // TODO: handle overflow in 32-bit systems.
-	n = int64(_n)
+	if !cdc.isStreamableStruct(info) {
+		var bz []byte
+		if bz, err = cdc.MarshalBinaryLengthPrefixed(o); err != nil {
+			return 0, err
+		}
+		var _n int
+		_n, err = w.Write(bz)
+		return int64(_n), err
+	}
+	size, err := cdc.SizeBinary(o)
+	if err != nil {
+		return 0, err
+	}
+
+	var cw = &countWriter{w: w}
+	var prefix [binary.MaxVarintLen64]byte
+	if _, err = cw.Write(prefix[:binary.PutUvarint(prefix[:], uint64(size))]); err != nil {
+		return cw.n, err
+	}
+	var start = cw.n
+	var buf = getBuffer()
+	defer putBuffer(buf)
+	if info.Registered {
+		buf.Write(info.Prefix.Bytes())
+	}
+	if cdc.indexedAny {
+		if err = cdc.writeIndexedAnyTag(buf); err != nil {
+			return cw.n, err
+		}
+	}
+	if err = cdc.streamReflectBinaryStruct(cw, buf, info, rv); err != nil {
+		return cw.n, err
+	}
+	if buf.Len() > 0 {
+		if _, err = cw.Write(buf.Bytes()); err != nil {
+			return cw.n, err
+		}
+	}
+	if cw.n-start != int64(size) {
+		panic(internalError(fmt.Sprintf("wrote %v bytes after a length prefix of %v", cw.n-start, size)))
+	}
+	return cw.n, nil
+}
+
+// MarshalBinaryChunked writes the same bytes as MarshalBinaryBare to w,
+// but streams the byte slice fields of struct o which are longer than
+// chunkSize directly from o, in writes of at most chunkSize bytes, rather
+// than copying them into an intermediate buffer.  Byte slices within nested
+// structs or lists are buffered as usual, since their enclosing length
+// prefix must be known first.  Values other than structs are encoded with
+// MarshalBinaryBare and written at once.
+func (cdc *Codec) MarshalBinaryChunked(o interface{}, w io.Writer, chunkSize int) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	if chunkSize <= 0 {
+		return errors.New("MarshalBinaryChunked expects a positive chunk size")
+	}
+	segments, err := cdc.binaryBareSegments(o, chunkSize+1)
+	if err != nil {
+		return err
+	}
+	for i, bz := range segments {
+		if i%2 == 0 {
+			// Buffered, see binaryBareSegments().
+			if _, err = w.Write(bz); err != nil {
+				return err
+			}
+			continue
+		}
+		for len(bz) > 0 {
+			var n = chunkSize
+			if n > len(bz) {
+				n = len(bz)
+			}
+			if _, err = w.Write(bz[:n]); err != nil {
+				return err
+			}
+			bz = bz[n:]
+		}
+	}
+	return nil
+}
+
+// Returns the bytes of MarshalBinaryBare(o) in segments, which alternate
+// between buffered bytes and, for byte slice fields of struct o of at least
+// minDirect bytes, the field values of o themselves.
+func (cdc *Codec) binaryBareSegments(o interface{}, minDirect int) (segments [][]byte, err error) {
+	var rv, _, isNilPtr = derefPointers(reflect.ValueOf(o))
+	if isNilPtr {
+		return nil, errors.New("cannot marshal a nil pointer")
+	}
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType || info.IsAminoMarshaler || info.Raw ||
+		info.IsBinaryMarshaler {
+		var bz []byte
+		bz, err = cdc.MarshalBinaryBare(o)
+		return [][]byte{bz}, err
+	}
+
+	// Buffer all but the large byte slices, like encodeReflectBinaryStruct().
+	var buf = new(bytes.Buffer)
+	if info.Registered {
+		buf.Write(info.Prefix.Bytes())
+	}
+	if cdc.indexedAny {
+		if err = cdc.writeIndexedAnyTag(buf); err != nil {
+			return nil, err
+		}
+	}
+	var fopts = FieldOptions{BinFieldNum: 1}
+	var eopts = encodeOptions{}
+	for _, field := range info.Fields {
+		var chunkable bool
+		if chunkable, err = cdc.isChunkableField(field, eopts); err != nil {
+			return nil, err
+		}
+		var frv = field.valueOf(rv)
+		if !chunkable || frv.Len() < minDirect {
+			err = cdc.encodeReflectBinaryStructField(buf, field, rv, fopts, eopts)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Buffer the field key and length, then refer to the bytes.
+		err = encodeFieldNumberAndTyp3(buf, field.BinFieldNum, Typ3ByteLength)
+		if err != nil {
+			return nil, err
+		}
+		err = EncodeUvarint(buf, uint64(frv.Len()))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, buf.Bytes(), frv.Bytes())
+		buf = new(bytes.Buffer)
+	}
+	for _, vfield := range info.VirtualFields {
+		err = cdc.encodeReflectBinaryVirtualField(buf, vfield, rv, eopts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(segments, buf.Bytes()), nil
+}
+
+// Returns true if the struct is written field by field by
+// MarshalBinaryLengthPrefixedWriter, i.e. if it has no custom encoding.
+func (cdc *Codec) isStreamableStruct(info *TypeInfo) bool {
+	return info.Type.Kind() == reflect.Struct && info.Type != timeType && !info.IsAminoMarshaler && !info.Raw &&
+		!info.IsBinaryMarshaler && !info.Immutable
+}
+
+// Writes the fields of the struct rv to w like encodeReflectBinaryStruct(),
+// without its length.  Large byte slices are written directly from rv, and
+// nested structs (and unpacked lists of them) are written recursively after
+// their length, as computed by sizeReflectBinary().  Other fields are
+// appended to buf, which is written to w before anything is written
+// directly, or once it's grown large, so the caller must write what's
+// left in buf at the end.
+func (cdc *Codec) streamReflectBinaryStruct(w io.Writer, buf *bytes.Buffer, info *TypeInfo,
+	rv reflect.Value) (err error) {
+	var fopts = FieldOptions{BinFieldNum: 1}
+	var eopts = encodeOptions{}
+	var flush = func() (err error) {
+		_, err = w.Write(buf.Bytes())
+		buf.Reset()
+		return
+	}
+	for _, field := range info.Fields {
+		var chunkable bool
+		if chunkable, err = cdc.isChunkableField(field, eopts); err != nil {
+			return
+		}
+		if chunkable && field.valueOf(rv).Len() >= directWriteMinSize {
+			// Buffer the field key and length, then write the bytes.
+			var frv = field.valueOf(rv)
+			if err = encodeFieldNumberAndTyp3(buf, field.BinFieldNum, Typ3ByteLength); err != nil {
+				return
+			}
+			if err = EncodeUvarint(buf, uint64(frv.Len())); err != nil {
+				return
+			}
+			if err = flush(); err != nil {
+				return
+			}
+			if _, err = w.Write(frv.Bytes()); err != nil {
+				return
+			}
+			continue
+		}
+		var streamed bool
+		if streamed, err = cdc.streamReflectBinaryStructField(w, buf, field, rv); err != nil {
+			return
+		} else if streamed {
+			continue
+		}
+		if err = cdc.encodeReflectBinaryStructField(buf, field, rv, fopts, eopts); err != nil {
+			return
+		}
+		if buf.Len() >= directWriteMinSize {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+	for _, vfield := range info.VirtualFields {
+		if err = cdc.encodeReflectBinaryVirtualField(buf, vfield, rv, eopts); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// Writes the field of the struct rv with streamReflectBinaryStruct() if it
+// is a plain struct, or an unpacked list of them, and returns false for
+// any other field, which must be encoded as usual.
+func (cdc *Codec) streamReflectBinaryStructField(w io.Writer, buf *bytes.Buffer, field FieldInfo,
+	rv reflect.Value) (streamed bool, err error) {
+	if field.FieldCodec != nil || field.WrapperType != nil || field.union != nil || field.DynamicResolver != nil {
+		return false, nil
+	}
+	var frv = field.valueOf(rv)
+	var dfrv, isDefault = isDefaultValue(frv)
+	if isDefault {
+		return false, nil
+	}
+	var finfo *TypeInfo
+	if finfo, err = cdc.getTypeInfoWlock(dfrv.Type()); err != nil {
+		return
+	}
+
+	if !field.UnpackedList {
+		if !cdc.isStreamableStruct(finfo) {
+			return false, nil
+		}
+		var size int
+		if size, err = cdc.sizeReflectBinary(finfo, dfrv, field.FieldOptions, true); err != nil {
+			return
+		}
+		var writeEmpty = field.WriteEmpty || cdc.alwaysWriteEmpty || frv.Kind() == reflect.Ptr
+		if size == 0 && !writeEmpty {
+			// Omitted, see writeFieldIfNotEmpty().
+			return true, nil
+		}
+		if err = encodeFieldNumberAndTyp3(buf, field.BinFieldNum, Typ3ByteLength); err != nil {
+			return
+		}
+		if err = EncodeUvarint(buf, uint64(size)); err != nil {
+			return
+		}
+		return true, cdc.streamReflectBinaryStruct(w, buf, finfo, dfrv)
+	}
+
+	// Write repeated field entries for each struct, see
+	// encodeReflectBinaryList().
+	if dfrv.Kind() == reflect.Map {
+		return false, nil
+	}
+	var ert = finfo.Type.Elem()
+	var einfo *TypeInfo
+	if einfo, err = cdc.getTypeInfoWlock(ert); err != nil {
+		return
+	}
+	if !cdc.isStreamableStruct(einfo) {
+		return false, nil
+	}
+	var efopts = field.FieldOptions
+	efopts.BinFieldNum = 1
+	for i := 0; i < dfrv.Len(); i++ {
+		if err = encodeFieldNumberAndTyp3(buf, field.BinFieldNum, Typ3ByteLength); err != nil {
+			return
+		}
+		var erv, isDefault = isDefaultValue(dfrv.Index(i))
+		if isDefault {
+			if ert.Kind() == reflect.Ptr && field.EmptyElements {
+				return true, errors.New("nil struct pointers not supported when empty_elements field tag is set")
+			}
+			buf.WriteByte(0x00)
+			continue
+		}
+		var size int
+		if size, err = cdc.sizeReflectBinary(einfo, erv, efopts, true); err != nil {
+			return
+		}
+		if err = EncodeUvarint(buf, uint64(size)); err != nil {
+			return
+		}
+		if err = cdc.streamReflectBinaryStruct(w, buf, einfo, erv); err != nil {
+			return
+		}
+	}
+	return true, nil
+}
+
+// An io.Writer which counts the bytes written to w, or only counts them if w
+// is nil.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (n int, err error) {
+	if cw.w == nil {
+		cw.n += int64(len(p))
+		return len(p), nil
+	}
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
 	return
 }
 
+// Returns true if the field is a plain []byte, which is encoded as its bytes
+// after the field key and length, see MarshalBinaryChunked().  Fields with
+// any other encoding, e.g. `amino:"gzip"` fields or types with their own
+// marshalers, are always buffered.
+func (cdc *Codec) isChunkableField(field FieldInfo, eopts encodeOptions) (bool, error) {
+	if field.Type.Kind() != reflect.Slice || field.Type.Elem() != bytesType.Elem() {
+		return false, nil
+	}
+	if field.FieldCodec != nil || field.WrapperType != nil || field.union != nil || field.DynamicResolver != nil ||
+		field.Gzip || field.NilAsEmpty || field.UnpackedList || eopts.omits(field) {
+		return false, nil
+	}
+	finfo, err := cdc.getTypeInfoWlock(field.Type)
+	if err != nil {
+		return false, err
+	}
+	if finfo.IsAminoMarshaler || finfo.IsBinaryMarshaler || finfo.Raw || finfo.IntEncoder != nil || finfo.Immutable {
+		return false, nil
+	}
+	return true, nil
+}
+
 // Panics if error.
 func (cdc *Codec) MustMarshalBinaryLengthPrefixed(o interface{}) []byte {
 	bz, err := cdc.MarshalBinaryLengthPrefixed(o)
@@ -194,8 +562,93 @@ func (cdc *Codec) MustMarshalBinaryLengthPrefixed(o interface{}) []byte {
 // MarshalBinaryBare encodes the object o according to the Amino spec.
 // MarshalBinaryBare doesn't prefix the byte-length of the encoding,
 // so the caller must handle framing.
-func (cdc *Codec) MarshalBinaryBare(o interface{}) ([]byte, error) {
+func (cdc *Codec) MarshalBinaryBare(o interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	return cdc.marshalBinaryBare(o, encodeOptions{})
+}
 
+// MarshalBinaryMaxSize is like MarshalBinaryBare, but fails with
+// ErrMaxSizeExceeded as soon as the encoding is known to exceed max bytes,
+// without first encoding the whole value.
+func (cdc *Codec) MarshalBinaryMaxSize(o interface{}, max int) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	if max <= 0 {
+		return nil, errors.New("MarshalBinaryMaxSize expects a positive max size")
+	}
+	bz, err = cdc.marshalBinaryBare(o, encodeOptions{MaxSize: max})
+	if err != nil {
+		return nil, err
+	}
+	if len(bz) > max {
+		return nil, ErrMaxSizeExceeded
+	}
+	return bz, nil
+}
+
+// MarshalBinaryWithOverrides is like MarshalBinaryBare, but the values of
+// the fields of struct o with the given field numbers are replaced by the
+// corresponding overrides.  o itself is not modified.  Each override must be
+// assignable to its field, or be nil for fields which can be nil.
+func (cdc *Codec) MarshalBinaryWithOverrides(o interface{}, overrides map[uint32]interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	var rv, _, isNilPtr = derefPointers(reflect.ValueOf(o))
+	if isNilPtr {
+		return nil, errors.New("MarshalBinaryWithOverrides cannot marshal a nil pointer")
+	}
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType || info.IsAminoMarshaler {
+		return nil, errors.Errorf("MarshalBinaryWithOverrides expects a plain struct, got %v", info.Type)
+	}
+
+	// Override the fields of a (shallow) copy.
+	var crv = reflect.New(info.Type).Elem()
+	crv.Set(rv)
+	for fnum, v := range overrides {
+		field, ok := info.fieldByBinFieldNum(fnum)
+		if !ok {
+			return nil, errors.Errorf("no field # %v in %v", fnum, info.Type)
+		}
+		var frv = field.valueOf(crv)
+		if v == nil {
+			switch field.Type.Kind() {
+			case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+				frv.Set(reflect.Zero(field.Type))
+				continue
+			}
+			return nil, errors.Errorf("cannot override field %v of %v with nil", field.Name, info.Type)
+		}
+		var vrv = reflect.ValueOf(v)
+		if !vrv.Type().AssignableTo(field.Type) {
+			return nil, errors.Errorf("cannot override field %v of %v with a value of type %v, expected %v",
+				field.Name, info.Type, vrv.Type(), field.Type)
+		}
+		frv.Set(vrv)
+	}
+	return cdc.marshalBinaryBare(crv.Interface(), encodeOptions{})
+}
+
+// MarshalBinaryForVersion is like MarshalBinaryBare, but omits the struct
+// fields (at any depth) tagged `amino:"since_version=N"` with N greater than
+// version, i.e. those added after that version, so that one Go type can
+// serve peers of several protocol versions.  Decoding needs no version, as
+// missing fields decode to their default values as usual.
+func (cdc *Codec) MarshalBinaryForVersion(o interface{}, version uint32) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	return cdc.marshalBinaryBare(o, encodeOptions{Version: version, Versioned: true})
+}
+
+func (cdc *Codec) marshalBinaryBare(o interface{}, eopts encodeOptions) (bz []byte, err error) {
 	// Dereference value if pointer.
 	var rv, _, isNilPtr = derefPointers(reflect.ValueOf(o))
 	if isNilPtr {
@@ -205,31 +658,45 @@ func (cdc *Codec) MarshalBinaryBare(o interface{}) ([]byte, error) {
 	}
 
 	// Encode Amino:binary bytes.
-	var bz []byte
-	buf := new(bytes.Buffer)
+	buf := getBuffer()
+	defer putBuffer(buf)
 	rt := rv.Type()
 	info, err := cdc.getTypeInfoWlock(rt)
 	if err != nil {
 		return nil, err
 	}
+	var hash uint64
+	var cached = info.Immutable && !eopts.Versioned
+	if cached {
+		var ok bool
+		if bz, hash, ok = cdc.immutableCache.get(rv); ok {
+			return bz, nil
+		}
+	}
+	if cdc.indexedAny {
+		// Tag the message, see SetIndexedAnyMode().
+		if err = cdc.writeIndexedAnyTag(buf); err != nil {
+			return nil, err
+		}
+	}
 	// in the case of of a repeated struct (e.g. type Alias []SomeStruct),
-	// we do not need to prepend with `(field_number << 3) | wire_type` as this
-	// would need to be done for each struct and not only for the first.
-	if rv.Kind() != reflect.Struct && !isStructOrRepeatedStruct(info) {
+	// or any other unpacked list (e.g. [][]byte), we do not need to prepend
+	// with `(field_number << 3) | wire_type` as this would need to be done
+	// for each element and not only for the first.
+	if rv.Kind() != reflect.Struct && !isStructOrRepeatedStruct(info) && !isUnpackedList(info.Type, FieldOptions{}) {
 		writeEmpty := false
-		typ3 := typeToTyp3(info.Type, FieldOptions{})
+		typ3 := typeToTyp3(info, FieldOptions{})
 		bare := typ3 != Typ3ByteLength
-		if err = cdc.writeFieldIfNotEmpty(buf, 1, info, FieldOptions{}, FieldOptions{}, rv, writeEmpty, bare); err != nil {
+		if err = cdc.writeFieldIfNotEmpty(buf, 1, info, FieldOptions{}, FieldOptions{}, rv, writeEmpty, bare, eopts); err != nil {
 			return nil, err
 		}
-		bz = buf.Bytes()
 	} else {
-		err = cdc.encodeReflectBinary(buf, info, rv, FieldOptions{BinFieldNum: 1}, true)
+		err = cdc.encodeReflectBinary(buf, info, rv, FieldOptions{BinFieldNum: 1}, true, eopts)
 		if err != nil {
 			return nil, err
 		}
-		bz = buf.Bytes()
 	}
+	// Copy the bytes out of buf, which is pooled, so the caller may keep bz.
 	// If registered concrete, prepend prefix bytes.
 	if info.Registered {
 		// TODO: https://github.com/tendermint/go-amino/issues/267
@@ -238,7 +705,12 @@ func (cdc *Codec) MarshalBinaryBare(o interface{}) ([]byte, error) {
 		//	Value: bz,
 		//})
 		pb := info.Prefix.Bytes()
-		bz = append(pb, bz...)
+		bz = append(pb, buf.Bytes()...)
+	} else if buf.Len() > 0 {
+		bz = append([]byte(nil), buf.Bytes()...)
+	}
+	if cached {
+		cdc.immutableCache.put(rv, hash, bz)
 	}
 
 	return bz, nil
@@ -271,6 +743,9 @@ func (cdc *Codec) UnmarshalBinaryLengthPrefixed(bz []byte, ptr interface{}) erro
 	if n < 0 {
 		return errors.Errorf("Error reading msg byte-length prefix: got code %v", n)
 	}
+	if err := cdc.checkCanonical(n, UvarintSize(u64)); err != nil {
+		return err
+	}
 	if u64 > uint64(len(bz)-n) {
 		return errors.Errorf("Not enough bytes to read in UnmarshalBinaryLengthPrefixed, want %v more bytes but only have %v",
 			u64, len(bz)-n)
@@ -286,34 +761,50 @@ func (cdc *Codec) UnmarshalBinaryLengthPrefixed(bz []byte, ptr interface{}) erro
 
 // Like UnmarshalBinaryBare, but will first read the byte-length prefix.
 // UnmarshalBinaryLengthPrefixedReader will panic if ptr is a nil-pointer.
-// If maxSize is 0, there is no limit (not recommended).
+// If maxSize is 0, there is no limit (not recommended).  Exactly the prefix
+// and the message are read from r, so consecutive messages written by
+// MarshalBinaryLengthPrefixedWriter can be read back in sequence.  Returns
+// the number of bytes read.
 func (cdc *Codec) UnmarshalBinaryLengthPrefixedReader(r io.Reader, ptr interface{},
 	maxSize int64) (n int64, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
 	if maxSize < 0 {
 		panic("maxSize cannot be negative.")
 	}
 
-	// Read byte-length prefix.
+	// Read byte-length prefix, one byte at a time so that r is left
+	// positioned right after the message.
 	var l int64
 	var buf [binary.MaxVarintLen64]byte
-	for i := 0; i < len(buf); i++ {
-		_, err = r.Read(buf[i : i+1])
-		if err != nil {
+	for i := 0; ; i++ {
+		if i == len(buf) {
+			err = errors.New("Error reading msg byte-length prefix: uvarint overflows 64 bits")
 			return
 		}
-		n++
-		if buf[i]&0x80 == 0 {
-			break
-		}
-		if n >= maxSize {
+		if maxSize > 0 && n >= maxSize {
 			err = errors.Errorf(
 				"read overflow, maxSize is %v but uvarint(length-prefix) is itself greater than maxSize",
 				maxSize,
 			)
+			return
+		}
+		_, err = io.ReadFull(r, buf[i:i+1])
+		if err != nil {
+			return
+		}
+		n++
+		if buf[i]&0x80 == 0 {
+			break
 		}
 	}
-	u64, _ := binary.Uvarint(buf[:])
-	if err != nil {
+	u64, m := binary.Uvarint(buf[:n])
+	if m <= 0 {
+		err = errors.Errorf("Error reading msg byte-length prefix: got code %v", m)
+		return
+	}
+	if err = cdc.checkCanonical(m, UvarintSize(u64)); err != nil {
 		return
 	}
 	if maxSize > 0 {
@@ -331,9 +822,10 @@ func (cdc *Codec) UnmarshalBinaryLengthPrefixedReader(r io.Reader, ptr interface
 	}
 	l = int64(u64)
 	if l < 0 {
-		_ = errors.Errorf( //nolint:errcheck
+		err = errors.Errorf(
 			"read overflow, this implementation can't read this because, why would anyone have this much data? Hello from 2018",
 		)
+		return
 	}
 
 	// Read that many bytes.
@@ -358,8 +850,96 @@ func (cdc *Codec) MustUnmarshalBinaryLengthPrefixed(bz []byte, ptr interface{})
 }
 
 // UnmarshalBinaryBare will panic if ptr is a nil-pointer.
-func (cdc *Codec) UnmarshalBinaryBare(bz []byte, ptr interface{}) error {
+func (cdc *Codec) UnmarshalBinaryBare(bz []byte, ptr interface{}) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	return cdc.unmarshalBinaryBare(bz, ptr, decodeOptions{})
+}
+
+// UnmarshalBinaryAllowing is like UnmarshalBinaryBare, but fails if any
+// interface (or dynamic field) in bz, at any depth, decodes to a concrete
+// type not in allowed.  Use it to restrict which registered types
+// untrusted input may instantiate.  Pointer types in allowed also allow the
+// type they point to, and vice versa.
+func (cdc *Codec) UnmarshalBinaryAllowing(bz []byte, ptr interface{}, allowed []reflect.Type) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	var dopts = decodeOptions{Allowed: make(map[reflect.Type]struct{}, len(allowed))}
+	for _, rt := range allowed {
+		for rt.Kind() == reflect.Ptr {
+			rt = rt.Elem()
+		}
+		dopts.Allowed[rt] = struct{}{}
+	}
+	return cdc.unmarshalBinaryBare(bz, ptr, dopts)
+}
+
+// UnmarshalBinaryBareSkipping is like UnmarshalBinaryBare, but leaves
+// interface values of unregistered concrete types nil instead of failing,
+// and returns them, in the order they were skipped, see
+// SetSkipUnknownInterfaceValues.
+func (cdc *Codec) UnmarshalBinaryBareSkipping(bz []byte, ptr interface{}) (
+	skipped []UnknownInterfaceValue, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	err = cdc.unmarshalBinaryBare(bz, ptr, decodeOptions{Skipped: &skipped})
+	return skipped, err
+}
+
+// UnmarshalBinaryBareWithTypeURL decodes bz into a new value of the
+// registered concrete type named by typeURL, and returns it, as a pointer if
+// the type was registered as such.  typeURL is the registered name, which
+// may have a leading "/" as in a protobuf Any, and bz is the encoding of the
+// concrete value without its prefix bytes.
+func (cdc *Codec) UnmarshalBinaryBareWithTypeURL(typeURL string, bz []byte) (o interface{}, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	cinfo, err := cdc.getTypeInfoFromNameRlock(typeURLToName(typeURL))
+	if err != nil {
+		return nil, errors.Errorf("unregistered TypeURL %q", typeURL)
+	}
+	crv, irvSet := cdc.constructConcreteType(cinfo)
+	err = cdc.unmarshalBinaryBareContents(bz, cinfo, crv, decodeOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return irvSet.Interface(), nil
+}
+
+// MarshalBinaryChecksummed is like MarshalBinaryBare, but appends a
+// checksum of the encoding, see SetChecksumHash.  Use
+// UnmarshalBinaryChecksummed to verify and decode it.
+func (cdc *Codec) MarshalBinaryChecksummed(o interface{}) ([]byte, error) {
+	bz, err := cdc.MarshalBinaryBare(o)
+	if err != nil {
+		return nil, err
+	}
+	h := cdc.checksumHash()
+	h.Write(bz) // nolint: errcheck
+	return h.Sum(bz), nil
+}
+
+// UnmarshalBinaryChecksummed verifies the checksum appended by
+// MarshalBinaryChecksummed, and then decodes the rest of bz like
+// UnmarshalBinaryBare.  Returns ErrChecksumMismatch if bz was corrupted.
+func (cdc *Codec) UnmarshalBinaryChecksummed(bz []byte, ptr interface{}) error {
+	h := cdc.checksumHash()
+	if len(bz) < h.Size() {
+		return ErrChecksumMismatch
+	}
+	body, sum := bz[:len(bz)-h.Size()], bz[len(bz)-h.Size():]
+	h.Write(body) // nolint: errcheck
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return ErrChecksumMismatch
+	}
+	return cdc.UnmarshalBinaryBare(body, ptr)
+}
 
+func (cdc *Codec) unmarshalBinaryBare(bz []byte, ptr interface{}, dopts decodeOptions) (err error) {
 	rv := reflect.ValueOf(ptr)
 	if rv.Kind() != reflect.Ptr {
 		return ErrNoPointer
@@ -388,13 +968,30 @@ func (cdc *Codec) UnmarshalBinaryBare(bz []byte, ptr interface{}) error {
 		}
 		bz = bz[4:]
 	}
+	return cdc.unmarshalBinaryBareContents(bz, info, rv, dopts)
+}
+
+// Decodes bz, as written by marshalBinaryBare() without any prefix bytes,
+// into rv.
+func (cdc *Codec) unmarshalBinaryBareContents(bz []byte, info *TypeInfo, rv reflect.Value,
+	dopts decodeOptions) (err error) {
+	rt := rv.Type()
 	// Only add length prefix if we have another typ3 then Typ3ByteLength.
 	// Default is non-length prefixed:
 	bare := true
 	var nWrap int
+	if cdc.indexedAny {
+		// Check the message tag, see SetIndexedAnyMode().
+		var _n int
+		if _n, err = cdc.readIndexedAnyTag(bz); err != nil {
+			return err
+		}
+		slide(&bz, &nWrap, _n)
+	}
 	isKnownType := (info.Type.Kind() != reflect.Map) && (info.Type.Kind() != reflect.Func)
 	if !isStructOrRepeatedStruct(info) &&
 		!isPointerToStructOrToRepeatedStruct(rv, rt) &&
+		!isUnpackedList(info.Type, FieldOptions{}) &&
 		len(bz) > 0 &&
 		(rv.Kind() != reflect.Interface) &&
 		isKnownType {
@@ -403,25 +1000,25 @@ func (cdc *Codec) UnmarshalBinaryBare(bz []byte, ptr interface{}) error {
 			typ       Typ3
 			nFnumTyp3 int
 		)
-		fnum, typ, nFnumTyp3, err = decodeFieldNumberAndTyp3(bz)
+		fnum, typ, nFnumTyp3, err = cdc.decodeFieldNumberAndTyp3(bz)
 		if err != nil {
 			return errors.Wrap(err, "could not decode field number and type")
 		}
 		if fnum != 1 {
 			return fmt.Errorf("expected field number: 1; got: %v", fnum)
 		}
-		typWanted := typeToTyp3(info.Type, FieldOptions{})
+		typWanted := typeToTyp3(info, FieldOptions{})
 		if typ != typWanted {
 			return fmt.Errorf("expected field type %v for # %v of %v, got %v",
 				typWanted, fnum, info.Type, typ)
 		}
 
 		slide(&bz, &nWrap, nFnumTyp3)
-		bare = typeToTyp3(info.Type, FieldOptions{}) != Typ3ByteLength
+		bare = typeToTyp3(info, FieldOptions{}) != Typ3ByteLength
 	}
 
 	// Decode contents into rv.
-	n, err := cdc.decodeReflectBinary(bz, info, rv, FieldOptions{BinFieldNum: 1}, bare)
+	n, err := cdc.decodeReflectBinary(bz, info, rv, FieldOptions{BinFieldNum: 1}, bare, dopts)
 	if err != nil {
 		return fmt.Errorf(
 			"unmarshal to %v failed after %d bytes (%v): %X",
@@ -492,7 +1089,43 @@ func (cdc *Codec) MustUnmarshalBinaryBare(bz []byte, ptr interface{}) {
 	}
 }
 
-func (cdc *Codec) MarshalJSON(o interface{}) ([]byte, error) {
+// TryUnmarshal decodes bz with UnmarshalBinaryBare into each of the
+// candidate types in order, and returns the first type and decoded value for
+// which decoding succeeds.  To avoid false positives, the decoded value must
+// also encode back to exactly bz, so a candidate is rejected if bz had
+// leftover bytes, unknown fields, or was otherwise not a canonical encoding.
+func (cdc *Codec) TryUnmarshal(bz []byte, candidates ...reflect.Type) (rt reflect.Type, o interface{}, err error) {
+	for _, crt := range candidates {
+		if o, ok := cdc.tryUnmarshal(bz, crt); ok {
+			return crt, o, nil
+		}
+	}
+	return nil, nil, errors.New("bytes could not be decoded into any of the candidate types")
+}
+
+func (cdc *Codec) tryUnmarshal(bz []byte, rt reflect.Type) (o interface{}, ok bool) {
+	// A malformed encoding may cause a panic, which only means no match.
+	defer func() {
+		if r := recover(); r != nil {
+			o, ok = nil, false
+		}
+	}()
+	var prv = reflect.New(rt)
+	if err := cdc.UnmarshalBinaryBare(bz, prv.Interface()); err != nil {
+		return nil, false
+	}
+	o = prv.Elem().Interface()
+	bz2, err := cdc.MarshalBinaryBare(o)
+	if err != nil || !bytes.Equal(bz, bz2) {
+		return nil, false
+	}
+	return o, true
+}
+
+func (cdc *Codec) MarshalJSON(o interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
 	rv := reflect.ValueOf(o)
 	if rv.Kind() == reflect.Invalid {
 		return []byte("null"), nil
@@ -506,7 +1139,7 @@ func (cdc *Codec) MarshalJSON(o interface{}) ([]byte, error) {
 
 	// Write the disfix wrapper if it is a registered concrete type.
 	if info.Registered {
-		err = writeStr(w, _fmt(`{"type":"%s","value":`, info.Name))
+		err = cdc.writeAnyPrefixJSON(w, info.Name)
 		if err != nil {
 			return nil, err
 		}
@@ -536,7 +1169,42 @@ func (cdc *Codec) MustMarshalJSON(o interface{}) []byte {
 	return bz
 }
 
-func (cdc *Codec) UnmarshalJSON(bz []byte, ptr interface{}) error {
+func (cdc *Codec) UnmarshalJSON(bz []byte, ptr interface{}) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	return cdc.unmarshalJSON(bz, ptr, decodeOptions{})
+}
+
+// UnmarshalJSONWithRenames is like UnmarshalJSON, but first renames the keys
+// of JSON objects decoded into structs (at any depth) from the keys of
+// renames to its values, to decode JSON written with legacy field names.
+// Keys of maps are never renamed.  Keys which then match no field are ignored
+// as usual, and it is an error for an object to have both a legacy key and
+// the key it is renamed to, or for a key to be renamed to one which is itself
+// renamed.
+func (cdc *Codec) UnmarshalJSONWithRenames(bz []byte, ptr interface{}, renames map[string]string) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	if err = checkJSONRenames(renames); err != nil {
+		return err
+	}
+	return cdc.unmarshalJSON(bz, ptr, decodeOptions{Renames: renames})
+}
+
+// UnmarshalJSONSkipping is like UnmarshalJSON, but leaves interface values
+// of unregistered concrete types nil instead of failing, and returns them,
+// in the order they were skipped, see SetSkipUnknownInterfaceValues.
+func (cdc *Codec) UnmarshalJSONSkipping(bz []byte, ptr interface{}) (skipped []UnknownInterfaceValue, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	err = cdc.unmarshalJSON(bz, ptr, decodeOptions{Skipped: &skipped})
+	return skipped, err
+}
+
+func (cdc *Codec) unmarshalJSON(bz []byte, ptr interface{}, dopts decodeOptions) (err error) {
 	if len(bz) == 0 {
 		return errors.New("cannot decode empty bytes")
 	}
@@ -554,7 +1222,7 @@ func (cdc *Codec) UnmarshalJSON(bz []byte, ptr interface{}) error {
 	// If registered concrete, consume and verify type wrapper.
 	if info.Registered {
 		// Consume type wrapper info.
-		name, data, err := decodeInterfaceJSON(bz)
+		name, data, err := cdc.decodeInterfaceJSON(bz)
 		if err != nil {
 			return err
 		}
@@ -564,7 +1232,7 @@ func (cdc *Codec) UnmarshalJSON(bz []byte, ptr interface{}) error {
 		}
 		bz = data
 	}
-	return cdc.decodeReflectJSON(bz, info, rv, FieldOptions{})
+	return cdc.decodeReflectJSON(bz, info, rv, FieldOptions{}, dopts)
 }
 
 // MustUnmarshalJSON panics if an error occurs. Besides that behaves exactly like UnmarshalJSON.
@@ -575,7 +1243,9 @@ func (cdc *Codec) MustUnmarshalJSON(bz []byte, ptr interface{}) {
 }
 
 // MarshalJSONIndent calls json.Indent on the output of cdc.MarshalJSON
-// using the given prefix and indent string.
+// using the given prefix and indent string.  The output is otherwise the same,
+// so fields keep their order (e.g. the type key comes first in each
+// interface value, see SetAnyTypeKey), and omitempty fields are omitted.
 func (cdc *Codec) MarshalJSONIndent(o interface{}, prefix, indent string) ([]byte, error) {
 	bz, err := cdc.MarshalJSON(o)
 	if err != nil {
@@ -588,3 +1258,68 @@ func (cdc *Codec) MarshalJSONIndent(o interface{}, prefix, indent string) ([]byt
 	}
 	return out.Bytes(), nil
 }
+
+// BinaryToJSON decodes the Amino:binary bytes bz (as written by
+// MarshalBinaryBare) into a new value of type rt, and returns its
+// Amino:JSON encoding.  Interface fields and time are converted as usual,
+// so rt is the only type that needs to be known ahead of time.
+func (cdc *Codec) BinaryToJSON(bz []byte, rt reflect.Type) ([]byte, error) {
+	var prv = reflect.New(rt)
+	if err := cdc.UnmarshalBinaryBare(bz, prv.Interface()); err != nil {
+		return nil, err
+	}
+	return cdc.MarshalJSON(prv.Elem().Interface())
+}
+
+// JSONToBinary is the inverse of BinaryToJSON: it decodes the Amino:JSON
+// bytes jsonBz into a new value of type rt, and returns its Amino:binary
+// encoding as written by MarshalBinaryBare.
+func (cdc *Codec) JSONToBinary(jsonBz []byte, rt reflect.Type) ([]byte, error) {
+	var prv = reflect.New(rt)
+	if err := cdc.UnmarshalJSON(jsonBz, prv.Interface()); err != nil {
+		return nil, err
+	}
+	return cdc.MarshalBinaryBare(prv.Elem().Interface())
+}
+
+// The maximum size of each message read by TranscodeBinaryToJSONL.
+const defaultMaxTranscodeSize = 64 << 20
+
+// TranscodeBinaryToJSONL reads length-prefixed Amino:binary messages (as
+// written by MarshalBinaryLengthPrefixed) from r until EOF, decodes each
+// into the pointer returned by newPtr, and writes its Amino:JSON encoding to
+// w followed by a newline, i.e. as JSON Lines.  Only one message is held in
+// memory at a time.  Each message, including its length prefix, may be at
+// most 64 MiB, so that a corrupt length prefix fails instead of allocating
+// its length, see TranscodeBinaryToJSONLMaxSize for another limit.  Errors
+// are wrapped with the index of the message.
+func (cdc *Codec) TranscodeBinaryToJSONL(r io.Reader, w io.Writer, newPtr func() interface{}) error {
+	return cdc.TranscodeBinaryToJSONLMaxSize(r, w, newPtr, defaultMaxTranscodeSize)
+}
+
+// TranscodeBinaryToJSONLMaxSize is like TranscodeBinaryToJSONL, but each
+// message may be at most maxSize bytes instead.  If maxSize is 0, there is no
+// limit (not recommended), as for UnmarshalBinaryLengthPrefixedReader.
+func (cdc *Codec) TranscodeBinaryToJSONLMaxSize(r io.Reader, w io.Writer, newPtr func() interface{},
+	maxSize int64) error {
+	if maxSize < 0 {
+		panic("maxSize cannot be negative.")
+	}
+	for i := 0; ; i++ {
+		ptr := newPtr()
+		n, err := cdc.UnmarshalBinaryLengthPrefixedReader(r, ptr, maxSize)
+		if err == io.EOF && n == 0 {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "message %v", i)
+		}
+		bz, err := cdc.MarshalJSON(ptr)
+		if err != nil {
+			return errors.Wrapf(err, "message %v", i)
+		}
+		if _, err = w.Write(append(bz, '\n')); err != nil {
+			return errors.Wrapf(err, "message %v", i)
+		}
+	}
+}
@@ -2,10 +2,16 @@ package amino_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"hash"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	amino "github.com/tendermint/go-amino"
 )
 
@@ -154,6 +160,58 @@ func TestUnmarshalBinaryBufferedWritesReads(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestUnmarshalBinaryReaderLongPrefix(t *testing.T) {
+	var cdc = amino.NewCodec()
+	var buf = bytes.NewBuffer(nil)
+
+	// A message over 127 bytes has a multi-byte length prefix.
+	s1 := stringWrapper{strings.Repeat("x", 300)}
+	s2 := stringWrapper{"bar"}
+	_, err := cdc.MarshalBinaryLengthPrefixedWriter(buf, s1)
+	require.Nil(t, err)
+	_, err = cdc.MarshalBinaryLengthPrefixedWriter(buf, s2)
+	require.Nil(t, err)
+	total := buf.Len()
+
+	var s3 stringWrapper
+	n1, err := cdc.UnmarshalBinaryLengthPrefixedReader(buf, &s3, 0)
+	require.Nil(t, err)
+	assert.Equal(t, s1, s3)
+	n2, err := cdc.UnmarshalBinaryLengthPrefixedReader(buf, &s3, 0)
+	require.Nil(t, err)
+	assert.Equal(t, s2, s3)
+	assert.Equal(t, total, int(n1+n2))
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestUnmarshalBinaryReaderBadPrefix(t *testing.T) {
+	var cdc = amino.NewCodec()
+	var s stringWrapper
+
+	// Prefix longer than maxSize.
+	_, err := cdc.UnmarshalBinaryLengthPrefixedReader(bytes.NewReader([]byte{0x80, 0x80, 0x01}), &s, 2)
+	assert.NotNil(t, err)
+
+	// Prefix that overflows 64 bits.
+	_, err = cdc.UnmarshalBinaryLengthPrefixedReader(bytes.NewReader(bytes.Repeat([]byte{0xff}, 11)), &s, 0)
+	assert.NotNil(t, err)
+
+	// Prefix larger than any int64.
+	bz := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}
+	_, err = cdc.UnmarshalBinaryLengthPrefixedReader(bytes.NewReader(bz), &s, 0)
+	assert.NotNil(t, err)
+
+	// Non-canonical prefix.
+	_, err = cdc.UnmarshalBinaryLengthPrefixedReader(bytes.NewReader([]byte{0x85, 0x00, 0x0a, 0x03, 'f', 'o', 'o'}), &s, 0)
+	assert.Equal(t, amino.ErrNonCanonicalVarint, err)
+
+	// Truncated prefix and truncated message.
+	_, err = cdc.UnmarshalBinaryLengthPrefixedReader(bytes.NewReader([]byte{0x80}), &s, 0)
+	assert.NotNil(t, err)
+	_, err = cdc.UnmarshalBinaryLengthPrefixedReader(bytes.NewReader([]byte{0x05, 0x0a, 0x03}), &s, 0)
+	assert.NotNil(t, err)
+}
+
 func TestBoolPointers(t *testing.T) {
 	var cdc = amino.NewCodec()
 	type SimpleStruct struct {
@@ -179,3 +237,763 @@ func TestBoolPointers(t *testing.T) {
 	assert.NotNil(t, s2.BoolPtrTrue)
 	assert.NotNil(t, s2.BoolPtrFalse)
 }
+
+func TestTryUnmarshal(t *testing.T) {
+	type Transfer struct {
+		From   string
+		To     string
+		Amount int64
+	}
+	type Vote struct {
+		Height int64
+		Yes    bool
+	}
+	// Decodes any Transfer leniently, skipping the trailing fields.
+	type TransferFrom struct {
+		From string
+	}
+
+	cdc := amino.NewCodec()
+	candidates := []reflect.Type{
+		reflect.TypeOf(TransferFrom{}),
+		reflect.TypeOf(Vote{}),
+		reflect.TypeOf(Transfer{}),
+	}
+
+	transfer := Transfer{"alice", "bob", 10}
+	bz, err := cdc.MarshalBinaryBare(transfer)
+	assert.NoError(t, err)
+	rt, o, err := cdc.TryUnmarshal(bz, candidates...)
+	assert.NoError(t, err)
+	assert.Equal(t, reflect.TypeOf(Transfer{}), rt)
+	assert.Equal(t, transfer, o)
+
+	vote := Vote{100, true}
+	bz, err = cdc.MarshalBinaryBare(vote)
+	assert.NoError(t, err)
+	rt, o, err = cdc.TryUnmarshal(bz, candidates...)
+	assert.NoError(t, err)
+	assert.Equal(t, reflect.TypeOf(Vote{}), rt)
+	assert.Equal(t, vote, o)
+
+	_, _, err = cdc.TryUnmarshal([]byte{0xFF, 0xFF}, candidates...)
+	assert.Error(t, err)
+}
+
+func TestMarshalBinaryWithOverrides(t *testing.T) {
+	type Header struct {
+		ChainID  string
+		Height   int64
+		Proposer []byte
+	}
+
+	cdc := amino.NewCodec()
+
+	h := Header{"test-chain", 10, []byte{0x01}}
+	bz, err := cdc.MarshalBinaryWithOverrides(h, map[uint32]interface{}{
+		1: "other-chain",
+		2: int64(11),
+	})
+	assert.NoError(t, err)
+	expected, err := cdc.MarshalBinaryBare(Header{"other-chain", 11, []byte{0x01}})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, bz)
+	// The original is untouched.
+	assert.Equal(t, Header{"test-chain", 10, []byte{0x01}}, h)
+
+	// Nil is allowed for slices.
+	bz, err = cdc.MarshalBinaryWithOverrides(&h, map[uint32]interface{}{3: nil})
+	assert.NoError(t, err)
+	expected, err = cdc.MarshalBinaryBare(Header{"test-chain", 10, nil})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, bz)
+
+	_, err = cdc.MarshalBinaryWithOverrides(h, map[uint32]interface{}{2: "eleven"})
+	assert.Error(t, err)
+	_, err = cdc.MarshalBinaryWithOverrides(h, map[uint32]interface{}{2: nil})
+	assert.Error(t, err)
+	_, err = cdc.MarshalBinaryWithOverrides(h, map[uint32]interface{}{4: int64(1)})
+	assert.Error(t, err)
+}
+
+type bridgeAsset interface {
+	Denom() string
+}
+
+type bridgeCoin struct {
+	Name   string
+	Amount int64
+}
+
+func (c bridgeCoin) Denom() string { return c.Name }
+
+func TestBinaryToJSON(t *testing.T) {
+	type Transfer struct {
+		Asset bridgeAsset
+		At    time.Time
+		Memo  string
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*bridgeAsset)(nil), nil)
+	cdc.RegisterConcrete(bridgeCoin{}, "test/coin", nil)
+
+	tr := Transfer{
+		Asset: bridgeCoin{Name: "atom", Amount: 10},
+		At:    time.Date(2019, 5, 1, 12, 0, 0, 500, time.UTC),
+		Memo:  "hi",
+	}
+	bz, err := cdc.MarshalBinaryBare(tr)
+	assert.NoError(t, err)
+	jsonBz, err := cdc.MarshalJSON(tr)
+	assert.NoError(t, err)
+
+	rt := reflect.TypeOf(Transfer{})
+	jsonBz2, err := cdc.BinaryToJSON(bz, rt)
+	assert.NoError(t, err)
+	assert.Equal(t, string(jsonBz), string(jsonBz2))
+	bz2, err := cdc.JSONToBinary(jsonBz, rt)
+	assert.NoError(t, err)
+	assert.Equal(t, bz, bz2)
+
+	_, err = cdc.BinaryToJSON([]byte{0x0A, 0xFF}, rt)
+	assert.Error(t, err)
+	_, err = cdc.JSONToBinary([]byte(`{"Asset":{"type":"test/unknown","value":{}}}`), rt)
+	assert.Error(t, err)
+}
+
+func TestTranscodeBinaryToJSONL(t *testing.T) {
+	type Transfer struct {
+		Asset bridgeAsset
+		Memo  string
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*bridgeAsset)(nil), nil)
+	cdc.RegisterConcrete(bridgeCoin{}, "test/coin", nil)
+
+	trs := []Transfer{
+		{Asset: bridgeCoin{Name: "atom", Amount: 10}, Memo: "hi"},
+		{},
+		{Asset: bridgeCoin{Name: "photon"}, Memo: strings.Repeat("long ", 100)},
+	}
+	var in bytes.Buffer
+	for _, tr := range trs {
+		_, err := cdc.MarshalBinaryLengthPrefixedWriter(&in, tr)
+		require.NoError(t, err)
+	}
+	newPtr := func() interface{} { return new(Transfer) }
+
+	var out bytes.Buffer
+	require.NoError(t, cdc.TranscodeBinaryToJSONL(bytes.NewReader(in.Bytes()), &out, newPtr))
+	lines := strings.Split(out.String(), "\n")
+	require.Equal(t, len(trs)+1, len(lines))
+	assert.Equal(t, "", lines[len(trs)])
+	for i, tr := range trs {
+		var tr2 Transfer
+		require.NoError(t, cdc.UnmarshalJSON([]byte(lines[i]), &tr2), "line %v", i)
+		assert.Equal(t, tr, tr2, "line %v", i)
+	}
+
+	// An empty stream is empty JSONL.
+	out.Reset()
+	require.NoError(t, cdc.TranscodeBinaryToJSONL(bytes.NewReader(nil), &out, newPtr))
+	assert.Equal(t, 0, out.Len())
+
+	// Errors report the index of the message, after writing the ones before.
+	out.Reset()
+	truncated := in.Bytes()[:in.Len()-1]
+	err := cdc.TranscodeBinaryToJSONL(bytes.NewReader(truncated), &out, newPtr)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "message 2")
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+
+	// Messages larger than a given maxSize fail.
+	out.Reset()
+	err = cdc.TranscodeBinaryToJSONLMaxSize(bytes.NewReader(in.Bytes()), &out, newPtr, 100)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "message 2")
+	assert.Contains(t, err.Error(), "read overflow")
+
+	// As do length prefixes larger than the default limit, before
+	// allocating their 4 GB.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err = cdc.TranscodeBinaryToJSONL(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}), &out, newPtr)
+	runtime.ReadMemStats(&after)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "read overflow")
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 1<<20, "allocated %v bytes", after.TotalAlloc-before.TotalAlloc)
+	assert.Panics(t, func() { cdc.TranscodeBinaryToJSONLMaxSize(bytes.NewReader(nil), &out, newPtr, -1) })
+}
+
+type allowMsg interface{}
+
+type allowSend struct {
+	To string
+}
+
+type allowBatch struct {
+	Msgs []allowMsg
+}
+
+type allowAdmin struct {
+	Op string
+}
+
+func TestUnmarshalBinaryAllowing(t *testing.T) {
+	type Tx struct {
+		Msg allowMsg
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*allowMsg)(nil), nil)
+	cdc.RegisterConcrete(allowSend{}, "test/send", nil)
+	cdc.RegisterConcrete(&allowBatch{}, "test/batch", nil)
+	cdc.RegisterConcrete(allowAdmin{}, "test/admin", nil)
+	allowed := []reflect.Type{reflect.TypeOf(allowSend{}), reflect.TypeOf(&allowBatch{})}
+
+	tx := Tx{Msg: &allowBatch{Msgs: []allowMsg{allowSend{To: "bob"}}}}
+	bz, err := cdc.MarshalBinaryBare(tx)
+	assert.NoError(t, err)
+	var tx2 Tx
+	err = cdc.UnmarshalBinaryAllowing(bz, &tx2, allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, tx, tx2)
+
+	// Disallowed types are rejected, even when nested.
+	for _, msg := range []allowMsg{
+		allowAdmin{Op: "upgrade"},
+		&allowBatch{Msgs: []allowMsg{allowSend{To: "bob"}, allowAdmin{Op: "upgrade"}}},
+	} {
+		bz, err := cdc.MarshalBinaryBare(Tx{Msg: msg})
+		assert.NoError(t, err)
+		err = cdc.UnmarshalBinaryAllowing(bz, new(Tx), allowed)
+		assert.Error(t, err)
+		err = cdc.UnmarshalBinaryBare(bz, new(Tx))
+		assert.NoError(t, err)
+	}
+}
+
+func TestMarshalBinaryChecksummed(t *testing.T) {
+	type Record struct {
+		Key   string
+		Value []byte
+	}
+
+	r := Record{Key: "k", Value: []byte("payload")}
+	for _, newHash := range []func() hash.Hash{nil, sha256.New} {
+		cdc := amino.NewCodec()
+		if newHash != nil {
+			cdc.SetChecksumHash(newHash)
+		}
+		bz, err := cdc.MarshalBinaryChecksummed(r)
+		assert.NoError(t, err)
+		bare, err := cdc.MarshalBinaryBare(r)
+		assert.NoError(t, err)
+		assert.Equal(t, bare, bz[:len(bare)])
+
+		var r2 Record
+		err = cdc.UnmarshalBinaryChecksummed(bz, &r2)
+		assert.NoError(t, err)
+		assert.Equal(t, r, r2)
+
+		// Flip each bit in turn, of both the body and the checksum.
+		for i := 0; i < len(bz)*8; i++ {
+			corrupt := append([]byte(nil), bz...)
+			corrupt[i/8] ^= 1 << uint(i%8)
+			err = cdc.UnmarshalBinaryChecksummed(corrupt, new(Record))
+			assert.Equal(t, amino.ErrChecksumMismatch, err)
+		}
+		err = cdc.UnmarshalBinaryChecksummed(bz[:2], new(Record))
+		assert.Equal(t, amino.ErrChecksumMismatch, err)
+	}
+}
+
+func TestMarshalBinaryChunked(t *testing.T) {
+	type Blob struct {
+		Name  string
+		Data  []byte
+		Small []byte
+		Tail  uint64
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterConcrete(&Blob{}, "test/blob", nil)
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, o := range []interface{}{
+		Blob{Name: "big", Data: data, Small: []byte{1, 2}, Tail: 7},
+		&Blob{Data: data[:10]},
+		Blob{},
+		"not a struct",
+	} {
+		want, err := cdc.MarshalBinaryBare(o)
+		assert.NoError(t, err)
+		w := new(chunkRecorder)
+		err = cdc.MarshalBinaryChunked(o, w, 4096)
+		assert.NoError(t, err)
+		assert.Equal(t, want, w.Bytes())
+		assert.True(t, w.maxWrite <= 4096 || w.writes == 1, "max write %v", w.maxWrite)
+	}
+}
+
+func TestMarshalBinaryLengthPrefixedWriter(t *testing.T) {
+	type Blob struct {
+		Name string
+		Data []byte
+		Tail uint64
+	}
+
+	cdc := amino.NewCodec()
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, o := range []interface{}{
+		Blob{Name: "big", Data: data, Tail: 7},
+		&Blob{Data: data[:10]},
+		Blob{},
+		"not a struct",
+	} {
+		want, err := cdc.MarshalBinaryLengthPrefixed(o)
+		assert.NoError(t, err)
+		w := new(chunkRecorder)
+		n, err := cdc.MarshalBinaryLengthPrefixedWriter(w, o)
+		assert.NoError(t, err)
+		assert.Equal(t, want, w.Bytes())
+		assert.Equal(t, int64(len(want)), n)
+	}
+
+	// The large byte slice is written directly, not copied into a buffer.
+	w := new(chunkRecorder)
+	_, err := cdc.MarshalBinaryLengthPrefixedWriter(w, Blob{Name: "big", Data: data, Tail: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), w.maxWrite)
+	assert.Equal(t, 4, w.writes) // The prefix, Name and the key of Data, Data, then Tail.
+}
+
+func TestMarshalBinaryLengthPrefixedWriterNested(t *testing.T) {
+	type Part struct {
+		Data []byte
+		Seq  int64
+	}
+	type Inner struct {
+		Part  *Part
+		Empty Part
+	}
+	type Outer struct {
+		Name  string
+		Inner Inner
+		Parts []Part
+		More  []*Part
+		Tail  uint64
+	}
+
+	cdc := amino.NewCodec()
+	data := make([]byte, 50000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, o := range []Outer{
+		{
+			Name:  "nested",
+			Inner: Inner{Part: &Part{Data: data, Seq: 1}},
+			Parts: []Part{{Data: data[:20000]}, {}, {Data: data[:3], Seq: 3}, {Data: data}},
+			More:  []*Part{{Data: data}, {Seq: 5}},
+			Tail:  9,
+		},
+		{Inner: Inner{Part: &Part{}}},
+		{Parts: []Part{{}, {}}},
+		{},
+	} {
+		want, err := cdc.MarshalBinaryLengthPrefixed(o)
+		require.NoError(t, err)
+		w := new(chunkRecorder)
+		n, err := cdc.MarshalBinaryLengthPrefixedWriter(w, o)
+		require.NoError(t, err)
+		assert.Equal(t, want, w.Bytes())
+		assert.Equal(t, int64(len(want)), n)
+	}
+
+	// The byte slices of nested structs and lists are written directly too,
+	// and nothing larger is buffered.
+	o := Outer{
+		Inner: Inner{Part: &Part{Data: data}},
+		Parts: []Part{{Data: data}, {Data: data}},
+	}
+	w := new(chunkRecorder)
+	_, err := cdc.MarshalBinaryLengthPrefixedWriter(w, o)
+	require.NoError(t, err)
+	assert.Equal(t, cdc.MustMarshalBinaryLengthPrefixed(o), w.Bytes())
+	assert.Equal(t, len(data), w.maxWrite)
+	assert.Equal(t, 7, w.writes) // The prefix, then each key and lengths followed by the Data.
+}
+
+// Records the largest write.
+type chunkRecorder struct {
+	bytes.Buffer
+	writes   int
+	maxWrite int
+}
+
+func (cr *chunkRecorder) Write(p []byte) (int, error) {
+	cr.writes++
+	if len(p) > cr.maxWrite {
+		cr.maxWrite = len(p)
+	}
+	return cr.Buffer.Write(p)
+}
+
+// streamRepr is encoded as its bytes reversed.
+type streamRepr []byte
+
+func (sr streamRepr) MarshalAmino() ([]byte, error) {
+	var bz = make([]byte, len(sr))
+	for i, b := range sr {
+		bz[len(sr)-1-i] = b
+	}
+	return bz, nil
+}
+
+func (sr *streamRepr) UnmarshalAmino(bz []byte) error {
+	rev, _ := streamRepr(bz).MarshalAmino()
+	*sr = rev
+	return nil
+}
+
+func TestMarshalBinaryStreamingFieldOptions(t *testing.T) {
+	type Blob struct {
+		Plain    []byte
+		Gzip     []byte  `amino:"gzip"`
+		NilEmpty []byte  `amino:"nil_as_empty"`
+		Empty    []byte  `amino:"write_empty"`
+		Raw      []byte  `amino:"json_raw"`
+		Numbered []byte  `amino:"field=20"`
+		Wrapped  *[]byte `amino:"wrapper"`
+		Repr     streamRepr
+		Since    []byte `amino:"since_version=2"`
+	}
+
+	cdc := amino.NewCodec()
+	data := make([]byte, 5000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	// Only plain byte slices are written directly, and the rest as usual.
+	for i, blob := range []Blob{
+		{data, data, data, data, data, data, &data, data, data},
+		{Plain: data[:2000], Gzip: data[:3000], Repr: data[:1024]},
+		{},
+	} {
+		want, err := cdc.MarshalBinaryLengthPrefixed(blob)
+		require.NoError(t, err, "case %v", i)
+		w := new(bytes.Buffer)
+		_, err = cdc.MarshalBinaryLengthPrefixedWriter(w, blob)
+		require.NoError(t, err, "case %v", i)
+		assert.Equal(t, want, w.Bytes(), "case %v", i)
+
+		want, err = cdc.MarshalBinaryBare(blob)
+		require.NoError(t, err, "case %v", i)
+		cw := new(chunkRecorder)
+		require.NoError(t, cdc.MarshalBinaryChunked(blob, cw, 512), "case %v", i)
+		assert.Equal(t, want, cw.Bytes(), "case %v", i)
+
+		var blob2 Blob
+		require.NoError(t, cdc.UnmarshalBinaryBare(cw.Bytes(), &blob2), "case %v", i)
+	}
+}
+
+type urlMsg struct {
+	From, To string
+	Amount   int64
+}
+
+type urlNote string
+
+func TestUnmarshalBinaryBareWithTypeURL(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterConcrete(&urlMsg{}, "bank/Send", nil)
+	cdc.RegisterConcrete(urlNote(""), "note", nil)
+
+	// Pointer preferred types decode as pointers.
+	msg := &urlMsg{"a", "b", 5}
+	bz := cdc.MustMarshalBinaryBare(msg)[amino.PrefixBytesLen:]
+	o, err := cdc.UnmarshalBinaryBareWithTypeURL("/bank/Send", bz)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, o)
+	o, err = cdc.UnmarshalBinaryBareWithTypeURL("bank/Send", bz)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, o)
+
+	bz = cdc.MustMarshalBinaryBare(urlNote("hi"))[amino.PrefixBytesLen:]
+	o, err = cdc.UnmarshalBinaryBareWithTypeURL("/note", bz)
+	assert.NoError(t, err)
+	assert.Equal(t, urlNote("hi"), o)
+
+	_, err = cdc.UnmarshalBinaryBareWithTypeURL("/bank/Receive", bz)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unregistered TypeURL "/bank/Receive"`)
+	_, err = cdc.UnmarshalBinaryBareWithTypeURL("/note", []byte{0xFF})
+	assert.Error(t, err)
+}
+
+type envTick struct {
+	Height int64
+	Time   time.Time
+}
+
+func TestMarshalEnvelope(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterConcrete(&urlMsg{}, "bank/Send", &amino.ConcreteOptions{SchemaVersion: 2})
+	cdc.RegisterConcrete(urlNote(""), "note", nil)
+	cdc.RegisterConcrete(envTick{}, "tick", &amino.ConcreteOptions{SchemaVersion: 7})
+
+	cases := []struct {
+		in      interface{}
+		version uint32
+	}{
+		{&urlMsg{"a", "b", 5}, 2},
+		{urlNote("hi"), 0},
+		{envTick{Height: 3, Time: time.Unix(100, 5).UTC()}, 7},
+		{envTick{}, 7},
+	}
+	for i, tc := range cases {
+		bz, err := cdc.MarshalEnvelope(tc.in)
+		require.NoError(t, err, "case %v", i)
+		o, version, err := cdc.UnmarshalEnvelope(bz)
+		require.NoError(t, err, "case %v", i)
+		assert.Equal(t, tc.in, o, "case %v", i)
+		assert.Equal(t, tc.version, version, "case %v", i)
+	}
+
+	_, err := cdc.MarshalEnvelope(urlMsg{})
+	assert.NoError(t, err, "non-pointer values of pointer preferred types")
+	_, err = cdc.MarshalEnvelope(struct{}{})
+	assert.Error(t, err, "unregistered")
+	_, err = cdc.MarshalEnvelope(nil)
+	assert.Error(t, err)
+
+	// A consumer without the type registered can't decode it.
+	bz, err := cdc.MarshalEnvelope(urlNote("hi"))
+	require.NoError(t, err)
+	_, _, err = amino.NewCodec().UnmarshalEnvelope(bz)
+	assert.Error(t, err)
+	_, _, err = cdc.UnmarshalEnvelope([]byte{0xFF})
+	assert.Error(t, err)
+}
+
+func TestMarshalMsgpack(t *testing.T) {
+	type Inner struct {
+		Label string `json:"label"`
+	}
+	type Record struct {
+		Name    string            `json:"name"`
+		Neg     int64             `json:"neg"`
+		Big     uint64            `json:"big"`
+		Small   int8              `json:"small"`
+		OK      bool              `json:"ok"`
+		Data    []byte            `json:"data"`
+		Hash    [4]byte           `json:"hash"`
+		Tags    []string          `json:"tags"`
+		Inner   *Inner            `json:"inner"`
+		Missing *Inner            `json:"missing"`
+		Counts  map[string]int64  `json:"counts"`
+		Asset   bridgeAsset       `json:"asset"`
+		At      time.Time         `json:"at"`
+		Wait    time.Duration     `json:"wait"`
+		Ratio   float64           `json:"ratio" amino:"unsafe"`
+		Extra   map[int32]float32 `json:"extra" amino:"unsafe"`
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*bridgeAsset)(nil), nil)
+	cdc.RegisterConcrete(bridgeCoin{}, "test/coin", nil)
+
+	rec := Record{
+		Name:   "msgpack",
+		Neg:    -1 << 40,
+		Big:    1<<64 - 1,
+		Small:  -5,
+		OK:     true,
+		Data:   []byte{1, 2, 3},
+		Hash:   [4]byte{0xDE, 0xAD, 0xBE, 0xEF},
+		Tags:   []string{"a", strings.Repeat("b", 40)},
+		Inner:  &Inner{Label: "in"},
+		Counts: map[string]int64{"x": 1, "y": -300},
+		Asset:  bridgeCoin{Name: "atom", Amount: 10},
+		At:     time.Date(2019, 5, 1, 12, 0, 0, 500, time.UTC),
+		Wait:   -3 * time.Second,
+		Ratio:  0.25,
+		Extra:  map[int32]float32{-1: 1.5},
+	}
+	bz, err := cdc.MarshalMsgpack(rec)
+	require.NoError(t, err)
+	var rec2 Record
+	require.NoError(t, cdc.UnmarshalMsgpack(bz, &rec2))
+	assert.Equal(t, rec, rec2)
+
+	// The zero value, including times outside of 34 bits of seconds.
+	bz, err = cdc.MarshalMsgpack(Record{})
+	require.NoError(t, err)
+	rec2 = Record{Name: "overwritten"}
+	require.NoError(t, cdc.UnmarshalMsgpack(bz, &rec2))
+	assert.Equal(t, Record{}, rec2)
+
+	// Times and durations are extension types.
+	type Stamp struct {
+		At   time.Time     `json:"at"`
+		Wait time.Duration `json:"wait"`
+	}
+	bz, err = cdc.MarshalMsgpack(Stamp{At: time.Unix(1, 0), Wait: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x82,
+		0xa2, 'a', 't', 0xd6, 0xff, 0, 0, 0, 1,
+		0xa4, 'w', 'a', 'i', 't', 0xd7, 0x01, 0, 0, 0, 0, 0, 0, 0, 2,
+	}, bz)
+
+	// Registered concrete values are wrapped with their name, like in JSON.
+	bz, err = cdc.MarshalMsgpack(bridgeCoin{Name: "a", Amount: 1})
+	require.NoError(t, err)
+	var coin bridgeCoin
+	require.NoError(t, cdc.UnmarshalMsgpack(bz, &coin))
+	assert.Equal(t, bridgeCoin{Name: "a", Amount: 1}, coin)
+	var asset bridgeAsset
+	require.NoError(t, cdc.UnmarshalMsgpack(bz, &asset))
+	assert.Equal(t, bridgeCoin{Name: "a", Amount: 1}, asset)
+
+	// Unknown keys are skipped.
+	bz = []byte{
+		0x82,
+		0xa1, 'z', 0x92, 0xc3, 0x81, 0xa1, 'k', 0xc4, 0x01, 0xff,
+		0xa2, 'a', 't', 0xd6, 0xff, 0, 0, 0, 1,
+	}
+	var stamp Stamp
+	require.NoError(t, cdc.UnmarshalMsgpack(bz, &stamp))
+	assert.Equal(t, time.Unix(1, 0).UTC(), stamp.At)
+
+	// Invalid input.
+	for i, bad := range [][]byte{
+		{},
+		{0x81, 0xa2, 'a', 't', 0xd7, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}, // 1<<30-1 nanos.
+		{0x81, 0xa2, 'a', 't', 0xd6, 0x01, 0, 0, 0, 1},                         // Wrong extension type.
+		{0x81, 0xa1, 'z', 0xdd, 0xff, 0xff, 0xff, 0xff},                        // Truncated array.
+		{0x82, 0xa1, 'z', 0xc0, 0xa1, 'z', 0xc0},                               // Duplicate key.
+		{0x80, 0xc0},                                                           // Trailing bytes.
+		{0xdf, 0xff, 0xff, 0xff, 0xff},                                         // Truncated map.
+	} {
+		assert.Error(t, cdc.UnmarshalMsgpack(bad, &stamp), "case %v", i)
+	}
+	_, err = amino.NewCodec().MarshalMsgpack(rec)
+	assert.Error(t, err, "unregistered concrete type")
+}
+
+func TestMarshalBinaryNamed(t *testing.T) {
+	type Inner struct {
+		Label string `json:"label"`
+	}
+	type Record struct {
+		Name   string           `json:"name"`
+		Neg    int32            `json:"neg"`
+		Big    uint64           `json:"big"`
+		OK     bool             `json:"ok"`
+		Data   []byte           `json:"data"`
+		Hash   [2]byte          `json:"hash"`
+		Tags   []string         `json:"tags"`
+		Inner  *Inner           `json:"inner"`
+		Counts map[int64]string `json:"counts"`
+		Asset  bridgeAsset      `json:"asset"`
+		At     time.Time        `json:"at"`
+		Ratio  float32          `json:"ratio" amino:"unsafe"`
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*bridgeAsset)(nil), nil)
+	cdc.RegisterConcrete(bridgeCoin{}, "test/coin", nil)
+
+	rec := Record{
+		Name:   "named",
+		Neg:    -7,
+		Big:    1<<64 - 1,
+		OK:     true,
+		Data:   []byte{1, 2},
+		Hash:   [2]byte{3, 4},
+		Tags:   []string{"a", ""},
+		Inner:  &Inner{Label: "in"},
+		Counts: map[int64]string{-1: "x", 2: "y"},
+		Asset:  bridgeCoin{Name: "atom", Amount: 10},
+		At:     time.Date(2019, 5, 1, 12, 0, 0, 500, time.UTC),
+		Ratio:  0.5,
+	}
+	bz, err := cdc.MarshalBinaryNamed(rec)
+	require.NoError(t, err)
+
+	// Into the original type.
+	var rec2 Record
+	require.NoError(t, cdc.UnmarshalBinaryNamed(bz, &rec2))
+	assert.Equal(t, rec, rec2)
+
+	// And into a generic map, without the type (or even the codec).
+	m, err := amino.NewCodec().UnmarshalBinaryNamedGeneric(bz)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":   "named",
+		"neg":    int64(-7),
+		"big":    uint64(1<<64 - 1),
+		"ok":     true,
+		"data":   []byte{1, 2},
+		"hash":   []byte{3, 4},
+		"tags":   []interface{}{"a", ""},
+		"inner":  map[string]interface{}{"label": "in"},
+		"counts": map[string]interface{}{"-1": "x", "2": "y"},
+		"asset": map[string]interface{}{
+			"type":  "test/coin",
+			"value": map[string]interface{}{"Name": "atom", "Amount": int64(10)},
+		},
+		"at":    time.Date(2019, 5, 1, 12, 0, 0, 500, time.UTC),
+		"ratio": float64(0.5),
+	}, m)
+
+	// The zero value.
+	bz, err = cdc.MarshalBinaryNamed(Record{})
+	require.NoError(t, err)
+	rec2 = Record{Name: "overwritten"}
+	require.NoError(t, cdc.UnmarshalBinaryNamed(bz, &rec2))
+	assert.Equal(t, Record{}, rec2)
+	m, err = cdc.UnmarshalBinaryNamedGeneric(bz)
+	require.NoError(t, err)
+	assert.Nil(t, m["inner"])
+	assert.Equal(t, "", m["name"])
+
+	// Fields are matched by name, so other types with some of them decode.
+	type Partial struct {
+		Big   uint64 `json:"big"`
+		Label string `json:"name"`
+		New   int8   `json:"new"`
+	}
+	bz, err = cdc.MarshalBinaryNamed(rec)
+	require.NoError(t, err)
+	var p Partial
+	require.NoError(t, cdc.UnmarshalBinaryNamed(bz, &p))
+	assert.Equal(t, Partial{Big: 1<<64 - 1, Label: "named"}, p)
+	type Mismatch struct {
+		Name int64 `json:"name"`
+	}
+	assert.Error(t, cdc.UnmarshalBinaryNamed(bz, new(Mismatch)))
+
+	// Registered concrete values are wrapped with their name.
+	bz, err = cdc.MarshalBinaryNamed(bridgeCoin{Name: "a"})
+	require.NoError(t, err)
+	var asset bridgeAsset
+	require.NoError(t, cdc.UnmarshalBinaryNamed(bz, &asset))
+	assert.Equal(t, bridgeCoin{Name: "a"}, asset)
+	_, err = cdc.UnmarshalBinaryNamedGeneric([]byte{0x08, 0x01})
+	assert.Error(t, err, "not a struct")
+}
@@ -0,0 +1,152 @@
+package amino
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Annotated binary
+
+// AnnotateBinary returns a hex dump of bz, the binary encoding of a struct
+// of type rt, which shows what each run of bytes encodes.  Each line holds
+// the offset of the run, its bytes in hex, and the field it belongs to
+// along with the field's decoded value.  Struct fields are annotated in
+// turn, indented below the line holding their field key and length, e.g.:
+//
+//	0000  0A 05 61 6C 69 63 65  Name (#1, ByteLength) = "alice"
+//	0007  12 02                 Inner (#2, 2 bytes)
+//	0009  08 01                   A (#1, (U)Varint) = 1
+//
+// This is meant for debugging, and the format may change.
+func (cdc *Codec) AnnotateBinary(bz []byte, rt reflect.Type) (annotated string, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		return "", err
+	}
+	if !isAnnotatedStruct(info) {
+		return "", fmt.Errorf("AnnotateBinary expects a struct type, got %v", rt)
+	}
+
+	buf := new(bytes.Buffer)
+	tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	var offset int
+	if info.Registered && bytes.HasPrefix(bz, info.Prefix.Bytes()) {
+		writeAnnotation(tw, offset, bz[:PrefixBytesLen], 0, "prefix bytes of %s", info.Name)
+		offset += PrefixBytesLen
+	}
+	err = cdc.annotateBinaryStruct(tw, bz[offset:], offset, info, 0)
+	if flushErr := tw.Flush(); err == nil {
+		err = flushErr
+	}
+	return buf.String(), err
+}
+
+// Annotates the fields of the struct encoded by bz, which starts at offset.
+func (cdc *Codec) annotateBinaryStruct(w io.Writer, bz []byte, offset int, info *TypeInfo, depth int) error {
+	for len(bz) > 0 {
+		fnum, typ, n, err := decodeFieldNumberAndTyp3(bz)
+		if err != nil {
+			return errors.Wrapf(err, "could not decode field key at offset %v", offset)
+		}
+		_n, err := consumeAny(typ, bz[n:])
+		if err != nil {
+			return errors.Wrapf(err, "could not decode field # %v at offset %v", fnum, offset)
+		}
+		var run = bz[:n+_n]
+		field, ok := fieldByNum(info, fnum)
+		if !ok {
+			writeAnnotation(w, offset, run, depth, "unknown field (#%v, %v)", fnum, typ)
+			bz, offset = bz[len(run):], offset+len(run)
+			continue
+		}
+		if field.FieldCodec != nil {
+			writeAnnotation(w, offset, run, depth, "%v (#%v, %v) with field codec", field.Name, fnum, typ)
+			bz, offset = bz[len(run):], offset+len(run)
+			continue
+		}
+
+		// Find the type of the value, as in decodeReflectBinaryStruct().
+		var vtype = field.Type
+		switch {
+		case field.WrapperType != nil:
+			vtype = reflect.PtrTo(field.WrapperType)
+		case field.DynamicResolver != nil:
+			vtype = reflect.PtrTo(dynamicAnyType)
+		case field.isUnionPayload():
+			vtype = bytesType
+		case field.UnpackedList && vtype.Kind() == reflect.Map:
+			vtype = mapEntryType(vtype) // Each entry holds a key and value.
+		case field.UnpackedList:
+			vtype = vtype.Elem() // Each entry holds a single element.
+		}
+		vinfo, err := cdc.getTypeInfoWlock(vtype)
+		if err != nil {
+			return err
+		}
+
+		if isAnnotatedStruct(vinfo) && typ == Typ3ByteLength {
+			_, nLen, _ := DecodeUvarint(bz[n:])
+			writeAnnotation(w, offset, run[:n+nLen], depth, "%v (#%v, %v bytes)", field.Name, fnum, _n-nLen)
+			err = cdc.annotateBinaryStruct(w, run[n+nLen:], offset+n+nLen, vinfo, depth+1)
+			if err != nil {
+				return err
+			}
+		} else {
+			var vrv = reflect.New(vtype).Elem()
+			_, err = cdc.decodeReflectBinary(run[n:], vinfo, vrv, field.FieldOptions, false, decodeOptions{})
+			if err != nil {
+				return errors.Wrapf(err, "could not decode field %v at offset %v", field.Name, offset)
+			}
+			writeAnnotation(w, offset, run, depth, "%v (#%v, %v) = %v", field.Name, fnum, typ, annotatedValue(vrv))
+		}
+		bz, offset = bz[len(run):], offset+len(run)
+	}
+	return nil
+}
+
+// Returns the field of the struct info with the field number fnum.
+func fieldByNum(info *TypeInfo, fnum uint32) (FieldInfo, bool) {
+	for _, field := range info.Fields {
+		if field.BinFieldNum == fnum {
+			return field, true
+		}
+	}
+	return FieldInfo{}, false
+}
+
+// Returns whether the fields of values of info are annotated individually.
+func isAnnotatedStruct(info *TypeInfo) bool {
+	return info.Type.Kind() == reflect.Struct && info.Type != timeType && !info.IsAminoMarshaler && !info.Raw &&
+		!info.IsBinaryMarshaler
+}
+
+func writeAnnotation(w io.Writer, offset int, run []byte, depth int, format string, args ...interface{}) {
+	fmt.Fprintf(w, "%04X\t% X\t%*s%s\n", offset, run, 2*depth, "", fmt.Sprintf(format, args...))
+}
+
+// Formats a decoded value for AnnotateBinary().
+func annotatedValue(rv reflect.Value) string {
+	rv, _, isNilPtr := derefPointers(rv)
+	switch {
+	case isNilPtr:
+		return "nil"
+	case rv.Kind() == reflect.String:
+		return fmt.Sprintf("%q", rv.String())
+	case (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() == reflect.Uint8:
+		return fmt.Sprintf("%X", rv.Interface())
+	default:
+		return fmt.Sprintf("%v", rv.Interface())
+	}
+}
@@ -0,0 +1,77 @@
+package amino_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+func TestAnnotateBinary(t *testing.T) {
+	type Inner struct {
+		A int64
+		B []byte
+	}
+	type Outer struct {
+		Name   string
+		Inner  Inner
+		Counts []uint16
+		Tags   []string
+	}
+
+	cdc := amino.NewCodec()
+	o := Outer{Name: "alice", Inner: Inner{A: 1, B: []byte{0xFF}}, Counts: []uint16{3, 300}, Tags: []string{"x", "y"}}
+	bz, err := cdc.MarshalBinaryBare(o)
+	require.NoError(t, err)
+
+	annotated, err := cdc.AnnotateBinary(bz, reflect.TypeOf(o))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`0000  0A 05 61 6C 69 63 65  Name (#1, ByteLength) = "alice"`,
+		`0007  12 05                 Inner (#2, 5 bytes)`,
+		`0009  08 01                   A (#1, (U)Varint) = 1`,
+		`000B  12 01 FF                B (#2, ByteLength) = FF`,
+		`000E  1A 03 03 AC 02        Counts (#3, ByteLength) = [3 300]`,
+		`0013  22 01 78              Tags (#4, ByteLength) = "x"`,
+		`0016  22 01 79              Tags (#4, ByteLength) = "y"`,
+		``,
+	}, "\n"), annotated)
+
+	// Unknown fields are annotated as such.
+	type Extended struct {
+		Name   string
+		Inner  Inner
+		Counts []uint16
+		Tags   []string
+		Extra  string
+	}
+	bz, err = cdc.MarshalBinaryBare(Extended{Name: "bob", Extra: "!"})
+	require.NoError(t, err)
+	annotated, err = cdc.AnnotateBinary(bz, reflect.TypeOf(Outer{}))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`0000  0A 03 62 6F 62  Name (#1, ByteLength) = "bob"`,
+		`0005  2A 01 21        unknown field (#5, ByteLength)`,
+		``,
+	}, "\n"), annotated)
+
+	// Registered types show their prefix bytes.
+	cdc = amino.NewCodec()
+	cdc.RegisterConcrete(Extended{}, "test/Extended", nil)
+	bz, err = cdc.MarshalBinaryBare(Extended{Name: "bob", Extra: "!"})
+	require.NoError(t, err)
+	annotated, err = cdc.AnnotateBinary(bz, reflect.TypeOf(Extended{}))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(annotated, "0000  "))
+	assert.Contains(t, annotated, "prefix bytes of test/Extended\n")
+	assert.Contains(t, annotated, `0009  2A 01 21        Extra (#5, ByteLength) = "!"`)
+
+	_, err = cdc.AnnotateBinary(bz[:len(bz)-1], reflect.TypeOf(Extended{}))
+	assert.Error(t, err)
+	_, err = cdc.AnnotateBinary(bz, reflect.TypeOf(""))
+	assert.Error(t, err)
+}
@@ -2,7 +2,6 @@ package amino
 
 import (
 	"fmt"
-	"math"
 	"reflect"
 	"time"
 
@@ -14,28 +13,160 @@ import (
 //----------------------------------------
 // cdc.decodeReflectBinary
 
+// Per-call options for binary decoding, passed down to all decode methods.
+// The zero value is used by UnmarshalBinaryBare.
+type decodeOptions struct {
+	Allowed  map[reflect.Type]struct{} // If not nil, see UnmarshalBinaryAllowing.
+	AnyDepth int                       // Number of enclosing interface values.
+	Depth    int                       // Number of enclosing complex values, see enterComplex().
+	Renames  map[string]string         // (JSON) See UnmarshalJSONWithRenames.
+	Skipped  *[]UnknownInterfaceValue  // If not nil, see UnmarshalBinaryBareSkipping.
+}
+
+// Returns dopts for decoding the value of an interface, or an error if
+// there are too many enclosing interface values, see SetMaxAnyDepth().
+func (cdc *Codec) enterAny(dopts decodeOptions) (decodeOptions, error) {
+	dopts.AnyDepth++
+	if cdc.maxAnyDepth > 0 && dopts.AnyDepth > cdc.maxAnyDepth {
+		return dopts, fmt.Errorf("interface values nested deeper than %v", cdc.maxAnyDepth)
+	}
+	return dopts, nil
+}
+
+// Returns dopts for decoding the elements of a value of kind, or an error if
+// there are too many enclosing structs, lists, maps and interface values,
+// see SetMaxDecodeDepth().
+func (cdc *Codec) enterComplex(kind reflect.Kind, dopts decodeOptions) (decodeOptions, error) {
+	switch kind {
+	case reflect.Interface, reflect.Array, reflect.Slice, reflect.Map, reflect.Struct:
+		dopts.Depth++
+		if cdc.maxDecodeDepth > 0 && dopts.Depth > cdc.maxDecodeDepth {
+			return dopts, fmt.Errorf("values nested deeper than %v", cdc.maxDecodeDepth)
+		}
+	}
+	return dopts, nil
+}
+
+// Returns an error if interfaces may not decode to the concrete type rt.
+func (dopts decodeOptions) checkAllowed(rt reflect.Type) error {
+	if dopts.Allowed == nil {
+		return nil
+	}
+	if _, ok := dopts.Allowed[rt]; !ok {
+		return fmt.Errorf("decoding to %v is not allowed", rt)
+	}
+	return nil
+}
+
+// Returns true if interface values of unregistered concrete types are left
+// nil instead of failing to decode, see SetSkipUnknownInterfaceValues().
+func (cdc *Codec) skipsUnknownIface(dopts decodeOptions) bool {
+	return cdc.skipUnknownIface || dopts.Skipped != nil
+}
+
+// Records the skipped interface value, see UnmarshalBinaryBareSkipping.
+func (dopts decodeOptions) addSkipped(skipped UnknownInterfaceValue) {
+	if dopts.Skipped != nil {
+		*dopts.Skipped = append(*dopts.Skipped, skipped)
+	}
+}
+
 var (
 	ErrOverflowInt = errors.New("encoded integer value overflows int(32)")
 )
 
-const (
-	// architecture dependent int limits:
-	maxInt = int(^uint(0) >> 1)
-	minInt = -maxInt - 1
-)
+// Sets the decoded integer i on rv, of a signed integer kind, or returns
+// an error if it overflows rv, see SetIntOverflowMode().
+func (cdc *Codec) setDecodedInt(rv reflect.Value, i int64, fopts FieldOptions) error {
+	if rv.OverflowInt(i) && cdc.intOverflowMode != IntOverflowWrap {
+		return intOverflowError(rv, i, fopts)
+	}
+	rv.SetInt(i) // Truncates.
+	return nil
+}
+
+// Like setDecodedInt, for unsigned integer kinds.
+func (cdc *Codec) setDecodedUint(rv reflect.Value, u uint64, fopts FieldOptions) error {
+	if rv.OverflowUint(u) && cdc.intOverflowMode != IntOverflowWrap {
+		return intOverflowError(rv, u, fopts)
+	}
+	rv.SetUint(u) // Truncates.
+	return nil
+}
+
+func intOverflowError(rv reflect.Value, v interface{}, fopts FieldOptions) error {
+	var msg = fmt.Sprintf("decoded value %v overflows %v", v, rv.Type())
+	if fopts.JSONName != "" {
+		msg = fmt.Sprintf("field %v: %v", fopts.JSONName, msg)
+	}
+	if rv.Kind() == reflect.Int || rv.Kind() == reflect.Int32 {
+		return errors.Wrap(ErrOverflowInt, msg)
+	}
+	return errors.New(msg)
+}
+
+// Returns true if a field of type rt written as typ can be decoded with
+// decodeCoercedInt(), see SetNumericCoercion().
+func isCoercibleInt(rt reflect.Type, info *TypeInfo, typ Typ3) bool {
+	if info.IsAminoUnmarshaler || info.IntDecoder != nil || (typ != Typ3Varint && typ != Typ38Byte && typ != Typ3_4Byte) {
+		return false
+	}
+	switch rt.Kind() {
+	case reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Int,
+		reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint:
+		return true
+	default:
+		return false
+	}
+}
+
+// Decodes an integer written as typ into rv, with the signedness of rv,
+// or returns an error if it overflows rv.
+func (cdc *Codec) decodeCoercedInt(bz []byte, typ Typ3, rv reflect.Value, fopts FieldOptions) (n int, err error) {
+	var u uint64
+	var i int64
+	switch typ {
+	case Typ3Varint:
+		u, n, err = cdc.decodeUvarint(bz)
+		i = int64(u)
+	case Typ38Byte:
+		u, n, err = DecodeUint64(bz)
+		i = int64(u)
+	case Typ3_4Byte:
+		var u32 uint32
+		u32, n, err = DecodeUint32(bz)
+		u, i = uint64(u32), int64(int32(u32))
+	}
+	if err != nil {
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Int:
+		if rv.OverflowInt(i) {
+			return n, intOverflowError(rv, i, fopts)
+		}
+		rv.SetInt(i)
+	default:
+		if rv.OverflowUint(u) {
+			return n, intOverflowError(rv, u, fopts)
+		}
+		rv.SetUint(u)
+	}
+	return
+}
 
 // This is the main entrypoint for decoding all types from binary form. This
 // function calls decodeReflectBinary*, and generally those functions should
 // only call this one, for the prefix bytes are consumed here when present.
 // CONTRACT: rv.CanAddr() is true.
 func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
-	rv reflect.Value, fopts FieldOptions, bare bool) (n int, err error) {
+	rv reflect.Value, fopts FieldOptions, bare bool, dopts decodeOptions) (n int, err error) {
 
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if info.Type.Kind() == reflect.Interface && rv.Kind() == reflect.Ptr {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	if printLog {
 		spew.Printf("(D) decodeReflectBinary(bz: %X, info: %v, rv: %#v (%v), fopts: %v)\n",
@@ -52,7 +183,7 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 	// This works for pointer-pointers.
 	for rv.Kind() == reflect.Ptr {
 		if rv.IsNil() {
-			newPtr := reflect.New(rv.Type().Elem())
+			newPtr := cdc.newValue(rv.Type().Elem())
 			rv.Set(newPtr)
 		}
 		rv = rv.Elem()
@@ -67,7 +198,7 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 		if err != nil {
 			return
 		}
-		_n, err = cdc.decodeReflectBinary(bz, rinfo, rrv, fopts, bare)
+		_n, err = cdc.decodeReflectBinary(bz, rinfo, rrv, fopts, bare, dopts)
 		if slide(&bz, &n, _n) && err != nil {
 			return
 		}
@@ -81,23 +212,149 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 		return
 	}
 
+	// Special case: time.Time as milliseconds, see unixMillis().
+	if info.Type == timeType && fopts.UnixMillis {
+		var u64 uint64
+		u64, _n, err = cdc.decodeUvarint(bz)
+		if slide(&bz, &n, _n) && err != nil {
+			return
+		}
+		rv.Set(reflect.ValueOf(timeFromUnixMillis(int64(u64))))
+		return
+	}
+
+	// Special case: time.Time as days, see dateOnlyDays().
+	if info.Type == timeType && fopts.DateOnly {
+		var u64 uint64
+		u64, _n, err = cdc.decodeUvarint(bz)
+		if slide(&bz, &n, _n) && err != nil {
+			return
+		}
+		rv.Set(reflect.ValueOf(timeFromDateOnlyDays(int64(u64))))
+		return
+	}
+
+	// Special case: json.Number, see jsonNumberRepr.
+	if info.Type == jsonNumberType {
+		var rinfo *TypeInfo
+		rinfo, err = cdc.getTypeInfoWlock(jsonNumberReprType)
+		if err != nil {
+			return
+		}
+		rrv := reflect.New(jsonNumberReprType).Elem()
+		_n, err = cdc.decodeReflectBinary(bz, rinfo, rrv, fopts, bare, dopts)
+		if slide(&bz, &n, _n) && err != nil {
+			return
+		}
+		rv.SetString(string(fromJSONNumberRepr(rrv.Interface().(jsonNumberRepr))))
+		return
+	}
+
+	// Special case: pre-encoded bytes, see RegisterRawType().
+	if info.Raw {
+		var raw = bz
+		if !bare {
+			raw, _n, err = cdc.decodeByteSlice(bz)
+			if slide(&bz, &n, _n) && err != nil {
+				return
+			}
+		} else {
+			slide(&bz, &n, len(bz))
+		}
+		if len(raw) == 0 {
+			rv.Field(0).Set(reflect.Zero(rv.Field(0).Type()))
+		} else {
+			rv.Field(0).SetBytes(append([]byte(nil), raw...))
+		}
+		return
+	}
+
+	// Special case: encoding.BinaryMarshaler, see isBinaryMarshalerType().
+	if info.IsBinaryMarshaler {
+		var payload = bz
+		if !bare {
+			payload, _n, err = cdc.decodeByteSlice(bz)
+			if slide(&bz, &n, _n) && err != nil {
+				return
+			}
+		} else {
+			slide(&bz, &n, len(bz))
+		}
+		err = unmarshalBinary(payload, info, rv)
+		return
+	}
+
+	// Special case: compressed strings and byte slices, see gzipPayload().
+	if fopts.Gzip && isGzipKind(info.Type) {
+		var payload, bz2 []byte
+		payload, _n, err = cdc.decodeByteSlice(bz)
+		if slide(&bz, &n, _n) && err != nil {
+			return
+		}
+		bz2, err = gunzipPayload(payload, cdc.maxGunzipSize)
+		if err != nil {
+			return
+		}
+		if rv.Kind() == reflect.String {
+			rv.SetString(string(bz2))
+		} else if len(bz2) == 0 {
+			rv.Set(info.ZeroValue)
+		} else {
+			rv.SetBytes(bz2)
+		}
+		return
+	}
+
+	// Handle custom integer decoding, see RegisterIntCodec().
+	if usesIntCodec(info, fopts) {
+		var bz2 []byte
+		bz2, _n, err = cdc.decodeByteSlice(bz)
+		if slide(&bz, &n, _n) && err != nil {
+			return
+		}
+		var u uint64
+		u, _n, err = info.IntDecoder(bz2)
+		if err != nil {
+			return
+		}
+		if _n != len(bz2) {
+			err = fmt.Errorf("int codec for %v reported reading %v bytes of %v", info.Type, _n, len(bz2))
+			return
+		}
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			err = cdc.setDecodedInt(rv, int64(u), fopts)
+		default:
+			err = cdc.setDecodedUint(rv, u, fopts)
+		}
+		return
+	}
+
+	// Count nested values, see SetMaxDecodeDepth().
+	if dopts, err = cdc.enterComplex(info.Type.Kind(), dopts); err != nil {
+		return
+	}
+
 	switch info.Type.Kind() {
 
 	//----------------------------------------
 	// Complex
 
 	case reflect.Interface:
-		_n, err = cdc.decodeReflectBinaryInterface(bz, info, rv, fopts, bare)
+		_n, err = cdc.decodeReflectBinaryInterface(bz, info, rv, fopts, bare, dopts)
 		n += _n
 		return
 
 	case reflect.Array:
 		ert := info.Type.Elem()
-		if ert.Kind() == reflect.Uint8 {
+		if fopts.Sparse {
+			_n, err = cdc.decodeReflectBinarySparseArray(bz, info, rv, fopts, bare, dopts)
+			n += _n
+		} else if ert.Kind() == reflect.Uint8 {
 			_n, err = cdc.decodeReflectBinaryByteArray(bz, info, rv, fopts)
 			n += _n
 		} else {
-			_n, err = cdc.decodeReflectBinaryArray(bz, info, rv, fopts, bare)
+			_n, err = cdc.decodeReflectBinaryArray(bz, info, rv, fopts, bare, dopts)
 			n += _n
 		}
 		return
@@ -108,13 +365,18 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 			_n, err = cdc.decodeReflectBinaryByteSlice(bz, info, rv, fopts)
 			n += _n
 		} else {
-			_n, err = cdc.decodeReflectBinarySlice(bz, info, rv, fopts, bare)
+			_n, err = cdc.decodeReflectBinarySlice(bz, info, rv, fopts, bare, dopts)
 			n += _n
 		}
 		return
 
+	case reflect.Map:
+		_n, err = cdc.decodeReflectBinaryMap(bz, info, rv, fopts, bare, dopts)
+		n += _n
+		return
+
 	case reflect.Struct:
-		_n, err = cdc.decodeReflectBinaryStruct(bz, info, rv, fopts, bare)
+		_n, err = cdc.decodeReflectBinaryStruct(bz, info, rv, fopts, bare, dopts)
 		n += _n
 		return
 
@@ -131,7 +393,7 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 			rv.SetInt(num)
 		} else {
 			var u64 uint64
-			u64, _n, err = DecodeUvarint(bz)
+			u64, _n, err = cdc.decodeUvarint(bz)
 			if slide(&bz, &n, _n) && err != nil {
 				return
 			}
@@ -149,47 +411,30 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 			rv.SetInt(int64(num))
 		} else {
 			var num uint64
-			num, _n, err = DecodeUvarint(bz)
+			num, _n, err = cdc.decodeUvarint(bz)
 			if slide(&bz, &n, _n) && err != nil {
 				return
 			}
-			if int64(num) > math.MaxInt32 || int64(num) < math.MinInt32 {
-				err = ErrOverflowInt
-				return
-			}
-			rv.SetInt(int64(num))
-		}
-		return
-
-	case reflect.Int16:
-		var num int16
-		num, _n, err = DecodeInt16(bz)
-		if slide(&bz, &n, _n) && err != nil {
-			return
+			err = cdc.setDecodedInt(rv, int64(num), fopts)
 		}
-		rv.SetInt(int64(num))
 		return
 
-	case reflect.Int8:
-		var num int8
-		num, _n, err = DecodeInt8(bz)
+	case reflect.Int16, reflect.Int8:
+		var num int64
+		num, _n, err = cdc.decodeVarint(bz)
 		if slide(&bz, &n, _n) && err != nil {
 			return
 		}
-		rv.SetInt(int64(num))
+		err = cdc.setDecodedInt(rv, num, fopts)
 		return
 
 	case reflect.Int:
 		var num uint64
-		num, _n, err = DecodeUvarint(bz)
+		num, _n, err = cdc.decodeUvarint(bz)
 		if slide(&bz, &n, _n) && err != nil {
 			return
 		}
-		if int64(num) > int64(maxInt) || int64(num) < int64(minInt) {
-			err = ErrOverflowInt
-			return
-		}
-		rv.SetInt(int64(num))
+		err = cdc.setDecodedInt(rv, int64(num), fopts)
 		return
 
 	//----------------------------------------
@@ -204,7 +449,7 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 			}
 			rv.SetUint(num)
 		} else {
-			num, _n, err = DecodeUvarint(bz)
+			num, _n, err = cdc.decodeUvarint(bz)
 			if slide(&bz, &n, _n) && err != nil {
 				return
 			}
@@ -222,39 +467,30 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 			rv.SetUint(uint64(num))
 		} else {
 			var num uint64
-			num, _n, err = DecodeUvarint(bz)
+			num, _n, err = cdc.decodeUvarint(bz)
 			if slide(&bz, &n, _n) && err != nil {
 				return
 			}
-			rv.SetUint(num)
+			err = cdc.setDecodedUint(rv, num, fopts)
 		}
 		return
 
-	case reflect.Uint16:
-		var num uint16
-		num, _n, err = DecodeUint16(bz)
-		if slide(&bz, &n, _n) && err != nil {
-			return
-		}
-		rv.SetUint(uint64(num))
-		return
-
-	case reflect.Uint8:
-		var num uint8
-		num, _n, err = DecodeUint8(bz)
+	case reflect.Uint16, reflect.Uint8:
+		var num uint64
+		num, _n, err = cdc.decodeUvarint(bz)
 		if slide(&bz, &n, _n) && err != nil {
 			return
 		}
-		rv.SetUint(uint64(num))
+		err = cdc.setDecodedUint(rv, num, fopts)
 		return
 
 	case reflect.Uint:
 		var num uint64
-		num, _n, err = DecodeUvarint(bz)
+		num, _n, err = cdc.decodeUvarint(bz)
 		if slide(&bz, &n, _n) && err != nil {
 			return
 		}
-		rv.SetUint(num)
+		err = cdc.setDecodedUint(rv, num, fopts)
 		return
 
 	//----------------------------------------
@@ -297,7 +533,7 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 
 	case reflect.String:
 		var str string
-		str, _n, err = DecodeString(bz)
+		str, _n, err = cdc.decodeString(bz)
 		if slide(&bz, &n, _n) && err != nil {
 			return
 		}
@@ -312,9 +548,9 @@ func (cdc *Codec) decodeReflectBinary(bz []byte, info *TypeInfo,
 
 // CONTRACT: rv.CanAddr() is true.
 func (cdc *Codec) decodeReflectBinaryInterface(bz []byte, iinfo *TypeInfo, rv reflect.Value,
-	fopts FieldOptions, bare bool) (n int, err error) {
+	fopts FieldOptions, bare bool, dopts decodeOptions) (n int, err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectBinaryInterface")
@@ -336,37 +572,118 @@ func (cdc *Codec) decodeReflectBinaryInterface(bz []byte, iinfo *TypeInfo, rv re
 			buf []byte
 			_n  int
 		)
-		buf, _n, err = DecodeByteSlice(bz)
+		buf, _n, err = cdc.decodeByteSlice(bz)
 		if slide(&bz, nil, _n) && err != nil {
 			return
 		}
 		// This is a trick for debuggability -- we slide on &n more later.
-		n += UvarintSize(uint64(len(buf)))
+		n += _n - len(buf)
 		bz = buf
 	}
 
-	// Consume disambiguation / prefix bytes.
-	disamb, hasDisamb, prefix, hasPrefix, _n, err := DecodeDisambPrefixBytes(bz)
-	if slide(&bz, &n, _n) && err != nil {
-		return n, err
+	// Get concrete type info, which may be implied,
+	// see SetOmitAnyTypeWhenUnique().
+	var _n int
+	cinfo, ok := cdc.getUniqueImplementerWlock(iinfo)
+	if ok && len(bz) == 0 {
+		// Leave rv nil.
+		return
+	}
+	if ok && len(bz) == 1 && bz[0] == 0x00 {
+		// A value which encodes to no bytes, see encodeReflectBinaryInterface().
+		slide(&bz, &n, 1)
+	}
+	if !ok && cdc.indexedAny {
+		// Consume the index instead, see SetIndexedAnyMode().
+		cinfo, _n, err = cdc.readIndexedAnyPrefix(bz, iinfo)
+		if slide(&bz, &n, _n) && err != nil {
+			return
+		}
+	} else if !ok {
+		// Consume disambiguation / prefix bytes.
+		var (
+			disamb                DisambBytes
+			prefix                PrefixBytes
+			hasDisamb, hasPrefix  bool
+			bzNoPrefix, nNoPrefix = bz, n
+		)
+		// Whether there are no prefix bytes, rather than unknown ones.
+		var missing bool
+		disamb, hasDisamb, prefix, hasPrefix, _n, err = DecodeDisambPrefixBytes(bz)
+		if err == nil {
+			slide(&bz, &n, _n)
+			// Get concrete type info from disfix/prefix.
+			switch {
+			case hasDisamb:
+				cinfo, err = cdc.getTypeInfoFromDisfixRlock(toDisfix(disamb, prefix))
+			case hasPrefix:
+				cinfo, err = cdc.getTypeInfoFromPrefixRlock(iinfo, prefix)
+				missing = err != nil && !cdc.hasPrefixRlock(iinfo, prefix) && !cdc.skipsUnknownIface(dopts)
+			default:
+				err = errors.New("expected disambiguation or prefix bytes")
+			}
+		} else {
+			missing = true
+		}
+		if err != nil && missing && iinfo.DefaultConcrete != nil {
+			// Fall back to the default, see RegisterInterfaceDefault().
+			cinfo, bz, n, err = iinfo.DefaultConcrete, bzNoPrefix, nNoPrefix, nil
+		}
+		if err != nil && (hasDisamb || hasPrefix) && cdc.skipsUnknownIface(dopts) {
+			// Leave rv nil, see SetSkipUnknownInterfaceValues().
+			dopts.addSkipped(UnknownInterfaceValue{
+				Interface: iinfo.Type, Disamb: disamb, Prefix: prefix})
+			return nNoPrefix + len(bzNoPrefix), nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	if err = checkOneof(iinfo, cinfo); err != nil {
+		return
+	}
+	if err = dopts.checkAllowed(cinfo.Type); err != nil {
+		return
+	}
+	if dopts, err = cdc.enterAny(dopts); err != nil {
+		return
 	}
 
-	// Get concrete type info from disfix/prefix.
-	var cinfo *TypeInfo
-	switch {
-	case hasDisamb:
-		cinfo, err = cdc.getTypeInfoFromDisfixRlock(toDisfix(disamb, prefix))
-	case hasPrefix:
-		cinfo, err = cdc.getTypeInfoFromPrefixRlock(iinfo, prefix)
-	default:
-		err = errors.New("expected disambiguation or prefix bytes")
+	if cdc.lazyAny && lazyAnyPtrType.Implements(iinfo.Type) {
+		// Defer decoding the concrete value, see SetLazyAny().
+		var value = make([]byte, len(bz))
+		copy(value, bz)
+		rv.Set(reflect.ValueOf(&LazyAny{Name: cinfo.Name, value: value, fopts: fopts, dopts: dopts}))
+		return n + len(bz), nil
 	}
-	if err != nil {
+
+	var irvSet reflect.Value
+	irvSet, _n, err = cdc.decodeReflectBinaryConcrete(bz, cinfo, fopts, dopts)
+	if slide(&bz, &n, _n) && err != nil {
+		if irvSet.IsValid() {
+			rv.Set(irvSet) // Helps with debugging
+		}
 		return
 	}
 
+	// We need to set here, for when !PointerPreferred and the type
+	// is say, an array of bytes (e.g. [32]byte), then we must call
+	// rv.Set() *after* the value was acquired.
+	// NOTE: rv.Set() should succeed because it was validated
+	// already during Register[Interface/Concrete].
+	rv.Set(irvSet)
+	return n, err
+}
+
+// Decodes the value of an interface, after any prefix bytes, into a new
+// value of the concrete type cinfo, and returns the value to set the
+// interface to.  All of bz must be consumed.
+func (cdc *Codec) decodeReflectBinaryConcrete(bz []byte, cinfo *TypeInfo,
+	fopts FieldOptions, dopts decodeOptions) (irvSet reflect.Value, n int, err error) {
+	var _n int
 	// Construct the concrete type.
-	var crv, irvSet = constructConcreteType(cinfo)
+	var crv reflect.Value
+	crv, irvSet = cdc.constructConcreteType(cinfo)
 	isKnownType := (cinfo.Type.Kind() != reflect.Map) && (cinfo.Type.Kind() != reflect.Func)
 	if !isStructOrRepeatedStruct(cinfo) &&
 		!isPointerToStructOrToRepeatedStruct(crv, cinfo.Type) &&
@@ -379,25 +696,24 @@ func (cdc *Codec) decodeReflectBinaryInterface(bz []byte, iinfo *TypeInfo, rv re
 			typ       Typ3
 			nFnumTyp3 int
 		)
-		fnum, typ, nFnumTyp3, err = decodeFieldNumberAndTyp3(bz)
+		fnum, typ, nFnumTyp3, err = cdc.decodeFieldNumberAndTyp3(bz)
 		if err != nil {
-			return n, errors.Wrap(err, "could not decode field number and type")
+			return irvSet, n, errors.Wrap(err, "could not decode field number and type")
 		}
 		if fnum != 1 {
-			return n, fmt.Errorf("expected field number: 1; got: %v", fnum)
+			return irvSet, n, fmt.Errorf("expected field number: 1; got: %v", fnum)
 		}
-		typWanted := typeToTyp3(cinfo.Type, FieldOptions{})
+		typWanted := typeToTyp3(cinfo, FieldOptions{})
 		if typ != typWanted {
-			return n, fmt.Errorf("expected field type %v for # %v of %v, got %v",
+			return irvSet, n, fmt.Errorf("expected field type %v for # %v of %v, got %v",
 				typWanted, fnum, cinfo.Type, typ)
 		}
 		slide(&bz, &n, nFnumTyp3)
 	}
 
 	// Decode into the concrete type.
-	_n, err = cdc.decodeReflectBinary(bz, cinfo, crv, fopts, true)
+	_n, err = cdc.decodeReflectBinary(bz, cinfo, crv, fopts, true, dopts)
 	if slide(&bz, &n, _n) && err != nil {
-		rv.Set(irvSet) // Helps with debugging
 		return
 	}
 
@@ -408,20 +724,14 @@ func (cdc *Codec) decodeReflectBinaryInterface(bz []byte, iinfo *TypeInfo, rv re
 		return
 	}
 
-	// We need to set here, for when !PointerPreferred and the type
-	// is say, an array of bytes (e.g. [32]byte), then we must call
-	// rv.Set() *after* the value was acquired.
-	// NOTE: rv.Set() should succeed because it was validated
-	// already during Register[Interface/Concrete].
-	rv.Set(irvSet)
-	return n, err
+	return irvSet, n, nil
 }
 
 // CONTRACT: rv.CanAddr() is true.
 func (cdc *Codec) decodeReflectBinaryByteArray(bz []byte, info *TypeInfo, rv reflect.Value,
 	fopts FieldOptions) (n int, err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectBinaryByteArray")
@@ -431,7 +741,7 @@ func (cdc *Codec) decodeReflectBinaryByteArray(bz []byte, info *TypeInfo, rv ref
 	}
 	ert := info.Type.Elem()
 	if ert.Kind() != reflect.Uint8 {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	length := info.Type.Len()
 	if len(bz) < length {
@@ -439,7 +749,7 @@ func (cdc *Codec) decodeReflectBinaryByteArray(bz []byte, info *TypeInfo, rv ref
 	}
 
 	// Read byte-length prefixed byteslice.
-	byteslice, _n, err := DecodeByteSlice(bz)
+	byteslice, _n, err := cdc.decodeByteSlice(bz)
 	if slide(&bz, &n, _n) && err != nil {
 		return
 	}
@@ -454,12 +764,78 @@ func (cdc *Codec) decodeReflectBinaryByteArray(bz []byte, info *TypeInfo, rv ref
 	return n, err
 }
 
+// Decodes an array written by encodeReflectBinarySparseArray.  Elements
+// which weren't written are zero.
+// CONTRACT: rv.CanAddr() is true.
+func (cdc *Codec) decodeReflectBinarySparseArray(bz []byte, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool, dopts decodeOptions) (n int, err error) {
+	if !rv.CanAddr() {
+		panic(internalError("rv not addressable"))
+	}
+	if printLog {
+		fmt.Println("(d) decodeReflectBinarySparseArray")
+		defer func() {
+			fmt.Printf("(d) -> err: %v\n", err)
+		}()
+	}
+	einfo, err := cdc.getTypeInfoWlock(info.Type.Elem())
+	if err != nil {
+		return
+	}
+	efopts := fopts
+	efopts.BinFieldNum = 1
+	efopts.Sparse = false
+
+	if !bare {
+		// Read byte-length prefixed byteslice.
+		var (
+			buf []byte
+			_n  int
+		)
+		buf, _n, err = cdc.decodeByteSlice(bz)
+		if slide(&bz, nil, _n) && err != nil {
+			return
+		}
+		// This is a trick for debuggability -- we slide on &n more later.
+		n += _n - len(buf)
+		bz = buf
+	}
+
+	rv.Set(reflect.Zero(info.Type))
+	var next uint64 // The smallest valid index.
+	for len(bz) > 0 {
+		var (
+			idx uint64
+			_n  int
+		)
+		idx, _n, err = cdc.decodeUvarint(bz)
+		if slide(&bz, &n, _n) && err != nil {
+			return
+		}
+		if idx >= uint64(rv.Len()) {
+			err = fmt.Errorf("sparse array index %v out of bounds for %v", idx, info.Type)
+			return
+		}
+		if idx < next {
+			err = fmt.Errorf("sparse array index %v out of order", idx)
+			return
+		}
+		next = idx + 1
+		_n, err = cdc.decodeReflectBinary(bz, einfo, rv.Index(int(idx)), efopts, false, dopts)
+		if slide(&bz, &n, _n) && err != nil {
+			err = fmt.Errorf("error reading sparse array contents: %v", err)
+			return
+		}
+	}
+	return
+}
+
 // CONTRACT: rv.CanAddr() is true.
 // NOTE: Keep the code structure similar to decodeReflectBinarySlice.
 func (cdc *Codec) decodeReflectBinaryArray(bz []byte, info *TypeInfo, rv reflect.Value,
-	fopts FieldOptions, bare bool) (n int, err error) {
+	fopts FieldOptions, bare bool, dopts decodeOptions) (n int, err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectBinaryArray")
@@ -469,7 +845,7 @@ func (cdc *Codec) decodeReflectBinaryArray(bz []byte, info *TypeInfo, rv reflect
 	}
 	ert := info.Type.Elem()
 	if ert.Kind() == reflect.Uint8 {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	length := info.Type.Len()
 	einfo, err := cdc.getTypeInfoWlock(ert)
@@ -483,25 +859,25 @@ func (cdc *Codec) decodeReflectBinaryArray(bz []byte, info *TypeInfo, rv reflect
 			buf []byte
 			_n  int
 		)
-		buf, _n, err = DecodeByteSlice(bz)
+		buf, _n, err = cdc.decodeByteSlice(bz)
 		if slide(&bz, nil, _n) && err != nil {
 			return
 		}
 		// This is a trick for debuggability -- we slide on &n more later.
-		n += UvarintSize(uint64(len(buf)))
+		n += _n - len(buf)
 		bz = buf
 	}
 
 	// If elem is not already a ByteLength type, read in packed form.
 	// This is a Proto wart due to Proto backwards compatibility issues.
 	// Amino2 will probably migrate to use the List typ3.
-	typ3 := typeToTyp3(einfo.Type, fopts)
+	typ3 := kindToTyp3(einfo.Type, fopts)
 	if typ3 != Typ3ByteLength {
 		// Read elements in packed form.
 		for i := 0; i < length; i++ {
 			erv := rv.Index(i)
 			var _n int
-			_n, err = cdc.decodeReflectBinary(bz, einfo, erv, fopts, false)
+			_n, err = cdc.decodeReflectBinary(bz, einfo, erv, fopts, false, dopts)
 			if slide(&bz, &n, _n) && err != nil {
 				err = fmt.Errorf("error reading array contents: %v", err)
 				return
@@ -532,7 +908,7 @@ func (cdc *Codec) decodeReflectBinaryArray(bz []byte, info *TypeInfo, rv reflect
 				typ  Typ3
 				_n   int
 			)
-			fnum, typ, _n, err = decodeFieldNumberAndTyp3(bz)
+			fnum, typ, _n, err = cdc.decodeFieldNumberAndTyp3(bz)
 			// Validate field number and typ3.
 			if fnum != fopts.BinFieldNum {
 				err = errors.New(fmt.Sprintf("expected repeated field number %v, got %v", fopts.BinFieldNum, fnum))
@@ -563,7 +939,7 @@ func (cdc *Codec) decodeReflectBinaryArray(bz []byte, info *TypeInfo, rv reflect
 			// In case of any inner lists in unpacked form.
 			efopts := fopts
 			efopts.BinFieldNum = 1
-			_n, err = cdc.decodeReflectBinary(bz, einfo, erv, efopts, false)
+			_n, err = cdc.decodeReflectBinary(bz, einfo, erv, efopts, false, dopts)
 			if slide(&bz, &n, _n) && err != nil {
 				err = fmt.Errorf("error reading array contents: %v", err)
 				return
@@ -574,7 +950,7 @@ func (cdc *Codec) decodeReflectBinaryArray(bz []byte, info *TypeInfo, rv reflect
 		// This is to provide better error messages.
 		if len(bz) > 0 {
 			var fnum uint32
-			fnum, _, _, err = decodeFieldNumberAndTyp3(bz)
+			fnum, _, _, err = cdc.decodeFieldNumberAndTyp3(bz)
 			if err != nil {
 				return
 			}
@@ -591,7 +967,7 @@ func (cdc *Codec) decodeReflectBinaryArray(bz []byte, info *TypeInfo, rv reflect
 func (cdc *Codec) decodeReflectBinaryByteSlice(bz []byte, info *TypeInfo, rv reflect.Value,
 	fopts FieldOptions) (n int, err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectByteSlice")
@@ -601,7 +977,7 @@ func (cdc *Codec) decodeReflectBinaryByteSlice(bz []byte, info *TypeInfo, rv ref
 	}
 	ert := info.Type.Elem()
 	if ert.Kind() != reflect.Uint8 {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	// If len(bz) == 0 the code below will err
 	if len(bz) == 0 {
@@ -614,7 +990,7 @@ func (cdc *Codec) decodeReflectBinaryByteSlice(bz []byte, info *TypeInfo, rv ref
 		byteslice []byte
 		_n        int
 	)
-	byteslice, _n, err = DecodeByteSlice(bz)
+	byteslice, _n, err = cdc.decodeByteSlice(bz)
 	if slide(&bz, &n, _n) && err != nil {
 		return
 	}
@@ -628,12 +1004,110 @@ func (cdc *Codec) decodeReflectBinaryByteSlice(bz []byte, info *TypeInfo, rv ref
 	return n, err
 }
 
+// Decodes the entries written by encodeReflectBinaryMap().
+// CONTRACT: rv.CanAddr() is true.
+func (cdc *Codec) decodeReflectBinaryMap(bz []byte, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool, dopts decodeOptions) (n int, err error) {
+	if !rv.CanAddr() {
+		panic(internalError("rv not addressable"))
+	}
+	if printLog {
+		fmt.Println("(d) decodeReflectBinaryMap")
+		defer func() {
+			fmt.Printf("(d) -> err: %v\n", err)
+		}()
+	}
+	_, einfo, err := cdc.getMapEntryTypeInfo(info)
+	if err != nil {
+		return
+	}
+
+	if !bare {
+		// Read byte-length prefixed byteslice.
+		var (
+			buf []byte
+			_n  int
+		)
+		buf, _n, err = cdc.decodeByteSlice(bz)
+		if slide(&bz, nil, _n) && err != nil {
+			return
+		}
+		// This is a trick for debuggability -- we slide on &n more later.
+		n += _n - len(buf)
+		bz = buf
+	}
+
+	// Read entries in unpacked form, into a map preallocated to hold them
+	// all, see countMapEntries().
+	var mrv = reflect.Zero(info.Type)
+	var size = countMapEntries(bz, fopts.BinFieldNum)
+	for len(bz) > 0 {
+		var (
+			typ  Typ3
+			_n   int
+			fnum uint32
+		)
+		fnum, typ, _n, err = cdc.decodeFieldNumberAndTyp3(bz)
+		if err != nil {
+			return
+		}
+		if fnum < fopts.BinFieldNum {
+			err = fmt.Errorf("expected repeated field number %v or greater, got %v", fopts.BinFieldNum, fnum)
+			return
+		}
+		if fnum > fopts.BinFieldNum {
+			break
+		}
+		if typ != Typ3ByteLength {
+			err = fmt.Errorf("expected repeated field type %v, got %v", Typ3ByteLength, typ)
+			return
+		}
+		slide(&bz, &n, _n)
+		var erv = reflect.New(einfo.Type).Elem()
+		_n, err = cdc.decodeReflectBinary(bz, einfo, erv, FieldOptions{BinFieldNum: 1}, false, dopts)
+		if slide(&bz, &n, _n) && err != nil {
+			err = fmt.Errorf("error reading map entry: %v", err)
+			return
+		}
+		if mrv.IsNil() {
+			mrv = reflect.MakeMapWithSize(info.Type, size)
+		}
+		if mrv.MapIndex(erv.Field(0)).IsValid() {
+			err = fmt.Errorf("duplicate key %v in %v", erv.Field(0), info.Type)
+			return
+		}
+		mrv.SetMapIndex(erv.Field(0), erv.Field(1))
+	}
+	rv.Set(mrv)
+	return n, err
+}
+
+// Returns the number of map entries at the start of bz, i.e. of consecutive
+// ByteLength fields numbered fnum, by skipping over their contents.  Counting
+// stops at the first other or malformed field, which is left to the decoder.
+func countMapEntries(bz []byte, fnum uint32) (count int) {
+	for len(bz) > 0 {
+		num, typ, _n, err := decodeFieldNumberAndTyp3(bz)
+		if err != nil || num != fnum || typ != Typ3ByteLength {
+			return
+		}
+		bz = bz[_n:]
+		length, _n, err := DecodeUvarint(bz)
+		if err != nil || length > uint64(len(bz)-_n) {
+			return
+		}
+		bz = bz[_n+int(length):]
+		count++
+	}
+	return
+}
+
 // CONTRACT: rv.CanAddr() is true.
 // NOTE: Keep the code structure similar to decodeReflectBinaryArray.
 func (cdc *Codec) decodeReflectBinarySlice(bz []byte, info *TypeInfo, rv reflect.Value,
-	fopts FieldOptions, bare bool) (n int, err error) {
+	fopts FieldOptions, bare bool, dopts decodeOptions) (n int, err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectBinarySlice")
@@ -643,7 +1117,7 @@ func (cdc *Codec) decodeReflectBinarySlice(bz []byte, info *TypeInfo, rv reflect
 	}
 	ert := info.Type.Elem()
 	if ert.Kind() == reflect.Uint8 {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	einfo, err := cdc.getTypeInfoWlock(ert)
 	if err != nil {
@@ -661,27 +1135,27 @@ func (cdc *Codec) decodeReflectBinarySlice(bz []byte, info *TypeInfo, rv reflect
 			buf []byte
 			_n  int
 		)
-		buf, _n, err = DecodeByteSlice(bz)
+		buf, _n, err = cdc.decodeByteSlice(bz)
 		if slide(&bz, nil, _n) && err != nil {
 			return
 		}
 		// This is a trick for debuggability -- we slide on &n more later.
-		n += UvarintSize(uint64(len(buf)))
+		n += _n - len(buf)
 		bz = buf
 	}
 
 	// If elem is not already a ByteLength type, read in packed form.
 	// This is a Proto wart due to Proto backwards compatibility issues.
 	// Amino2 will probably migrate to use the List typ3.
-	typ3 := typeToTyp3(einfo.Type, fopts)
+	typ3 := kindToTyp3(einfo.Type, fopts)
 	if typ3 != Typ3ByteLength {
 		// Read elems in packed form.
 		for {
 			if len(bz) == 0 {
 				break
 			}
-			erv, _n := reflect.New(ert).Elem(), int(0)
-			_n, err = cdc.decodeReflectBinary(bz, einfo, erv, fopts, false)
+			erv, _n := cdc.newValue(ert).Elem(), int(0)
+			_n, err = cdc.decodeReflectBinary(bz, einfo, erv, fopts, false, dopts)
 			if slide(&bz, &n, _n) && err != nil {
 				err = fmt.Errorf("error reading array contents: %v", err)
 				return
@@ -712,7 +1186,7 @@ func (cdc *Codec) decodeReflectBinarySlice(bz []byte, info *TypeInfo, rv reflect
 				_n   int
 				fnum uint32
 			)
-			fnum, typ, _n, err = decodeFieldNumberAndTyp3(bz)
+			fnum, typ, _n, err = cdc.decodeFieldNumberAndTyp3(bz)
 			// Validate field number and typ3.
 			if fnum < fopts.BinFieldNum {
 				err = errors.New(fmt.Sprintf("expected repeated field number %v or greater, got %v", fopts.BinFieldNum, fnum))
@@ -729,7 +1203,7 @@ func (cdc *Codec) decodeReflectBinarySlice(bz []byte, info *TypeInfo, rv reflect
 				return
 			}
 			// Decode the next ByteLength bytes into erv.
-			erv, _n := reflect.New(ert).Elem(), int(0)
+			erv, _n := cdc.newValue(ert).Elem(), int(0)
 			// Special case if:
 			//  * next ByteLength bytes are 0x00, and
 			//  * - erv is not a struct pointer, or
@@ -747,7 +1221,7 @@ func (cdc *Codec) decodeReflectBinarySlice(bz []byte, info *TypeInfo, rv reflect
 			// In case of any inner lists in unpacked form.
 			efopts := fopts
 			efopts.BinFieldNum = 1
-			_n, err = cdc.decodeReflectBinary(bz, einfo, erv, efopts, false)
+			_n, err = cdc.decodeReflectBinary(bz, einfo, erv, efopts, false, dopts)
 			if slide(&bz, &n, _n) && err != nil {
 				err = fmt.Errorf("error reading array contents: %v", err)
 				return
@@ -761,9 +1235,9 @@ func (cdc *Codec) decodeReflectBinarySlice(bz []byte, info *TypeInfo, rv reflect
 
 // CONTRACT: rv.CanAddr() is true.
 func (cdc *Codec) decodeReflectBinaryStruct(bz []byte, info *TypeInfo, rv reflect.Value,
-	_ FieldOptions, bare bool) (n int, err error) {
+	fopts FieldOptions, bare bool, dopts decodeOptions) (n int, err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectBinaryStruct")
@@ -779,12 +1253,12 @@ func (cdc *Codec) decodeReflectBinaryStruct(bz []byte, info *TypeInfo, rv reflec
 	if !bare {
 		// Read byte-length prefixed byteslice.
 		var buf []byte
-		buf, _n, err = DecodeByteSlice(bz)
+		buf, _n, err = cdc.decodeByteSlice(bz)
 		if slide(&bz, nil, _n) && err != nil {
 			return
 		}
 		// This is a trick for debuggability -- we slide on &n more later.
-		n += UvarintSize(uint64(len(buf)))
+		n += _n - len(buf)
 		bz = buf
 	}
 
@@ -793,35 +1267,66 @@ func (cdc *Codec) decodeReflectBinaryStruct(bz []byte, info *TypeInfo, rv reflec
 	case timeType:
 		// Special case: time.Time
 		var t time.Time
-		t, _n, err = DecodeTime(bz)
+		t, _n, err = cdc.decodeTime(bz, fopts.TimeNanos)
 		if slide(&bz, &n, _n) && err != nil {
 			return
 		}
+		if fopts.TimeSeconds {
+			t = t.Truncate(time.Second)
+		}
 		rv.Set(reflect.ValueOf(t))
 
 	default:
 		// Track the last seen field number.
 		var lastFieldNum uint32
+		// The union payload, decoded once its kind is known.
+		var unionField FieldInfo
+		var unionPayload reflect.Value
 		// Read each field.
 		for _, field := range info.Fields {
 			// Get field rv and info.
-			var frv = rv.Field(field.Index)
+			var frv = field.valueOf(rv)
+			var ftype = field.Type
+			if field.WrapperType != nil {
+				// Decode the wrapper struct instead, see wrapperType().
+				frv.Set(reflect.Zero(ftype))
+				ftype = reflect.PtrTo(field.WrapperType)
+				frv = reflect.New(ftype).Elem()
+			} else if field.isUnionPayload() {
+				// Decode the bare payload bytes instead, see unionFieldValue().
+				frv.Set(reflect.Zero(ftype))
+				ftype = bytesType
+				frv = reflect.New(ftype).Elem()
+				unionField, unionPayload = field, frv
+			} else if field.DynamicResolver != nil {
+				// Decode the wire form instead, see encodeDynamicAny().
+				frv.Set(reflect.Zero(ftype))
+				ftype = reflect.PtrTo(dynamicAnyType)
+				frv = reflect.New(ftype).Elem()
+			}
 			var finfo *TypeInfo
-			finfo, err = cdc.getTypeInfoWlock(field.Type)
+			finfo, err = cdc.getTypeInfoWlock(ftype)
 			if err != nil {
 				return
 			}
 
+			// Skip unknown fields numbered below this one, e.g. of
+			// fields removed from between `amino:"field=N"` fields.
+			_n, err = cdc.skipFieldsBelow(bz, info, field.BinFieldNum, &lastFieldNum)
+			if slide(&bz, &n, _n) && err != nil {
+				return
+			}
+
 			// We're done if we've consumed all the bytes.
 			if len(bz) == 0 {
 				frv.Set(defaultValue(frv.Type()))
 				continue
 			}
 
-			if field.UnpackedList {
+			if field.UnpackedList && field.FieldCodec == nil {
 				// This is a list that was encoded unpacked, e.g.
 				// with repeated field entries for each list item.
-				_n, err = cdc.decodeReflectBinary(bz, finfo, frv, field.FieldOptions, true)
+				_n, err = cdc.decodeReflectBinary(bz, finfo, frv, field.FieldOptions, true, dopts)
 				if slide(&bz, &n, _n) && err != nil {
 					return
 				}
@@ -831,7 +1336,7 @@ func (cdc *Codec) decodeReflectBinaryStruct(bz []byte, info *TypeInfo, rv reflec
 					fnum uint32
 					typ  Typ3
 				)
-				fnum, typ, _n, err = decodeFieldNumberAndTyp3(bz)
+				fnum, typ, _n, err = cdc.decodeFieldNumberAndTyp3(bz)
 				if field.BinFieldNum < fnum {
 					// Set zero field value.
 					frv.Set(defaultValue(frv.Type()))
@@ -857,17 +1362,58 @@ func (cdc *Codec) decodeReflectBinaryStruct(bz []byte, info *TypeInfo, rv reflec
 						field.BinFieldNum, info.Type, fnum))
 					return
 				}
-				typWanted := typeToTyp3(finfo.Type, field.FieldOptions)
-				if typ != typWanted {
+				typWanted := typeToTyp3(finfo, field.FieldOptions)
+				if field.FieldCodec != nil {
+					typWanted = Typ3ByteLength // See encodeFieldCodecField().
+				}
+				var coerce = typ != typWanted && cdc.numericCoercion && isCoercibleInt(frv.Type(), finfo, typ)
+				if typ != typWanted && !coerce {
 					err = errors.New(fmt.Sprintf("expected field type %v for # %v of %v, got %v",
 						typWanted, fnum, info.Type, typ))
 					return
 				}
 				// Decode field into frv.
-				_n, err = cdc.decodeReflectBinary(bz, finfo, frv, field.FieldOptions, false)
+				if field.FieldCodec != nil {
+					_n, err = decodeFieldCodecValue(bz, field, frv, dopts)
+				} else if coerce {
+					// See SetNumericCoercion().
+					_n, err = cdc.decodeCoercedInt(bz, typ, frv, field.FieldOptions)
+				} else {
+					_n, err = cdc.decodeReflectBinary(bz, finfo, frv, field.FieldOptions, false, dopts)
+				}
+				if err == nil && _n == 1 && typ == Typ3ByteLength && cdc.emptyStructNil && isStructPointerField(field, finfo) {
+					// Zero length, see SetEmptyStructPointersNil().
+					frv.Set(reflect.Zero(frv.Type()))
+				}
 				if slide(&bz, &n, _n) && err != nil {
 					return
 				}
+				if field.WrapperType != nil && !frv.IsNil() {
+					var vrv = reflect.New(field.Type.Elem())
+					vrv.Elem().Set(frv.Elem().Field(0))
+					field.valueOf(rv).Set(vrv)
+				}
+				if field.DynamicResolver != nil && !frv.IsNil() {
+					var irvSet reflect.Value
+					irvSet, err = cdc.decodeDynamicAny(field, frv.Elem().Interface().(dynamicAny), dopts)
+					if err != nil {
+						return
+					}
+					field.valueOf(rv).Set(irvSet)
+				}
+			}
+		}
+		if unionPayload.IsValid() {
+			err = cdc.decodeUnionPayload(unionField, rv, unionPayload.Bytes(), dopts)
+			if err != nil {
+				return
+			}
+		}
+
+		// Missing and empty slices are both nil, unless `amino:"nil_as_empty"`.
+		for _, field := range info.Fields {
+			if frv := field.valueOf(rv); nilAsEmpty(field, frv) && frv.IsNil() {
+				frv.Set(reflect.MakeSlice(frv.Type(), 0, 0))
 			}
 		}
 
@@ -877,7 +1423,8 @@ func (cdc *Codec) decodeReflectBinaryStruct(bz []byte, info *TypeInfo, rv reflec
 			typ3 Typ3
 		)
 		for len(bz) > 0 {
-			fnum, typ3, _n, err = decodeFieldNumberAndTyp3(bz)
+			var left = len(bz)
+			fnum, typ3, _n, err = cdc.decodeFieldNumberAndTyp3(bz)
 			if slide(&bz, &n, _n) && err != nil {
 				return
 			}
@@ -887,6 +1434,15 @@ func (cdc *Codec) decodeReflectBinaryStruct(bz []byte, info *TypeInfo, rv reflec
 				return
 			}
 			lastFieldNum = fnum
+			// Virtual fields are written by this codec, so are expected.
+			if cdc.strictNesting && !hasVirtualField(info, fnum) {
+				err = fmt.Errorf("%v bytes left over after reading fields of %v", left, info.Type)
+				return
+			}
+			if cdc.rejectUnknown && !hasVirtualField(info, fnum) {
+				err = fmt.Errorf("unknown field # %v of %v", fnum, info.Type)
+				return
+			}
 
 			_n, err = consumeAny(typ3, bz)
 			if slide(&bz, &n, _n) && err != nil {
@@ -897,6 +1453,58 @@ func (cdc *Codec) decodeReflectBinaryStruct(bz []byte, info *TypeInfo, rv reflec
 	return n, err
 }
 
+// Consumes the fields of struct info numbered below fnumNext, which are
+// unknown, and updates lastFieldNum.
+func (cdc *Codec) skipFieldsBelow(bz []byte, info *TypeInfo, fnumNext uint32,
+	lastFieldNum *uint32) (n int, err error) {
+	for len(bz) > 0 {
+		var (
+			fnum uint32
+			typ3 Typ3
+			_n   int
+		)
+		fnum, typ3, _n, err = cdc.decodeFieldNumberAndTyp3(bz)
+		if err != nil || fnum >= fnumNext {
+			// Do not slide, the field is read again.
+			return n, err
+		}
+		if fnum <= *lastFieldNum {
+			err = fmt.Errorf("encountered fieldnNum: %v, but we have already seen fnum: %v\nbytes:%X",
+				fnum, *lastFieldNum, bz)
+			return
+		}
+		*lastFieldNum = fnum
+		if cdc.rejectUnknown && !hasVirtualField(info, fnum) {
+			err = fmt.Errorf("unknown field # %v of %v", fnum, info.Type)
+			return
+		}
+		slide(&bz, &n, _n)
+		_n, err = consumeAny(typ3, bz)
+		if slide(&bz, &n, _n) && err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns true if the struct info has a virtual field numbered fnum.
+func hasVirtualField(info *TypeInfo, fnum uint32) bool {
+	for _, vfield := range info.VirtualFields {
+		if vfield.BinFieldNum == fnum {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the field is a pointer to the struct finfo, which is
+// encoded as such.
+func isStructPointerField(field FieldInfo, finfo *TypeInfo) bool {
+	return field.Type.Kind() == reflect.Ptr && finfo.Type.Kind() == reflect.Struct && finfo.Type != timeType &&
+		!finfo.IsAminoUnmarshaler && field.WrapperType == nil && field.DynamicResolver == nil &&
+		field.FieldCodec == nil && field.union == nil
+}
+
 //----------------------------------------
 // consume* for skipping struct fields
 
@@ -976,3 +1584,87 @@ func decodeFieldNumberAndTyp3(bz []byte) (num uint32, typ Typ3, n int, err error
 	num = uint32(num64)
 	return
 }
+
+//----------------------------------------
+// Canonical varints, see SetRejectNonCanonicalVarint().
+
+// Returns ErrNonCanonicalVarint if n bytes were read for a value whose
+// minimal encoding is size bytes long, unless lenient.
+func (cdc *Codec) checkCanonical(n, size int) error {
+	if n != size && !cdc.lenientVarints {
+		return ErrNonCanonicalVarint
+	}
+	return nil
+}
+
+func (cdc *Codec) decodeUvarint(bz []byte) (u uint64, n int, err error) {
+	u, n, err = DecodeUvarint(bz)
+	if err == nil {
+		err = cdc.checkCanonical(n, UvarintSize(u))
+	}
+	return
+}
+
+func (cdc *Codec) decodeVarint(bz []byte) (i int64, n int, err error) {
+	i, n, err = DecodeVarint(bz)
+	if err == nil {
+		err = cdc.checkCanonical(n, VarintSize(i))
+	}
+	return
+}
+
+func (cdc *Codec) decodeByteSlice(bz []byte) (bz2 []byte, n int, err error) {
+	bz2, n, err = DecodeByteSlice(bz)
+	if err == nil {
+		err = cdc.checkCanonical(n, ByteSliceSize(bz2))
+	}
+	return
+}
+
+func (cdc *Codec) decodeString(bz []byte) (s string, n int, err error) {
+	if cdc.zeroCopyStrings {
+		return cdc.decodeStringZeroCopy(bz)
+	}
+	s, n, err = DecodeString(bz)
+	if err == nil {
+		err = cdc.checkCanonical(n, UvarintSize(uint64(len(s)))+len(s))
+	}
+	return
+}
+
+func (cdc *Codec) decodeFieldNumberAndTyp3(bz []byte) (num uint32, typ Typ3, n int, err error) {
+	num, typ, n, err = decodeFieldNumberAndTyp3(bz)
+	if err == nil {
+		err = cdc.checkCanonical(n, fieldKeySize(num, typ))
+	}
+	return
+}
+
+// Like DecodeString, but the string points into bz, see SetZeroCopyStrings().
+func (cdc *Codec) decodeStringZeroCopy(bz []byte) (s string, n int, err error) {
+	count, n, err := cdc.decodeUvarint(bz)
+	if err != nil {
+		return
+	}
+	if count > uint64(len(bz)-n) {
+		err = fmt.Errorf("insufficient bytes decoding string of length %v", count)
+		return
+	}
+	if count > 0 {
+		s = unsafeBytesToString(bz[n : n+int(count)])
+	}
+	n += int(count)
+	return
+}
+
+// The varints of a time are checked by the size of its canonical encoding.
+func (cdc *Codec) decodeTime(bz []byte, strict bool) (t time.Time, n int, err error) {
+	t, n, err = decodeTime(bz, strict)
+	if err == nil && !cdc.lenientVarints {
+		var cw countWriter
+		if EncodeTime(&cw, t) == nil {
+			err = cdc.checkCanonical(n, int(cw.n))
+		}
+	}
+	return
+}
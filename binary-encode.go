@@ -6,7 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -15,6 +18,53 @@ import (
 //----------------------------------------
 // cdc.encodeReflectBinary
 
+// Per-call options for binary encoding, passed down to all encode methods.
+// The zero value is used by MarshalBinaryBare.
+type encodeOptions struct {
+	MaxSize   int    // If > 0, see MarshalBinaryMaxSize.
+	Version   uint32 // If Versioned, see MarshalBinaryForVersion.
+	Versioned bool
+}
+
+// Returns true if field is omitted since it is newer than the version being
+// encoded, see MarshalBinaryForVersion.
+func (eopts encodeOptions) omits(field FieldInfo) bool {
+	return eopts.Versioned && field.SinceVersion > eopts.Version
+}
+
+// Returns ErrMaxSizeExceeded if buf is larger than the max size.  Since all
+// nested buffers end up in the output, it suffices to check each of them.
+func (eopts encodeOptions) checkSize(buf *bytes.Buffer) error {
+	if eopts.MaxSize > 0 && buf.Len() > eopts.MaxSize {
+		return ErrMaxSizeExceeded
+	}
+	return nil
+}
+
+// Buffers for encoding nested values, which are written to the output and
+// so needn't be kept, see getBuffer().
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Buffers which have grown larger than this aren't pooled, so that encoding
+// a large value once doesn't pin its memory.
+const maxPooledBufferSize = 64 * 1024
+
+// Returns an empty buffer from bufferPool, which should be returned with
+// putBuffer() once its bytes are no longer referenced.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
 // This is the main entrypoint for encoding all types in binary form.  This
 // function calls encodeReflectBinary*, and generally those functions should
 // only call this one, for the prefix bytes are only written here.
@@ -23,12 +73,12 @@ import (
 // CONTRACT: rv is not a pointer
 // CONTRACT: rv is valid.
 func (cdc *Codec) encodeReflectBinary(w io.Writer, info *TypeInfo, rv reflect.Value,
-	fopts FieldOptions, bare bool) (err error) {
+	fopts FieldOptions, bare bool, eopts encodeOptions) (err error) {
 	if rv.Kind() == reflect.Ptr {
-		panic("not allowed to be called with a reflect.Ptr")
+		panic(internalError("not allowed to be called with a reflect.Ptr"))
 	}
 	if !rv.IsValid() {
-		panic("not allowed to be called with invalid / zero Value")
+		panic(internalError("not allowed to be called with invalid / zero Value"))
 	}
 	if printLog {
 		spew.Printf("(E) encodeReflectBinary(info: %v, rv: %#v (%v), fopts: %v)\n",
@@ -52,7 +102,84 @@ func (cdc *Codec) encodeReflectBinary(w io.Writer, info *TypeInfo, rv reflect.Va
 			return
 		}
 		// Then, encode the repr instance.
-		err = cdc.encodeReflectBinary(w, rinfo, rrv, fopts, bare)
+		err = cdc.encodeReflectBinary(w, rinfo, rrv, fopts, bare, eopts)
+		return
+	}
+
+	// Special case: pre-encoded bytes, see RegisterRawType().
+	if info.Raw {
+		if bare {
+			_, err = w.Write(rv.Field(0).Bytes())
+		} else {
+			err = EncodeByteSlice(w, rv.Field(0).Bytes())
+		}
+		return
+	}
+
+	// Special case: encoding.BinaryMarshaler, see isBinaryMarshalerType().
+	if info.IsBinaryMarshaler {
+		var bz []byte
+		bz, err = marshalBinary(rv)
+		if err != nil {
+			return
+		}
+		if bare {
+			_, err = w.Write(bz)
+		} else {
+			err = EncodeByteSlice(w, bz)
+		}
+		return
+	}
+
+	// Special case: time.Time as milliseconds, see unixMillis().
+	if info.Type == timeType && fopts.UnixMillis {
+		err = EncodeUvarint(w, uint64(unixMillis(rv.Interface().(time.Time))))
+		return
+	}
+
+	// Special case: time.Time as days, see dateOnlyDays().
+	if info.Type == timeType && fopts.DateOnly {
+		err = EncodeUvarint(w, uint64(dateOnlyDays(rv.Interface().(time.Time))))
+		return
+	}
+
+	// Special case: json.Number, see jsonNumberRepr.
+	if info.Type == jsonNumberType {
+		var rinfo *TypeInfo
+		rinfo, err = cdc.getTypeInfoWlock(jsonNumberReprType)
+		if err != nil {
+			return
+		}
+		rrv := reflect.ValueOf(toJSONNumberRepr(rv.String()))
+		err = cdc.encodeReflectBinary(w, rinfo, rrv, fopts, bare, eopts)
+		return
+	}
+
+	// Special case: compressed strings and byte slices, see gzipPayload().
+	if fopts.Gzip && isGzipKind(info.Type) {
+		var payload []byte
+		if rv.Kind() == reflect.String {
+			payload, err = gzipPayload([]byte(rv.String()))
+		} else {
+			payload, err = gzipPayload(rv.Bytes())
+		}
+		if err != nil {
+			return
+		}
+		err = EncodeByteSlice(w, payload)
+		return
+	}
+
+	// Handle custom integer encoding, see RegisterIntCodec().
+	if usesIntCodec(info, fopts) {
+		var u uint64
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			u = uint64(rv.Int())
+		default:
+			u = rv.Uint()
+		}
+		err = EncodeByteSlice(w, info.IntEncoder(u))
 		return
 	}
 
@@ -62,10 +189,12 @@ func (cdc *Codec) encodeReflectBinary(w io.Writer, info *TypeInfo, rv reflect.Va
 	// Complex
 
 	case reflect.Interface:
-		err = cdc.encodeReflectBinaryInterface(w, info, rv, fopts, bare)
+		err = cdc.encodeReflectBinaryInterface(w, info, rv, fopts, bare, eopts)
 
 	case reflect.Array:
-		if info.Type.Elem().Kind() == reflect.Uint8 {
+		if fopts.Sparse {
+			err = cdc.encodeReflectBinarySparseArray(w, info, rv, fopts, bare, eopts)
+		} else if info.Type.Elem().Kind() == reflect.Uint8 {
 			err = cdc.encodeReflectBinaryByteArray(w, info, rv, fopts)
 		} else if kind := info.Type.Elem().Kind(); kind == reflect.Slice || kind == reflect.Array {
 			// for proto3 compatibility, we do not allow multidimensional arrays,
@@ -84,10 +213,17 @@ func (cdc *Codec) encodeReflectBinary(w io.Writer, info *TypeInfo, rv reflect.Va
 					break
 				}
 			}
+			if err == nil {
+				// e.g. [N][]byte, encoded as repeated bytes.
+				err = cdc.encodeReflectBinaryList(w, info, rv, fopts, bare, eopts)
+			}
 		} else {
-			err = cdc.encodeReflectBinaryList(w, info, rv, fopts, bare)
+			err = cdc.encodeReflectBinaryList(w, info, rv, fopts, bare, eopts)
 		}
 
+	case reflect.Map:
+		err = cdc.encodeReflectBinaryMap(w, info, rv, fopts, bare, eopts)
+
 	case reflect.Slice:
 		switch info.Type.Elem().Kind() {
 
@@ -108,12 +244,16 @@ func (cdc *Codec) encodeReflectBinary(w io.Writer, info *TypeInfo, rv reflect.Va
 				err = errors.New("multidimensional slices not allowed")
 				break
 			}
+			if err == nil {
+				// e.g. [][]byte, encoded as repeated bytes.
+				err = cdc.encodeReflectBinaryList(w, info, rv, fopts, bare, eopts)
+			}
 		default:
-			err = cdc.encodeReflectBinaryList(w, info, rv, fopts, bare)
+			err = cdc.encodeReflectBinaryList(w, info, rv, fopts, bare, eopts)
 		}
 
 	case reflect.Struct:
-		err = cdc.encodeReflectBinaryStruct(w, info, rv, fopts, bare)
+		err = cdc.encodeReflectBinaryStruct(w, info, rv, fopts, bare, eopts)
 
 	//----------------------------------------
 	// Signed
@@ -201,7 +341,7 @@ func (cdc *Codec) encodeReflectBinary(w io.Writer, info *TypeInfo, rv reflect.Va
 }
 
 func (cdc *Codec) encodeReflectBinaryInterface(w io.Writer, iinfo *TypeInfo, rv reflect.Value,
-	fopts FieldOptions, bare bool) (err error) {
+	fopts FieldOptions, bare bool, eopts encodeOptions) (err error) {
 	if printLog {
 		fmt.Println("(e) encodeReflectBinaryInterface")
 		defer func() {
@@ -219,7 +359,7 @@ func (cdc *Codec) encodeReflectBinaryInterface(w io.Writer, iinfo *TypeInfo, rv
 	var crv, isPtr, isNilPtr = derefPointers(rv.Elem())
 	if isPtr && crv.Kind() == reflect.Interface {
 		// See "MARKER: No interface-pointers" in codec.go
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	if isNilPtr {
 		panic(fmt.Sprintf("Illegal nil-pointer of type %v for registered interface %v. "+
@@ -234,38 +374,80 @@ func (cdc *Codec) encodeReflectBinaryInterface(w io.Writer, iinfo *TypeInfo, rv
 		return
 	}
 	if !cinfo.Registered {
-		err = fmt.Errorf("cannot encode unregistered concrete type %v", crt)
+		var ok bool
+		if crv, cinfo, ok = cdc.toStdError(iinfo, rv); !ok {
+			err = ErrUnregisteredConcrete{crt}
+			return
+		}
+	}
+	if err = checkOneof(iinfo, cinfo); err != nil {
 		return
 	}
 
 	// For Proto3 compatibility, encode interfaces as ByteLength.
-	buf := bytes.NewBuffer(nil)
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	// The concrete type may be implied, see SetOmitAnyTypeWhenUnique().
+	uinfo, implied := cdc.getUniqueImplementerWlock(iinfo)
+	implied = implied && uinfo == cinfo
+	if implied {
+		// Nothing to write.
+	} else if cdc.indexedAny {
+		// Write the index instead, see SetIndexedAnyMode().
+		if err = cdc.writeIndexedAnyPrefix(buf, cinfo); err != nil {
+			return
+		}
+	} else {
+		// Write disambiguation bytes if needed.
+		needDisamb := false
+		if iinfo.AlwaysDisambiguate {
+			needDisamb = true
+		} else if len(iinfo.Implementers[cinfo.Prefix]) > 1 {
+			needDisamb = true
+		}
+		if needDisamb {
+			_, err = buf.Write(append([]byte{0x00}, cinfo.Disamb[:]...))
+			if err != nil {
+				return
+			}
+		}
 
-	// Write disambiguation bytes if needed.
-	needDisamb := false
-	if iinfo.AlwaysDisambiguate {
-		needDisamb = true
-	} else if len(iinfo.Implementers[cinfo.Prefix]) > 1 {
-		needDisamb = true
-	}
-	if needDisamb {
-		_, err = buf.Write(append([]byte{0x00}, cinfo.Disamb[:]...))
+		// Write prefix bytes.
+		_, err = buf.Write(cinfo.Prefix.Bytes())
 		if err != nil {
 			return
 		}
 	}
 
-	// Write prefix bytes.
-	_, err = buf.Write(cinfo.Prefix.Bytes())
-	if err != nil {
-		return
+	// Write the field key of non-struct values as the decoder expects,
+	// see decodeReflectBinaryConcrete().
+	isKnownType := (cinfo.Type.Kind() != reflect.Map) && (cinfo.Type.Kind() != reflect.Func)
+	if !isStructOrRepeatedStruct(cinfo) &&
+		!isPointerToStructOrToRepeatedStruct(crv, cinfo.Type) &&
+		isKnownType &&
+		fopts.BinFieldNum == 1 {
+		err = encodeFieldNumberAndTyp3(buf, 1, typeToTyp3(cinfo, FieldOptions{}))
+		if err != nil {
+			return
+		}
 	}
 
 	// Write actual concrete value.
-	err = cdc.encodeReflectBinary(buf, cinfo, crv, fopts, true)
+	err = cdc.encodeReflectBinary(buf, cinfo, crv, fopts, true, eopts)
 	if err != nil {
 		return
 	}
+	if implied && buf.Len() == 0 {
+		// Tell the value from nil, see SetOmitAnyTypeWhenUnique().
+		err = buf.WriteByte(0x00)
+		if err != nil {
+			return
+		}
+	}
+	if err = eopts.checkSize(buf); err != nil {
+		return
+	}
 
 	if bare {
 		// Write byteslice without byte-length prefixing.
@@ -281,7 +463,7 @@ func (cdc *Codec) encodeReflectBinaryByteArray(w io.Writer, info *TypeInfo, rv r
 	fopts FieldOptions) (err error) {
 	ert := info.Type.Elem()
 	if ert.Kind() != reflect.Uint8 {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	length := info.Type.Len()
 
@@ -300,7 +482,7 @@ func (cdc *Codec) encodeReflectBinaryByteArray(w io.Writer, info *TypeInfo, rv r
 }
 
 func (cdc *Codec) encodeReflectBinaryList(w io.Writer, info *TypeInfo, rv reflect.Value,
-	fopts FieldOptions, bare bool) (err error) {
+	fopts FieldOptions, bare bool, eopts encodeOptions) (err error) {
 	if printLog {
 		fmt.Println("(e) encodeReflectBinaryList")
 		defer func() {
@@ -309,7 +491,7 @@ func (cdc *Codec) encodeReflectBinaryList(w io.Writer, info *TypeInfo, rv reflec
 	}
 	ert := info.Type.Elem()
 	if ert.Kind() == reflect.Uint8 {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	einfo, err := cdc.getTypeInfoWlock(ert)
 	if err != nil {
@@ -318,22 +500,26 @@ func (cdc *Codec) encodeReflectBinaryList(w io.Writer, info *TypeInfo, rv reflec
 
 	// Proto3 byte-length prefixing incurs alloc cost on the encoder.
 	// Here we incur it for unpacked form for ease of dev.
-	buf := bytes.NewBuffer(nil)
+	buf := getBuffer()
+	defer putBuffer(buf)
 
 	// If elem is not already a ByteLength type, write in packed form.
 	// This is a Proto wart due to Proto backwards compatibility issues.
 	// Amino2 will probably migrate to use the List typ3.  Please?  :)
-	typ3 := typeToTyp3(einfo.Type, fopts)
+	typ3 := kindToTyp3(einfo.Type, fopts)
 	if typ3 != Typ3ByteLength {
 		// Write elems in packed form.
 		for i := 0; i < rv.Len(); i++ {
 			// Get dereferenced element value (or zero).
 			var erv, _, _ = derefPointersZero(rv.Index(i))
 			// Write the element value.
-			err = cdc.encodeReflectBinary(buf, einfo, erv, fopts, false)
+			err = cdc.encodeReflectBinary(buf, einfo, erv, fopts, false, eopts)
 			if err != nil {
 				return
 			}
+			if err = eopts.checkSize(buf); err != nil {
+				return
+			}
 		}
 	} else { // typ3 == Typ3ByteLength
 		// NOTE: ert is for the element value, while einfo.Type is dereferenced.
@@ -360,6 +546,12 @@ func (cdc *Codec) encodeReflectBinaryList(w io.Writer, info *TypeInfo, rv reflec
 					// which would enable the encoding of nil structs.
 					return errors.New("nil struct pointers not supported when empty_elements field tag is set")
 				}
+				if ert.Kind() == reflect.Interface && !fopts.EmptyElements {
+					// Likewise, nil interface values are only written
+					// (as empty) when explicitly allowed.
+					return fmt.Errorf("nil element %v of %v not supported unless empty_elements field tag is set",
+						i, info.Type)
+				}
 				// Nothing to encode, so the length is 0.
 				err = EncodeByte(buf, byte(0x00))
 				if err != nil {
@@ -370,11 +562,133 @@ func (cdc *Codec) encodeReflectBinaryList(w io.Writer, info *TypeInfo, rv reflec
 				// In case of any inner lists in unpacked form.
 				efopts := fopts
 				efopts.BinFieldNum = 1
-				err = cdc.encodeReflectBinary(buf, einfo, erv, efopts, false)
-				if err != nil {
+				err = cdc.encodeReflectBinary(buf, einfo, erv, efopts, false, eopts)
+				if err != nil && ert.Kind() == reflect.Interface {
+					return fmt.Errorf("cannot encode element %v of %v: %v", i, info.Type, err)
+				} else if err != nil {
 					return
 				}
 			}
+			if err = eopts.checkSize(buf); err != nil {
+				return
+			}
+		}
+	}
+
+	if bare {
+		// Write byteslice without byte-length prefixing.
+		_, err = w.Write(buf.Bytes())
+	} else {
+		// Write byte-length prefixed byteslice.
+		err = EncodeByteSlice(w, buf.Bytes())
+	}
+	return err
+}
+
+// Writes the entries of the map rv like the elements of an unpacked list,
+// each as a struct with the key as field 1 and the value as field 2, see
+// mapEntryType().  The entries are sorted by the encoding of their keys,
+// so equal maps are always encoded the same.
+func (cdc *Codec) encodeReflectBinaryMap(w io.Writer, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool, eopts encodeOptions) (err error) {
+	if printLog {
+		fmt.Println("(e) encodeReflectBinaryMap")
+		defer func() {
+			fmt.Printf("(e) -> err: %v\n", err)
+		}()
+	}
+	kinfo, einfo, err := cdc.getMapEntryTypeInfo(info)
+	if err != nil {
+		return
+	}
+
+	// Encode each entry along with its key.
+	type encodedEntry struct {
+		key, entry []byte
+	}
+	var entries = make([]encodedEntry, 0, rv.Len())
+	var erv = reflect.New(einfo.Type).Elem()
+	for _, krv := range rv.MapKeys() {
+		erv.Field(0).Set(krv)
+		erv.Field(1).Set(rv.MapIndex(krv))
+		kbuf, ebuf := new(bytes.Buffer), new(bytes.Buffer)
+		err = cdc.encodeReflectBinary(kbuf, kinfo, krv, FieldOptions{}, false, eopts)
+		if err != nil {
+			return
+		}
+		err = cdc.encodeReflectBinary(ebuf, einfo, erv, FieldOptions{BinFieldNum: 1}, false, eopts)
+		if err != nil {
+			return
+		}
+		entries = append(entries, encodedEntry{kbuf.Bytes(), ebuf.Bytes()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+
+	// Write entries in unpacked form.
+	buf := getBuffer()
+	defer putBuffer(buf)
+	for _, entry := range entries {
+		err = encodeFieldNumberAndTyp3(buf, fopts.BinFieldNum, Typ3ByteLength)
+		if err != nil {
+			return
+		}
+		buf.Write(entry.entry)
+		if err = eopts.checkSize(buf); err != nil {
+			return
+		}
+	}
+
+	if bare {
+		// Write byteslice without byte-length prefixing.
+		_, err = w.Write(buf.Bytes())
+	} else {
+		// Write byte-length prefixed byteslice.
+		err = EncodeByteSlice(w, buf.Bytes())
+	}
+	return err
+}
+
+// Writes only the non-zero elements of the array rv, each as its index (a
+// uvarint) followed by the element as written in a packed list, or for
+// ByteLength elements, with its byte-length prefix.  See `amino:"sparse"`.
+func (cdc *Codec) encodeReflectBinarySparseArray(w io.Writer, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool, eopts encodeOptions) (err error) {
+	if printLog {
+		fmt.Println("(e) encodeReflectBinarySparseArray")
+		defer func() {
+			fmt.Printf("(e) -> err: %v\n", err)
+		}()
+	}
+	ert := info.Type.Elem()
+	if kind := ert.Kind(); kind == reflect.Slice || kind == reflect.Array {
+		return errors.New("sparse arrays of lists not supported")
+	}
+	einfo, err := cdc.getTypeInfoWlock(ert)
+	if err != nil {
+		return
+	}
+	efopts := fopts
+	efopts.BinFieldNum = 1
+	efopts.Sparse = false
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	zero := reflect.Zero(ert).Interface()
+	for i := 0; i < rv.Len(); i++ {
+		if reflect.DeepEqual(rv.Index(i).Interface(), zero) {
+			continue
+		}
+		err = EncodeUvarint(buf, uint64(i))
+		if err != nil {
+			return
+		}
+		var erv, _, _ = derefPointersZero(rv.Index(i))
+		err = cdc.encodeReflectBinary(buf, einfo, erv, efopts, false, eopts)
+		if err != nil {
+			return
+		}
+		if err = eopts.checkSize(buf); err != nil {
+			return
 		}
 	}
 
@@ -399,7 +713,7 @@ func (cdc *Codec) encodeReflectBinaryByteSlice(w io.Writer, info *TypeInfo, rv r
 	}
 	ert := info.Type.Elem()
 	if ert.Kind() != reflect.Uint8 {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 
 	// Write byte-length prefixed byte-slice.
@@ -409,7 +723,7 @@ func (cdc *Codec) encodeReflectBinaryByteSlice(w io.Writer, info *TypeInfo, rv r
 }
 
 func (cdc *Codec) encodeReflectBinaryStruct(w io.Writer, info *TypeInfo, rv reflect.Value,
-	fopts FieldOptions, bare bool) (err error) {
+	fopts FieldOptions, bare bool, eopts encodeOptions) (err error) {
 	if printLog {
 		fmt.Println("(e) encodeReflectBinaryBinaryStruct")
 		defer func() {
@@ -419,47 +733,44 @@ func (cdc *Codec) encodeReflectBinaryStruct(w io.Writer, info *TypeInfo, rv refl
 
 	// Proto3 incurs a cost in writing non-root structs.
 	// Here we incur it for root structs as well for ease of dev.
-	buf := bytes.NewBuffer(nil)
+	buf := getBuffer()
+	defer putBuffer(buf)
 
 	switch info.Type {
 
 	case timeType:
 		// Special case: time.Time
-		err = EncodeTime(buf, rv.Interface().(time.Time))
+		t := rv.Interface().(time.Time)
+		if fopts.TimeSeconds {
+			t = t.Truncate(time.Second)
+		}
+		err = EncodeTime(buf, t)
 		if err != nil {
 			return
 		}
 
 	default:
+		if info.FixedWidth && len(info.VirtualFields) == 0 && !cdc.alwaysWriteEmpty && !eopts.Versioned {
+			// Fast path, see isFixedWidthField().
+			encodeReflectBinaryFixedWidthStruct(buf, info, rv)
+			break
+		}
 		for _, field := range info.Fields {
-			// Get type info for field.
-			var finfo *TypeInfo
-			finfo, err = cdc.getTypeInfoWlock(field.Type)
+			err = cdc.encodeReflectBinaryStructField(buf, field, rv, fopts, eopts)
 			if err != nil {
 				return
 			}
-			// Get dereferenced field value and info.
-			var frv = rv.Field(field.Index)
-			var frvIsPtr = frv.Kind() == reflect.Ptr
-			var dfrv, isDefault = isDefaultValue(frv)
-			if isDefault && !field.WriteEmpty {
-				// Do not encode default value fields
-				// (except when `amino:"write_empty"` is set).
-				continue
+			if err = eopts.checkSize(buf); err != nil {
+				return
 			}
-			if field.UnpackedList {
-				// Write repeated field entries for each list item.
-				err = cdc.encodeReflectBinaryList(buf, finfo, dfrv, field.FieldOptions, true)
-				if err != nil {
-					return
-				}
-			} else {
-				// write empty if explicitly set or if this is a pointer:
-				writeEmpty := field.WriteEmpty || frvIsPtr
-				err = cdc.writeFieldIfNotEmpty(buf, field.BinFieldNum, finfo, fopts, field.FieldOptions, dfrv, writeEmpty, false)
-				if err != nil {
-					return
-				}
+		}
+		for _, vfield := range info.VirtualFields {
+			err = cdc.encodeReflectBinaryVirtualField(buf, vfield, rv, eopts)
+			if err != nil {
+				return
+			}
+			if err = eopts.checkSize(buf); err != nil {
+				return
 			}
 		}
 	}
@@ -474,6 +785,205 @@ func (cdc *Codec) encodeReflectBinaryStruct(w io.Writer, info *TypeInfo, rv refl
 	return err
 }
 
+// Writes a single field of the struct rv, including its field key(s).
+// Nothing is written for default values unless WriteEmpty is set.
+func (cdc *Codec) encodeReflectBinaryStructField(buf *bytes.Buffer, field FieldInfo, rv reflect.Value,
+	fopts FieldOptions, eopts encodeOptions) (err error) {
+	if eopts.omits(field) {
+		return
+	}
+	if field.FieldCodec != nil {
+		return cdc.encodeFieldCodecField(buf, field, field.valueOf(rv))
+	}
+	ftype, frv, err := cdc.structFieldValue(field, rv, eopts)
+	if err != nil {
+		return
+	}
+	// Get type info for field.
+	var finfo *TypeInfo
+	finfo, err = cdc.getTypeInfoWlock(ftype)
+	if err != nil {
+		return
+	}
+	// Get dereferenced field value and info.
+	var frvIsPtr = frv.Kind() == reflect.Ptr
+	var dfrv, omit, fieldWriteEmpty = cdc.omitsStructField(field, finfo, frv)
+	if omit {
+		return
+	}
+	if field.UnpackedList && dfrv.Kind() == reflect.Map {
+		// Write repeated field entries for each map entry.
+		err = cdc.encodeReflectBinaryMap(buf, finfo, dfrv, field.FieldOptions, true, eopts)
+	} else if field.UnpackedList {
+		// Write repeated field entries for each list item.
+		err = cdc.encodeReflectBinaryList(buf, finfo, dfrv, field.FieldOptions, true, eopts)
+	} else {
+		// write empty if explicitly set or if this is a pointer:
+		writeEmpty := fieldWriteEmpty || frvIsPtr
+		err = cdc.writeFieldIfNotEmpty(buf, field.BinFieldNum, finfo, fopts, field.FieldOptions, dfrv, writeEmpty, false, eopts)
+	}
+	return
+}
+
+// Returns whether the struct field frv of type finfo is omitted as a default
+// value, and otherwise its dereferenced value (the zero value for a nil
+// pointer which is written anyway) and whether it's written even if empty.
+// Values which encode to 0x00 are omitted as well, see writeFieldIfNotEmpty().
+func (cdc *Codec) omitsStructField(field FieldInfo, finfo *TypeInfo,
+	frv reflect.Value) (dfrv reflect.Value, omit bool, writeEmpty bool) {
+	var isDefault bool
+	dfrv, isDefault = isDefaultValue(frv)
+	writeEmpty = (field.WriteEmpty || cdc.alwaysWriteEmpty) && !nilAsEmpty(field, frv)
+	if isDefault && !writeEmpty && !isPresentEnum(finfo, frv) {
+		// Do not encode default value fields
+		// (except when `amino:"write_empty"` is set,
+		// or for non-nil pointers to enums, to record their presence).
+		return dfrv, true, false
+	}
+	if !dfrv.IsValid() {
+		// A nil pointer which is written anyway, as its zero value.
+		dfrv, _, _ = derefPointersZero(frv)
+	}
+	return dfrv, false, writeEmpty
+}
+
+// Returns the type and value to encode for the field of the struct rv, which
+// differ from the field's own for wrapped, union and dynamic fields.
+func (cdc *Codec) structFieldValue(field FieldInfo, rv reflect.Value,
+	eopts encodeOptions) (ftype reflect.Type, frv reflect.Value, err error) {
+	ftype, frv = field.Type, field.valueOf(rv)
+	if field.WrapperType != nil {
+		// Encode the wrapper struct instead, see wrapperType().
+		ftype = reflect.PtrTo(field.WrapperType)
+		if !frv.IsNil() {
+			var wrv = reflect.New(field.WrapperType)
+			wrv.Elem().Field(0).Set(frv.Elem())
+			frv = wrv
+		} else {
+			frv = reflect.Zero(ftype)
+		}
+	} else if field.union != nil {
+		// Encode the kind from the payload, or the bare payload.
+		ftype, frv, err = cdc.unionFieldValue(field, rv, eopts)
+	} else if field.DynamicResolver != nil {
+		// Encode the resolved concrete value as a dynamicAny instead.
+		ftype = reflect.PtrTo(dynamicAnyType)
+		if !frv.IsNil() {
+			var any dynamicAny
+			any, err = cdc.encodeDynamicAny(field, frv.Elem(), eopts)
+			if err != nil {
+				return
+			}
+			frv = reflect.ValueOf(&any)
+		} else {
+			frv = reflect.Zero(ftype)
+		}
+	}
+	return
+}
+
+// Returns true if the field is always encoded as a fixed number of bytes,
+// i.e. is a fixed32 or fixed64 integer, a float or a bool.  Structs with
+// only such fields are encoded by encodeReflectBinaryFixedWidthStruct().
+func isFixedWidthField(field FieldInfo) bool {
+	if _, ok := field.Type.MethodByName("MarshalAmino"); ok {
+		return false
+	}
+	if isBinaryMarshalerType(field.Type) {
+		return false
+	}
+	switch field.Type.Kind() {
+	case reflect.Int64, reflect.Uint64:
+		return field.BinFixed64
+	case reflect.Int32, reflect.Uint32:
+		return field.BinFixed32
+	case reflect.Float64, reflect.Float32, reflect.Bool:
+		return true
+	default:
+		return false
+	}
+}
+
+// Writes the fields of a struct with StructInfo.FixedWidth, using the
+// precomputed field keys and without dispatching on each field's TypeInfo.
+// The output is identical to that of encodeReflectBinaryStructField().
+func encodeReflectBinaryFixedWidthStruct(buf *bytes.Buffer, info *TypeInfo, rv reflect.Value) {
+	var scratch [8]byte
+	for _, field := range info.Fields {
+		frv := field.valueOf(rv)
+		switch field.Type.Kind() {
+		case reflect.Int64, reflect.Uint64, reflect.Float64:
+			var u uint64
+			switch field.Type.Kind() {
+			case reflect.Int64:
+				u = uint64(frv.Int())
+			case reflect.Uint64:
+				u = frv.Uint()
+			default:
+				u = math.Float64bits(frv.Float())
+			}
+			// Zero integers are default values, but floats are always written.
+			if u == 0 && field.Type.Kind() != reflect.Float64 && !field.WriteEmpty {
+				continue
+			}
+			buf.Write(field.binKey)
+			binary.LittleEndian.PutUint64(scratch[:], u)
+			buf.Write(scratch[:8])
+		case reflect.Int32, reflect.Uint32, reflect.Float32:
+			var u uint32
+			switch field.Type.Kind() {
+			case reflect.Int32:
+				u = uint32(frv.Int())
+			case reflect.Uint32:
+				u = uint32(frv.Uint())
+			default:
+				u = math.Float32bits(float32(frv.Float()))
+			}
+			if u == 0 && field.Type.Kind() != reflect.Float32 && !field.WriteEmpty {
+				continue
+			}
+			buf.Write(field.binKey)
+			binary.LittleEndian.PutUint32(scratch[:], u)
+			buf.Write(scratch[:4])
+		case reflect.Bool:
+			if frv.Bool() {
+				buf.Write(field.binKey)
+				buf.WriteByte(0x01)
+			} else if field.WriteEmpty {
+				buf.Write(field.binKey)
+				buf.WriteByte(0x00)
+			}
+		default:
+			panic(internalError("should not happen"))
+		}
+	}
+}
+
+// Writes an output-only field computed from the struct rv.
+// See RegisterVirtualField().
+func (cdc *Codec) encodeReflectBinaryVirtualField(buf *bytes.Buffer, vfield VirtualFieldInfo,
+	rv reflect.Value, eopts encodeOptions) (err error) {
+	var v = vfield.Getter(rv)
+	if v == nil {
+		return
+	}
+	var vrv, isDefault = isDefaultValue(reflect.ValueOf(v))
+	if isDefault {
+		return
+	}
+	var vinfo *TypeInfo
+	vinfo, err = cdc.getTypeInfoWlock(vrv.Type())
+	if err != nil {
+		return
+	}
+	if isUnpackedList(vinfo.Type, vfield.FieldOptions) && vrv.Kind() == reflect.Map {
+		return cdc.encodeReflectBinaryMap(buf, vinfo, vrv, vfield.FieldOptions, true, eopts)
+	} else if isUnpackedList(vinfo.Type, vfield.FieldOptions) {
+		return cdc.encodeReflectBinaryList(buf, vinfo, vrv, vfield.FieldOptions, true, eopts)
+	}
+	return cdc.writeFieldIfNotEmpty(buf, vfield.BinFieldNum, vinfo, FieldOptions{}, vfield.FieldOptions, vrv, false, false, eopts)
+}
+
 //----------------------------------------
 // Misc.
 
@@ -505,23 +1015,29 @@ func (cdc *Codec) writeFieldIfNotEmpty(
 	derefedVal reflect.Value,
 	isWriteEmpty bool,
 	bare bool,
+	eopts encodeOptions,
 ) error {
 	lBeforeKey := buf.Len()
 	// Write field key (number and type).
-	err := encodeFieldNumberAndTyp3(buf, fieldNum, typeToTyp3(finfo.Type, fieldOpts))
+	err := encodeFieldNumberAndTyp3(buf, fieldNum, typeToTyp3(finfo, fieldOpts))
 	if err != nil {
 		return err
 	}
 	lBeforeValue := buf.Len()
 
 	// Write field value from rv.
-	err = cdc.encodeReflectBinary(buf, finfo, derefedVal, fieldOpts, bare)
+	err = cdc.encodeReflectBinary(buf, finfo, derefedVal, fieldOpts, bare, eopts)
 	if err != nil {
 		return err
 	}
 	lAfterValue := buf.Len()
 
-	if !isWriteEmpty && lBeforeValue == lAfterValue-1 && buf.Bytes()[buf.Len()-1] == 0x00 {
+	isEmpty := lBeforeValue == lAfterValue-1 && buf.Bytes()[buf.Len()-1] == 0x00
+	if usesIntCodec(finfo, fieldOpts) {
+		// The value is length-prefixed, so its bytes don't tell.
+		_, isEmpty = isDefaultValue(derefedVal)
+	}
+	if !isWriteEmpty && isEmpty {
 		// rollback typ3/fieldnum and last byte if
 		// not a pointer and empty:
 		buf.Truncate(lBeforeKey)
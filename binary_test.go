@@ -1,7 +1,12 @@
 package amino_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -181,6 +186,661 @@ func TestStructSlice(t *testing.T) {
 	assert.Equal(t, f, f2)
 }
 
+func TestByteSliceSlice(t *testing.T) {
+	type Sigs struct {
+		Sigs [][]byte
+	}
+
+	cdc := amino.NewCodec()
+
+	cases := []struct {
+		sigs [][]byte
+		hex  string
+	}{
+		{nil, ""},
+		{[][]byte{{0x01}}, "0A0101"},
+		{[][]byte{{0x01}, {}, {0x02, 0x03}}, "0A01010A000A020203"},
+	}
+	for i, tc := range cases {
+		// As a struct field.
+		bz, err := cdc.MarshalBinaryBare(Sigs{tc.sigs})
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, tc.hex, fmt.Sprintf("%X", bz), "case %d", i)
+		var s2 Sigs
+		err = cdc.UnmarshalBinaryBare(bz, &s2)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, len(tc.sigs), len(s2.Sigs), "case %d", i)
+		for j := range tc.sigs {
+			// Empty inner slices decode as nil.
+			assert.True(t, bytes.Equal(tc.sigs[j], s2.Sigs[j]), "case %d", i)
+		}
+
+		// At the top level, encoded as if it were field 1.
+		bz, err = cdc.MarshalBinaryBare(tc.sigs)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, tc.hex, fmt.Sprintf("%X", bz), "case %d", i)
+		var sigs2 [][]byte
+		err = cdc.UnmarshalBinaryBare(bz, &sigs2)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, len(tc.sigs), len(sigs2), "case %d", i)
+		for j := range tc.sigs {
+			assert.True(t, bytes.Equal(tc.sigs[j], sigs2[j]), "case %d", i)
+		}
+	}
+}
+
+func TestTimeSeconds(t *testing.T) {
+	type Times struct {
+		Full    time.Time
+		Seconds time.Time `amino:"time_seconds"`
+	}
+
+	cdc := amino.NewCodec()
+
+	tm := time.Unix(1, 978131102).UTC()
+	bz, err := cdc.MarshalBinaryBare(Times{tm, tm})
+	require.NoError(t, err)
+	// Only the seconds of the second field are written.
+	assert.Equal(t, "0A080801109EB1B4D20312020801", fmt.Sprintf("%X", bz))
+
+	var t2 Times
+	err = cdc.UnmarshalBinaryBare(bz, &t2)
+	require.NoError(t, err)
+	assert.Equal(t, tm, t2.Full)
+	assert.Equal(t, time.Unix(1, 0).UTC(), t2.Seconds)
+}
+
+func TestTimeNanos(t *testing.T) {
+	type Bounded struct {
+		At time.Time
+	}
+	type Nanos struct {
+		At time.Time `amino:"time_nanos"`
+	}
+
+	cdc := amino.NewCodec()
+
+	for i, tm := range []time.Time{
+		time.Unix(1, 978131102).UTC(),
+		time.Unix(-1, 1).UTC(),
+		time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.UTC),
+	} {
+		bz, err := cdc.MarshalBinaryBare(Nanos{tm})
+		require.NoError(t, err, "case %v", i)
+		// The encoding is unchanged.
+		bz2, err := cdc.MarshalBinaryBare(Bounded{tm})
+		require.NoError(t, err, "case %v", i)
+		assert.Equal(t, bz2, bz, "case %v", i)
+		var n Nanos
+		require.NoError(t, cdc.UnmarshalBinaryBare(bz, &n), "case %v", i)
+		assert.Equal(t, tm, n.At, "case %v", i)
+	}
+
+	// Year 10000 and later are still out of range.
+	for i, tm := range []time.Time{
+		time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(10000, 1, 1, 0, 0, 0, 1, time.UTC),
+		time.Date(0, 12, 31, 23, 59, 59, 999999999, time.UTC),
+	} {
+		_, err := cdc.MarshalBinaryBare(Nanos{tm})
+		assert.Error(t, err, "case %v", i)
+	}
+	// 253402300800 seconds, i.e. 10000-01-01.
+	var n Nanos
+	bz := []byte{0x0A, 0x07, 0x08, 0x80, 0x83, 0xD1, 0xFF, 0xAF, 0x07}
+	assert.Error(t, cdc.UnmarshalBinaryBare(bz, &n))
+	bz = []byte{0x0A, 0x07, 0x08, 0xFF, 0x82, 0xD1, 0xFF, 0xAF, 0x07}
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &n))
+	assert.Equal(t, time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC), n.At)
+
+	// Negative nanoseconds, which proto3 Timestamps forbid, are rejected.
+	bz = []byte{0x0A, 0x0D, 0x08, 0x01, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}
+	assert.Error(t, cdc.UnmarshalBinaryBare(bz, &n))
+	// As are a billion nanoseconds.
+	bz = []byte{0x0A, 0x08, 0x08, 0x01, 0x10, 0x80, 0x94, 0xEB, 0xDC, 0x03}
+	assert.Error(t, cdc.UnmarshalBinaryBare(bz, &n))
+	bz = []byte{0x0A, 0x08, 0x08, 0x01, 0x10, 0xFF, 0x93, 0xEB, 0xDC, 0x03}
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &n))
+	assert.Equal(t, time.Unix(1, 999999999).UTC(), n.At)
+
+	// Nanoseconds which aren't a varint are dropped, unless time_nanos.
+	bz = []byte{0x0A, 0x07, 0x08, 0x01, 0x15, 0x01, 0x00, 0x00, 0x00}
+	var b Bounded
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &b))
+	assert.Equal(t, time.Unix(1, 0).UTC(), b.At)
+	assert.Error(t, cdc.UnmarshalBinaryBare(bz, &n))
+
+	type Both struct {
+		At time.Time `amino:"time_nanos,time_seconds"`
+	}
+	assert.Panics(t, func() { cdc.MarshalBinaryBare(Both{}) })
+}
+
+func TestUnixMillis(t *testing.T) {
+	type Event struct {
+		At time.Time `amino:"unixmillis"`
+	}
+
+	cdc := amino.NewCodec()
+
+	for _, tc := range []struct {
+		tm   time.Time
+		ms   int64
+		want time.Time
+	}{
+		// Sub-millisecond precision is truncated.
+		{time.Unix(1, 2999999).UTC(), 1002, time.Unix(1, 2000000).UTC()},
+		// Pre-epoch times round down too.
+		{time.Unix(-1, 999500000).UTC(), -1, time.Unix(0, -1000000).UTC()},
+		{time.Unix(-86400, 0).UTC(), -86400000, time.Unix(-86400, 0).UTC()},
+		{time.Unix(0, 0).UTC(), 0, time.Unix(0, 0).UTC()},
+	} {
+		bz, err := cdc.MarshalBinaryBare(Event{tc.tm})
+		require.NoError(t, err)
+		var e Event
+		err = cdc.UnmarshalBinaryBare(bz, &e)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, e.At)
+
+		jsonBz, err := cdc.MarshalJSON(Event{tc.tm})
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf(`{"At":%d}`, tc.ms), string(jsonBz))
+		e = Event{}
+		err = cdc.UnmarshalJSON(jsonBz, &e)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, e.At)
+	}
+
+	// Written as a varint.
+	bz, err := cdc.MarshalBinaryBare(Event{time.Unix(0, 300e6)})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x08, 0xAC, 0x02}, bz)
+}
+
+func TestDateOnly(t *testing.T) {
+	type Person struct {
+		Birth time.Time `amino:"dateonly"`
+	}
+
+	cdc := amino.NewCodec()
+
+	// Times on the same calendar day encode identically, whatever the time
+	// of day and location.
+	est := time.FixedZone("EST", -5*3600)
+	morning, err := cdc.MarshalBinaryBare(Person{time.Date(1990, 6, 15, 0, 0, 1, 0, time.UTC)})
+	require.NoError(t, err)
+	evening, err := cdc.MarshalBinaryBare(Person{time.Date(1990, 6, 15, 23, 59, 59, 999, est)})
+	require.NoError(t, err)
+	assert.Equal(t, morning, evening)
+	// 7470 days since epoch, as a varint.
+	assert.Equal(t, []byte{0x08, 0xAE, 0x3A}, morning)
+
+	var p Person
+	err = cdc.UnmarshalBinaryBare(evening, &p)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(1990, 6, 15, 0, 0, 0, 0, time.UTC), p.Birth)
+
+	jsonBz, err := cdc.MarshalJSON(Person{time.Date(1969, 12, 31, 22, 0, 0, 0, est)})
+	require.NoError(t, err)
+	assert.Equal(t, `{"Birth":"1969-12-31"}`, string(jsonBz))
+	p = Person{}
+	err = cdc.UnmarshalJSON(jsonBz, &p)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC), p.Birth)
+	bz, err := cdc.MarshalBinaryBare(p)
+	require.NoError(t, err)
+	p = Person{}
+	err = cdc.UnmarshalBinaryBare(bz, &p)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC), p.Birth)
+
+	err = cdc.UnmarshalJSON([]byte(`{"Birth":"1969-12-31T00:00:00Z"}`), &p)
+	assert.Error(t, err)
+}
+
+func TestEmbeddedStructFieldNumbers(t *testing.T) {
+	// Embedded structs are not flattened; the embedded struct is encoded as
+	// a nested message under its own field number, so its field numbers
+	// can't collide with those of the parent.
+	type Inner struct {
+		A string
+	}
+	type Outer struct {
+		Inner
+		A string
+	}
+
+	cdc := amino.NewCodec()
+
+	o := Outer{Inner{"in"}, "out"}
+	bz, err := cdc.MarshalBinaryBare(o)
+	require.NoError(t, err)
+	assert.Equal(t, "0A040A02696E12036F7574", fmt.Sprintf("%X", bz))
+
+	var o2 Outer
+	err = cdc.UnmarshalBinaryBare(bz, &o2)
+	require.NoError(t, err)
+	assert.Equal(t, o, o2)
+}
+
+func TestExplicitFieldNumbers(t *testing.T) {
+	// C pins 1, so A and B are numbered around it, and D follows C.
+	type V2 struct {
+		A string
+		B string
+		C string `amino:"field=1"`
+		D string
+		E string `amino:"field=10"`
+	}
+	cdc := amino.NewCodec()
+	o := V2{"a", "b", "c", "d", "e"}
+	bz, err := cdc.MarshalBinaryBare(o)
+	require.NoError(t, err)
+	assert.Equal(t, "0A01631201611A0162220164520165", fmt.Sprintf("%X", bz))
+	bz2, err := cdc.MarshalJSON(o)
+	require.NoError(t, err)
+	assert.Equal(t, `{"C":"c","A":"a","B":"b","D":"d","E":"e"}`, string(bz2))
+	var o2 V2
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &o2))
+	assert.Equal(t, o, o2)
+
+	// V1 had no B, D and E, which are skipped as unknown trailing fields.
+	type V1 struct {
+		A string
+		C string `amino:"field=1"`
+	}
+	var o1 V1
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &o1))
+	assert.Equal(t, V1{"a", "c"}, o1)
+
+	// B was removed from Removed, so its field number is skipped as unknown.
+	type Removed struct {
+		A string `amino:"field=1"`
+		C string `amino:"field=3"`
+	}
+	type Full struct {
+		A string
+		B string
+		C string
+	}
+	bz, err = cdc.MarshalBinaryBare(Full{"a", "b", "c"})
+	require.NoError(t, err)
+	var removed Removed
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &removed))
+	assert.Equal(t, Removed{"a", "c"}, removed)
+	bz, err = cdc.MarshalBinaryBare(removed)
+	require.NoError(t, err)
+	var full Full
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &full))
+	assert.Equal(t, Full{"a", "", "c"}, full)
+
+	strict := amino.NewCodec()
+	strict.SetRejectUnknownFields(true)
+	bz, err = strict.MarshalBinaryBare(Full{"a", "b", "c"})
+	require.NoError(t, err)
+	err = strict.UnmarshalBinaryBare(bz, &removed)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown field # 2 of amino_test.Removed")
+
+	type Duplicate struct {
+		A string `amino:"field=2"`
+		B string `amino:"field=2"`
+	}
+	type TooLarge struct {
+		A string `amino:"field=536870912"`
+	}
+	type Zero struct {
+		A string `amino:"field=0"`
+	}
+	type Overflow struct {
+		A string `amino:"field=536870911"`
+		B string
+	}
+	for _, o := range []interface{}{Duplicate{}, TooLarge{}, Zero{}, Overflow{}} {
+		assert.Panics(t, func() { cdc.MarshalBinaryBare(o) }, "%T", o) // nolint: errcheck
+	}
+}
+
+type versionedVote struct {
+	Height int64
+	Round  int32
+	Proof  []byte  `amino:"since_version=2"`
+	Extra  *string `amino:"since_version=3"`
+}
+
+func TestMarshalBinaryForVersion(t *testing.T) {
+	type Envelope struct {
+		Votes []versionedVote
+	}
+	cdc := amino.NewCodec()
+	extra := "x"
+	v := versionedVote{Height: 1, Round: 2, Proof: []byte{0xAB}, Extra: &extra}
+
+	v1, err := cdc.MarshalBinaryForVersion(v, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "08011002", fmt.Sprintf("%X", v1))
+	v2, err := cdc.MarshalBinaryForVersion(v, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "080110021A01AB", fmt.Sprintf("%X", v2))
+	all, err := cdc.MarshalBinaryBare(v)
+	require.NoError(t, err)
+	v3, err := cdc.MarshalBinaryForVersion(v, 3)
+	require.NoError(t, err)
+	assert.Equal(t, all, v3)
+
+	// Older encodings decode without the newer fields.
+	var out versionedVote
+	require.NoError(t, cdc.UnmarshalBinaryBare(v1, &out))
+	assert.Equal(t, versionedVote{Height: 1, Round: 2}, out)
+	out = versionedVote{}
+	require.NoError(t, cdc.UnmarshalBinaryBare(v2, &out))
+	assert.Equal(t, versionedVote{Height: 1, Round: 2, Proof: []byte{0xAB}}, out)
+
+	// Nested structs are versioned too.
+	bz, err := cdc.MarshalBinaryForVersion(Envelope{[]versionedVote{v, v}}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "0A04080110020A0408011002", fmt.Sprintf("%X", bz))
+
+	type NotMonotonic struct {
+		A string `amino:"since_version=2"`
+		B string `amino:"since_version=1"`
+	}
+	assert.Panics(t, func() { cdc.MarshalBinaryForVersion(NotMonotonic{}, 1) }) // nolint: errcheck
+}
+
+func TestMarshalBinaryMaxSize(t *testing.T) {
+	type Item struct {
+		Name string
+	}
+	type Items struct {
+		Items []Item
+	}
+
+	cdc := amino.NewCodec()
+
+	items := Items{Items: []Item{{"a"}, {"bc"}}}
+	bz, err := cdc.MarshalBinaryBare(items)
+	require.NoError(t, err)
+
+	// Exactly at the limit.
+	bz2, err := cdc.MarshalBinaryMaxSize(items, len(bz))
+	require.NoError(t, err)
+	assert.Equal(t, bz, bz2)
+
+	// One byte over the limit.
+	_, err = cdc.MarshalBinaryMaxSize(items, len(bz)-1)
+	assert.Equal(t, amino.ErrMaxSizeExceeded, err)
+
+	// A nested list which is too large.
+	big := Items{Items: make([]Item, 100000)}
+	for i := range big.Items {
+		big.Items[i].Name = "item"
+	}
+	_, err = cdc.MarshalBinaryMaxSize(big, 1024)
+	assert.Equal(t, amino.ErrMaxSizeExceeded, err)
+}
+
+type testEnum int32
+
+func TestPointerEnumPresence(t *testing.T) {
+	type OptionalEnum struct {
+		E *testEnum
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterEnum(reflect.TypeOf(testEnum(0)), map[int32]string{0: "NONE", 2: "TWO"})
+
+	zero, two := testEnum(0), testEnum(2)
+	cases := []struct {
+		in   OptionalEnum
+		bin  string
+		json string
+	}{
+		{OptionalEnum{nil}, "", `{"E":null}`},         // unset
+		{OptionalEnum{&zero}, "0800", `{"E":"NONE"}`}, // set to the zero value
+		{OptionalEnum{&two}, "0802", `{"E":"TWO"}`},   // set
+	}
+	for i, tc := range cases {
+		bz, err := cdc.MarshalBinaryBare(tc.in)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, tc.bin, fmt.Sprintf("%X", bz), "case %d", i)
+		var out OptionalEnum
+		err = cdc.UnmarshalBinaryBare(bz, &out)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, tc.in, out, "case %d", i)
+
+		bz, err = cdc.MarshalJSON(tc.in)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, tc.json, string(bz), "case %d", i)
+		out = OptionalEnum{}
+		err = cdc.UnmarshalJSON(bz, &out)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, tc.in, out, "case %d", i)
+	}
+
+	// Pointers to zero values of other types are still omitted.
+	type Pointers struct {
+		Int  *int64
+		Str  *string
+		List *[]int64
+		Enum *testEnum
+	}
+	i, s, l := int64(0), "", []int64{}
+	bz, err := cdc.MarshalBinaryBare(Pointers{&i, &s, &l, nil})
+	require.NoError(t, err)
+	assert.Empty(t, bz)
+	size, err := cdc.SizeBinary(Pointers{&i, &s, &l, nil})
+	require.NoError(t, err)
+	assert.Equal(t, 0, size)
+}
+
+func TestWrapperFields(t *testing.T) {
+	type Wrappers struct {
+		Double *float64 `amino:"wrapper,unsafe"`
+		Float  *float32 `amino:"wrapper,unsafe"`
+		Int64  *int64   `amino:"wrapper"`
+		UInt64 *uint64  `amino:"wrapper"`
+		Int32  *int32   `amino:"wrapper"`
+		UInt32 *uint32  `amino:"wrapper"`
+		Bool   *bool    `amino:"wrapper"`
+		String *string  `amino:"wrapper"`
+		Bytes  *[]byte  `amino:"wrapper"`
+	}
+
+	cdc := amino.NewCodec()
+
+	// Null.
+	bz, err := cdc.MarshalBinaryBare(Wrappers{})
+	require.NoError(t, err)
+	assert.Empty(t, bz)
+	var w2 Wrappers
+	err = cdc.UnmarshalBinaryBare(bz, &w2)
+	require.NoError(t, err)
+	assert.Equal(t, Wrappers{}, w2)
+	bz, err = cdc.MarshalJSON(Wrappers{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"Double":null,"Float":null,"Int64":null,"UInt64":null,"Int32":null,`+
+		`"UInt32":null,"Bool":null,"String":null,"Bytes":null}`, string(bz))
+
+	// Present and zero, encoded as empty wrapper messages
+	// (except for floats, since amino always writes those).
+	var (
+		d   float64
+		f   float32
+		i64 int64
+		u64 uint64
+		i32 int32
+		u32 uint32
+		b   bool
+		s   string
+		bs  []byte
+	)
+	zero := Wrappers{&d, &f, &i64, &u64, &i32, &u32, &b, &s, &bs}
+	bz, err = cdc.MarshalBinaryBare(zero)
+	require.NoError(t, err)
+	assert.Equal(t, "0A09090000000000000000"+"1205"+"0D00000000"+
+		"1A0022002A0032003A0042004A00", fmt.Sprintf("%X", bz))
+	w2 = Wrappers{}
+	err = cdc.UnmarshalBinaryBare(bz, &w2)
+	require.NoError(t, err)
+	assert.Equal(t, zero, w2)
+
+	// Present, encoded as messages with the value as field 1.
+	d, f, i64, u64, i32, u32, b, s, bs = 0.5, -1, -2, 3, -4, 5, true, "six", []byte{7}
+	present := Wrappers{&d, &f, &i64, &u64, &i32, &u32, &b, &s, &bs}
+	bz, err = cdc.MarshalBinaryBare(present)
+	require.NoError(t, err)
+	assert.Equal(t, "0A09"+"09000000000000E03F"+"1205"+"0D000080BF"+
+		"1A0B"+"08FEFFFFFFFFFFFFFFFF01"+"2202"+"0803"+"2A0B"+"08FCFFFFFFFFFFFFFFFF01"+
+		"3202"+"0805"+"3A02"+"0801"+"4205"+"0A03736978"+"4A03"+"0A0107", fmt.Sprintf("%X", bz))
+	w2 = Wrappers{}
+	err = cdc.UnmarshalBinaryBare(bz, &w2)
+	require.NoError(t, err)
+	assert.Equal(t, present, w2)
+
+	// JSON uses the bare value.
+	bz, err = cdc.MarshalJSON(present)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Double":0.5,"Float":-1,"Int64":"-2","UInt64":"3","Int32":-4,`+
+		`"UInt32":5,"Bool":true,"String":"six","Bytes":"Bw=="}`, string(bz))
+	w2 = Wrappers{}
+	err = cdc.UnmarshalJSON(bz, &w2)
+	require.NoError(t, err)
+	assert.Equal(t, present, w2)
+}
+
+type fixedWidthHeader struct {
+	Height  int64   `binary:"fixed64"`
+	Round   int32   `binary:"fixed32"`
+	Time    uint64  `binary:"fixed64"`
+	Nonce   uint32  `binary:"fixed32" amino:"write_empty"`
+	Ratio   float64 `amino:"unsafe"`
+	Weight  float32 `amino:"unsafe"`
+	Final   bool
+	Pending bool `amino:"write_empty"`
+}
+
+// Same as fixedWidthHeader, but not fixed-width due to Pad.
+type variableWidthHeader struct {
+	Height  int64   `binary:"fixed64"`
+	Round   int32   `binary:"fixed32"`
+	Time    uint64  `binary:"fixed64"`
+	Nonce   uint32  `binary:"fixed32" amino:"write_empty"`
+	Ratio   float64 `amino:"unsafe"`
+	Weight  float32 `amino:"unsafe"`
+	Final   bool
+	Pending bool `amino:"write_empty"`
+	Pad     string
+}
+
+func TestFixedWidthStruct(t *testing.T) {
+	cdc := amino.NewCodec()
+
+	cases := []fixedWidthHeader{
+		{},
+		{Height: 1, Round: -1, Time: 1 << 40, Nonce: 7, Ratio: 0.5, Weight: -2, Final: true, Pending: true},
+		{Height: -1 << 62, Final: false, Pending: false},
+	}
+	for i, h := range cases {
+		bz, err := cdc.MarshalBinaryBare(h)
+		require.NoError(t, err, "case %d", i)
+		vh := variableWidthHeader{h.Height, h.Round, h.Time, h.Nonce, h.Ratio, h.Weight, h.Final, h.Pending, ""}
+		vbz, err := cdc.MarshalBinaryBare(vh)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, vbz, bz, "case %d", i)
+
+		var h2 fixedWidthHeader
+		err = cdc.UnmarshalBinaryBare(bz, &h2)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, h, h2, "case %d", i)
+	}
+}
+
+func BenchmarkMarshalBinaryFixedWidthStruct(b *testing.B) {
+	cdc := amino.NewCodec()
+	h := fixedWidthHeader{Height: 1, Round: 2, Time: 3, Nonce: 4, Ratio: 0.5, Weight: 6, Final: true}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cdc.MustMarshalBinaryBare(h)
+	}
+}
+
+func BenchmarkMarshalBinaryVariableWidthStruct(b *testing.B) {
+	cdc := amino.NewCodec()
+	h := variableWidthHeader{Height: 1, Round: 2, Time: 3, Nonce: 4, Ratio: 0.5, Weight: 6, Final: true}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cdc.MustMarshalBinaryBare(h)
+	}
+}
+
+type smallVote struct {
+	Height    int64
+	Validator string
+	BlockID   struct {
+		Hash  []byte
+		Parts []int32
+	}
+	Signature []byte
+}
+
+func TestMarshalBinaryBareOwnership(t *testing.T) {
+	cdc := amino.NewCodec()
+	v := smallVote{Height: 100, Validator: "val1", Signature: []byte("signature")}
+	bz := cdc.MustMarshalBinaryBare(v)
+	want := append([]byte(nil), bz...)
+
+	// Encoding again, which reuses pooled buffers, doesn't change bz.
+	for i := 0; i < 10; i++ {
+		cdc.MustMarshalBinaryBare(smallVote{Height: int64(i), Validator: "val2"})
+	}
+	assert.Equal(t, want, bz)
+
+	// An empty encoding is nil.
+	bz, err := cdc.MarshalBinaryBare(smallVote{})
+	require.NoError(t, err)
+	assert.Nil(t, bz)
+}
+
+func BenchmarkMarshalBinarySmallMessage(b *testing.B) {
+	cdc := amino.NewCodec()
+	v := smallVote{Height: 100, Validator: "val1", Signature: []byte("signature")}
+	v.BlockID.Hash = []byte("0123456789abcdef")
+	v.BlockID.Parts = []int32{1, 2, 3}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cdc.MustMarshalBinaryBare(v)
+	}
+}
+
+// Encodes the same struct from 32 goroutines sharing a codec, which only
+// contend on the codec's read lock once its TypeInfos are known.
+func BenchmarkMarshalBinaryConcurrent(b *testing.B) {
+	const goroutines = 32
+	cdc := amino.NewCodec()
+	v := smallVote{Height: 100, Validator: "val1", Signature: []byte("signature")}
+	v.BlockID.Hash = []byte("0123456789abcdef")
+	v.BlockID.Parts = []int32{1, 2, 3}
+	cdc.MustMarshalBinaryBare(v)
+	b.ReportAllocs()
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				cdc.MustMarshalBinaryBare(v)
+			}
+		}((b.N + goroutines - 1) / goroutines)
+	}
+	wg.Wait()
+}
+
 func TestStructPointerSlice1(t *testing.T) {
 	cdc := amino.NewCodec()
 
@@ -271,23 +931,129 @@ func TestUnmarshalMapBinary(t *testing.T) {
 	obj := new(map[string]int)
 	cdc := amino.NewCodec()
 
-	// Binary doesn't support decoding to a map...
+	// Invalid bytes fail to decode.
 	binBytes := []byte(`dontcare`)
-	assert.Panics(t, func() {
-		err := cdc.UnmarshalBinaryBare(binBytes, &obj)
-		assert.Fail(t, "should have paniced but got err: %v", err)
-	})
+	err := cdc.UnmarshalBinaryBare(binBytes, obj)
+	assert.Error(t, err)
 
-	assert.Panics(t, func() {
-		err := cdc.UnmarshalBinaryBare(binBytes, obj)
-		require.Error(t, err)
-	})
+	// Maps are encoded as repeated key/value entries.
+	*obj = map[string]int{"b": 2, "a": 1}
+	bz, err := cdc.MarshalBinaryBare(obj)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0A, 0x05, 0x0A, 0x01, 'a', 0x10, 0x01, 0x0A, 0x05, 0x0A, 0x01, 'b', 0x10, 0x02}, bz)
+	res := new(map[string]int)
+	err = cdc.UnmarshalBinaryBare(bz, res)
+	require.NoError(t, err)
+	assert.Equal(t, *obj, *res)
 
-	// ... nor encoding it.
-	assert.Panics(t, func() {
-		bz, err := cdc.MarshalBinaryBare(obj)
-		assert.Fail(t, "should have paniced but got bz: %X err: %v", bz, err)
-	})
+	// Keys must be scalars.
+	_, err = cdc.MarshalBinaryBare(map[[2]int8]int{{1, 2}: 3})
+	assert.Error(t, err)
+}
+
+func TestMapBinary(t *testing.T) {
+	type Entry struct {
+		Note string
+	}
+	type Ledger struct {
+		Name     string
+		Balances map[string]int64
+		Entries  map[uint32]*Entry
+		Flags    map[int8]bool
+		Tail     int64
+	}
+
+	cdc := amino.NewCodec()
+	l := Ledger{
+		Name:     "l",
+		Balances: map[string]int64{"carol": 3, "alice": 1, "bob": -2, "": 4},
+		Entries:  map[uint32]*Entry{300: {"x"}, 2: {"y"}, 1: {}},
+		Flags:    map[int8]bool{-1: true, 1: false},
+		Tail:     9,
+	}
+	bz, err := cdc.MarshalBinaryBare(l)
+	require.NoError(t, err)
+	// Equal maps are always encoded the same.
+	for i := 0; i < 20; i++ {
+		bz2, err := cdc.MarshalBinaryBare(l)
+		require.NoError(t, err)
+		require.Equal(t, bz, bz2)
+	}
+	var l2 Ledger
+	err = cdc.UnmarshalBinaryBare(bz, &l2)
+	require.NoError(t, err)
+	assert.Equal(t, l, l2)
+
+	// Empty maps are omitted, and decode as nil.
+	bz, err = cdc.MarshalBinaryBare(Ledger{Balances: map[string]int64{}, Tail: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x28, 0x01}, bz)
+	l2 = Ledger{}
+	err = cdc.UnmarshalBinaryBare(bz, &l2)
+	require.NoError(t, err)
+	assert.Equal(t, Ledger{Tail: 1}, l2)
+
+	// Duplicate keys are rejected.
+	entry := []byte{0x12, 0x05, 0x0A, 0x01, 'a', 0x10, 0x01}
+	err = cdc.UnmarshalBinaryBare(append(entry, entry...), &l2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate key a")
+}
+
+type mapLedger struct {
+	Name     string
+	Balances map[string]int64
+	Tail     int64
+}
+
+// Encodes like mapLedger, with the map entries as a list of structs.
+type listLedger struct {
+	Name     string
+	Balances []struct {
+		Key   string
+		Value int64
+	}
+	Tail int64
+}
+
+func newMapLedger(size int) mapLedger {
+	l := mapLedger{Name: "l", Balances: make(map[string]int64, size), Tail: 9}
+	for i := 0; i < size; i++ {
+		l.Balances[fmt.Sprintf("account-%v", i)] = int64(i) - 100
+	}
+	return l
+}
+
+func TestMapBinaryLarge(t *testing.T) {
+	cdc := amino.NewCodec()
+	l := newMapLedger(5000)
+	bz, err := cdc.MarshalBinaryBare(l)
+	require.NoError(t, err)
+	var l2 mapLedger
+	err = cdc.UnmarshalBinaryBare(bz, &l2)
+	require.NoError(t, err)
+	assert.Equal(t, l, l2)
+
+	// The entries are the same as those read by the list decoder.
+	var ll listLedger
+	err = cdc.UnmarshalBinaryBare(bz, &ll)
+	require.NoError(t, err)
+	require.Len(t, ll.Balances, len(l.Balances))
+	for _, entry := range ll.Balances {
+		assert.Equal(t, l.Balances[entry.Key], entry.Value)
+	}
+	assert.Equal(t, l.Tail, ll.Tail)
+}
+
+func BenchmarkUnmarshalBinaryMap(b *testing.B) {
+	cdc := amino.NewCodec()
+	bz := cdc.MustMarshalBinaryBare(newMapLedger(5000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var l mapLedger
+		cdc.MustUnmarshalBinaryBare(bz, &l)
+	}
 }
 
 func TestUnmarshalFuncBinary(t *testing.T) {
@@ -314,3 +1080,766 @@ func TestUnmarshalFuncBinary(t *testing.T) {
 		assert.Fail(t, "should have paniced but got bz: %X err: %v", bz, err)
 	})
 }
+
+func TestJSONNumber(t *testing.T) {
+	type Amount struct {
+		Value json.Number
+		Fees  []json.Number
+	}
+
+	cdc := amino.NewCodec()
+	for _, num := range []json.Number{
+		"0", "-42", "9007199254740993", // Exceeds float64 precision.
+		"123456789012345678901234567890.000000000000000001", "1e3", "1.50",
+	} {
+		a := Amount{Value: num, Fees: []json.Number{num, "1.5"}}
+		bz, err := cdc.MarshalBinaryBare(a)
+		require.NoError(t, err)
+		var a2 Amount
+		err = cdc.UnmarshalBinaryBare(bz, &a2)
+		require.NoError(t, err)
+		assert.Equal(t, a, a2)
+
+		jsonBz, err := cdc.MarshalJSON(a)
+		require.NoError(t, err)
+		assert.Equal(t, `{"Value":`+string(num)+`,"Fees":[`+string(num)+`,1.5]}`, string(jsonBz))
+		var a3 Amount
+		err = cdc.UnmarshalJSON(jsonBz, &a3)
+		require.NoError(t, err)
+		assert.Equal(t, a, a3)
+	}
+
+	// Integers are written as varints, anything else as text.
+	bz, err := cdc.MarshalBinaryBare(Amount{Value: "300"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0A, 0x03, 0x08, 0xAC, 0x02}, bz)
+	bz, err = cdc.MarshalBinaryBare(Amount{Value: "0.5"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0A, 0x05, 0x12, 0x03, '0', '.', '5'}, bz)
+}
+
+func TestSetAlwaysWriteEmpty(t *testing.T) {
+	type Inner struct {
+		A int32
+	}
+	type Record struct {
+		Num    int64
+		Str    string
+		Flag   bool
+		Bytes  []byte
+		Nums   []int64
+		Ptr    *Inner
+		Fixed  uint64 `binary:"fixed64"`
+		Inners []Inner
+	}
+
+	cdc := amino.NewCodec()
+	bz, err := cdc.MarshalBinaryBare(Record{})
+	require.NoError(t, err)
+	assert.Empty(t, bz)
+
+	cdc.SetAlwaysWriteEmpty(true)
+	bz, err = cdc.MarshalBinaryBare(Record{})
+	require.NoError(t, err)
+	// Every field but the empty repeated Inners is written, also of Ptr.
+	assert.Equal(t, "08001200180022002A0032020800"+"390000000000000000", fmt.Sprintf("%X", bz))
+
+	var r Record
+	err = cdc.UnmarshalBinaryBare(bz, &r)
+	require.NoError(t, err)
+	assert.Equal(t, Record{Ptr: &Inner{}}, r)
+}
+
+func TestSetIntOverflowMode(t *testing.T) {
+	type Narrow struct {
+		Small  uint8
+		Medium int16 `json:"medium"`
+	}
+
+	// Write wider values than the fields can hold.
+	var buf bytes.Buffer
+	buf.Write([]byte{0x08})
+	require.NoError(t, amino.EncodeUvarint(&buf, 300))
+	buf.Write([]byte{0x10})
+	require.NoError(t, amino.EncodeVarint(&buf, 7))
+	uint8Bz := buf.Bytes()
+	buf = bytes.Buffer{}
+	buf.Write([]byte{0x10})
+	require.NoError(t, amino.EncodeVarint(&buf, -40000))
+	int16Bz := buf.Bytes()
+
+	cdc := amino.NewCodec()
+	var n Narrow
+	err := cdc.UnmarshalBinaryBare(uint8Bz, &n)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "field Small: decoded value 300 overflows uint8")
+	err = cdc.UnmarshalBinaryBare(int16Bz, &n)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "field medium: decoded value -40000 overflows int16")
+
+	cdc.SetIntOverflowMode("wrap")
+	n = Narrow{}
+	err = cdc.UnmarshalBinaryBare(uint8Bz, &n)
+	require.NoError(t, err)
+	assert.Equal(t, Narrow{Small: 44, Medium: 7}, n)
+	n = Narrow{}
+	err = cdc.UnmarshalBinaryBare(int16Bz, &n)
+	require.NoError(t, err)
+	assert.Equal(t, Narrow{Medium: 25536}, n)
+
+	assert.Panics(t, func() { cdc.SetIntOverflowMode("saturate") })
+}
+
+func TestSparseArray(t *testing.T) {
+	type Bitmap struct {
+		Dense  [100]int64
+		Sparse [100]int64 `amino:"sparse"`
+	}
+
+	cdc := amino.NewCodec()
+	var b Bitmap
+	b.Sparse[0], b.Sparse[42], b.Sparse[99] = 7, -1, 300
+	b.Dense = b.Sparse
+	bz, err := cdc.MarshalBinaryBare(b)
+	require.NoError(t, err)
+	var b2 Bitmap
+	err = cdc.UnmarshalBinaryBare(bz, &b2)
+	require.NoError(t, err)
+	assert.Equal(t, b, b2)
+
+	// Only the (index, value) pairs are written.
+	type SparseOnly struct {
+		Values [100]int64 `amino:"sparse"`
+	}
+	bz, err = cdc.MarshalBinaryBare(SparseOnly{b.Sparse})
+	require.NoError(t, err)
+	assert.Equal(t, "0A10"+"0007"+"2AFFFFFFFFFFFFFFFFFF01"+"63AC02", fmt.Sprintf("%X", bz))
+	// Nothing is written when all elements are zero.
+	bz, err = cdc.MarshalBinaryBare(SparseOnly{})
+	require.NoError(t, err)
+	assert.Empty(t, bz)
+
+	// Indices must be in bounds and in order.
+	err = cdc.UnmarshalBinaryBare([]byte{0x12, 0x02, 0x64, 0x01}, &b2)
+	assert.Error(t, err)
+	err = cdc.UnmarshalBinaryBare([]byte{0x12, 0x04, 0x05, 0x01, 0x05, 0x02}, &b2)
+	assert.Error(t, err)
+	err = cdc.UnmarshalBinaryBare([]byte{0x12, 0x04, 0x05, 0x01, 0x06, 0x02}, &b2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), b2.Sparse[5])
+	assert.Equal(t, int64(2), b2.Sparse[6])
+	assert.Equal(t, int64(0), b2.Sparse[0])
+
+	// Only arrays may be sparse.
+	type SparseSlice struct {
+		Values []int64 `amino:"sparse"`
+	}
+	assert.Panics(t, func() { cdc.MarshalBinaryBare(SparseSlice{}) }) // nolint: errcheck
+}
+
+func TestGzipField(t *testing.T) {
+	type Doc struct {
+		Title string `amino:"gzip"`
+		Body  string `amino:"gzip"`
+		Data  []byte `amino:"gzip"`
+	}
+
+	cdc := amino.NewCodec()
+	doc := Doc{
+		Title: "short",
+		Body:  strings.Repeat("all work and no play makes jack a dull boy ", 1000),
+		Data:  bytes.Repeat([]byte{0xAB}, 4096),
+	}
+	bz, err := cdc.MarshalBinaryBare(doc)
+	require.NoError(t, err)
+	assert.True(t, len(bz) < 1000, "got %v bytes", len(bz))
+	// Short values are written as is, after the flag byte.
+	assert.Equal(t, []byte{0x0A, 0x06, 0x00, 's', 'h', 'o', 'r', 't'}, bz[:8])
+	// Long ones are compressed.
+	assert.Equal(t, byte(0x01), bz[11])
+
+	var doc2 Doc
+	err = cdc.UnmarshalBinaryBare(bz, &doc2)
+	require.NoError(t, err)
+	assert.Equal(t, doc, doc2)
+
+	// Empty values are omitted.
+	bz, err = cdc.MarshalBinaryBare(Doc{})
+	require.NoError(t, err)
+	assert.Empty(t, bz)
+
+	err = cdc.UnmarshalBinaryBare([]byte{0x0A, 0x02, 0x02, 'x'}, &doc2)
+	assert.Error(t, err)
+
+	// Values decompressing to more than the limit are rejected.
+	bomb, err := cdc.MarshalBinaryBare(Doc{Data: make([]byte, 64<<20+1)})
+	require.NoError(t, err)
+	assert.True(t, len(bomb) < (64<<20)/500, "got %v bytes", len(bomb))
+	err = cdc.UnmarshalBinaryBare(bomb, &doc2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds limit of 67108864 bytes")
+
+	cdc2 := amino.NewCodec()
+	cdc2.SetMaxGunzipSize(4096)
+	bz, err = cdc.MarshalBinaryBare(Doc{Data: make([]byte, 4096)})
+	require.NoError(t, err)
+	err = cdc2.UnmarshalBinaryBare(bz, &doc2)
+	require.NoError(t, err)
+	bz, err = cdc.MarshalBinaryBare(Doc{Data: make([]byte, 4097)})
+	require.NoError(t, err)
+	err = cdc2.UnmarshalBinaryBare(bz, &doc2)
+	assert.Error(t, err)
+	cdc2.SetMaxGunzipSize(0)
+	err = cdc2.UnmarshalBinaryBare(bomb, &doc2)
+	require.NoError(t, err)
+	assert.Len(t, doc2.Data, 64<<20+1)
+}
+
+func TestSetStrictNesting(t *testing.T) {
+	type Inner struct {
+		A int64
+	}
+	type Outer struct {
+		In Inner
+		B  int64
+	}
+
+	// Inner is padded with a field it doesn't have.
+	bz := []byte{0x0A, 0x04, 0x08, 0x01, 0x10, 0x05, 0x10, 0x02}
+
+	cdc := amino.NewCodec()
+	var o Outer
+	err := cdc.UnmarshalBinaryBare(bz, &o)
+	require.NoError(t, err)
+	assert.Equal(t, Outer{Inner{1}, 2}, o)
+
+	cdc.SetStrictNesting(true)
+	o = Outer{}
+	err = cdc.UnmarshalBinaryBare(bz, &o)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 bytes left over after reading fields of amino_test.Inner")
+	// Also at the top level.
+	err = cdc.UnmarshalBinaryBare([]byte{0x0A, 0x02, 0x08, 0x01, 0x10, 0x02, 0x18, 0x03}, &o)
+	assert.Error(t, err)
+
+	// Canonical encodings still decode.
+	o = Outer{}
+	err = cdc.UnmarshalBinaryBare([]byte{0x0A, 0x02, 0x08, 0x01, 0x10, 0x02}, &o)
+	require.NoError(t, err)
+	assert.Equal(t, Outer{Inner{1}, 2}, o)
+
+	// As do virtual fields, but not unknown fields after them.
+	cdc = amino.NewCodec()
+	cdc.RegisterVirtualField(reflect.TypeOf(Inner{}), 2, "double", func(v reflect.Value) interface{} {
+		return 2 * v.Interface().(Inner).A
+	})
+	cdc.SetStrictNesting(true)
+	bz, err = cdc.MarshalBinaryBare(Outer{Inner{3}, 4})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0A, 0x04, 0x08, 0x03, 0x10, 0x06, 0x10, 0x04}, bz)
+	o = Outer{}
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &o))
+	assert.Equal(t, Outer{Inner{3}, 4}, o)
+	err = cdc.UnmarshalBinaryBare([]byte{0x0A, 0x06, 0x08, 0x03, 0x10, 0x06, 0x18, 0x01, 0x10, 0x04}, &o)
+	assert.Error(t, err)
+}
+
+func TestSetEmptyStructPointersNil(t *testing.T) {
+	type Inner struct {
+		A int64
+	}
+	type Outer struct {
+		Value Inner
+		Ptr   *Inner
+		B     int64
+	}
+
+	// Both nested structs have zero length.
+	bz := []byte{0x0A, 0x00, 0x12, 0x00, 0x18, 0x01}
+
+	cdc := amino.NewCodec()
+	var o Outer
+	err := cdc.UnmarshalBinaryBare(bz, &o)
+	require.NoError(t, err)
+	assert.Equal(t, Outer{Inner{}, &Inner{}, 1}, o)
+	// Which round-trips.
+	bz2, err := cdc.MarshalBinaryBare(o)
+	require.NoError(t, err)
+	assert.Equal(t, bz[2:], bz2)
+
+	cdc.SetEmptyStructPointersNil(true)
+	o = Outer{}
+	err = cdc.UnmarshalBinaryBare(bz, &o)
+	require.NoError(t, err)
+	assert.Equal(t, Outer{Inner{}, nil, 1}, o)
+	// Non-empty structs are unaffected.
+	o = Outer{}
+	err = cdc.UnmarshalBinaryBare([]byte{0x12, 0x02, 0x08, 0x02}, &o)
+	require.NoError(t, err)
+	assert.Equal(t, Outer{Ptr: &Inner{2}}, o)
+}
+
+type polyPoint struct {
+	X, Y int64
+}
+
+type polyLabel struct {
+	Text string
+}
+
+type polyBlob []byte
+
+func TestPolymorphicSlice(t *testing.T) {
+	type Canvas struct {
+		Items []interface{} `amino:"empty_elements"`
+		Title string
+	}
+	type StrictCanvas struct {
+		Items []interface{}
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*interface{})(nil), nil)
+	cdc.RegisterConcrete(polyPoint{}, "test/polyPoint", nil)
+	cdc.RegisterConcrete(&polyLabel{}, "test/polyLabel", nil)
+	cdc.RegisterConcrete(polyBlob{}, "test/polyBlob", nil)
+
+	c := Canvas{
+		Items: []interface{}{polyBlob{0x01, 0x02}, polyPoint{1, 2}, nil, &polyLabel{"hi"}, polyPoint{3, 4}},
+		Title: "t",
+	}
+	bz, err := cdc.MarshalBinaryBare(c)
+	require.NoError(t, err)
+	var c2 Canvas
+	err = cdc.UnmarshalBinaryBare(bz, &c2)
+	require.NoError(t, err)
+	assert.Equal(t, c, c2)
+
+	// Without empty_elements, nil elements are rejected.
+	_, err = cdc.MarshalBinaryBare(StrictCanvas{Items: []interface{}{polyPoint{}, nil}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nil element 1")
+	// As are unregistered ones.
+	_, err = cdc.MarshalBinaryBare(StrictCanvas{Items: []interface{}{polyPoint{}, &polyLabel{}, 5}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot encode element 2")
+
+	sc := StrictCanvas{Items: []interface{}{&polyLabel{"a"}, polyBlob{0x03}}}
+	bz, err = cdc.MarshalBinaryBare(sc)
+	require.NoError(t, err)
+	var sc2 StrictCanvas
+	err = cdc.UnmarshalBinaryBare(bz, &sc2)
+	require.NoError(t, err)
+	assert.Equal(t, sc, sc2)
+}
+
+func TestSetNumericCoercion(t *testing.T) {
+	type OldRecord struct {
+		Count   int32  `binary:"fixed32"`
+		Size    uint32 `binary:"fixed32"`
+		Version int32
+		Total   int64 `binary:"fixed64"`
+	}
+	type NewRecord struct {
+		Count   int64
+		Size    uint64
+		Version int64
+		Total   int64
+	}
+	type NarrowRecord struct {
+		Count   int64
+		Size    uint64
+		Version int64
+		Total   int32
+	}
+
+	cdc := amino.NewCodec()
+	bz, err := cdc.MarshalBinaryBare(OldRecord{Count: -5, Size: 4000000000, Version: -2, Total: 7})
+	require.NoError(t, err)
+
+	// The fixed-width fields are rejected by default.
+	var nr NewRecord
+	err = cdc.UnmarshalBinaryBare(bz, &nr)
+	require.Error(t, err)
+
+	cdc.SetNumericCoercion(true)
+	err = cdc.UnmarshalBinaryBare(bz, &nr)
+	require.NoError(t, err)
+	assert.Equal(t, NewRecord{Count: -5, Size: 4000000000, Version: -2, Total: 7}, nr)
+
+	// Narrowing works while values fit.
+	var nar NarrowRecord
+	err = cdc.UnmarshalBinaryBare(bz, &nar)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), nar.Total)
+	bz, err = cdc.MarshalBinaryBare(OldRecord{Total: 1 << 40})
+	require.NoError(t, err)
+	err = cdc.UnmarshalBinaryBare(bz, &nar)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "overflows int32")
+}
+
+func TestSetRejectNonCanonicalVarint(t *testing.T) {
+	type Inner struct {
+		N int64
+	}
+	type Msg struct {
+		Height int64
+		Memo   string
+		Inner  Inner
+		Tail   int8
+	}
+
+	cdc := amino.NewCodec()
+	want := Msg{Height: 5, Memo: "hi", Inner: Inner{N: 1}, Tail: -1}
+	bz := cdc.MustMarshalBinaryBare(want)
+	require.Equal(t, []byte{0x08, 0x05, 0x12, 0x02, 'h', 'i', 0x1A, 0x02, 0x08, 0x01, 0x20, 0x01}, bz)
+
+	cases := []struct {
+		name string
+		bz   []byte
+	}{
+		{"value", []byte{0x08, 0x85, 0x00, 0x12, 0x02, 'h', 'i', 0x1A, 0x02, 0x08, 0x01, 0x20, 0x01}},
+		{"field key", []byte{0x88, 0x80, 0x00, 0x05, 0x12, 0x02, 'h', 'i', 0x1A, 0x02, 0x08, 0x01, 0x20, 0x01}},
+		{"string length", []byte{0x08, 0x05, 0x12, 0x82, 0x00, 'h', 'i', 0x1A, 0x02, 0x08, 0x01, 0x20, 0x01}},
+		{"struct length", []byte{0x08, 0x05, 0x12, 0x02, 'h', 'i', 0x1A, 0x82, 0x00, 0x08, 0x01, 0x20, 0x01}},
+		{"zigzag value", []byte{0x08, 0x05, 0x12, 0x02, 'h', 'i', 0x1A, 0x02, 0x08, 0x01, 0x20, 0x81, 0x80, 0x00}},
+	}
+	for _, tc := range cases {
+		// Rejected by default.
+		var m Msg
+		err := cdc.UnmarshalBinaryBare(tc.bz, &m)
+		require.Error(t, err, tc.name)
+		assert.Contains(t, err.Error(), amino.ErrNonCanonicalVarint.Error(), tc.name)
+	}
+	// A non-minimal length prefix is rejected too.
+	var m Msg
+	err := cdc.UnmarshalBinaryLengthPrefixed(append([]byte{0x8C, 0x00}, bz...), &m)
+	assert.Equal(t, amino.ErrNonCanonicalVarint, err)
+
+	// But accepted for legacy data.
+	cdc = amino.NewCodec()
+	cdc.SetRejectNonCanonicalVarint(false)
+	for _, tc := range cases {
+		var m Msg
+		err := cdc.UnmarshalBinaryBare(tc.bz, &m)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, want, m, tc.name)
+	}
+	m = Msg{}
+	err = cdc.UnmarshalBinaryLengthPrefixed(append([]byte{0x8C, 0x00}, bz...), &m)
+	require.NoError(t, err)
+	assert.Equal(t, want, m)
+}
+
+type zeroCopyDoc struct {
+	Title string
+	Body  string
+	Tags  []string
+}
+
+func TestSetZeroCopyStrings(t *testing.T) {
+	doc := zeroCopyDoc{Title: "title", Body: "body", Tags: []string{"a", "", "bc"}}
+
+	for _, zeroCopy := range []bool{false, true} {
+		cdc := amino.NewCodec()
+		cdc.SetZeroCopyStrings(zeroCopy)
+		bz := cdc.MustMarshalBinaryBare(doc)
+		var doc2 zeroCopyDoc
+		require.NoError(t, cdc.UnmarshalBinaryBare(bz, &doc2))
+		assert.Equal(t, doc, doc2)
+
+		// Only zero-copy strings reflect (forbidden) changes to the bytes.
+		i := bytes.Index(bz, []byte("title"))
+		bz[i] = 'T'
+		if zeroCopy {
+			assert.Equal(t, "Title", doc2.Title)
+		} else {
+			assert.Equal(t, "title", doc2.Title)
+		}
+	}
+
+	// Lengths are still checked.
+	cdc := amino.NewCodec()
+	cdc.SetZeroCopyStrings(true)
+	var doc2 zeroCopyDoc
+	err := cdc.UnmarshalBinaryBare([]byte{0x0A, 0x05, 't'}, &doc2)
+	assert.Error(t, err)
+}
+
+func BenchmarkUnmarshalBinaryStrings(b *testing.B) {
+	doc := zeroCopyDoc{Title: "title", Body: strings.Repeat("x", 64*1024)}
+	for i := 0; i < 100; i++ {
+		doc.Tags = append(doc.Tags, fmt.Sprintf("tag-%v", i))
+	}
+	for _, zeroCopy := range []bool{false, true} {
+		b.Run(fmt.Sprintf("zeroCopy=%v", zeroCopy), func(b *testing.B) {
+			cdc := amino.NewCodec()
+			cdc.SetZeroCopyStrings(zeroCopy)
+			bz := cdc.MustMarshalBinaryBare(doc)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var doc2 zeroCopyDoc
+				cdc.MustUnmarshalBinaryBare(bz, &doc2)
+			}
+		})
+	}
+}
+
+type hoistBase struct {
+	ID       int64
+	Name     string // Ambiguous with hoistMeta.Name, so dropped.
+	Shadowed string // Shadowed by HoistDoc.Shadowed.
+}
+
+type hoistMeta struct {
+	Name string
+	Note string
+}
+
+type HoistDoc struct {
+	hoistBase
+	hoistMeta
+	Shadowed string
+	Body     string
+}
+
+func TestUnexportedEmbeddedStruct(t *testing.T) {
+	type FlatDoc struct {
+		ID       int64
+		Note     string
+		Shadowed string
+		Body     string
+	}
+
+	cdc := amino.NewCodec()
+	doc := HoistDoc{
+		hoistBase: hoistBase{ID: 7, Name: "dropped", Shadowed: "dropped"},
+		hoistMeta: hoistMeta{Name: "dropped", Note: "note"},
+		Shadowed:  "top",
+		Body:      "body",
+	}
+	want := HoistDoc{
+		hoistBase: hoistBase{ID: 7},
+		hoistMeta: hoistMeta{Note: "note"},
+		Shadowed:  "top",
+		Body:      "body",
+	}
+
+	// Promoted fields are encoded in place of the embedded struct.
+	bz, err := cdc.MarshalBinaryBare(doc)
+	require.NoError(t, err)
+	assert.Equal(t, cdc.MustMarshalBinaryBare(FlatDoc{7, "note", "top", "body"}), bz)
+	var doc2 HoistDoc
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &doc2))
+	assert.Equal(t, want, doc2)
+
+	jsonBz, err := cdc.MarshalJSON(doc)
+	require.NoError(t, err)
+	assert.Equal(t, `{"ID":"7","Note":"note","Shadowed":"top","Body":"body"}`, string(jsonBz))
+	doc2 = HoistDoc{}
+	require.NoError(t, cdc.UnmarshalJSON(jsonBz, &doc2))
+	assert.Equal(t, want, doc2)
+
+	assert.Equal(t, &doc, amino.DeepCopy(&doc))
+}
+
+// bmPoint encodes itself as "x,y".
+type bmPoint struct {
+	X, Y int
+}
+
+func (p bmPoint) MarshalBinary() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", p.X, p.Y)), nil
+}
+
+func (p *bmPoint) UnmarshalBinary(bz []byte) error {
+	_, err := fmt.Sscanf(string(bz), "%d,%d", &p.X, &p.Y)
+	return err
+}
+
+// bmVersion only implements encoding.BinaryMarshaler.
+type bmVersion uint32
+
+func (v bmVersion) MarshalBinary() ([]byte, error) {
+	return []byte{'v', byte('0' + v)}, nil
+}
+
+// bmTagged implements MarshalAmino too, which takes precedence.
+type bmTagged struct {
+	Tag string
+}
+
+func (bt bmTagged) MarshalBinary() ([]byte, error)   { return []byte("binary"), nil }
+func (bt *bmTagged) UnmarshalBinary(bz []byte) error { bt.Tag = "binary"; return nil }
+func (bt bmTagged) MarshalAmino() (string, error)    { return bt.Tag, nil }
+func (bt *bmTagged) UnmarshalAmino(tag string) error { bt.Tag = tag; return nil }
+
+func TestBinaryMarshaler(t *testing.T) {
+	type Shape struct {
+		Name   string
+		Origin bmPoint
+		Corner *bmPoint
+		Path   []bmPoint
+	}
+
+	cdc := amino.NewCodec()
+	s := Shape{"box", bmPoint{1, 2}, &bmPoint{-3, 4}, []bmPoint{{5, 6}, {7, 8}}}
+	bz, err := cdc.MarshalBinaryBare(s)
+	require.NoError(t, err)
+	assert.Equal(t, "\x0a\x03box"+"\x12\x031,2"+"\x1a\x04-3,4"+"\x22\x035,6"+"\x22\x037,8", string(bz))
+
+	var s2 Shape
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &s2))
+	assert.Equal(t, s, s2)
+
+	n, err := cdc.SizeBinary(s)
+	require.NoError(t, err)
+	assert.Equal(t, len(bz), n)
+
+	// At the top level a struct is written bare, like any struct.
+	bz, err = cdc.MarshalBinaryBare(bmPoint{9, 10})
+	require.NoError(t, err)
+	assert.Equal(t, "9,10", string(bz))
+	var p bmPoint
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &p))
+	assert.Equal(t, bmPoint{9, 10}, p)
+
+	// Without UnmarshalBinary, values can be encoded but not decoded.
+	bz, err = cdc.MarshalBinaryBare(struct{ V bmVersion }{2})
+	require.NoError(t, err)
+	assert.Equal(t, "\x0a\x02v2", string(bz))
+	var v struct{ V bmVersion }
+	err = cdc.UnmarshalBinaryBare(bz, &v)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "implements encoding.BinaryMarshaler but not encoding.BinaryUnmarshaler")
+
+	// MarshalAmino takes precedence.
+	bz, err = cdc.MarshalBinaryBare(struct{ T bmTagged }{bmTagged{"amino"}})
+	require.NoError(t, err)
+	assert.Equal(t, "\x0a\x05amino", string(bz))
+	var tagged struct{ T bmTagged }
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &tagged))
+	assert.Equal(t, "amino", tagged.T.Tag)
+
+	// time.Time is still encoded by amino, as a Timestamp.
+	type Event struct{ T time.Time }
+	now := time.Unix(1500000000, 0).UTC()
+	bz, err = cdc.MarshalBinaryBare(Event{now})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 0x06, 0x08, 0x80, 0xde, 0xa0, 0xcb, 0x05}, bz)
+}
+
+func TestSetRejectUnknownFields(t *testing.T) {
+	type Inner struct {
+		A int64
+	}
+	type Outer struct {
+		In Inner
+		B  int64
+	}
+
+	// Inner has an extra field # 2, and Outer an extra field # 3.
+	bz := []byte{0x0A, 0x04, 0x08, 0x01, 0x10, 0x05, 0x10, 0x02, 0x1A, 0x01, 'x'}
+
+	cdc := amino.NewCodec()
+	var o Outer
+	err := cdc.UnmarshalBinaryBare(bz, &o)
+	require.NoError(t, err)
+	assert.Equal(t, Outer{Inner{1}, 2}, o)
+
+	cdc.SetRejectUnknownFields(true)
+	err = cdc.UnmarshalBinaryBare(bz, &o)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown field # 2 of amino_test.Inner")
+	err = cdc.UnmarshalBinaryBare([]byte{0x0A, 0x02, 0x08, 0x01, 0x10, 0x02, 0x1A, 0x01, 'x'}, &o)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown field # 3 of amino_test.Outer")
+
+	// Virtual fields are known.
+	cdc.RegisterVirtualField(reflect.TypeOf(Outer{}), 3, "c", func(v reflect.Value) interface{} {
+		return "x"
+	})
+	o = Outer{}
+	err = cdc.UnmarshalBinaryBare([]byte{0x0A, 0x02, 0x08, 0x01, 0x10, 0x02, 0x1A, 0x01, 'x'}, &o)
+	require.NoError(t, err)
+	assert.Equal(t, Outer{Inner{1}, 2}, o)
+}
+
+func TestNilAsEmptyFieldOption(t *testing.T) {
+	type Item struct {
+		N int64
+	}
+	type Bag struct {
+		Bytes  []byte   `amino:"nil_as_empty"`
+		Ints   []int64  `amino:"nil_as_empty,write_empty"`
+		Items  []Item   `amino:"nil_as_empty"`
+		Names  []string `amino:"write_empty"`
+		Height int64
+	}
+
+	cdc := amino.NewCodec()
+	nilBag := Bag{Height: 1}
+	emptyBag := Bag{[]byte{}, []int64{}, []Item{}, []string{}, 1}
+	nilBz, err := cdc.MarshalBinaryBare(nilBag)
+	require.NoError(t, err)
+	emptyBz, err := cdc.MarshalBinaryBare(emptyBag)
+	require.NoError(t, err)
+	// nil_as_empty takes precedence over write_empty.
+	assert.Equal(t, []byte{0x28, 0x01}, nilBz)
+	assert.Equal(t, nilBz, emptyBz)
+
+	for _, bag := range []Bag{nilBag, emptyBag} {
+		var bag2 Bag
+		require.NoError(t, cdc.UnmarshalBinaryBare(cdc.MustMarshalBinaryBare(bag), &bag2))
+		assert.Equal(t, []byte{}, bag2.Bytes)
+		assert.Equal(t, []int64{}, bag2.Ints)
+		assert.Equal(t, []Item{}, bag2.Items)
+		assert.Nil(t, bag2.Names)
+
+		// Further round trips are stable.
+		var bag3 Bag
+		require.NoError(t, cdc.UnmarshalBinaryBare(cdc.MustMarshalBinaryBare(bag2), &bag3))
+		assert.True(t, reflect.DeepEqual(bag2, bag3))
+	}
+
+	// Non-empty slices are unaffected.
+	bag := Bag{[]byte{1}, []int64{2}, []Item{{3}}, []string{"a"}, 4}
+	var bag2 Bag
+	require.NoError(t, cdc.UnmarshalBinaryBare(cdc.MustMarshalBinaryBare(bag), &bag2))
+	assert.Equal(t, bag, bag2)
+	n, err := cdc.SizeBinary(emptyBag)
+	require.NoError(t, err)
+	assert.Equal(t, len(emptyBz), n)
+}
+
+func TestWireTypeFor(t *testing.T) {
+	cases := []struct {
+		o    interface{}
+		opts amino.FieldOptions
+		want amino.Typ3
+	}{
+		{int64(0), amino.FieldOptions{}, amino.Typ3Varint},
+		{int64(0), amino.FieldOptions{BinFixed64: true}, amino.Typ38Byte},
+		{uint32(0), amino.FieldOptions{BinFixed32: true}, amino.Typ3_4Byte},
+		{false, amino.FieldOptions{}, amino.Typ3Varint},
+		{"", amino.FieldOptions{}, amino.Typ3ByteLength},
+		{[]byte(nil), amino.FieldOptions{}, amino.Typ3ByteLength},
+		{new(*SimpleStruct), amino.FieldOptions{}, amino.Typ3ByteLength},
+		{map[string]int{}, amino.FieldOptions{}, amino.Typ3ByteLength},
+		{time.Time{}, amino.FieldOptions{}, amino.Typ3ByteLength},
+		{time.Time{}, amino.FieldOptions{UnixMillis: true}, amino.Typ3Varint},
+		{float32(0), amino.FieldOptions{}, amino.Typ3_4Byte},
+	}
+	for _, tc := range cases {
+		typ3, err := amino.WireTypeFor(reflect.TypeOf(tc.o), tc.opts)
+		require.NoError(t, err, "%T", tc.o)
+		assert.Equal(t, tc.want, typ3, "%T", tc.o)
+	}
+
+	for _, o := range []interface{}{nil, make(chan int), func() {}, complex64(0), new(uintptr)} {
+		_, err := amino.WireTypeFor(reflect.TypeOf(o), amino.FieldOptions{})
+		assert.Error(t, err, "%T", o)
+	}
+}
@@ -0,0 +1,69 @@
+package amino
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+)
+
+//----------------------------------------
+// encoding.BinaryMarshaler
+
+var (
+	binaryMarshalerType   = reflect.TypeOf(new(encoding.BinaryMarshaler)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf(new(encoding.BinaryUnmarshaler)).Elem()
+)
+
+// Returns true if values of rt are encoded with MarshalBinary(), as a
+// byte-length prefixed field (or verbatim if bare), i.e. if rt or a pointer
+// to it implements encoding.BinaryMarshaler, but not MarshalAmino(), which
+// takes precedence.  time.Time is always encoded by amino.
+func isBinaryMarshalerType(rt reflect.Type) bool {
+	if rt == timeType || rt.Kind() == reflect.Interface || rt.Kind() == reflect.Ptr {
+		return false
+	}
+	if _, ok := rt.MethodByName("MarshalAmino"); ok {
+		return false
+	}
+	return reflect.PtrTo(rt).Implements(binaryMarshalerType)
+}
+
+// Like isBinaryMarshalerType, for UnmarshalBinary() and UnmarshalAmino().
+func isBinaryUnmarshalerType(rt reflect.Type) bool {
+	if rt == timeType || rt.Kind() == reflect.Interface || rt.Kind() == reflect.Ptr {
+		return false
+	}
+	if _, ok := reflect.PtrTo(rt).MethodByName("UnmarshalAmino"); ok {
+		return false
+	}
+	return reflect.PtrTo(rt).Implements(binaryUnmarshalerType)
+}
+
+// Calls MarshalBinary() on rv, or on a pointer to (a copy of) it.
+func marshalBinary(rv reflect.Value) ([]byte, error) {
+	if !rv.Type().Implements(binaryMarshalerType) {
+		if !rv.CanAddr() {
+			var prv = reflect.New(rv.Type())
+			prv.Elem().Set(rv)
+			rv = prv.Elem()
+		}
+		rv = rv.Addr()
+	}
+	bz, err := rv.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("MarshalBinary of %v failed: %v", rv.Type(), err)
+	}
+	return bz, nil
+}
+
+// Calls UnmarshalBinary() on a pointer to rv, which must be addressable.
+func unmarshalBinary(bz []byte, info *TypeInfo, rv reflect.Value) error {
+	if !info.IsBinaryUnmarshaler {
+		return fmt.Errorf("%v implements encoding.BinaryMarshaler but not encoding.BinaryUnmarshaler", info.Type)
+	}
+	err := rv.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(bz)
+	if err != nil {
+		return fmt.Errorf("UnmarshalBinary of %v failed: %v", info.Type, err)
+	}
+	return nil
+}
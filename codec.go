@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"unicode"
@@ -60,12 +65,22 @@ type TypeInfo struct {
 	InterfaceInfo
 	ConcreteInfo
 	StructInfo
+	MapInfo
 }
 
 type InterfaceInfo struct {
 	Priority     []DisfixBytes               // Disfix priority.
 	Implementers map[PrefixBytes][]*TypeInfo // Mutated over time.
 	InterfaceOptions
+
+	// Set once prefix bytes were omitted, see SetOmitAnyTypeWhenUnique().
+	OmittedPrefix bool
+
+	// Decoded when the concrete type is missing, see RegisterInterfaceDefault().
+	DefaultConcrete *TypeInfo
+
+	// The only concrete types allowed, if not nil, see RegisterOneof().
+	Oneof []reflect.Type
 }
 
 type InterfaceOptions struct {
@@ -90,10 +105,42 @@ type ConcreteInfo struct {
 	AminoMarshalReprType   reflect.Type // <ReprType>
 	IsAminoUnmarshaler     bool         // Implements UnmarshalAmino(<ReprObject>) (error).
 	AminoUnmarshalReprType reflect.Type // <ReprType>
+	IsBinaryMarshaler      bool         // Implements encoding.BinaryMarshaler, see isBinaryMarshalerType().
+	IsBinaryUnmarshaler    bool         // Implements encoding.BinaryUnmarshaler.
+
+	// These fields are only set by RegisterIntCodec().
+	IntEncoder func(uint64) []byte               // Replaces the varint encoding.
+	IntDecoder func([]byte) (uint64, int, error) // Replaces the varint decoding.
+
+	// This field is only set by RegisterImmutable().
+	Immutable bool // Encodings are cached, see RegisterImmutable.
+
+	// This field is only set by RegisterRawType().
+	Raw bool // Encoded as its bytes, see RegisterRawType.
+
+	// This field is only set by RegisterOmitEmptyFunc().
+	OmitEmpty func(reflect.Value) bool // Replaces isEmpty() for json:",omitempty".
+
+	// These fields are only set by RegisterJSONNull().
+	JSONIsNull  func(reflect.Value) bool // Values for which JSON null is written.
+	JSONNewNull func() reflect.Value     // Value which JSON null decodes to.
+
+	// These fields are only set by RegisterEnum().
+	EnumNames  map[int32]string // JSON names of enum values.
+	EnumValues map[string]int32 // The inverse of EnumNames.
 }
 
 type StructInfo struct {
-	Fields []FieldInfo // If a struct.
+	Fields        []FieldInfo        // If a struct.
+	VirtualFields []VirtualFieldInfo // Output-only fields, see RegisterVirtualField().
+	FixedWidth    bool               // All fields are fixed-width scalars, see isFixedWidthField().
+
+	// This field is only set by ReserveFieldNumbers().
+	ReservedFieldNums []uint32 // Field numbers that may not be used, see Validate().
+}
+
+type MapInfo struct {
+	EntryType reflect.Type // If a map, the struct type of its entries, see mapEntryType().
 }
 
 func (cinfo ConcreteInfo) GetDisfix() DisfixBytes {
@@ -101,27 +148,68 @@ func (cinfo ConcreteInfo) GetDisfix() DisfixBytes {
 }
 
 type ConcreteOptions struct {
+	SchemaVersion uint32 // Written by MarshalEnvelope.
 }
 
 type FieldInfo struct {
 	Name         string        // Struct field name
 	Type         reflect.Type  // Struct field type
-	Index        int           // Struct field index
+	Index        int           // Struct field index, or -1 if promoted, see valueOf().
 	ZeroValue    reflect.Value // Could be nil pointer unlike TypeInfo.ZeroValue.
 	UnpackedList bool          // True iff this field should be encoded as an unpacked list.
+	WrapperType  reflect.Type  // If Wrapper, the struct encoded instead, see wrapperType().
 	FieldOptions               // Encoding options
+
+	DynamicResolver func(v reflect.Value) (reflect.Type, string) // See RegisterDynamicField().
+	FieldCodec      FieldCodec                                   // See RegisterFieldCodec().
+	union           *unionInfo                                   // See RegisterUnion().
+
+	binKey    []byte // Field number and Typ3, only set if StructInfo.FixedWidth.
+	indexPath []int  // If promoted from an unexported embedded struct, see structFields().
+}
+
+// Returns the field of the struct rv.
+func (field FieldInfo) valueOf(rv reflect.Value) reflect.Value {
+	if field.indexPath != nil {
+		return rv.FieldByIndex(field.indexPath)
+	}
+	return rv.Field(field.Index)
+}
+
+// Returns the reflect.StructField of the field of the struct type rt.
+func (field FieldInfo) structField(rt reflect.Type) reflect.StructField {
+	if field.indexPath != nil {
+		return rt.FieldByIndex(field.indexPath)
+	}
+	return rt.Field(field.Index)
+}
+
+type VirtualFieldInfo struct {
+	Getter       func(v reflect.Value) interface{} // Computes the field value at encode time.
+	FieldOptions                                   // Only JSONName and BinFieldNum are set.
 }
 
 type FieldOptions struct {
 	JSONName      string // (JSON) field name
 	JSONOmitEmpty bool   // (JSON) omitempty
+	JSONHex       bool   // (JSON) Encode a byte slice or array as a hex string, not base64.
+	JSONRaw       bool   // (JSON) Encode a byte slice as the JSON it holds, e.g. a json.RawMessage.
 	BinFixed64    bool   // (Binary) Encode as fixed64
 	BinFixed32    bool   // (Binary) Encode as fixed32
-	BinFieldNum   uint32 // (Binary) max 1<<29-1
+	BinFieldNum   uint32 // (Binary) max 1<<29-1, or explicitly `amino:"field=N"`
+	SinceVersion  uint32 // (Binary) The version which added the field, see MarshalBinaryForVersion.
 
 	Unsafe        bool // e.g. if this field is a float.
 	WriteEmpty    bool // write empty structs and lists (default false except for pointers)
 	EmptyElements bool // Slice and Array elements are never nil, decode 0x00 as empty struct.
+	TimeSeconds   bool // (Binary) Encode time.Time without nanoseconds.
+	TimeNanos     bool // (Binary) Reject time.Time encodings whose nanoseconds wouldn't round-trip.
+	UnixMillis    bool // Encode time.Time as an int64 of milliseconds since epoch.
+	DateOnly      bool // Encode time.Time as an int64 of days since epoch, or "YYYY-MM-DD" in JSON.
+	Sparse        bool // (Binary) Encode only the non-zero elements of an array, with their indices.
+	Gzip          bool // (Binary) Compress a string or byte slice, if large enough.
+	Wrapper       bool // (Binary) Encode a pointer to a scalar as a google.protobuf wrapper type.
+	NilAsEmpty    bool // (Binary) Never write an empty slice, and decode it as non-nil, see nilAsEmpty().
 }
 
 //----------------------------------------
@@ -135,6 +223,37 @@ type Codec struct {
 	concreteInfos    []*TypeInfo
 	disfixToTypeInfo map[DisfixBytes]*TypeInfo
 	nameToTypeInfo   map[string]*TypeInfo
+	nameAliases      map[string]*TypeInfo // See RegisterNameAlias.
+
+	// Settings, see the Set* methods.
+	recoverPolicy    RecoverPolicy
+	anyTypeKey       string
+	resolver         func(fieldContext string, name string) (reflect.Type, bool)
+	omitUnique       bool
+	checksumHash     func() hash.Hash
+	maxAnyDepth      int
+	maxDecodeDepth   int
+	maxGunzipSize    int
+	stdErrors        bool
+	alwaysWriteEmpty bool
+	jsonWriteEmpty   bool
+	skipUnknownIface bool
+	allocator        func(rt reflect.Type) reflect.Value
+	intOverflowMode  IntOverflowMode
+	strictNesting    bool
+	lazyAny          bool
+	emptyStructNil   bool
+	numericCoercion  bool
+	lenientVarints   bool
+	zeroCopyStrings  bool
+	rejectUnknown    bool
+	jsonNamePolicy   func(goFieldName string) string
+	indexedAny       bool
+
+	anyIndex       *anyIndex      // See SetIndexedAnyMode.
+	immutableCache *encodingCache // See RegisterImmutable.
+
+	registrationErrs []error // See RegistrationErrors.
 }
 
 func NewCodec() *Codec {
@@ -143,15 +262,67 @@ func NewCodec() *Codec {
 		typeInfos:        make(map[reflect.Type]*TypeInfo),
 		disfixToTypeInfo: make(map[DisfixBytes]*TypeInfo),
 		nameToTypeInfo:   make(map[string]*TypeInfo),
+		nameAliases:      make(map[string]*TypeInfo),
+		anyTypeKey:       defaultAnyTypeKey,
+		checksumHash:     defaultChecksumHash,
+		maxAnyDepth:      defaultMaxAnyDepth,
+		maxDecodeDepth:   defaultMaxDecodeDepth,
+		maxGunzipSize:    defaultMaxGunzipSize,
+		intOverflowMode:  IntOverflowError,
+		immutableCache:   newEncodingCache(defaultImmutableCacheSize),
 	}
 	return cdc
 }
 
+// NewCodecFromExamples returns a new codec with the types of examples
+// registered as concrete types.  Each is registered under the name
+// "<domain>/<package path>.<type name>", e.g. "example.com/time.Duration",
+// and like RegisterConcrete, as preferring pointers if the example is a
+// pointer.  Returns an error if two examples share a name, or if a type
+// can't be registered.  The codec is not sealed, so more types may still
+// be registered; call Seal() when done.
+func NewCodecFromExamples(domain string, examples ...interface{}) (cdc *Codec, err error) {
+	names := make([]string, len(examples))
+	seen := make(map[string]struct{}, len(examples))
+	for i, o := range examples {
+		rt := reflect.TypeOf(o)
+		if rt == nil {
+			return nil, errors.New("nil example")
+		}
+		for rt.Kind() == reflect.Ptr {
+			rt = rt.Elem()
+		}
+		if rt.Name() == "" || rt.PkgPath() == "" {
+			return nil, fmt.Errorf("example %v is not a named type of a package", rt)
+		}
+		names[i] = fmt.Sprintf("%s/%s.%s", domain, rt.PkgPath(), rt.Name())
+		if _, ok := seen[names[i]]; ok {
+			return nil, fmt.Errorf("duplicate name %s of example %v", names[i], rt)
+		}
+		seen[names[i]] = struct{}{}
+	}
+
+	// Registration panics on errors, e.g. on prefix collisions.
+	defer func() {
+		if r := recover(); r != nil {
+			cdc, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	cdc = NewCodec()
+	for i, o := range examples {
+		cdc.RegisterConcrete(o, names[i], nil)
+	}
+	return cdc, nil
+}
+
 // This function should be used to register all interfaces that will be
 // encoded/decoded by go-amino.
 // Usage:
 // `amino.RegisterInterface((*MyInterface1)(nil), nil)`
 func (cdc *Codec) RegisterInterface(ptr interface{}, iopts *InterfaceOptions) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
 	cdc.assertNotSealed()
 
 	// Get reflect.Type from ptr.
@@ -198,47 +369,904 @@ func (cdc *Codec) RegisterInterface(ptr interface{}, iopts *InterfaceOptions) {
 		// Here, cdc.mtx.Unlock() hasn't been called yet.
 		```
 
-		So, while the above code could be simplified, it's there for defense.
-	*/
+		So, while the above code could be simplified, it's there for defense.
+	*/
+}
+
+// RegisterInterfaceDefault sets the concrete type to decode into for values
+// of the registered interface ifaceType which don't tell their concrete
+// type, e.g. data written before the interface was introduced.  In JSON,
+// this is when the type key is missing or names no registered type; the
+// value is then read from the "value" key if it's the only other key, and
+// otherwise the whole JSON value is decoded as the default concrete type.
+// In binary, this is when there are too few bytes for prefix bytes, or they
+// don't start with the prefix bytes of any implementer (nor with
+// disambiguation bytes), and the bytes are then decoded as the default
+// concrete type.  If unknown interface values are skipped (see
+// SetSkipUnknownInterfaceValues), values with unknown prefix bytes are
+// skipped instead.  Other errors, e.g. ambiguous prefix bytes, are returned
+// as is.  (Legacy binary data which happens to start with the prefix bytes
+// of another type is decoded as that type.)  defaultConcrete must be a
+// registered concrete type implementing ifaceType.
+func (cdc *Codec) RegisterInterfaceDefault(ifaceType reflect.Type, defaultConcrete reflect.Type) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	if ifaceType.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("RegisterInterfaceDefault expects an interface, got %v", ifaceType))
+	}
+	iinfo, err := cdc.getTypeInfoWlock(ifaceType)
+	if err != nil {
+		panic(err)
+	}
+	for defaultConcrete.Kind() == reflect.Ptr {
+		defaultConcrete = defaultConcrete.Elem()
+	}
+	cinfo, err := cdc.getTypeInfoWlock(defaultConcrete)
+	if err != nil {
+		panic(err)
+	}
+	if !cinfo.Registered {
+		panic(fmt.Sprintf("default concrete type %v of %v is not registered", defaultConcrete, ifaceType))
+	}
+	crt := cinfo.Type
+	if cinfo.PointerPreferred {
+		crt = cinfo.PtrToType
+	}
+	if !crt.Implements(ifaceType) {
+		panic(fmt.Sprintf("default concrete type %v does not implement %v", crt, ifaceType))
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		iinfo.DefaultConcrete = cinfo
+	}()
+}
+
+// RegisterOneof restricts the values of the registered interface ifaceType,
+// like a protobuf oneof, to the concrete types concretes, which must be
+// registered and implement it.  Encoding or decoding a value of any other
+// concrete type as ifaceType, in binary or JSON, fails with an error naming
+// the allowed types, even if that type is registered.
+func (cdc *Codec) RegisterOneof(ifaceType reflect.Type, concretes []reflect.Type) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	if ifaceType.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("RegisterOneof expects an interface, got %v", ifaceType))
+	}
+	if len(concretes) == 0 {
+		panic(fmt.Sprintf("RegisterOneof expects concrete types for %v", ifaceType))
+	}
+	iinfo, err := cdc.getTypeInfoWlock(ifaceType)
+	if err != nil {
+		panic(err)
+	}
+	var oneof = make([]reflect.Type, 0, len(concretes))
+	for _, crt := range concretes {
+		for crt.Kind() == reflect.Ptr {
+			crt = crt.Elem()
+		}
+		cinfo, err := cdc.getTypeInfoWlock(crt)
+		if err != nil {
+			panic(err)
+		}
+		if !cinfo.Registered {
+			panic(fmt.Sprintf("oneof concrete type %v of %v is not registered", crt, ifaceType))
+		}
+		if !cinfo.PtrToType.Implements(ifaceType) {
+			panic(fmt.Sprintf("oneof concrete type %v does not implement %v", crt, ifaceType))
+		}
+		oneof = append(oneof, crt)
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		iinfo.Oneof = oneof
+	}()
+}
+
+// Returns an error if the interface iinfo is restricted to other concrete
+// types than cinfo, see RegisterOneof().
+func checkOneof(iinfo *TypeInfo, cinfo *TypeInfo) error {
+	if iinfo.Oneof == nil {
+		return nil
+	}
+	for _, crt := range iinfo.Oneof {
+		if crt == cinfo.Type {
+			return nil
+		}
+	}
+	return fmt.Errorf("concrete type %v is not one of %v allowed for %v", cinfo.Type, iinfo.Oneof, iinfo.Type)
+}
+
+// This function should be used to register concrete types that will appear in
+// interface fields/elements to be encoded/decoded by go-amino.
+// Usage:
+// `amino.RegisterConcrete(MyStruct1{}, "com.tendermint/MyStruct1", nil)`
+// Panics if the type can't be registered, see TryRegisterConcrete().
+func (cdc *Codec) RegisterConcrete(o interface{}, name string, copts *ConcreteOptions) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	err := cdc.TryRegisterConcrete(o, name, copts)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// TryRegisterConcrete is like RegisterConcrete, but returns an error instead
+// of panicking, e.g. to register types described by plugins at runtime.  It
+// fails if the codec is sealed, if o is nil, an interface, a pointer-pointer
+// or an interface pointer, if the type or name (or its prefix bytes) is
+// already registered, or if the type conflicts with other implementers of
+// an interface.  The codec is unchanged when an error is returned.
+func (cdc *Codec) TryRegisterConcrete(o interface{}, name string, copts *ConcreteOptions) (err error) {
+	var pointerPreferred bool
+
+	// Get reflect.Type.
+	rt := reflect.TypeOf(o)
+	if rt == nil {
+		return errors.New("cannot register nil as a concrete type")
+	}
+	if rt.Kind() == reflect.Interface {
+		return fmt.Errorf("expected a non-interface: %v", rt)
+	}
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+		if rt.Kind() == reflect.Ptr {
+			// We can encode/decode pointer-pointers, but not register them.
+			return fmt.Errorf("registering pointer-pointers not yet supported: *%v", rt)
+		}
+		if rt.Kind() == reflect.Interface {
+			// MARKER: No interface-pointers
+			return fmt.Errorf("registering interface-pointers not yet supported: *%v", rt)
+		}
+		pointerPreferred = true
+	}
+
+	// Construct ConcreteInfo, which panics on unsupported fields.
+	var info *TypeInfo
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("cannot register %v: %v", rt, r)
+			}
+		}()
+		info = cdc.newTypeInfoFromRegisteredConcreteType(rt, pointerPreferred, name, copts)
+	}()
+	if err != nil {
+		return err
+	}
+	if err = valueCycleError(info); err != nil {
+		return fmt.Errorf("cannot register %v: %v", rt, err)
+	}
+
+	// Finally, check conflicts and register.
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+
+	if cdc.sealed {
+		return errors.New("codec sealed")
+	}
+	if _, ok := cdc.typeInfos[info.Type]; ok {
+		return fmt.Errorf("TypeInfo already exists for %v", info.Type)
+	}
+	if existing, ok := cdc.disfixToTypeInfo[info.GetDisfix()]; ok {
+		return fmt.Errorf("disfix <%X> already registered for %v", info.GetDisfix(), existing.Type)
+	}
+	if existing, ok := cdc.nameToTypeInfo[info.Name]; ok {
+		return fmt.Errorf("name <%s> already registered for %v", info.Name, existing.Type)
+	}
+	err = cdc.addCheckConflictsWithConcreteNolock(info)
+	if err != nil {
+		return err
+	}
+	cdc.setTypeInfoNolock(info)
+	return nil
+}
+
+// RegisterNameAlias makes alias another name for the concrete type already
+// registered as typeURL (the registered name, optionally with a leading
+// "/", see RegisteredTypeInfo), when decoding an interface value by name,
+// e.g. from JSON or an Any, so that data from a legacy system using other
+// names can be decoded.  Encoding always uses the registered name.  Panics
+// if typeURL isn't registered, or if alias is a registered name.
+func (cdc *Codec) RegisterNameAlias(alias, typeURL string) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	alias = typeURLToName(alias)
+	name := typeURLToName(typeURL)
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+
+	info, ok := cdc.nameToTypeInfo[name]
+	if !ok {
+		panic(fmt.Sprintf("cannot alias unregistered concrete type name %s", name))
+	}
+	if existing, ok := cdc.nameToTypeInfo[alias]; ok {
+		panic(fmt.Sprintf("alias <%s> is already the name of %v", alias, existing.Type))
+	}
+	if existing, ok := cdc.nameAliases[alias]; ok && existing != info {
+		panic(fmt.Sprintf("alias <%s> already registered for %v", alias, existing.Type))
+	}
+	cdc.nameAliases[alias] = info
+}
+
+// RecoverPolicy determines what happens when the codec panics while
+// encoding, decoding or registering types.
+type RecoverPolicy uint8
+
+const (
+	// PolicyPanic lets panics propagate to the caller (default).
+	PolicyPanic RecoverPolicy = iota
+	// PolicyError recovers panics and returns them as a RecoveredPanicErr,
+	// or as an InternalPanicErr for internal errors.
+	PolicyError
+)
+
+// RecoveredPanicErr is returned in place of a panic when the codec's
+// RecoverPolicy is PolicyError.  Panic holds the recovered value.
+type RecoveredPanicErr struct {
+	Panic interface{}
+}
+
+func (e RecoveredPanicErr) Error() string {
+	return fmt.Sprintf("amino: recovered from panic: %v", e.Panic)
+}
+
+// InternalPanicErr is returned instead of a RecoveredPanicErr when the
+// recovered panic is a Go runtime error, e.g. a nil pointer dereference, or
+// a violated invariant of this package.  Unlike other panics, which are due
+// to unsupported types or misuse, these are bugs, e.g. of this package or of
+// a MarshalAmino method.  Panic holds the recovered value.
+type InternalPanicErr struct {
+	Panic interface{}
+}
+
+func (e InternalPanicErr) Error() string {
+	return fmt.Sprintf("amino: recovered from internal error: %v", e.Panic)
+}
+
+// internalError is panicked when an invariant of this package is violated,
+// see InternalPanicErr.
+type internalError string
+
+func (e internalError) Error() string {
+	return string(e)
+}
+
+// SetRecoverPolicy sets the policy for panics raised by the Marshal*,
+// Unmarshal* and Register* methods.  With PolicyError, panics of Marshal*
+// and Unmarshal* are recovered and returned as errors, see
+// RecoveredPanicErr and InternalPanicErr.  Register* methods have no error
+// to return, so their panics are recovered and recorded instead, and the
+// failed registration is skipped; see RegistrationErrors().  Set the policy
+// before registering types.
+func (cdc *Codec) SetRecoverPolicy(policy RecoverPolicy) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.recoverPolicy = policy
+}
+
+// Returns whether panics are recovered, see SetRecoverPolicy().
+func (cdc *Codec) recoversPanics() bool {
+	cdc.mtx.RLock()
+	defer cdc.mtx.RUnlock()
+	return cdc.recoverPolicy == PolicyError
+}
+
+// RegistrationErrors returns the errors recovered from the Register*
+// methods of the codec, in order, when its RecoverPolicy is PolicyError.
+// Check it after registering types, e.g. before Seal().
+func (cdc *Codec) RegistrationErrors() []error {
+	cdc.mtx.RLock()
+	defer cdc.mtx.RUnlock()
+	return append([]error(nil), cdc.registrationErrs...)
+}
+
+// Set *err to the error for a recovered panic, if there is one.
+// Must be called with defer, and only when the policy is PolicyError.
+func recoverToError(err *error) {
+	if r := recover(); r != nil {
+		*err = panicError(r)
+	}
+}
+
+// Records the error for a recovered panic, if there is one, see
+// RegistrationErrors().  Must be called with defer, and only when the
+// policy is PolicyError.
+func (cdc *Codec) recoverToRegistrationErr() {
+	if r := recover(); r != nil {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+		cdc.registrationErrs = append(cdc.registrationErrs, panicError(r))
+	}
+}
+
+// Returns the error for the recovered panic r.
+func panicError(r interface{}) error {
+	switch r.(type) {
+	case runtime.Error, internalError:
+		return InternalPanicErr{Panic: r}
+	default:
+		return RecoveredPanicErr{Panic: r}
+	}
+}
+
+// The JSON key of the concrete type name in interface wrappers.
+const defaultAnyTypeKey = "type"
+
+// SetAnyTypeKey sets the JSON key under which the concrete type name is
+// written in interface wrappers, e.g. {"_type":"name","value":{}} for key
+// "_type".  The default is "type".  The key is used both for encoding and
+// decoding, so both sides must agree on it.
+func (cdc *Codec) SetAnyTypeKey(key string) {
+	cdc.assertNotSealed()
+	if key == "" || key == "value" {
+		panic(fmt.Sprintf("invalid any type key %q", key))
+	}
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.anyTypeKey = key
+}
+
+// The checksum of MarshalBinaryChecksummed, unless set otherwise.
+func defaultChecksumHash() hash.Hash { return crc32.NewIEEE() }
+
+// SetChecksumHash sets the checksum algorithm used by
+// MarshalBinaryChecksummed and UnmarshalBinaryChecksummed, given as a
+// constructor of new hashes, e.g. sha256.New.  The default is CRC-32 (IEEE).
+func (cdc *Codec) SetChecksumHash(newHash func() hash.Hash) {
+	cdc.assertNotSealed()
+	if newHash == nil {
+		panic("SetChecksumHash expects a non-nil hash constructor")
+	}
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.checksumHash = newHash
+}
+
+// The maximum number of nested interface values decoded, unless set otherwise.
+const defaultMaxAnyDepth = 32
+
+// SetMaxAnyDepth sets how many interface values (and dynamic fields) may be
+// nested within each other when decoding, counting the outermost as 1.
+// Decoding fails when there are more, since resolving each one looks up
+// and allocates its concrete type, which could otherwise be exploited with
+// deeply nested inputs.  The default is 32.  Zero removes the limit.
+func (cdc *Codec) SetMaxAnyDepth(n int) {
+	cdc.assertNotSealed()
+	if n < 0 {
+		panic(fmt.Sprintf("invalid max any depth %v", n))
+	}
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.maxAnyDepth = n
+}
+
+// The maximum nesting of structs, lists, maps and interface values decoded,
+// unless set otherwise.
+const defaultMaxDecodeDepth = 100
+
+// SetMaxDecodeDepth sets how many structs, lists, maps and interface values
+// may be nested within each other when decoding, counting the outermost as
+// 1.  Decoding fails when there are more, so that inputs crafted to recurse
+// deeply through recursive types (e.g. a struct with a pointer to its own
+// type) are rejected cleanly.  Unlike SetMaxAnyDepth(), this bounds the
+// nesting of all values.  The default is 100.  Zero removes the limit.
+func (cdc *Codec) SetMaxDecodeDepth(n int) {
+	cdc.assertNotSealed()
+	if n < 0 {
+		panic(fmt.Sprintf("invalid max decode depth %v", n))
+	}
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.maxDecodeDepth = n
+}
+
+// The maximum decompressed size of `amino:"gzip"` fields, unless set
+// otherwise.
+const defaultMaxGunzipSize = 64 << 20
+
+// SetMaxGunzipSize sets how many bytes the value of an `amino:"gzip"` field
+// may decompress to.  Decoding fails when there are more, since a small
+// compressed payload could otherwise expand to exhaust memory.  The default
+// is 64 MiB.  Zero removes the limit.
+func (cdc *Codec) SetMaxGunzipSize(n int) {
+	cdc.assertNotSealed()
+	if n < 0 {
+		panic(fmt.Sprintf("invalid max gunzip size %v", n))
+	}
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.maxGunzipSize = n
+}
+
+// SetAlwaysWriteEmpty sets whether to binary encode all struct fields as if
+// they were tagged `amino:"write_empty"`, i.e. to write their field even
+// for zero values, empty lists and nil pointers (as their zero value).
+// Only lists encoded as repeated fields, e.g. of structs or strings, still
+// write nothing when empty, as they have no entries to write.  Decoding is
+// unaffected.
+func (cdc *Codec) SetAlwaysWriteEmpty(always bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.alwaysWriteEmpty = always
+}
+
+// SetJSONWriteEmpty sets whether to JSON encode all struct fields, even
+// empty ones tagged `json:",omitempty"`, so that consumers always see the
+// same set of keys.  Empty values are written as usual, e.g. nil pointers as
+// null and empty structs as {}.  Fields without omitempty are always
+// written anyway.  This only affects JSON, see SetAlwaysWriteEmpty() for
+// the binary encoding.
+func (cdc *Codec) SetJSONWriteEmpty(always bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.jsonWriteEmpty = always
+}
+
+// UnknownInterfaceValue describes an interface value which was skipped
+// when decoding, see SetSkipUnknownInterfaceValues.
+type UnknownInterfaceValue struct {
+	Interface reflect.Type // The interface type of the skipped value.
+	Name      string       // The concrete type name, when decoding JSON.
+	Disamb    DisambBytes  // The disambiguation bytes, if any, when decoding binary.
+	Prefix    PrefixBytes  // The prefix bytes, when decoding binary.
+}
+
+// SetSkipUnknownInterfaceValues sets whether to tolerate interface values
+// of unregistered concrete types when decoding.  If true, such values are
+// left nil and decoding continues, so that the known parts of partially
+// understood messages can still be read.  Use UnmarshalBinaryBareSkipping
+// or UnmarshalJSONSkipping to also get the skipped values, and to skip them
+// regardless of this setting.  Malformed values still fail to decode.
+// In JSON, interfaces with a default concrete type (see
+// RegisterInterfaceDefault) decode that instead, but in binary only values
+// too short to have prefix bytes do.
+func (cdc *Codec) SetSkipUnknownInterfaceValues(skip bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.skipUnknownIface = skip
+}
+
+// SetAllocator sets the function used to allocate values when decoding,
+// instead of reflect.New, e.g. to allocate them from a memory arena.  Like
+// reflect.New, alloc must return a non-nil pointer to a new zero value of
+// type rt.  It is called for pointers (e.g. to structs), slice elements,
+// and the concrete values of interfaces.  Decoded values refer to the
+// allocated memory, so it must remain valid for as long as they are used,
+// e.g. until the arena is freed.  Nil restores the default.
+func (cdc *Codec) SetAllocator(alloc func(rt reflect.Type) reflect.Value) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.allocator = alloc
+}
+
+// IntOverflowMode determines what happens when a decoded integer does not
+// fit the Go type it is decoded into, e.g. 300 into a uint8.
+type IntOverflowMode string
+
+const (
+	// Fail to decode, with an error naming the field and value.
+	IntOverflowError IntOverflowMode = "error"
+	// Keep the low-order bits, like a Go conversion would.
+	IntOverflowWrap IntOverflowMode = "wrap"
+)
+
+// SetIntOverflowMode sets what happens when a binary decoded integer does
+// not fit the Go type of its field.  The default is IntOverflowError.
+func (cdc *Codec) SetIntOverflowMode(mode IntOverflowMode) {
+	cdc.assertNotSealed()
+	if mode != IntOverflowError && mode != IntOverflowWrap {
+		panic(fmt.Sprintf("invalid int overflow mode %q", mode))
+	}
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.intOverflowMode = mode
+}
+
+// SetNumericCoercion sets whether an integer struct field may be binary
+// decoded from a field written with a different integer encoding, e.g. as
+// fixed32 when the field is now a varint int64, for when a field's type
+// was changed.  Integers of all widths are written as varints unless
+// tagged `binary:"fixed32"` or `binary:"fixed64"`, so widening is
+// otherwise only a problem between these encodings.  The wire does not
+// record signedness, so values are read with the signedness of the field,
+// and decoding fails if they overflow it, regardless of the
+// IntOverflowMode.
+func (cdc *Codec) SetNumericCoercion(coerce bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.numericCoercion = coerce
+}
+
+// SetRejectNonCanonicalVarint sets whether binary decoding fails with
+// ErrNonCanonicalVarint on a varint which isn't minimally encoded, i.e. has
+// trailing zero continuation bytes, which is the default.  Such varints
+// would let a signed message be altered without changing what it decodes
+// to, so only accept them (by passing false) to read legacy data.  This
+// applies to integers, field keys, and the byte-length prefixes of strings,
+// byte slices, structs and lists, but not to skipped unknown fields.
+func (cdc *Codec) SetRejectNonCanonicalVarint(reject bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.lenientVarints = !reject
+}
+
+// SetZeroCopyStrings sets whether binary decoding makes strings which point
+// into the decoded bytes, rather than copies of them, to save copying when
+// parsing large buffers.
+//
+// WARNING: The decoded bytes must then never be modified (nor reused, e.g.
+// from a sync.Pool) while any decoded string is in use, since that would
+// change the string, which Go assumes is immutable: e.g. a map keyed by it
+// would break.  Only use this for read-only buffers which outlive the
+// decoded values.
+func (cdc *Codec) SetZeroCopyStrings(zeroCopy bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.zeroCopyStrings = zeroCopy
+}
+
+// SetRejectUnknownFields sets whether binary decoding fails when a struct,
+// at any level, has a field whose number isn't that of any of its fields
+// (or virtual fields, see RegisterVirtualField()), e.g. for
+// security-sensitive messages which must not carry data the decoder
+// ignores.  By default such fields are skipped, for compatibility with
+// encoders of newer versions of the struct.  Unlike SetStrictNesting(),
+// this names the unknown field number, and allows virtual fields.
+func (cdc *Codec) SetRejectUnknownFields(reject bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.rejectUnknown = reject
+}
+
+// SetStrictNesting sets whether decoding fails when a struct, at any level,
+// is followed by bytes within its encoding after its last known field,
+// other than its virtual fields (see RegisterVirtualField).
+// By default such bytes are skipped if they are well-formed fields, for
+// compatibility with encoders of newer versions of the struct.  Strict
+// nesting rejects them, so that each struct has a single encoding.
+func (cdc *Codec) SetStrictNesting(strict bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.strictNesting = strict
+}
+
+// SetEmptyStructPointersNil sets whether a struct field (at any level)
+// which is a pointer to a struct decodes to nil when its binary encoding is
+// empty, i.e. a field key followed by a zero length.  By default it
+// decodes to a pointer to the zero struct, which is how a non-nil pointer
+// to the zero struct is encoded, while a nil pointer is not encoded at
+// all.  Struct values are decoded to the zero struct either way.
+func (cdc *Codec) SetEmptyStructPointersNil(isNil bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.emptyStructNil = isNil
+}
+
+// SetLazyAny sets whether interface values are decoded lazily from binary:
+// if enabled, an interface value whose interface type is satisfied by
+// *LazyAny (e.g. interface{}) is decoded as a *LazyAny which holds the
+// encoding of its concrete value, and decodes it only when resolved.
+// JSON is always decoded eagerly.
+func (cdc *Codec) SetLazyAny(lazy bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.lazyAny = lazy
+}
+
+// SetInterfaceResolver sets a function which is consulted before the
+// registered names when decoding an interface from JSON.  It is called with
+// the JSON name of the enclosing struct field (empty at the top level) and
+// the concrete type name found on the wire.  If it returns true, the
+// returned type is decoded instead, which must be a registered concrete type
+// implementing the interface.  Otherwise the name is looked up as usual.
+func (cdc *Codec) SetInterfaceResolver(resolver func(fieldContext string, name string) (reflect.Type, bool)) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.resolver = resolver
+}
+
+// RegisterIntCodec replaces the varint encoding of the named integer type rt
+// with a custom encoding.  The value is passed as a uint64, two's complement
+// for signed types.  The encoding is written length-prefixed, with field type
+// Typ3ByteLength, and decode must read all of it.  Fields tagged with
+// `binary:"fixed32"` or `binary:"fixed64"` are still encoded as fixed-width.
+func (cdc *Codec) RegisterIntCodec(rt reflect.Type, encode func(uint64) []byte,
+	decode func([]byte) (uint64, int, error)) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	switch rt.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		panic(fmt.Sprintf("RegisterIntCodec expects an integer type, got %v", rt))
+	}
+	if encode == nil || decode == nil {
+		panic("RegisterIntCodec expects non-nil encode and decode functions")
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+	if info.IsAminoMarshaler {
+		panic(fmt.Sprintf("RegisterIntCodec cannot be used with amino marshaler %v", rt))
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		if info.IntEncoder != nil {
+			panic(fmt.Sprintf("int codec already registered for %v", rt))
+		}
+		info.IntEncoder = encode
+		info.IntDecoder = decode
+	}()
+}
+
+// Returns whether values of info with options fopts are encoded with the codec
+// registered by RegisterIntCodec().
+func usesIntCodec(info *TypeInfo, fopts FieldOptions) bool {
+	return info.IntEncoder != nil && !fopts.BinFixed64 && !fopts.BinFixed32
+}
+
+// RegisterOmitEmptyFunc makes fn decide whether a value of type rt is empty,
+// and so omitted from JSON where its field is tagged `json:",omitempty"`,
+// instead of comparing it to its zero value.  This allows e.g. a float type
+// to omit NaN, or to keep -0.0, which equals its zero value.  fn is never
+// called with a nil pointer, which is always empty.
+func (cdc *Codec) RegisterOmitEmptyFunc(rt reflect.Type, fn func(v reflect.Value) bool) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	if fn == nil {
+		panic("RegisterOmitEmptyFunc expects a non-nil function")
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		if info.OmitEmpty != nil {
+			panic(fmt.Sprintf("omitempty function already registered for %v", rt))
+		}
+		info.OmitEmpty = fn
+	}()
+}
+
+// RegisterJSONNull makes the JSON encoding of values of type rt null where
+// isNull returns true, e.g. for a sentinel "invalid" value, and makes null
+// decode to the value returned by newNull instead of the zero value.  The
+// binary encoding is unaffected.  Pointers to rt still encode nil as null,
+// and null still decodes to a nil pointer.
+func (cdc *Codec) RegisterJSONNull(rt reflect.Type, isNull func(v reflect.Value) bool, newNull func() reflect.Value) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	if isNull == nil || newNull == nil {
+		panic("RegisterJSONNull expects non-nil isNull and newNull functions")
+	}
+	if rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Interface {
+		panic(fmt.Sprintf("RegisterJSONNull expects a non-pointer concrete type, got %v", rt))
+	}
+	if nrv := newNull(); !nrv.IsValid() || nrv.Type() != rt {
+		panic(fmt.Sprintf("RegisterJSONNull expects newNull to return a %v", rt))
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		if info.JSONIsNull != nil {
+			panic(fmt.Sprintf("JSON null already registered for %v", rt))
+		}
+		info.JSONIsNull = isNull
+		info.JSONNewNull = newNull
+	}()
+}
+
+// SetOmitAnyTypeWhenUnique sets whether to omit the disambiguation and prefix
+// bytes when binary encoding a value of an interface type which has exactly
+// one registered implementer, since the concrete type is implied.  A non-nil
+// value whose concrete value encodes to no bytes, e.g. a zero struct, is
+// written as a single 0x00 byte instead, to tell it from nil.  Registering a
+// second implementer for such an interface after it was encoded or decoded
+// makes Seal() panic, as the encoding would change.
+func (cdc *Codec) SetOmitAnyTypeWhenUnique(omit bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.omitUnique = omit
+}
+
+// Returns the only implementer of iinfo if its prefix bytes are to be
+// omitted, see SetOmitAnyTypeWhenUnique().
+func (cdc *Codec) getUniqueImplementerWlock(iinfo *TypeInfo) (cinfo *TypeInfo, ok bool) {
+	if !cdc.omitUnique {
+		return nil, false
+	}
+	cdc.mtx.RLock()
+	if countImplementers(iinfo) == 1 {
+		for _, cinfos := range iinfo.Implementers {
+			cinfo, ok = cinfos[0], true
+		}
+	}
+	omitted := iinfo.OmittedPrefix
+	cdc.mtx.RUnlock()
+
+	if ok && !omitted {
+		// Only the first omission takes the write lock.
+		cdc.mtx.Lock()
+		iinfo.OmittedPrefix = true
+		cdc.mtx.Unlock()
+	}
+	return cinfo, ok
+}
+
+func countImplementers(iinfo *TypeInfo) (count int) {
+	for _, cinfos := range iinfo.Implementers {
+		count += len(cinfos)
+	}
+	return count
 }
 
-// This function should be used to register concrete types that will appear in
-// interface fields/elements to be encoded/decoded by go-amino.
-// Usage:
-// `amino.RegisterConcrete(MyStruct1{}, "com.tendermint/MyStruct1", nil)`
-func (cdc *Codec) RegisterConcrete(o interface{}, name string, copts *ConcreteOptions) {
+// RegisterVirtualField adds an output-only field to the struct type rt.
+// When encoding rt, getter is called with the struct value and its result is
+// written as field number fieldNum in binary, or under jsonName in JSON.
+// A nil result is omitted.  Decoding ignores virtual fields.
+// Since decoders expect ascending field numbers, fieldNum must be greater
+// than the field numbers of all of rt's fields.
+func (cdc *Codec) RegisterVirtualField(rt reflect.Type, fieldNum uint32, jsonName string,
+	getter func(v reflect.Value) interface{}) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
 	cdc.assertNotSealed()
 
-	var pointerPreferred bool
-
-	// Get reflect.Type.
-	rt := reflect.TypeOf(o)
-	if rt.Kind() == reflect.Interface {
-		panic(fmt.Sprintf("expected a non-interface: %v", rt))
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
 	}
-	if rt.Kind() == reflect.Ptr {
-		rt = rt.Elem()
-		if rt.Kind() == reflect.Ptr {
-			// We can encode/decode pointer-pointers, but not register them.
-			panic(fmt.Sprintf("registering pointer-pointers not yet supported: *%v", rt))
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType {
+		panic(fmt.Sprintf("RegisterVirtualField expects a struct, got %v", rt))
+	}
+	if fieldNum == 0 || fieldNum > (1<<29-1) {
+		panic(fmt.Sprintf("invalid field number %v", fieldNum))
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		for _, field := range info.Fields {
+			if field.BinFieldNum >= fieldNum {
+				panic(fmt.Sprintf("virtual field # %v of %v must be greater than field %v # %v",
+					fieldNum, info.Type, field.Name, field.BinFieldNum))
+			}
+			if field.JSONName == jsonName {
+				panic(fmt.Sprintf("virtual field JSON name %q of %v conflicts with field %v",
+					jsonName, info.Type, field.Name))
+			}
 		}
-		if rt.Kind() == reflect.Interface {
-			// MARKER: No interface-pointers
-			panic(fmt.Sprintf("registering interface-pointers not yet supported: *%v", rt))
+		var i int
+		for i = 0; i < len(info.VirtualFields); i++ {
+			vfield := info.VirtualFields[i]
+			if vfield.BinFieldNum == fieldNum || vfield.JSONName == jsonName {
+				panic(fmt.Sprintf("virtual field # %v (%q) of %v already registered",
+					vfield.BinFieldNum, vfield.JSONName, info.Type))
+			}
+			if vfield.BinFieldNum > fieldNum {
+				break
+			}
 		}
-		pointerPreferred = true
+		// Keep virtual fields sorted by field number.
+		vfield := VirtualFieldInfo{
+			Getter: getter,
+			FieldOptions: FieldOptions{
+				JSONName:    jsonName,
+				BinFieldNum: fieldNum,
+			},
+		}
+		vfields := make([]VirtualFieldInfo, 0, len(info.VirtualFields)+1)
+		vfields = append(vfields, info.VirtualFields[:i]...)
+		vfields = append(vfields, vfield)
+		vfields = append(vfields, info.VirtualFields[i:]...)
+		info.VirtualFields = vfields
+	}()
+}
+
+// ReserveFieldNumbers records that the field numbers nums of the struct type
+// rt may not be used, like proto3's `reserved` statement, e.g. for the
+// numbers of removed fields, so that a new field can't accidentally reuse
+// one and misread old encodings.  Reservations are checked by Validate().
+func (cdc *Codec) ReserveFieldNumbers(rt reflect.Type, nums ...uint32) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
 	}
+	cdc.assertNotSealed()
 
-	// Construct ConcreteInfo.
-	var info = cdc.newTypeInfoFromRegisteredConcreteType(rt, pointerPreferred, name, copts)
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType {
+		panic(fmt.Sprintf("ReserveFieldNumbers expects a struct, got %v", rt))
+	}
+	for _, num := range nums {
+		if num == 0 || num > (1<<29-1) {
+			panic(fmt.Sprintf("invalid field number %v", num))
+		}
+	}
 
-	// Finally, check conflicts and register.
 	func() {
 		cdc.mtx.Lock()
 		defer cdc.mtx.Unlock()
 
-		cdc.addCheckConflictsWithConcreteNolock(info)
-		cdc.setTypeInfoNolock(info)
+		info.ReservedFieldNums = append(info.ReservedFieldNums, nums...)
 	}()
 }
 
@@ -246,10 +1274,209 @@ func (cdc *Codec) Seal() *Codec {
 	cdc.mtx.Lock()
 	defer cdc.mtx.Unlock()
 
+	for _, iinfo := range cdc.interfaceInfos {
+		if iinfo.OmittedPrefix && countImplementers(iinfo) > 1 {
+			panic(fmt.Sprintf("prefix bytes were omitted for interface %v, "+
+				"but it has more than one implementer now", iinfo.Type))
+		}
+	}
 	cdc.sealed = true
 	return cdc
 }
 
+// Clone returns a new codec with the same registrations and settings as cdc,
+// e.g. to register test-only types on top of a sealed application codec.
+// The clone is never sealed, even if cdc is.  Its TypeInfos are copies, so
+// registering on the clone (which mutates them, e.g. to add implementers to
+// an interface) never affects cdc, and vice versa.  Encrypted fields (see
+// RegisterFieldEncryption) are encoded with the clone's registrations.
+func (cdc *Codec) Clone() *Codec {
+	cdc.mtx.RLock()
+	defer cdc.mtx.RUnlock()
+
+	clone := NewCodec()
+
+	// Copy the TypeInfos, then point the copies at each other.
+	infos := make(map[*TypeInfo]*TypeInfo, len(cdc.typeInfos))
+	for rt, info := range cdc.typeInfos {
+		info2 := new(TypeInfo)
+		*info2 = *info
+		info2.Fields = append([]FieldInfo(nil), info.Fields...)
+		for i, field := range info2.Fields {
+			// Encode and decode with the clone's registrations.
+			if fc, ok := field.FieldCodec.(codecBoundFieldCodec); ok {
+				info2.Fields[i].FieldCodec = fc.withCodec(clone)
+			}
+		}
+		info2.VirtualFields = append([]VirtualFieldInfo(nil), info.VirtualFields...)
+		info2.ReservedFieldNums = append([]uint32(nil), info.ReservedFieldNums...)
+		info2.InterfaceInfo.Priority = append([]DisfixBytes(nil), info.InterfaceInfo.Priority...)
+		clone.typeInfos[rt] = info2
+		infos[info] = info2
+	}
+	for _, info2 := range clone.typeInfos {
+		if info2.Implementers != nil {
+			impls := make(map[PrefixBytes][]*TypeInfo, len(info2.Implementers))
+			for pb, cinfos := range info2.Implementers {
+				for _, cinfo := range cinfos {
+					impls[pb] = append(impls[pb], infos[cinfo])
+				}
+			}
+			info2.Implementers = impls
+		}
+		if info2.DefaultConcrete != nil {
+			info2.DefaultConcrete = infos[info2.DefaultConcrete]
+		}
+	}
+	for _, iinfo := range cdc.interfaceInfos {
+		clone.interfaceInfos = append(clone.interfaceInfos, infos[iinfo])
+	}
+	for _, cinfo := range cdc.concreteInfos {
+		clone.concreteInfos = append(clone.concreteInfos, infos[cinfo])
+	}
+	for df, info := range cdc.disfixToTypeInfo {
+		clone.disfixToTypeInfo[df] = infos[info]
+	}
+	for name, info := range cdc.nameToTypeInfo {
+		clone.nameToTypeInfo[name] = infos[info]
+	}
+	for alias, info := range cdc.nameAliases {
+		clone.nameAliases[alias] = infos[info]
+	}
+
+	clone.recoverPolicy = cdc.recoverPolicy
+	clone.anyTypeKey = cdc.anyTypeKey
+	clone.resolver = cdc.resolver
+	clone.omitUnique = cdc.omitUnique
+	clone.checksumHash = cdc.checksumHash
+	clone.maxAnyDepth = cdc.maxAnyDepth
+	clone.maxDecodeDepth = cdc.maxDecodeDepth
+	clone.maxGunzipSize = cdc.maxGunzipSize
+	clone.stdErrors = cdc.stdErrors
+	clone.alwaysWriteEmpty = cdc.alwaysWriteEmpty
+	clone.jsonWriteEmpty = cdc.jsonWriteEmpty
+	clone.skipUnknownIface = cdc.skipUnknownIface
+	clone.allocator = cdc.allocator
+	clone.intOverflowMode = cdc.intOverflowMode
+	clone.strictNesting = cdc.strictNesting
+	clone.lazyAny = cdc.lazyAny
+	clone.emptyStructNil = cdc.emptyStructNil
+	clone.numericCoercion = cdc.numericCoercion
+	clone.lenientVarints = cdc.lenientVarints
+	clone.zeroCopyStrings = cdc.zeroCopyStrings
+	clone.rejectUnknown = cdc.rejectUnknown
+	clone.jsonNamePolicy = cdc.jsonNamePolicy
+	clone.indexedAny = cdc.indexedAny
+	return clone
+}
+
+// ValidateInterfaceCoverage returns an error for each interface field (or
+// list element, or map value) of the registered concrete types, and of the
+// types they contain, whose interface isn't implemented by any registered
+// concrete type, so such a field could only ever be decoded as nil.  Call
+// it at startup, e.g. after Seal(), to catch forgotten registrations.
+// Union and dynamic fields are not checked.
+func (cdc *Codec) ValidateInterfaceCoverage() (errs []error) {
+	cdc.mtx.RLock()
+	var concretes = append([]*TypeInfo(nil), cdc.concreteInfos...)
+	cdc.mtx.RUnlock()
+
+	var covered = make(map[reflect.Type]bool)
+	var visited = make(map[reflect.Type]bool)
+	var visitStruct func(rt reflect.Type)
+	// Checks the value of type rt of the field named owner.
+	var check = func(owner string, rt reflect.Type) {
+		for rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Slice ||
+			rt.Kind() == reflect.Array || rt.Kind() == reflect.Map {
+			rt = rt.Elem()
+		}
+		switch rt.Kind() {
+		case reflect.Struct:
+			visitStruct(rt)
+		case reflect.Interface:
+			ok, checked := covered[rt]
+			if !checked {
+				for _, cinfo := range concretes {
+					if cinfo.Type.Implements(rt) || cinfo.PtrToType.Implements(rt) {
+						ok = true
+						break
+					}
+				}
+				covered[rt] = ok
+			}
+			if !ok {
+				errs = append(errs, fmt.Errorf("%v has no registered implementation of %v", owner, rt))
+			}
+		}
+	}
+	visitStruct = func(rt reflect.Type) {
+		if visited[rt] {
+			return
+		}
+		visited[rt] = true
+		info, err := cdc.getTypeInfoWlock(rt)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if info.IsAminoMarshaler {
+			check(rt.String(), info.AminoMarshalReprType)
+			return
+		}
+		for _, field := range info.Fields {
+			if field.union != nil || field.DynamicResolver != nil {
+				continue
+			}
+			check(fmt.Sprintf("%v.%v", rt, field.Name), field.Type)
+		}
+	}
+	for _, cinfo := range concretes {
+		if cinfo.Type.Kind() == reflect.Struct {
+			visitStruct(cinfo.Type)
+		} else {
+			check(cinfo.Type.String(), cinfo.Type)
+		}
+	}
+	return errs
+}
+
+// Validate returns an error for each field (or virtual field) of a struct
+// type that uses a field number reserved with ReserveFieldNumbers().  Call
+// it at startup, or in a test, after all types are registered.
+func (cdc *Codec) Validate() (errs []error) {
+	cdc.mtx.RLock()
+	defer cdc.mtx.RUnlock()
+
+	var infos []*TypeInfo
+	for _, info := range cdc.typeInfos {
+		if len(info.ReservedFieldNums) > 0 {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Type.String() < infos[j].Type.String()
+	})
+	for _, info := range infos {
+		var reserved = make(map[uint32]bool, len(info.ReservedFieldNums))
+		for _, num := range info.ReservedFieldNums {
+			reserved[num] = true
+		}
+		for _, field := range info.Fields {
+			if reserved[field.BinFieldNum] {
+				errs = append(errs, fmt.Errorf("%v.%v uses reserved field number %v",
+					info.Type, field.Name, field.BinFieldNum))
+			}
+		}
+		for _, vfield := range info.VirtualFields {
+			if reserved[vfield.BinFieldNum] {
+				errs = append(errs, fmt.Errorf("%v virtual field %v uses reserved field number %v",
+					info.Type, vfield.JSONName, vfield.BinFieldNum))
+			}
+		}
+	}
+	return errs
+}
+
 // PrintTypes writes all registered types in a markdown-style table.
 // The table's header is:
 //
@@ -257,8 +1484,6 @@ func (cdc *Codec) Seal() *Codec {
 //
 // Where Type is the golang type name and Name is the name the type was registered with.
 func (cdc *Codec) PrintTypes(out io.Writer) error {
-	cdc.mtx.RLock()
-	defer cdc.mtx.RUnlock()
 	// print header
 	if _, err := io.WriteString(out, "| Type | Name | Prefix | Length | Notes |\n"); err != nil {
 		return err
@@ -267,40 +1492,11 @@ func (cdc *Codec) PrintTypes(out io.Writer) error {
 		return err
 	}
 	// only print concrete types for now (if we want everything, we can iterate over the typeInfos map instead)
-	for _, i := range cdc.concreteInfos {
-		if _, err := io.WriteString(out, "| "); err != nil {
-			return err
-		}
+	for _, i := range cdc.RegisteredTypes() {
 		// TODO(ismail): optionally create a link to code on github:
-		if _, err := io.WriteString(out, i.Type.Name()); err != nil {
-			return err
-		}
-		if _, err := io.WriteString(out, " | "); err != nil {
-			return err
-		}
-		if _, err := io.WriteString(out, i.Name); err != nil {
-			return err
-		}
-		if _, err := io.WriteString(out, " | "); err != nil {
-			return err
-		}
-		if _, err := io.WriteString(out, fmt.Sprintf("0x%X", i.Prefix)); err != nil {
-			return err
-		}
-		if _, err := io.WriteString(out, " | "); err != nil {
-			return err
-		}
-
-		if _, err := io.WriteString(out, getLengthStr(i)); err != nil {
-			return err
-		}
-
-		if _, err := io.WriteString(out, " | "); err != nil {
-			return err
-		}
 		// empty notes table data by default // TODO(ismail): make this configurable
-
-		if _, err := io.WriteString(out, " |\n"); err != nil {
+		row := fmt.Sprintf("| %v | %v | 0x%X | %v |  |\n", i.Type.Name(), i.Name, i.Prefix, i.Length)
+		if _, err := io.WriteString(out, row); err != nil {
 			return err
 		}
 	}
@@ -308,6 +1504,48 @@ func (cdc *Codec) PrintTypes(out io.Writer) error {
 	return nil
 }
 
+// RegisteredTypeInfo describes a concrete type registered with the codec,
+// see RegisteredTypes.
+type RegisteredTypeInfo struct {
+	Type             reflect.Type // Not a pointer, even if PointerPreferred.
+	Name             string       // The name the type was registered with.
+	TypeURL          string       // The name with a leading "/", as in a protobuf Any.
+	Prefix           PrefixBytes
+	PointerPreferred bool   // Decoded as a pointer in interface values.
+	Length           string // The encoded length if fixed, else "variable".
+}
+
+// RegisteredTypes returns all registered concrete types, sorted by TypeURL.
+// The result is a copy, which callers may modify.
+func (cdc *Codec) RegisteredTypes() []RegisteredTypeInfo {
+	cdc.mtx.RLock()
+	defer cdc.mtx.RUnlock()
+
+	var infos = make([]RegisteredTypeInfo, 0, len(cdc.nameToTypeInfo))
+	for name, info := range cdc.nameToTypeInfo {
+		infos = append(infos, RegisteredTypeInfo{
+			Type:             info.Type,
+			Name:             name,
+			TypeURL:          nameToTypeURL(name),
+			Prefix:           info.Prefix,
+			PointerPreferred: info.PointerPreferred,
+			Length:           getLengthStr(info),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].TypeURL < infos[j].TypeURL })
+	return infos
+}
+
+// Returns the type URL of the registered name, as in a protobuf Any.
+func nameToTypeURL(name string) string {
+	return "/" + name
+}
+
+// Returns the registered name of typeURL, which may lack the leading "/".
+func typeURLToName(typeURL string) string {
+	return strings.TrimPrefix(typeURL, "/")
+}
+
 // A heuristic to guess the size of a registered type and return it as a string.
 // If the size is not fixed it returns "variable".
 func getLengthStr(info *TypeInfo) string {
@@ -338,7 +1576,7 @@ func (cdc *Codec) assertNotSealed() {
 func (cdc *Codec) setTypeInfoNolock(info *TypeInfo) {
 
 	if info.Type.Kind() == reflect.Ptr {
-		panic(fmt.Sprintf("unexpected pointer type"))
+		panic(internalError("unexpected pointer type"))
 	}
 	if _, ok := cdc.typeInfos[info.Type]; ok {
 		panic(fmt.Sprintf("TypeInfo already exists for %v", info.Type))
@@ -349,6 +1587,7 @@ func (cdc *Codec) setTypeInfoNolock(info *TypeInfo) {
 		cdc.interfaceInfos = append(cdc.interfaceInfos, info)
 	} else if info.Registered {
 		cdc.concreteInfos = append(cdc.concreteInfos, info)
+		cdc.anyIndex = nil // See getAnyIndexWlock().
 		disfix := info.GetDisfix()
 		if existing, ok := cdc.disfixToTypeInfo[disfix]; ok {
 			panic(fmt.Sprintf("disfix <%X> already registered for %v", disfix, existing.Type))
@@ -364,29 +1603,50 @@ func (cdc *Codec) setTypeInfoNolock(info *TypeInfo) {
 }
 
 func (cdc *Codec) getTypeInfoWlock(rt reflect.Type) (info *TypeInfo, err error) {
-	// We do not use defer cdc.mtx.Unlock() here due to performance overhead of
-	// defer in go1.11 (and prior versions). Ensure new code paths unlock the
-	// mutex.
-	cdc.mtx.Lock() // requires wlock because we might set.
-
 	// Dereference pointer type.
 	for rt.Kind() == reflect.Ptr {
 		rt = rt.Elem()
 	}
 
+	// Fast path: most types are already known, so only take the read lock,
+	// which lets concurrent encoders and decoders proceed in parallel.
+	// We do not use defer cdc.mtx.RUnlock() here due to performance overhead
+	// of defer in go1.11 (and prior versions).
+	cdc.mtx.RLock()
 	info, ok := cdc.typeInfos[rt]
-	if !ok {
-		if rt.Kind() == reflect.Interface {
-			err = fmt.Errorf("unregistered interface %v", rt)
-			cdc.mtx.Unlock()
-			return
-		}
+	cdc.mtx.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	// Slow path: take the write lock, and check again as another goroutine
+	// may have set it in between.  Seal() also takes the write lock, so it
+	// can't race with the construction below.
+	cdc.mtx.Lock() // requires wlock because we might set.
+	info, ok = cdc.typeInfos[rt]
+	if ok {
+		cdc.mtx.Unlock()
+		return info, nil
+	}
+	if rt.Kind() == reflect.Interface {
+		err = fmt.Errorf("unregistered interface %v", rt)
+		cdc.mtx.Unlock()
+		return
+	}
 
+	// Construction may panic (e.g. on a float field without
+	// `amino:"unsafe"`), so unlock with defer on this slow path, otherwise
+	// the Codec would become unusable after a recovered panic.
+	func() {
+		defer cdc.mtx.Unlock()
 		info = cdc.newTypeInfoUnregistered(rt)
+		if err = valueCycleError(info); err != nil {
+			info = nil
+			return
+		}
 		cdc.setTypeInfoNolock(info)
-	}
-	cdc.mtx.Unlock()
-	return info, nil
+	}()
+	return info, err
 }
 
 // iinfo: TypeInfo for the interface for which we must decode a
@@ -413,6 +1673,15 @@ func (cdc *Codec) getTypeInfoFromPrefixRlock(iinfo *TypeInfo, pb PrefixBytes) (i
 	return
 }
 
+// Returns whether any implementer of iinfo has the prefix bytes pb.
+func (cdc *Codec) hasPrefixRlock(iinfo *TypeInfo, pb PrefixBytes) bool {
+	cdc.mtx.RLock()
+	defer cdc.mtx.RUnlock()
+
+	_, ok := iinfo.Implementers[pb]
+	return ok
+}
+
 func (cdc *Codec) getTypeInfoFromDisfixRlock(df DisfixBytes) (info *TypeInfo, err error) {
 	// We do not use defer cdc.mtx.Unlock() here due to performance overhead of
 	// defer in go1.11 (and prior versions). Ensure new code paths unlock the
@@ -436,6 +1705,9 @@ func (cdc *Codec) getTypeInfoFromNameRlock(name string) (info *TypeInfo, err err
 	cdc.mtx.RLock()
 
 	info, ok := cdc.nameToTypeInfo[name]
+	if !ok {
+		info, ok = cdc.nameAliases[name]
+	}
 	if !ok {
 		err = fmt.Errorf("unrecognized concrete type name %s", name)
 		cdc.mtx.RUnlock()
@@ -445,57 +1717,221 @@ func (cdc *Codec) getTypeInfoFromNameRlock(name string) (info *TypeInfo, err err
 	return
 }
 
+// Like getTypeInfoFromNameRlock, but consults the interface resolver first.
+func (cdc *Codec) resolveConcreteTypeInfo(iinfo *TypeInfo, fieldContext string, name string) (info *TypeInfo, err error) {
+	if cdc.resolver != nil {
+		if rt, ok := cdc.resolver(fieldContext, name); ok {
+			info, err = cdc.getTypeInfoWlock(rt)
+			if err != nil {
+				return
+			}
+			if !info.Registered {
+				err = fmt.Errorf("interface resolver returned unregistered concrete type %v for name %s", rt, name)
+				return
+			}
+			crt := info.Type
+			if info.PointerPreferred {
+				crt = reflect.PtrTo(crt)
+			}
+			if !crt.Implements(iinfo.Type) {
+				err = fmt.Errorf("interface resolver returned %v for name %s, which does not implement %v",
+					rt, name, iinfo.Type)
+				return
+			}
+			return
+		}
+	}
+	return cdc.getTypeInfoFromNameRlock(name)
+}
+
 func (cdc *Codec) parseStructInfo(rt reflect.Type) (sinfo StructInfo) {
 	if rt.Kind() != reflect.Struct {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 
-	var infos = make([]FieldInfo, 0, rt.NumField())
-	for i := 0; i < rt.NumField(); i++ {
-		var field = rt.Field(i)
+	var sfields = structFields(rt)
+	var infos = make([]FieldInfo, 0, len(sfields))
+	var explicit = make(map[uint32]string) // Set with `amino:"field=N"`.
+	for _, sfield := range sfields {
+		var field = sfield.field
 		var ftype = field.Type
-		var unpackedList = false
-		if !isExported(field) {
-			continue // field is unexported
-		}
 		skip, fopts := cdc.parseFieldOptions(field)
 		if skip {
 			continue // e.g. json:"-"
 		}
-		if ftype.Kind() == reflect.Array || ftype.Kind() == reflect.Slice {
-			if ftype.Elem().Kind() == reflect.Uint8 {
-				// These get handled by our optimized methods,
-				// encodeReflectBinaryByte[Slice/Array].
-				unpackedList = false
-			} else {
-				etype := ftype.Elem()
-				for etype.Kind() == reflect.Ptr {
-					etype = etype.Elem()
-				}
-				typ3 := typeToTyp3(etype, fopts)
-				if typ3 == Typ3ByteLength {
-					unpackedList = true
-				}
+		if fopts.BinFieldNum != 0 {
+			if other, ok := explicit[fopts.BinFieldNum]; ok {
+				panic(fmt.Sprintf("fields %v and %v of %v both have field number %v",
+					other, field.Name, rt, fopts.BinFieldNum))
 			}
+			explicit[fopts.BinFieldNum] = field.Name
 		}
-		// NOTE: This is going to change a bit.
-		// NOTE: BinFieldNum starts with 1.
-		fopts.BinFieldNum = uint32(len(infos) + 1)
 		fieldInfo := FieldInfo{
 			Name:         field.Name, // Mostly for debugging.
-			Index:        i,
+			Index:        sfield.path[0],
 			Type:         ftype,
 			ZeroValue:    reflect.Zero(ftype),
-			UnpackedList: unpackedList,
+			UnpackedList: isUnpackedList(ftype, fopts),
 			FieldOptions: fopts,
 		}
+		if len(sfield.path) > 1 {
+			fieldInfo.Index, fieldInfo.indexPath = -1, sfield.path
+		}
+		if fopts.Wrapper {
+			fieldInfo.WrapperType = wrapperType(field, fopts)
+		}
 		checkUnsafe(fieldInfo)
 		infos = append(infos, fieldInfo)
 	}
-	sinfo = StructInfo{infos}
+	// NOTE: BinFieldNum starts with 1.
+	// Fields without an explicit number get the one after the previous
+	// field's, skipping explicit numbers, so without any they are numbered
+	// in order.  Fields are then kept in order of their numbers, which the
+	// binary encoding requires, and which is also their JSON order.
+	var fieldNum uint32
+	for i := range infos {
+		if infos[i].BinFieldNum != 0 {
+			fieldNum = infos[i].BinFieldNum
+			continue
+		}
+		fieldNum++
+		for explicit[fieldNum] != "" {
+			fieldNum++
+		}
+		if fieldNum > (1<<29 - 1) {
+			panic(fmt.Sprintf("field %v of %v would have field number %v, which exceeds 1<<29-1",
+				infos[i].Name, rt, fieldNum))
+		}
+		infos[i].BinFieldNum = fieldNum
+		explicit[fieldNum] = infos[i].Name
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].BinFieldNum < infos[j].BinFieldNum })
+	// Fields added in later versions must have higher field numbers, so the
+	// fields of any version are a prefix of the fields of the next.
+	for i := 1; i < len(infos); i++ {
+		if infos[i].SinceVersion < infos[i-1].SinceVersion {
+			panic(fmt.Sprintf("field %v of %v has since_version %v, but follows field %v with since_version %v",
+				infos[i].Name, rt, infos[i].SinceVersion, infos[i-1].Name, infos[i-1].SinceVersion))
+		}
+	}
+	sinfo = StructInfo{Fields: infos, FixedWidth: len(infos) > 0}
+	for _, field := range infos {
+		if !isFixedWidthField(field) {
+			sinfo.FixedWidth = false
+			break
+		}
+	}
+	if sinfo.FixedWidth {
+		for i, field := range infos {
+			var key = new(bytes.Buffer)
+			err := encodeFieldNumberAndTyp3(key, field.BinFieldNum, kindToTyp3(field.Type, field.FieldOptions))
+			if err != nil {
+				panic(err) // should not happen.
+			}
+			infos[i].binKey = key.Bytes()
+		}
+	}
 	return sinfo
 }
 
+// An exported field of a struct, by its index path.
+type structField struct {
+	field reflect.StructField
+	path  []int
+}
+
+// Returns the exported fields of the struct type rt in order, with those of
+// unexported embedded (non-pointer) structs promoted in their place, like
+// encoding/json.  Of promoted fields with the same name, only the shallowest
+// is kept, and none if there are several at that depth.
+func structFields(rt reflect.Type) []structField {
+	var fields []structField
+	var collect func(rt reflect.Type, path []int)
+	collect = func(rt reflect.Type, path []int) {
+		for i := 0; i < rt.NumField(); i++ {
+			var field = rt.Field(i)
+			var fpath = append(append([]int(nil), path...), i)
+			if isExported(field) {
+				fields = append(fields, structField{field, fpath})
+			} else if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				collect(field.Type, fpath)
+			}
+		}
+	}
+	collect(rt, nil)
+
+	var depths = make(map[string]int)
+	var counts = make(map[string]int)
+	for _, sf := range fields {
+		depth, ok := depths[sf.field.Name]
+		if !ok || len(sf.path) < depth {
+			depths[sf.field.Name], counts[sf.field.Name] = len(sf.path), 1
+		} else if len(sf.path) == depth {
+			counts[sf.field.Name]++
+		}
+	}
+	var dominant = fields[:0]
+	for _, sf := range fields {
+		if len(sf.path) == depths[sf.field.Name] && counts[sf.field.Name] == 1 {
+			dominant = append(dominant, sf)
+		}
+	}
+	return dominant
+}
+
+// Returns the struct type which is encoded for an `amino:"wrapper"` field,
+// i.e. the google.protobuf wrapper message with the scalar as field 1:
+// DoubleValue, FloatValue, Int64Value, UInt64Value, Int32Value,
+// UInt32Value, BoolValue, StringValue or BytesValue.  Like other floats,
+// wrapped floats also require `amino:"unsafe"`.
+func wrapperType(field reflect.StructField, fopts FieldOptions) reflect.Type {
+	if field.Type.Kind() != reflect.Ptr {
+		panic(fmt.Sprintf("wrapper field %v must be a pointer, got %v", field.Name, field.Type))
+	}
+	var ert = field.Type.Elem()
+	switch ert.Kind() {
+	case reflect.Float64, reflect.Float32, reflect.Int64, reflect.Uint64,
+		reflect.Int32, reflect.Uint32, reflect.Bool, reflect.String:
+	case reflect.Slice:
+		if ert.Elem().Kind() != reflect.Uint8 {
+			panic(fmt.Sprintf("unsupported wrapper field %v of type %v", field.Name, field.Type))
+		}
+	default:
+		panic(fmt.Sprintf("unsupported wrapper field %v of type %v", field.Name, field.Type))
+	}
+	var tag reflect.StructTag
+	if fopts.Unsafe {
+		tag = `amino:"unsafe"`
+	}
+	return reflect.StructOf([]reflect.StructField{{Name: "Value", Type: ert, Tag: tag}})
+}
+
+// Returns true iff a field of type rt should be encoded as an unpacked list,
+// i.e. as repeated field entries for each list item.
+func isUnpackedList(rt reflect.Type, fopts FieldOptions) bool {
+	if rt.Kind() == reflect.Map {
+		// Maps are encoded as repeated entries, see encodeReflectBinaryMap.
+		return true
+	}
+	if rt.Kind() != reflect.Array && rt.Kind() != reflect.Slice {
+		return false
+	}
+	if fopts.Sparse && rt.Kind() == reflect.Array {
+		// See encodeReflectBinarySparseArray.
+		return false
+	}
+	if rt.Elem().Kind() == reflect.Uint8 {
+		// These get handled by our optimized methods,
+		// encodeReflectBinaryByte[Slice/Array].
+		return false
+	}
+	etype := rt.Elem()
+	for etype.Kind() == reflect.Ptr {
+		etype = etype.Elem()
+	}
+	return kindToTyp3(etype, fopts) == Typ3ByteLength
+}
+
 func (cdc *Codec) parseFieldOptions(field reflect.StructField) (skip bool, fopts FieldOptions) {
 	binTag := field.Tag.Get("binary")
 	aminoTag := field.Tag.Get("amino")
@@ -512,6 +1948,9 @@ func (cdc *Codec) parseFieldOptions(field reflect.StructField) (skip bool, fopts
 	jsonTagParts := strings.Split(jsonTag, ",")
 	if jsonTagParts[0] == "" {
 		fopts.JSONName = field.Name
+		if cdc.jsonNamePolicy != nil {
+			fopts.JSONName = cdc.jsonNamePolicy(field.Name)
+		}
 	} else {
 		fopts.JSONName = jsonTagParts[0]
 	}
@@ -542,6 +1981,64 @@ func (cdc *Codec) parseFieldOptions(field reflect.StructField) (skip bool, fopts
 		if aminoTag == "empty_elements" {
 			fopts.EmptyElements = true
 		}
+		if aminoTag == "time_seconds" {
+			fopts.TimeSeconds = true
+		}
+		if aminoTag == "time_nanos" {
+			fopts.TimeNanos = true
+		}
+		if aminoTag == "unixmillis" {
+			fopts.UnixMillis = true
+		}
+		if aminoTag == "dateonly" {
+			fopts.DateOnly = true
+		}
+		if aminoTag == "sparse" {
+			if derefType(field.Type).Kind() != reflect.Array {
+				panic(fmt.Sprintf("amino tag sparse on field %v expects an array, got %v", field.Name, field.Type))
+			}
+			fopts.Sparse = true
+		}
+		if aminoTag == "gzip" {
+			fopts.Gzip = true
+		}
+		if aminoTag == "wrapper" {
+			fopts.Wrapper = true
+		}
+		if aminoTag == "json_hex" {
+			fopts.JSONHex = true
+		}
+		if aminoTag == "nil_as_empty" {
+			fopts.NilAsEmpty = true
+		}
+		if aminoTag == "json_raw" {
+			if derefType(field.Type).Kind() != reflect.Slice || derefType(field.Type).Elem().Kind() != reflect.Uint8 {
+				panic(fmt.Sprintf("amino tag json_raw on field %v expects a byte slice, got %v", field.Name, field.Type))
+			}
+			fopts.JSONRaw = true
+		}
+		if strings.HasPrefix(aminoTag, "since_version=") {
+			version, err := strconv.ParseUint(strings.TrimPrefix(aminoTag, "since_version="), 10, 32)
+			if err != nil {
+				panic(fmt.Sprintf("invalid amino tag %q on field %v, expected a uint32 version", aminoTag, field.Name))
+			}
+			fopts.SinceVersion = uint32(version)
+		}
+		if strings.HasPrefix(aminoTag, "field=") {
+			num, err := strconv.ParseUint(strings.TrimPrefix(aminoTag, "field="), 10, 32)
+			if err != nil || num == 0 || num > (1<<29-1) {
+				panic(fmt.Sprintf("invalid amino tag %q on field %v, field numbers must be in [1, 1<<29-1]",
+					aminoTag, field.Name))
+			}
+			fopts.BinFieldNum = uint32(num)
+		}
+	}
+
+	if derefType(field.Type) == jsonRawMessageType {
+		fopts.JSONRaw = true
+	}
+	if fopts.TimeNanos && fopts.TimeSeconds {
+		panic(fmt.Sprintf("amino tags time_nanos and time_seconds on field %v are exclusive", field.Name))
 	}
 
 	return skip, fopts
@@ -550,10 +2047,10 @@ func (cdc *Codec) parseFieldOptions(field reflect.StructField) (skip bool, fopts
 // Constructs a *TypeInfo automatically, not from registration.
 func (cdc *Codec) newTypeInfoUnregistered(rt reflect.Type) *TypeInfo {
 	if rt.Kind() == reflect.Ptr {
-		panic("unexpected pointer type") // should not happen.
+		panic(internalError("unexpected pointer type")) // should not happen.
 	}
 	if rt.Kind() == reflect.Interface {
-		panic("unexpected interface type") // should not happen.
+		panic(internalError("unexpected interface type")) // should not happen.
 	}
 
 	var info = new(TypeInfo)
@@ -564,6 +2061,10 @@ func (cdc *Codec) newTypeInfoUnregistered(rt reflect.Type) *TypeInfo {
 	if rt.Kind() == reflect.Struct {
 		info.StructInfo = cdc.parseStructInfo(rt)
 	}
+	if rt.Kind() == reflect.Map {
+		// Maps are encoded as repeated entries, see encodeReflectBinaryMap().
+		info.MapInfo.EntryType = mapEntryType(rt)
+	}
 	if rm, ok := rt.MethodByName("MarshalAmino"); ok {
 		info.ConcreteInfo.IsAminoMarshaler = true
 		info.ConcreteInfo.AminoMarshalReprType = marshalAminoReprType(rm)
@@ -572,6 +2073,8 @@ func (cdc *Codec) newTypeInfoUnregistered(rt reflect.Type) *TypeInfo {
 		info.ConcreteInfo.IsAminoUnmarshaler = true
 		info.ConcreteInfo.AminoUnmarshalReprType = unmarshalAminoReprType(rm)
 	}
+	info.ConcreteInfo.IsBinaryMarshaler = isBinaryMarshalerType(rt)
+	info.ConcreteInfo.IsBinaryUnmarshaler = isBinaryUnmarshalerType(rt)
 	return info
 }
 
@@ -655,12 +2158,15 @@ func (cdc *Codec) checkConflictsInPrioNolock(iinfo *TypeInfo) error {
 	return nil
 }
 
-func (cdc *Codec) addCheckConflictsWithConcreteNolock(cinfo *TypeInfo) {
+// Adds cinfo to the implementers of the interfaces it implements, unless
+// that conflicts with the priority list of any of them, in which case none
+// are changed and an error is returned.
+func (cdc *Codec) addCheckConflictsWithConcreteNolock(cinfo *TypeInfo) error {
 
 	// Iterate over registered interfaces that this "implements".
 	// "Implement" in quotes because we only consider the pointer, for extra
 	// safety.
-	for _, iinfo := range cdc.interfaceInfos {
+	for i, iinfo := range cdc.interfaceInfos {
 		if !cinfo.PtrToType.Implements(iinfo.Type) {
 			continue
 		}
@@ -674,10 +2180,21 @@ func (cdc *Codec) addCheckConflictsWithConcreteNolock(cinfo *TypeInfo) {
 		err := cdc.checkConflictsInPrioNolock(iinfo)
 		if err != nil {
 			// Return to previous state.
-			iinfo.Implementers[cinfo.Prefix] = origImpls
-			panic(err)
+			for _, iinfo := range cdc.interfaceInfos[:i+1] {
+				if !cinfo.PtrToType.Implements(iinfo.Type) {
+					continue
+				}
+				impls := iinfo.Implementers[cinfo.Prefix]
+				if len(impls) == 1 {
+					delete(iinfo.Implementers, cinfo.Prefix)
+				} else {
+					iinfo.Implementers[cinfo.Prefix] = impls[:len(impls)-1]
+				}
+			}
+			return err
 		}
 	}
+	return nil
 }
 
 //----------------------------------------
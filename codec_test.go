@@ -3,7 +3,13 @@ package amino_test
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -250,3 +256,1561 @@ func TestCodecSeal(t *testing.T) {
 	assert.Panics(t, func() { cdc.RegisterInterface((*Bar)(nil), nil) })
 	assert.Panics(t, func() { cdc.RegisterConcrete(int(0), "int", nil) })
 }
+
+func TestCodecRecoverPolicy(t *testing.T) {
+
+	type Unsafe struct {
+		F float64
+	}
+
+	cdc := amino.NewCodec()
+	var c chan int
+	assert.Panics(t, func() { _ = cdc.UnmarshalBinaryBare([]byte{0x08, 0x01}, &c) })
+
+	cdc = amino.NewCodec()
+	cdc.SetRecoverPolicy(amino.PolicyError)
+	err := cdc.UnmarshalBinaryBare([]byte{0x08, 0x01}, &c)
+	require.Error(t, err)
+	assert.IsType(t, amino.RecoveredPanicErr{}, err)
+
+	// The codec stays usable after a panic during type info construction.
+	_, err = cdc.MarshalBinaryBare(Unsafe{1})
+	assert.IsType(t, amino.RecoveredPanicErr{}, err)
+	_, err = cdc.MarshalBinaryBare(newSimpleStruct())
+	assert.NoError(t, err)
+
+	// Runtime errors are internal errors.
+	_, err = cdc.MarshalBinaryBare(nilDerefMarshaler{})
+	require.Error(t, err)
+	assert.IsType(t, amino.InternalPanicErr{}, err)
+
+	// Failed registrations are recorded and skipped.
+	type First struct{ A int }
+	type Second struct{ B int }
+	assert.Empty(t, cdc.RegistrationErrors())
+	cdc.RegisterInterface(struct{}{}, nil)
+	cdc.RegisterConcrete(First{}, "first", nil)
+	cdc.RegisterConcrete(Second{}, "first", nil)
+	errs := cdc.RegistrationErrors()
+	require.Len(t, errs, 2)
+	assert.IsType(t, amino.RecoveredPanicErr{}, errs[0])
+	assert.Contains(t, errs[1].Error(), "already registered")
+	assert.Equal(t, amino.NewCodec().MustMarshalBinaryBare(Second{1}), cdc.MustMarshalBinaryBare(Second{1}))
+	var f First
+	require.NoError(t, cdc.UnmarshalBinaryBare(cdc.MustMarshalBinaryBare(First{1}), &f))
+	assert.Equal(t, First{1}, f)
+
+	cdc.Seal()
+	assert.Panics(t, func() { cdc.SetRecoverPolicy(amino.PolicyPanic) })
+	cdc.RegisterConcrete(struct{ C int }{}, "sealed", nil)
+	assert.Len(t, cdc.RegistrationErrors(), 3)
+
+	// The policy may be set while the codec is in use (see go test -race).
+	cdc = amino.NewCodec()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, _ = cdc.MarshalBinaryBare(newSimpleStruct())
+		}
+	}()
+	cdc.SetRecoverPolicy(amino.PolicyError)
+	wg.Wait()
+}
+
+type nilDerefMarshaler struct{}
+
+func (nilDerefMarshaler) MarshalAmino() (int, error) {
+	var p *int
+	return *p, nil
+}
+
+func TestCodecVirtualField(t *testing.T) {
+
+	type Item struct {
+		Name string
+		Qty  uint32
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterVirtualField(reflect.TypeOf(Item{}), 3, "name_len", func(v reflect.Value) interface{} {
+		return int32(len(v.Interface().(Item).Name))
+	})
+	assert.Panics(t, func() {
+		cdc.RegisterVirtualField(reflect.TypeOf(Item{}), 2, "bad", func(reflect.Value) interface{} { return nil })
+	})
+
+	item := Item{Name: "apple", Qty: 2}
+	bz, err := cdc.MarshalBinaryBare(item)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x0A, 0x05, 'a', 'p', 'p', 'l', 'e', 0x10, 0x02, 0x18, 0x05}, bz)
+	var item2 Item
+	err = cdc.UnmarshalBinaryBare(bz, &item2)
+	require.NoError(t, err)
+	assert.Equal(t, item, item2)
+
+	bz, err = cdc.MarshalJSON(item)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Name":"apple","Qty":2,"name_len":5}`, string(bz))
+	var item3 Item
+	err = cdc.UnmarshalJSON(bz, &item3)
+	require.NoError(t, err)
+	assert.Equal(t, item, item3)
+}
+
+type compactID uint64
+
+// A length byte followed by the big-endian value without leading zeros.
+func encodeCompactID(u uint64) []byte {
+	var buf [9]byte
+	binary.BigEndian.PutUint64(buf[1:], u)
+	var i = 1
+	for i < 9 && buf[i] == 0 {
+		i++
+	}
+	buf[i-1] = byte(9 - i)
+	return buf[i-1:]
+}
+
+func decodeCompactID(bz []byte) (u uint64, n int, err error) {
+	if len(bz) == 0 || int(bz[0]) > 8 || len(bz) < 1+int(bz[0]) {
+		return 0, 0, errors.New("invalid compact id")
+	}
+	for _, b := range bz[1 : 1+int(bz[0])] {
+		u = u<<8 | uint64(b)
+	}
+	return u, 1 + int(bz[0]), nil
+}
+
+func TestCodecIntCodec(t *testing.T) {
+	type Record struct {
+		ID  compactID
+		IDs []compactID
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterIntCodec(reflect.TypeOf(compactID(0)), encodeCompactID, decodeCompactID)
+
+	bz, err := cdc.MarshalBinaryBare(Record{ID: 0x1234})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x0a, 0x03, 0x02, 0x12, 0x34}, bz)
+	typ3, err := cdc.WireTypeFor(reflect.TypeOf(compactID(0)), amino.FieldOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, amino.Typ3ByteLength, typ3)
+
+	for _, id := range []compactID{0, 1, 0xFF, 0x100, 1 << 32, math.MaxUint64 - 1, math.MaxUint64} {
+		r := Record{ID: id, IDs: []compactID{id, 7}}
+		bz, err := cdc.MarshalBinaryBare(r)
+		require.Nil(t, err)
+		var r2 Record
+		err = cdc.UnmarshalBinaryBare(bz, &r2)
+		require.Nil(t, err)
+		assert.Equal(t, r, r2)
+		size, err := cdc.SizeBinary(r)
+		require.Nil(t, err)
+		assert.Equal(t, len(bz), size)
+	}
+
+	// Emptiness is decided by the value, not by its encoding, so a non-zero
+	// value encoded as 0x00 is kept.
+	cdc3 := amino.NewCodec()
+	cdc3.RegisterIntCodec(reflect.TypeOf(compactID(0)),
+		func(u uint64) []byte { return []byte{byte(u - 1)} },
+		func(bz []byte) (uint64, int, error) { return uint64(bz[0]) + 1, 1, nil })
+	bz, err = cdc3.MarshalBinaryBare(Record{ID: 1})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x0a, 0x01, 0x00}, bz)
+	var r3 Record
+	err = cdc3.UnmarshalBinaryBare(bz, &r3)
+	require.Nil(t, err)
+	assert.Equal(t, compactID(1), r3.ID)
+	bz, err = cdc3.MarshalBinaryBare(Record{})
+	require.Nil(t, err)
+	assert.Empty(t, bz)
+
+	// A decoder must not report reading more bytes than it was given.
+	cdc2 := amino.NewCodec()
+	cdc2.RegisterIntCodec(reflect.TypeOf(compactID(0)), encodeCompactID,
+		func(bz []byte) (uint64, int, error) { return 0, len(bz) + 1, nil })
+	bz, err = cdc2.MarshalBinaryBare(Record{ID: 1})
+	require.Nil(t, err)
+	err = cdc2.UnmarshalBinaryBare(bz, new(Record))
+	assert.NotNil(t, err)
+
+	assert.Panics(t, func() {
+		amino.NewCodec().RegisterIntCodec(reflect.TypeOf(""), encodeCompactID, decodeCompactID)
+	})
+}
+
+type uniqueShape interface {
+	Area() int64
+}
+
+type uniqueCircle struct {
+	R int64
+}
+
+func (c uniqueCircle) Area() int64 { return 3 * c.R * c.R }
+
+type uniqueSquare struct {
+	S int64
+}
+
+func (s uniqueSquare) Area() int64 { return s.S * s.S }
+
+func TestCodecOmitAnyTypeWhenUnique(t *testing.T) {
+	type Holder struct {
+		Shape uniqueShape
+	}
+
+	cdc := amino.NewCodec()
+	cdc.SetOmitAnyTypeWhenUnique(true)
+	cdc.RegisterInterface((*uniqueShape)(nil), nil)
+	cdc.RegisterConcrete(uniqueCircle{}, "test/circle", nil)
+
+	// No prefix bytes are written.
+	h := Holder{uniqueCircle{R: 2}}
+	bz, err := cdc.MarshalBinaryBare(h)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x0A, 0x02, 0x08, 0x02}, bz)
+	var h2 Holder
+	err = cdc.UnmarshalBinaryBare(bz, &h2)
+	require.Nil(t, err)
+	assert.Equal(t, h, h2)
+
+	// Nil is still nil.
+	bz, err = cdc.MarshalBinaryBare(Holder{})
+	require.Nil(t, err)
+	h2 = Holder{}
+	err = cdc.UnmarshalBinaryBare(bz, &h2)
+	require.Nil(t, err)
+	assert.Nil(t, h2.Shape)
+
+	// But a zero value isn't.
+	bz, err = cdc.MarshalBinaryBare(Holder{uniqueCircle{}})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x0A, 0x01, 0x00}, bz)
+	size, err := cdc.SizeBinary(Holder{uniqueCircle{}})
+	require.Nil(t, err)
+	assert.Equal(t, len(bz), size)
+	h2 = Holder{}
+	err = cdc.UnmarshalBinaryBare(bz, &h2)
+	require.Nil(t, err)
+	assert.Equal(t, Holder{uniqueCircle{}}, h2)
+
+	// A second implementer would change the encoding.
+	cdc.RegisterConcrete(uniqueSquare{}, "test/square", nil)
+	assert.Panics(t, func() { cdc.Seal() })
+
+	// It's fine if prefix bytes were never omitted.
+	cdc2 := amino.NewCodec()
+	cdc2.SetOmitAnyTypeWhenUnique(true)
+	cdc2.RegisterInterface((*uniqueShape)(nil), nil)
+	cdc2.RegisterConcrete(uniqueCircle{}, "test/circle", nil)
+	cdc2.RegisterConcrete(uniqueSquare{}, "test/square", nil)
+	bz, err = cdc2.MarshalBinaryBare(h)
+	require.Nil(t, err)
+	assert.Equal(t, 4+4, len(bz))
+	assert.NotPanics(t, func() { cdc2.Seal() })
+}
+
+func TestCodecIndexedAnyMode(t *testing.T) {
+	type Holder struct {
+		Shapes []uniqueShape
+	}
+	newCodec := func(names ...string) *amino.Codec {
+		cdc := amino.NewCodec()
+		cdc.SetIndexedAnyMode(true)
+		cdc.RegisterInterface((*uniqueShape)(nil), nil)
+		cdc.RegisterConcrete(uniqueSquare{}, "test/square", nil)
+		cdc.RegisterConcrete(uniqueCircle{}, "test/circle", nil)
+		for _, name := range names {
+			cdc.RegisterConcrete(&struct{ Name string }{}, name, nil)
+		}
+		return cdc
+	}
+
+	// Codecs with the same registrations interoperate, whatever their order.
+	cdc1 := newCodec()
+	cdc2 := amino.NewCodec()
+	cdc2.SetIndexedAnyMode(true)
+	cdc2.RegisterInterface((*uniqueShape)(nil), nil)
+	cdc2.RegisterConcrete(uniqueCircle{}, "test/circle", nil)
+	cdc2.RegisterConcrete(uniqueSquare{}, "test/square", nil)
+	assert.Equal(t, cdc1.SchemaHash(), cdc2.SchemaHash())
+
+	h := Holder{[]uniqueShape{uniqueCircle{R: 2}, uniqueSquare{S: 3}}}
+	bz, err := cdc1.MarshalBinaryBare(h)
+	require.NoError(t, err)
+	tag := fmt.Sprintf("%X", cdc1.SchemaHash()[:8])
+	// The message is tagged once, and each Any is only the index (circle
+	// is 0 and square 1).
+	assert.Equal(t, tag+"0A03000802"+"0A03010803", fmt.Sprintf("%X", bz))
+	size, err := cdc1.SizeBinary(h)
+	require.NoError(t, err)
+	assert.Equal(t, len(bz), size)
+	var h2 Holder
+	require.NoError(t, cdc2.UnmarshalBinaryBare(bz, &h2))
+	assert.Equal(t, h, h2)
+	var w bytes.Buffer
+	_, err = cdc1.MarshalBinaryLengthPrefixedWriter(&w, h)
+	require.NoError(t, err)
+	h2 = Holder{}
+	require.NoError(t, cdc2.UnmarshalBinaryLengthPrefixed(w.Bytes(), &h2))
+	assert.Equal(t, h, h2)
+
+	// Registrations which differ in any type are detected.
+	cdc3 := newCodec("test/another")
+	assert.NotEqual(t, cdc1.SchemaHash(), cdc3.SchemaHash())
+	err = cdc3.UnmarshalBinaryBare(bz, &h2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "schema hash mismatch")
+
+	// Even registrations whose hashes share their first bytes are detected.
+	var byPrefix = make(map[string]*amino.Codec)
+	var collided bool
+	for i := 0; i < 1<<12 && !collided; i++ {
+		cdc4 := newCodec(fmt.Sprintf("test/another%v", i))
+		prefix := string(cdc4.SchemaHash()[:2])
+		cdc5, ok := byPrefix[prefix]
+		if !ok {
+			byPrefix[prefix] = cdc4
+			continue
+		}
+		require.NotEqual(t, cdc4.SchemaHash(), cdc5.SchemaHash())
+		bz4, err := cdc4.MarshalBinaryBare(h)
+		require.NoError(t, err)
+		err = cdc5.UnmarshalBinaryBare(bz4, &h2)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "schema hash mismatch")
+		collided = true
+	}
+	require.True(t, collided, "no schema hashes with a common prefix")
+
+	// Registering another type changes the hash.
+	before := cdc1.SchemaHash()
+	cdc1.RegisterConcrete(&struct{ Name string }{}, "test/another", nil)
+	assert.Equal(t, cdc3.SchemaHash(), cdc1.SchemaHash())
+	assert.NotEqual(t, before, cdc1.SchemaHash())
+}
+
+type pluginCounter struct {
+	Count int64
+}
+
+type pluginLabel struct {
+	Label string
+}
+
+func TestCodecDynamicField(t *testing.T) {
+	type Envelope struct {
+		Kind    string
+		Payload interface{}
+	}
+
+	names := map[reflect.Type]string{
+		reflect.TypeOf(pluginCounter{}): "test/counter",
+		reflect.TypeOf(pluginLabel{}):   "test/label",
+		reflect.TypeOf(uniqueSquare{}):  "test/square",
+	}
+	cdc := amino.NewCodec()
+	cdc.RegisterConcrete(pluginCounter{}, "test/counter", nil)
+	cdc.RegisterConcrete(pluginLabel{}, "test/label", nil)
+	cdc.RegisterDynamicField(reflect.TypeOf(Envelope{}), 2, func(v reflect.Value) (reflect.Type, string) {
+		return v.Type(), names[v.Type()]
+	})
+	assert.Panics(t, func() {
+		cdc.RegisterDynamicField(reflect.TypeOf(Envelope{}), 1, nil)
+	})
+
+	for _, env := range []Envelope{
+		{Kind: "counter", Payload: pluginCounter{Count: 7}},
+		{Kind: "label", Payload: pluginLabel{Label: "hello"}},
+		{Kind: "none"},
+	} {
+		bz, err := cdc.MarshalBinaryBare(env)
+		require.Nil(t, err)
+		var env2 Envelope
+		err = cdc.UnmarshalBinaryBare(bz, &env2)
+		require.Nil(t, err)
+		assert.Equal(t, env, env2)
+
+		bz, err = cdc.MarshalJSON(env)
+		require.Nil(t, err)
+		var env3 Envelope
+		err = cdc.UnmarshalJSON(bz, &env3)
+		require.Nil(t, err, "%s", bz)
+		assert.Equal(t, env, env3)
+	}
+
+	bz, err := cdc.MarshalJSON(Envelope{Kind: "label", Payload: pluginLabel{Label: "hi"}})
+	require.Nil(t, err)
+	assert.Equal(t, `{"Kind":"label","Payload":{"type":"test/label","value":{"Label":"hi"}}}`, string(bz))
+
+	// The resolved type must be registered.
+	_, err = cdc.MarshalBinaryBare(Envelope{Payload: uniqueSquare{S: 2}})
+	assert.NotNil(t, err)
+	_, err = cdc.MarshalJSON(Envelope{Payload: uniqueSquare{S: 2}})
+	assert.NotNil(t, err)
+}
+
+type legacyMsg interface{}
+
+type legacyText struct {
+	Text string
+}
+
+type legacyVote struct {
+	Yes bool
+}
+
+func TestCodecInterfaceDefault(t *testing.T) {
+	type OldTx struct {
+		Msg legacyText
+	}
+	type Tx struct {
+		Msg legacyMsg
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*legacyMsg)(nil), nil)
+	cdc.RegisterConcrete(legacyText{}, "test/text", nil)
+	cdc.RegisterConcrete(legacyVote{}, "test/vote", nil)
+	msgType := reflect.TypeOf((*legacyMsg)(nil)).Elem()
+
+	// Data written before Msg was an interface lacks prefix bytes.
+	bz, err := cdc.MarshalBinaryBare(OldTx{legacyText{"hello"}})
+	require.Nil(t, err)
+	err = cdc.UnmarshalBinaryBare(bz, new(Tx))
+	assert.NotNil(t, err)
+
+	cdc.RegisterInterfaceDefault(msgType, reflect.TypeOf(legacyText{}))
+	var tx Tx
+	err = cdc.UnmarshalBinaryBare(bz, &tx)
+	require.Nil(t, err)
+	assert.Equal(t, Tx{legacyText{"hello"}}, tx)
+
+	// Also when too short to have prefix bytes.
+	bz, err = cdc.MarshalBinaryBare(OldTx{legacyText{"a"}})
+	require.Nil(t, err)
+	tx = Tx{}
+	err = cdc.UnmarshalBinaryBare(bz, &tx)
+	require.Nil(t, err)
+	assert.Equal(t, Tx{legacyText{"a"}}, tx)
+
+	// But values with unknown prefix bytes are skipped, if that's enabled.
+	bz, err = cdc.MarshalBinaryBare(OldTx{legacyText{"hello"}})
+	require.Nil(t, err)
+	tx = Tx{}
+	skipped, err := cdc.UnmarshalBinaryBareSkipping(bz, &tx)
+	require.Nil(t, err)
+	assert.Equal(t, Tx{}, tx)
+	assert.Len(t, skipped, 1)
+
+	for _, jsonBz := range []string{
+		`{"Msg":{"value":{"Text":"hello"}}}`,
+		`{"Msg":{"type":"","value":{"Text":"hello"}}}`,
+		`{"Msg":{"Text":"hello"}}`,
+	} {
+		tx = Tx{}
+		err = cdc.UnmarshalJSON([]byte(jsonBz), &tx)
+		require.Nil(t, err, jsonBz)
+		assert.Equal(t, Tx{legacyText{"hello"}}, tx)
+	}
+
+	// Types are still decoded when present.
+	for _, msg := range []legacyMsg{legacyText{"hi"}, legacyVote{true}} {
+		bz, err = cdc.MarshalBinaryBare(Tx{msg})
+		require.Nil(t, err)
+		tx = Tx{}
+		err = cdc.UnmarshalBinaryBare(bz, &tx)
+		require.Nil(t, err)
+		assert.Equal(t, Tx{msg}, tx)
+
+		bz, err = cdc.MarshalJSON(Tx{msg})
+		require.Nil(t, err)
+		tx = Tx{}
+		err = cdc.UnmarshalJSON(bz, &tx)
+		require.Nil(t, err)
+		assert.Equal(t, Tx{msg}, tx)
+	}
+
+	assert.Panics(t, func() {
+		cdc.RegisterInterfaceDefault(msgType, reflect.TypeOf(uniqueCircle{}))
+	})
+}
+
+type nestedAny interface{}
+
+type nestedBox struct {
+	Inner nestedAny
+}
+
+func TestCodecMaxAnyDepth(t *testing.T) {
+	newCodec := func(depth int) *amino.Codec {
+		cdc := amino.NewCodec()
+		cdc.RegisterInterface((*nestedAny)(nil), nil)
+		cdc.RegisterConcrete(nestedBox{}, "test/box", nil)
+		cdc.SetMaxAnyDepth(depth)
+		return cdc
+	}
+	// Five nested interface values.
+	var v nestedAny = nestedBox{}
+	for i := 0; i < 4; i++ {
+		v = nestedBox{Inner: v}
+	}
+	holder := nestedBox{Inner: v}
+
+	cdc := newCodec(5)
+	bz, err := cdc.MarshalBinaryBare(holder)
+	require.Nil(t, err)
+	jsonBz, err := cdc.MarshalJSON(holder)
+	require.Nil(t, err)
+
+	var holder2 nestedBox
+	err = cdc.UnmarshalBinaryBare(bz, &holder2)
+	require.Nil(t, err)
+	assert.Equal(t, holder, holder2)
+	holder2 = nestedBox{}
+	err = cdc.UnmarshalJSON(jsonBz, &holder2)
+	require.Nil(t, err)
+	assert.Equal(t, holder, holder2)
+
+	cdc = newCodec(4)
+	err = cdc.UnmarshalBinaryBare(bz, new(nestedBox))
+	assert.NotNil(t, err)
+	err = cdc.UnmarshalJSON(jsonBz, new(nestedBox))
+	assert.NotNil(t, err)
+
+	// No limit.
+	cdc = newCodec(0)
+	err = cdc.UnmarshalBinaryBare(bz, new(nestedBox))
+	assert.Nil(t, err)
+}
+
+type nestedNode struct {
+	Child *nestedNode
+	Kids  []nestedNode
+}
+
+// Returns the encoding of depth nodes, each the Child of the previous one.
+func nestedNodeBytes(depth int) []byte {
+	var bz []byte
+	var prefix [binary.MaxVarintLen64 + 1]byte
+	for i := 1; i < depth; i++ {
+		prefix[0] = 0x0A
+		n := binary.PutUvarint(prefix[1:], uint64(len(bz)))
+		bz = append(append([]byte(nil), prefix[:n+1]...), bz...)
+	}
+	return bz
+}
+
+func TestCodecSetMaxDecodeDepth(t *testing.T) {
+	cdc := amino.NewCodec()
+	var node nestedNode
+	err := cdc.UnmarshalBinaryBare(nestedNodeBytes(100), &node)
+	require.Nil(t, err)
+	assert.NotNil(t, node.Child.Child)
+
+	// A pathologically nested input fails cleanly.
+	err = cdc.UnmarshalBinaryBare(nestedNodeBytes(101), &node)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "values nested deeper than 100")
+	err = cdc.UnmarshalBinaryBare(nestedNodeBytes(10000), &node)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "values nested deeper than 100")
+
+	// Lists count too.
+	cdc.SetMaxDecodeDepth(2)
+	bz := cdc.MustMarshalBinaryBare(nestedNode{Kids: []nestedNode{{}}})
+	require.Nil(t, cdc.UnmarshalBinaryBare(bz, &node))
+	bz = cdc.MustMarshalBinaryBare(nestedNode{Kids: []nestedNode{{Kids: []nestedNode{{}}}}})
+	require.NotNil(t, cdc.UnmarshalBinaryBare(bz, &node))
+	jsonBz := cdc.MustMarshalJSON(nestedNode{Kids: []nestedNode{{Kids: []nestedNode{{}}}}})
+	require.NotNil(t, cdc.UnmarshalJSON(jsonBz, &node))
+
+	// No limit.
+	cdc.SetMaxDecodeDepth(0)
+	require.Nil(t, cdc.UnmarshalBinaryBare(nestedNodeBytes(1000), &node))
+	assert.Panics(t, func() { cdc.SetMaxDecodeDepth(-1) })
+}
+
+func TestCodecRegisterStdError(t *testing.T) {
+	type Result struct {
+		Err error
+	}
+
+	cdc := amino.NewCodec()
+	r := Result{Err: fmt.Errorf("account %v not found", 42)}
+	_, err := cdc.MarshalBinaryBare(r)
+	assert.NotNil(t, err)
+
+	cdc.RegisterStdError()
+	bz, err := cdc.MarshalBinaryBare(r)
+	require.Nil(t, err)
+	var r2 Result
+	err = cdc.UnmarshalBinaryBare(bz, &r2)
+	require.Nil(t, err)
+	assert.Equal(t, amino.Error{Message: "account 42 not found"}, r2.Err)
+	assert.EqualError(t, r2.Err, "account 42 not found")
+
+	jsonBz, err := cdc.MarshalJSON(r)
+	require.Nil(t, err)
+	assert.Equal(t, `{"Err":{"type":"amino/Error","value":{"Message":"account 42 not found"}}}`, string(jsonBz))
+	r2 = Result{}
+	err = cdc.UnmarshalJSON(jsonBz, &r2)
+	require.Nil(t, err)
+	assert.EqualError(t, r2.Err, "account 42 not found")
+
+	// Nil errors stay nil.
+	bz, err = cdc.MarshalBinaryBare(Result{})
+	require.Nil(t, err)
+	r2 = Result{}
+	err = cdc.UnmarshalBinaryBare(bz, &r2)
+	require.Nil(t, err)
+	assert.Nil(t, r2.Err)
+}
+
+func TestCodecErrUnregisteredConcrete(t *testing.T) {
+	type Result struct {
+		Err error
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*error)(nil), nil)
+	r := Result{Err: fmt.Errorf("account %v not found", 42)}
+	want := amino.ErrUnregisteredConcrete{Type: reflect.TypeOf(r.Err).Elem()}
+	for _, marshal := range []func(o interface{}) ([]byte, error){
+		cdc.MarshalBinaryBare,
+		cdc.MarshalBinaryLengthPrefixed,
+		cdc.MarshalJSON,
+		cdc.MarshalMsgpack,
+	} {
+		_, err := marshal(r)
+		assert.Equal(t, want, err)
+	}
+	assert.EqualError(t, want, "cannot encode unregistered concrete type errors.errorString")
+
+	// With RegisterStdError, such errors are encoded as an Error instead.
+	cdc = amino.NewCodec()
+	cdc.RegisterStdError()
+	bz, err := cdc.MarshalMsgpack(r)
+	require.Nil(t, err)
+	var r2 Result
+	require.Nil(t, cdc.UnmarshalMsgpack(bz, &r2))
+	assert.Equal(t, amino.Error{Message: "account 42 not found"}, r2.Err)
+}
+
+var immutableEncodings int
+
+type immutableBlock struct {
+	Height int64
+	Txs    []string
+}
+
+func (b immutableBlock) MarshalAmino() ([]string, error) {
+	immutableEncodings++
+	return append([]string{fmt.Sprint(b.Height)}, b.Txs...), nil
+}
+
+func TestCodecRegisterImmutable(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterImmutable(reflect.TypeOf(immutableBlock{}))
+	cdc.SetImmutableCacheSize(2)
+
+	immutableEncodings = 0
+	bz, err := cdc.MarshalBinaryBare(immutableBlock{Height: 7, Txs: []string{"a", "b"}})
+	require.Nil(t, err)
+	assert.Equal(t, 1, immutableEncodings)
+
+	// An equal value (not the same one) hits the cache.
+	bz2, err := cdc.MarshalBinaryBare(&immutableBlock{Height: 7, Txs: []string{"a", "b"}})
+	require.Nil(t, err)
+	assert.Equal(t, bz, bz2)
+	assert.Equal(t, 1, immutableEncodings)
+
+	// The returned bytes are a copy.
+	bz2[0] ^= 0xFF
+	bz3, err := cdc.MarshalBinaryBare(immutableBlock{Height: 7, Txs: []string{"a", "b"}})
+	require.Nil(t, err)
+	assert.Equal(t, bz, bz3)
+	assert.Equal(t, 1, immutableEncodings)
+
+	// Different values miss, and evict the least recently used encoding.
+	_, err = cdc.MarshalBinaryBare(immutableBlock{Height: 7, Txs: []string{"a"}})
+	require.Nil(t, err)
+	_, err = cdc.MarshalBinaryBare(immutableBlock{Height: 8})
+	require.Nil(t, err)
+	assert.Equal(t, 3, immutableEncodings)
+	_, err = cdc.MarshalBinaryBare(immutableBlock{Height: 7, Txs: []string{"a", "b"}})
+	require.Nil(t, err)
+	assert.Equal(t, 4, immutableEncodings)
+
+	assert.Panics(t, func() { amino.NewCodec().SetImmutableCacheSize(0) })
+}
+
+func TestCodecSkipUnknownInterfaceValues(t *testing.T) {
+	type Envelope struct {
+		First  legacyMsg
+		Second legacyMsg
+		Memo   string
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*legacyMsg)(nil), nil)
+	cdc.RegisterConcrete(legacyText{}, "test/text", nil)
+	cdc.RegisterConcrete(legacyVote{}, "test/vote", nil)
+	env := Envelope{First: legacyText{"hello"}, Second: legacyVote{true}, Memo: "memo"}
+	bz, err := cdc.MarshalBinaryBare(env)
+	require.Nil(t, err)
+	jsonBz, err := cdc.MarshalJSON(env)
+	require.Nil(t, err)
+
+	// A reader which doesn't know about votes.
+	cdc2 := amino.NewCodec()
+	cdc2.RegisterInterface((*legacyMsg)(nil), nil)
+	cdc2.RegisterConcrete(legacyText{}, "test/text", nil)
+	err = cdc2.UnmarshalBinaryBare(bz, new(Envelope))
+	assert.NotNil(t, err)
+	err = cdc2.UnmarshalJSON(jsonBz, new(Envelope))
+	assert.NotNil(t, err)
+
+	// The Skipping variants skip regardless of the setting, and return the
+	// skipped values of that call only.
+	msgType := reflect.TypeOf((*legacyMsg)(nil)).Elem()
+	want := Envelope{First: legacyText{"hello"}, Memo: "memo"}
+	var env2 Envelope
+	skipped, err := cdc2.UnmarshalBinaryBareSkipping(bz, &env2)
+	require.Nil(t, err)
+	assert.Equal(t, want, env2)
+	require.Len(t, skipped, 1)
+	assert.Equal(t, msgType, skipped[0].Interface)
+	_, votePrefix := amino.NameToDisfix("test/vote")
+	assert.Equal(t, votePrefix, skipped[0].Prefix)
+
+	env2 = Envelope{}
+	skipped, err = cdc2.UnmarshalJSONSkipping(jsonBz, &env2)
+	require.Nil(t, err)
+	assert.Equal(t, want, env2)
+	assert.Equal(t, []amino.UnknownInterfaceValue{{Interface: msgType, Name: "test/vote"}}, skipped)
+
+	skipped, err = cdc2.UnmarshalBinaryBareSkipping(cdc.MustMarshalBinaryBare(want), new(Envelope))
+	require.Nil(t, err)
+	assert.Empty(t, skipped)
+
+	cdc2.SetSkipUnknownInterfaceValues(true)
+	env2 = Envelope{}
+	err = cdc2.UnmarshalBinaryBare(bz, &env2)
+	require.Nil(t, err)
+	assert.Equal(t, want, env2)
+	env2 = Envelope{}
+	err = cdc2.UnmarshalJSON(jsonBz, &env2)
+	require.Nil(t, err)
+	assert.Equal(t, want, env2)
+
+	// Malformed values still fail.
+	err = cdc2.UnmarshalBinaryBare(bz[:len(bz)-3], new(Envelope))
+	assert.NotNil(t, err)
+}
+
+func TestCodecSetAllocator(t *testing.T) {
+	type Point struct {
+		X, Y int64
+	}
+	type Shape struct {
+		Origin *Point
+		Points []Point
+		Msg    legacyMsg
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*legacyMsg)(nil), nil)
+	cdc.RegisterConcrete(legacyText{}, "test/text", nil)
+	allocs := make(map[reflect.Type]int)
+	cdc.SetAllocator(func(rt reflect.Type) reflect.Value {
+		allocs[rt]++
+		return reflect.New(rt)
+	})
+
+	shape := Shape{
+		Origin: &Point{1, 2},
+		Points: []Point{{3, 4}, {5, 6}, {7, 8}},
+		Msg:    legacyText{"hi"},
+	}
+	bz, err := cdc.MarshalBinaryBare(shape)
+	require.Nil(t, err)
+	var shape2 Shape
+	err = cdc.UnmarshalBinaryBare(bz, &shape2)
+	require.Nil(t, err)
+	assert.Equal(t, shape, shape2)
+	assert.Equal(t, 4, allocs[reflect.TypeOf(Point{})])
+	assert.Equal(t, 1, allocs[reflect.TypeOf(legacyText{})])
+
+	allocs = make(map[reflect.Type]int)
+	jsonBz, err := cdc.MarshalJSON(shape)
+	require.Nil(t, err)
+	shape2 = Shape{}
+	err = cdc.UnmarshalJSON(jsonBz, &shape2)
+	require.Nil(t, err)
+	assert.Equal(t, shape, shape2)
+	assert.Equal(t, 1, allocs[reflect.TypeOf(Point{})])
+	assert.Equal(t, 1, allocs[reflect.TypeOf(legacyText{})])
+
+	// Allocators must return pointers to the requested type.
+	cdc.SetAllocator(func(rt reflect.Type) reflect.Value { return reflect.ValueOf(new(int)) })
+	assert.Panics(t, func() { cdc.UnmarshalBinaryBare(bz, new(Shape)) }) // nolint: errcheck
+}
+
+type exampleVote struct {
+	Yes bool
+}
+
+type exampleNote struct {
+	Text string
+}
+
+func TestNewCodecFromExamples(t *testing.T) {
+	type Envelope struct {
+		Msg legacyMsg
+	}
+
+	cdc, err := amino.NewCodecFromExamples("example.com", exampleVote{}, &exampleNote{})
+	require.Nil(t, err)
+	cdc.RegisterInterface((*legacyMsg)(nil), nil)
+	cdc.Seal()
+
+	for _, msg := range []legacyMsg{exampleVote{true}, &exampleNote{"hi"}} {
+		bz, err := cdc.MarshalBinaryBare(Envelope{msg})
+		require.Nil(t, err)
+		var env Envelope
+		err = cdc.UnmarshalBinaryBare(bz, &env)
+		require.Nil(t, err)
+		assert.Equal(t, Envelope{msg}, env)
+
+		bz, err = cdc.MarshalJSON(Envelope{msg})
+		require.Nil(t, err)
+		env = Envelope{}
+		err = cdc.UnmarshalJSON(bz, &env)
+		require.Nil(t, err)
+		assert.Equal(t, Envelope{msg}, env)
+	}
+	bz, err := cdc.MarshalJSON(exampleVote{true})
+	require.Nil(t, err)
+	assert.Equal(t,
+		`{"type":"example.com/github.com/tendermint/go-amino_test.exampleVote","value":{"Yes":true}}`,
+		string(bz))
+
+	_, err = amino.NewCodecFromExamples("example.com", exampleVote{}, &exampleVote{})
+	assert.EqualError(t, err,
+		"duplicate name example.com/github.com/tendermint/go-amino_test.exampleVote of example amino_test.exampleVote")
+	_, err = amino.NewCodecFromExamples("example.com", struct{}{})
+	assert.NotNil(t, err)
+	_, err = amino.NewCodecFromExamples("example.com", new(legacyMsg))
+	assert.NotNil(t, err)
+}
+
+// Encodes a sorted []int64 as the first value followed by the differences
+// between consecutive values, all as uvarints.
+type deltaCodec struct{}
+
+func (deltaCodec) EncodeField(w io.Writer, v reflect.Value) error {
+	var prev int64
+	for _, x := range v.Interface().([]int64) {
+		if err := amino.EncodeUvarint(w, uint64(x-prev)); err != nil {
+			return err
+		}
+		prev = x
+	}
+	return nil
+}
+
+func (deltaCodec) DecodeField(bz []byte, v reflect.Value) (n int, err error) {
+	var xs []int64
+	var prev int64
+	for n < len(bz) {
+		d, _n, err := amino.DecodeUvarint(bz[n:])
+		if err != nil {
+			return n, err
+		}
+		n += _n
+		prev += int64(d)
+		xs = append(xs, prev)
+	}
+	v.Set(reflect.ValueOf(xs))
+	return n, nil
+}
+
+func TestCodecRegisterFieldCodec(t *testing.T) {
+	type Index struct {
+		Name    string
+		Offsets []int64
+		Count   int64 `binary:"fixed64"`
+	}
+
+	cdc := amino.NewCodec()
+	idx := Index{Name: "a", Offsets: []int64{1000000, 1000001, 1000005, 1000100}, Count: 4}
+	plainBz, err := cdc.MarshalBinaryBare(idx)
+	require.Nil(t, err)
+
+	cdc.RegisterFieldCodec(reflect.TypeOf(Index{}), 2, deltaCodec{})
+	bz, err := cdc.MarshalBinaryBare(idx)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x12, 0x06, 0xC0, 0x84, 0x3D, 0x01, 0x04, 0x5F}, bz[3:11])
+	assert.True(t, len(bz) < len(plainBz))
+
+	var idx2 Index
+	err = cdc.UnmarshalBinaryBare(bz, &idx2)
+	require.Nil(t, err)
+	assert.Equal(t, idx, idx2)
+
+	// Empty fields are omitted.
+	bz, err = cdc.MarshalBinaryBare(Index{Name: "b"})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x0A, 0x01, 'b'}, bz)
+	idx2 = Index{}
+	err = cdc.UnmarshalBinaryBare(bz, &idx2)
+	require.Nil(t, err)
+	assert.Equal(t, Index{Name: "b"}, idx2)
+
+	// JSON is unaffected.
+	jsonBz, err := cdc.MarshalJSON(idx)
+	require.Nil(t, err)
+	assert.Contains(t, string(jsonBz), `"Offsets":["1000000","1000001","1000005","1000100"]`)
+
+	assert.Panics(t, func() { cdc.RegisterFieldCodec(reflect.TypeOf(Index{}), 4, deltaCodec{}) })
+}
+
+func xorCipher(bz []byte) ([]byte, error) {
+	out := make([]byte, len(bz))
+	for i, b := range bz {
+		out[i] = b ^ 0x5A
+	}
+	return out, nil
+}
+
+func TestCodecRegisterFieldEncryption(t *testing.T) {
+	type Account struct {
+		Owner  string
+		Secret string
+		Height int64
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterFieldEncryption(reflect.TypeOf(Account{}), 2, xorCipher, xorCipher)
+	acc := Account{Owner: "alice", Secret: "hunter2", Height: 7}
+	bz, err := cdc.MarshalBinaryBare(acc)
+	require.Nil(t, err)
+	assert.False(t, bytes.Contains(bz, []byte("hunter2")))
+	assert.True(t, bytes.Contains(bz, []byte("alice")))
+
+	var acc2 Account
+	err = cdc.UnmarshalBinaryBare(bz, &acc2)
+	require.Nil(t, err)
+	assert.Equal(t, acc, acc2)
+
+	// Empty fields are omitted, as if not encrypted.
+	bz, err = cdc.MarshalBinaryBare(Account{Owner: "bob"})
+	require.Nil(t, err)
+	assert.Equal(t, cdc.MustMarshalBinaryBare(struct{ Owner string }{"bob"}), bz)
+
+	// The field must be marked as encrypted.
+	plain := amino.NewCodec()
+	bz, err = plain.MarshalBinaryBare(struct {
+		Owner  string
+		Secret []byte
+	}{"alice", []byte{0x00, 0x01}})
+	require.Nil(t, err)
+	err = cdc.UnmarshalBinaryBare(bz, &acc2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected an encrypted field")
+
+	// Decryption errors are returned.
+	failing := amino.NewCodec()
+	failing.RegisterFieldEncryption(reflect.TypeOf(Account{}), 2, xorCipher,
+		func([]byte) ([]byte, error) { return nil, errors.New("bad key") })
+	err = failing.UnmarshalBinaryBare(cdc.MustMarshalBinaryBare(acc), &acc2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bad key")
+
+	// Encrypted values are decoded with the options of the enclosing decoding.
+	type Vault struct {
+		Msg allowMsg
+	}
+	cdc.RegisterInterface((*allowMsg)(nil), nil)
+	cdc.RegisterConcrete(allowSend{}, "test/send", nil)
+	cdc.RegisterConcrete(allowAdmin{}, "test/admin", nil)
+	cdc.RegisterFieldEncryption(reflect.TypeOf(Vault{}), 1, xorCipher, xorCipher)
+	bz, err = cdc.MarshalBinaryBare(Vault{Msg: allowAdmin{Op: "upgrade"}})
+	require.Nil(t, err)
+	require.Nil(t, cdc.UnmarshalBinaryBare(bz, new(Vault)))
+	err = cdc.UnmarshalBinaryAllowing(bz, new(Vault), []reflect.Type{reflect.TypeOf(allowSend{})})
+	assert.Error(t, err)
+	cdc.SetMaxDecodeDepth(3)
+	assert.Nil(t, cdc.UnmarshalBinaryBare(bz, new(Vault)))
+	cdc.SetMaxDecodeDepth(2) // Vault, allowMsg and allowAdmin.
+	assert.Error(t, cdc.UnmarshalBinaryBare(bz, new(Vault)))
+	cdc.SetMaxDecodeDepth(0)
+
+	// Encrypted byte slices are never written directly when streaming.
+	type Blob struct {
+		Data []byte
+	}
+	cdc.RegisterFieldEncryption(reflect.TypeOf(Blob{}), 1, xorCipher, xorCipher)
+	blob := Blob{Data: bytes.Repeat([]byte("secret"), 1000)}
+	want, err := cdc.MarshalBinaryLengthPrefixed(blob)
+	require.Nil(t, err)
+	w := new(bytes.Buffer)
+	_, err = cdc.MarshalBinaryLengthPrefixedWriter(w, blob)
+	require.Nil(t, err)
+	assert.Equal(t, want, w.Bytes())
+	assert.False(t, bytes.Contains(w.Bytes(), []byte("secret")))
+}
+
+type unionPayload interface{ isUnionPayload() }
+
+type transferPayload struct {
+	To     string
+	Amount int64
+}
+
+type votePayload struct {
+	Proposal uint64
+	Yes      bool
+}
+
+func (transferPayload) isUnionPayload() {}
+func (*votePayload) isUnionPayload()    {}
+
+func TestCodecRegisterUnion(t *testing.T) {
+	type Msg struct {
+		Kind    string
+		Payload unionPayload
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterUnion(reflect.TypeOf(Msg{}), "Kind", "Payload", map[string]reflect.Type{
+		"transfer": reflect.TypeOf(transferPayload{}),
+		"vote":     reflect.TypeOf(&votePayload{}),
+	})
+
+	// The kind is set from the payload, which is written without prefix bytes.
+	bz, err := cdc.MarshalBinaryBare(Msg{Payload: transferPayload{To: "b", Amount: 5}})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x0A, 0x08, 't', 'r', 'a', 'n', 's', 'f', 'e', 'r',
+		0x12, 0x05, 0x0A, 0x01, 'b', 0x10, 0x05}, bz)
+	var msg Msg
+	err = cdc.UnmarshalBinaryBare(bz, &msg)
+	require.Nil(t, err)
+	assert.Equal(t, Msg{Kind: "transfer", Payload: transferPayload{To: "b", Amount: 5}}, msg)
+
+	vote := Msg{Kind: "vote", Payload: &votePayload{Proposal: 7, Yes: true}}
+	bz, err = cdc.MarshalBinaryBare(vote)
+	require.Nil(t, err)
+	msg = Msg{}
+	err = cdc.UnmarshalBinaryBare(bz, &msg)
+	require.Nil(t, err)
+	assert.Equal(t, vote, msg)
+
+	jsonBz, err := cdc.MarshalJSON(vote)
+	require.Nil(t, err)
+	assert.Equal(t, `{"Kind":"vote","Payload":{"Proposal":"7","Yes":true}}`, string(jsonBz))
+	msg = Msg{}
+	err = cdc.UnmarshalJSON(jsonBz, &msg)
+	require.Nil(t, err)
+	assert.Equal(t, vote, msg)
+
+	// A nil payload has no kind.
+	bz, err = cdc.MarshalBinaryBare(Msg{Kind: "vote"})
+	require.Nil(t, err)
+	assert.Empty(t, bz)
+
+	// Unknown kinds are rejected.
+	bz = []byte{0x0A, 0x04, 'm', 'i', 'n', 't', 0x12, 0x02, 0x10, 0x05}
+	err = cdc.UnmarshalBinaryBare(bz, &msg)
+	assert.NotNil(t, err)
+	err = cdc.UnmarshalJSON([]byte(`{"Kind":"mint","Payload":{}}`), &msg)
+	assert.NotNil(t, err)
+
+	assert.Panics(t, func() {
+		cdc.RegisterUnion(reflect.TypeOf(Msg{}), "Kind", "Payload", map[string]reflect.Type{
+			"vote": reflect.TypeOf(votePayload{}), // Does not implement unionPayload.
+		})
+	})
+}
+
+type lazyInner struct {
+	Data string
+}
+
+var lazyDecodings int
+
+func (li lazyInner) MarshalAmino() (string, error) { return li.Data, nil }
+
+func (li *lazyInner) UnmarshalAmino(data string) error {
+	lazyDecodings++
+	li.Data = data
+	return nil
+}
+
+func TestCodecSetLazyAny(t *testing.T) {
+	type Envelope struct {
+		ID    int64
+		Inner interface{}
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*interface{})(nil), nil)
+	cdc.RegisterConcrete(lazyInner{}, "test/lazyInner", nil)
+	env := Envelope{ID: 3, Inner: lazyInner{Data: "payload"}}
+	bz, err := cdc.MarshalBinaryBare(env)
+	require.Nil(t, err)
+
+	lazyDecodings = 0
+	var env2 Envelope
+	err = cdc.UnmarshalBinaryBare(bz, &env2)
+	require.Nil(t, err)
+	assert.Equal(t, 1, lazyDecodings)
+	assert.Equal(t, env, env2)
+
+	// In lazy mode, the inner value is decoded on Resolve, once.
+	cdc.SetLazyAny(true)
+	lazyDecodings = 0
+	env2 = Envelope{}
+	err = cdc.UnmarshalBinaryBare(bz, &env2)
+	require.Nil(t, err)
+	assert.Equal(t, 0, lazyDecodings)
+	assert.Equal(t, int64(3), env2.ID)
+	lazy, ok := env2.Inner.(*amino.LazyAny)
+	require.True(t, ok)
+	assert.Equal(t, "test/lazyInner", lazy.Name)
+
+	inner, err := lazy.Resolve(cdc)
+	require.Nil(t, err)
+	assert.Equal(t, 1, lazyDecodings)
+	assert.Equal(t, lazyInner{Data: "payload"}, inner)
+	inner, err = lazy.Resolve(cdc)
+	require.Nil(t, err)
+	assert.Equal(t, 1, lazyDecodings)
+	assert.Equal(t, lazyInner{Data: "payload"}, inner)
+
+	// Types unknown to the resolving codec fail.
+	_, err = (&amino.LazyAny{Name: "test/missing"}).Resolve(cdc)
+	assert.NotNil(t, err)
+}
+
+func TestCodecRegisterRawType(t *testing.T) {
+	type Foreign struct {
+		Bz []byte
+	}
+	type Envelope struct {
+		ID      int64
+		Msg     Foreign
+		Extra   *Foreign
+		Trailer string
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterRawType(reflect.TypeOf(&Foreign{}))
+
+	// Arbitrary bytes, which are not a valid encoding of Foreign.
+	payload := []byte{0xFF, 0xFF, 0x00, 0x07}
+	env := Envelope{ID: 1, Msg: Foreign{payload}, Extra: &Foreign{[]byte{0x08, 0x01}}, Trailer: "t"}
+	bz, err := cdc.MarshalBinaryBare(env)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x08, 0x01, 0x12, 0x04, 0xFF, 0xFF, 0x00, 0x07, 0x1A, 0x02, 0x08, 0x01,
+		0x22, 0x01, 't'}, bz)
+
+	var env2 Envelope
+	err = cdc.UnmarshalBinaryBare(bz, &env2)
+	require.Nil(t, err)
+	assert.Equal(t, env, env2)
+
+	// At the top level the bytes are written as is.
+	bz, err = cdc.MarshalBinaryBare(Foreign{payload})
+	require.Nil(t, err)
+	assert.Equal(t, payload, bz)
+	var f Foreign
+	err = cdc.UnmarshalBinaryBare(bz, &f)
+	require.Nil(t, err)
+	assert.Equal(t, payload, f.Bz)
+
+	// Empty payloads are omitted.
+	bz, err = cdc.MarshalBinaryBare(Envelope{ID: 2})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x08, 0x02}, bz)
+
+	assert.Panics(t, func() { cdc.RegisterRawType(reflect.TypeOf(Envelope{})) })
+}
+
+type regSend struct {
+	Amount int64
+}
+
+type regHash [32]byte
+
+func TestCodecRegisteredTypes(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterConcrete(&regSend{}, "bank/Send", nil)
+	cdc.RegisterConcrete(regHash{}, "crypto/Hash", nil)
+	cdc.RegisterConcrete(SimpleStruct{}, "amino/Simple", nil)
+
+	infos := cdc.RegisteredTypes()
+	require.Len(t, infos, 3)
+	_, pb := amino.NameToDisfix("amino/Simple")
+	assert.Equal(t, amino.RegisteredTypeInfo{
+		Type:             reflect.TypeOf(SimpleStruct{}),
+		Name:             "amino/Simple",
+		TypeURL:          "/amino/Simple",
+		Prefix:           pb,
+		PointerPreferred: false,
+		Length:           "variable",
+	}, infos[0])
+	assert.Equal(t, "/bank/Send", infos[1].TypeURL)
+	assert.True(t, infos[1].PointerPreferred)
+	assert.Equal(t, "/crypto/Hash", infos[2].TypeURL)
+	assert.Equal(t, "0x20", infos[2].Length)
+
+	// The result is a copy.
+	infos[0].Name = "changed"
+	assert.Equal(t, "amino/Simple", cdc.RegisteredTypes()[0].Name)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, cdc.PrintTypes(buf))
+	assert.Equal(t, "| Type | Name | Prefix | Length | Notes |\n"+
+		"| ---- | ---- | ------ | ----- | ------ |\n"+
+		fmt.Sprintf("| SimpleStruct | amino/Simple | 0x%X | variable |  |\n", infos[0].Prefix)+
+		fmt.Sprintf("| regSend | bank/Send | 0x%X | variable |  |\n", infos[1].Prefix)+
+		fmt.Sprintf("| regHash | crypto/Hash | 0x%X | 0x20 |  |\n", infos[2].Prefix),
+		buf.String())
+}
+
+func TestPrintTypesDeterministic(t *testing.T) {
+	type Last struct{ A string }
+	type First struct{ B string }
+	type Middle struct{ C string }
+	type Second struct{ D string }
+	type Penultimate struct{ E string }
+	cdc := amino.NewCodec()
+	cdc.RegisterConcrete(Last{}, "z/Last", nil)
+	cdc.RegisterConcrete(First{}, "a/First", nil)
+	cdc.RegisterConcrete(&Middle{}, "m/Middle", nil)
+	cdc.RegisterConcrete(Second{}, "b/Second", nil)
+	cdc.RegisterConcrete(Penultimate{}, "y/Penultimate", nil)
+	cdc.RegisterConcrete(SimpleStruct{}, "amino/Simple", nil)
+
+	// Rows are sorted by TypeURL, not in map order, so output is stable.
+	var first, second bytes.Buffer
+	require.NoError(t, cdc.PrintTypes(&first))
+	for i := 0; i < 10; i++ {
+		second.Reset()
+		require.NoError(t, cdc.PrintTypes(&second))
+		require.Equal(t, first.Bytes(), second.Bytes(), "run %v", i)
+	}
+	lines := strings.Split(strings.TrimSpace(first.String()), "\n")
+	require.Len(t, lines, 2+6)
+	assert.Contains(t, lines[2], "a/First")
+	assert.Contains(t, lines[7], "z/Last")
+}
+
+type covShape interface{ Area() int }
+type covColor interface{ RGB() int }
+
+type covSquare struct{ Side int }
+
+func (s covSquare) Area() int { return s.Side * s.Side }
+
+type covRed struct{}
+
+func (covRed) RGB() int { return 0xFF0000 }
+
+type covStyle struct {
+	Stroke covColor
+}
+
+type covDrawing struct {
+	Shapes  []covShape
+	Main    *covShape
+	Fill    covColor
+	Styles  map[string]covStyle
+	Comment string
+}
+
+func TestCodecValidateInterfaceCoverage(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*covShape)(nil), nil)
+	cdc.RegisterInterface((*covColor)(nil), nil)
+	cdc.RegisterConcrete(covSquare{}, "cov/Square", nil)
+	cdc.RegisterConcrete(covDrawing{}, "cov/Drawing", nil)
+	cdc.Seal()
+
+	errs := cdc.ValidateInterfaceCoverage()
+	require.Len(t, errs, 2)
+	assert.Equal(t, "amino_test.covDrawing.Fill has no registered implementation of amino_test.covColor",
+		errs[0].Error())
+	assert.Equal(t, "amino_test.covStyle.Stroke has no registered implementation of amino_test.covColor",
+		errs[1].Error())
+
+	// Once covered, there are no errors.
+	cdc = amino.NewCodec()
+	cdc.RegisterInterface((*covShape)(nil), nil)
+	cdc.RegisterInterface((*covColor)(nil), nil)
+	cdc.RegisterConcrete(covSquare{}, "cov/Square", nil)
+	cdc.RegisterConcrete(covRed{}, "cov/Red", nil)
+	cdc.RegisterConcrete(covDrawing{}, "cov/Drawing", nil)
+	assert.Empty(t, cdc.ValidateInterfaceCoverage())
+}
+
+func TestCodecClone(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*covShape)(nil), nil)
+	cdc.RegisterInterface((*covColor)(nil), nil)
+	cdc.RegisterConcrete(covSquare{}, "cov/Square", nil)
+	cdc.SetAnyTypeKey("kind")
+	cdc.Seal()
+
+	clone := cdc.Clone()
+	clone.RegisterConcrete(covRed{}, "cov/Red", nil)
+	assert.Panics(t, func() { cdc.RegisterConcrete(covRed{}, "cov/Red", nil) })
+
+	// The clone has the parent's registrations and settings, and its own.
+	var shape covShape = covSquare{2}
+	bz, err := clone.MarshalBinaryBare(&shape)
+	require.NoError(t, err)
+	assert.Equal(t, cdc.MustMarshalBinaryBare(&shape), bz)
+	style := covStyle{Stroke: covRed{}}
+	bz, err = clone.MarshalBinaryBare(style)
+	require.NoError(t, err)
+	var style2 covStyle
+	require.NoError(t, clone.UnmarshalBinaryBare(bz, &style2))
+	assert.Equal(t, style, style2)
+	jbz, err := clone.MarshalJSON(style)
+	require.NoError(t, err)
+	assert.Contains(t, string(jbz), `"kind":"cov/Red"`)
+
+	// The parent is unaffected.
+	assert.Len(t, cdc.RegisteredTypes(), 1)
+	assert.Len(t, clone.RegisteredTypes(), 2)
+	_, err = cdc.MarshalBinaryBare(style)
+	assert.Error(t, err)
+	assert.Error(t, cdc.UnmarshalBinaryBare(bz, &style2))
+
+	// Encrypted fields use the clone's registrations too.
+	type Secret struct {
+		Style covStyle
+	}
+	cdc = amino.NewCodec()
+	cdc.RegisterInterface((*covColor)(nil), nil)
+	cdc.RegisterFieldEncryption(reflect.TypeOf(Secret{}), 1, xorCipher, xorCipher)
+	clone = cdc.Clone()
+	clone.RegisterConcrete(covRed{}, "cov/Red", nil)
+	secret := Secret{style}
+	bz, err = clone.MarshalBinaryBare(secret)
+	require.NoError(t, err)
+	var secret2 Secret
+	require.NoError(t, clone.UnmarshalBinaryBare(bz, &secret2))
+	assert.Equal(t, secret, secret2)
+	_, err = cdc.MarshalBinaryBare(secret)
+	assert.Error(t, err)
+}
+
+func TestCodecReserveFieldNumbers(t *testing.T) {
+	// Version 1 had a third field Memo, which was removed.
+	type TxV2 struct {
+		From   string
+		Amount int64
+	}
+	// A careless version 3 adds another field, reusing #3.
+	type TxV3 struct {
+		From   string
+		Amount int64
+		Fee    int64
+	}
+
+	cdc := amino.NewCodec()
+	cdc.ReserveFieldNumbers(reflect.TypeOf(TxV2{}), 3)
+	assert.Empty(t, cdc.Validate())
+
+	cdc.ReserveFieldNumbers(reflect.TypeOf(TxV3{}), 3)
+	cdc.RegisterVirtualField(reflect.TypeOf(TxV3{}), 4, "total", func(v reflect.Value) interface{} {
+		return v.Field(1).Int() + v.Field(2).Int()
+	})
+	cdc.ReserveFieldNumbers(reflect.TypeOf(TxV3{}), 4)
+	errs := cdc.Validate()
+	require.Len(t, errs, 2)
+	assert.Equal(t, "amino_test.TxV3.Fee uses reserved field number 3", errs[0].Error())
+	assert.Equal(t, "amino_test.TxV3 virtual field total uses reserved field number 4", errs[1].Error())
+
+	assert.Panics(t, func() { cdc.ReserveFieldNumbers(reflect.TypeOf(""), 1) })
+	assert.Panics(t, func() { cdc.ReserveFieldNumbers(reflect.TypeOf(TxV2{}), 0) })
+}
+
+func TestCodecRegisterNameAlias(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*covShape)(nil), nil)
+	cdc.RegisterConcrete(covSquare{}, "cov/Square", nil)
+	cdc.RegisterConcrete(covRed{}, "cov/Red", nil)
+	cdc.RegisterNameAlias("sq", "/cov/Square")
+
+	var shape covShape
+	err := cdc.UnmarshalJSON([]byte(`{"type":"sq","value":{"Side":"3"}}`), &shape)
+	require.NoError(t, err)
+	assert.Equal(t, covSquare{3}, shape)
+
+	bz := cdc.MustMarshalBinaryBare(covSquare{4})[amino.PrefixBytesLen:]
+	o, err := cdc.UnmarshalBinaryBareWithTypeURL("/sq", bz)
+	require.NoError(t, err)
+	assert.Equal(t, covSquare{4}, o)
+
+	// Encoding uses the registered name.
+	bz, err = cdc.MarshalJSON(shape)
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"cov/Square","value":{"Side":"3"}}`, string(bz))
+
+	assert.Panics(t, func() { cdc.RegisterNameAlias("circle", "cov/Circle") })
+	assert.Panics(t, func() { cdc.RegisterNameAlias("cov/Red", "cov/Square") })
+	assert.Panics(t, func() { cdc.RegisterNameAlias("sq", "cov/Red") })
+	cdc.RegisterNameAlias("sq", "cov/Square") // Idempotent.
+}
+
+func TestCodecTryRegisterConcrete(t *testing.T) {
+	type pluginFloat struct {
+		F float64
+	}
+
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*covShape)(nil), nil)
+	require.NoError(t, cdc.TryRegisterConcrete(covSquare{}, "cov/Square", nil))
+
+	sq := covSquare{}
+	sqPtr := &sq
+	cases := []struct {
+		o    interface{}
+		name string
+		want string
+	}{
+		{nil, "cov/Nil", "cannot register nil as a concrete type"},
+		{(*covShape)(nil), "cov/ShapePtr", "registering interface-pointers not yet supported: *amino_test.covShape"},
+		{&sqPtr, "cov/SquarePtrPtr", "registering pointer-pointers not yet supported: **amino_test.covSquare"},
+		{covSquare{}, "cov/Square2", "TypeInfo already exists for amino_test.covSquare"},
+		{covRed{}, "cov/Square", "already registered for amino_test.covSquare"},
+		{pluginFloat{}, "cov/Float", "cannot register amino_test.pluginFloat: floating point types are unsafe"},
+	}
+	for _, tc := range cases {
+		err := cdc.TryRegisterConcrete(tc.o, tc.name, nil)
+		require.Error(t, err, tc.name)
+		assert.Contains(t, err.Error(), tc.want, tc.name)
+		assert.Panics(t, func() { cdc.RegisterConcrete(tc.o, tc.name, nil) }, tc.name)
+	}
+
+	// Failed registrations leave the codec unchanged.
+	assert.Len(t, cdc.RegisteredTypes(), 1)
+	require.NoError(t, cdc.TryRegisterConcrete(covRed{}, "cov/Red", nil))
+	var shape covShape = covSquare{2}
+	bz, err := cdc.MarshalBinaryBare(struct{ S covShape }{shape})
+	require.NoError(t, err)
+	var s struct{ S covShape }
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &s))
+	assert.Equal(t, shape, s.S)
+
+	cdc.Seal()
+	err = cdc.TryRegisterConcrete(covDrawing{}, "cov/Drawing", nil)
+	require.Error(t, err)
+	assert.Equal(t, "codec sealed", err.Error())
+}
+
+type covTriangle struct{ Base, Height int }
+
+func (tr covTriangle) Area() int { return tr.Base * tr.Height / 2 }
+
+func TestCodecRegisterOneof(t *testing.T) {
+	type Drawing struct {
+		Shape covShape
+	}
+	newCodec := func() *amino.Codec {
+		cdc := amino.NewCodec()
+		cdc.RegisterInterface((*covShape)(nil), nil)
+		cdc.RegisterConcrete(covSquare{}, "cov/Square", nil)
+		cdc.RegisterConcrete(covTriangle{}, "cov/Triangle", nil)
+		return cdc
+	}
+	open := newCodec()
+	cdc := newCodec()
+	cdc.RegisterOneof(reflect.TypeOf((*covShape)(nil)).Elem(), []reflect.Type{reflect.TypeOf(covSquare{})})
+
+	d := Drawing{covSquare{2}}
+	bz, err := cdc.MarshalBinaryBare(d)
+	require.NoError(t, err)
+	var d2 Drawing
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &d2))
+	assert.Equal(t, d, d2)
+
+	want := "concrete type amino_test.covTriangle is not one of [amino_test.covSquare] allowed for amino_test.covShape"
+	d = Drawing{covTriangle{2, 3}}
+	_, err = cdc.MarshalBinaryBare(d)
+	require.Error(t, err)
+	assert.Equal(t, want, err.Error())
+	_, err = cdc.MarshalJSON(d)
+	require.Error(t, err)
+	assert.Equal(t, want, err.Error())
+
+	// Decoding is checked too.
+	d2 = Drawing{}
+	err = cdc.UnmarshalBinaryBare(open.MustMarshalBinaryBare(d), &d2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), want)
+	d2 = Drawing{}
+	err = cdc.UnmarshalJSON(open.MustMarshalJSON(d), &d2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), want)
+
+	shapeType := reflect.TypeOf((*covShape)(nil)).Elem()
+	assert.Panics(t, func() { cdc.RegisterOneof(shapeType, nil) })
+	assert.Panics(t, func() { cdc.RegisterOneof(shapeType, []reflect.Type{reflect.TypeOf(covRed{})}) })
+	assert.Panics(t, func() { cdc.RegisterOneof(reflect.TypeOf(covSquare{}), []reflect.Type{shapeType}) })
+}
+
+// cycNode is encoded as cycRepr, which holds a cycNode by value.
+type cycNode struct{ ID int }
+type cycRepr struct {
+	ID    int
+	Inner [1]cycNode
+}
+
+func (n cycNode) MarshalAmino() (cycRepr, error) { return cycRepr{ID: n.ID}, nil }
+
+// cycTree is encoded as cycTreeRepr, which only holds cycTrees by pointer
+// and in a slice.
+type cycTree struct{ ID int }
+type cycTreeRepr struct {
+	Parent   *cycTree
+	Children []cycTree
+}
+
+func (n cycTree) MarshalAmino() (cycTreeRepr, error) { return cycTreeRepr{}, nil }
+
+func TestCodecValueCycle(t *testing.T) {
+	cdc := amino.NewCodec()
+	want := "amino_test.cycNode contains itself by value, through " +
+		"amino_test.cycNode.MarshalAmino() -> amino_test.cycRepr.Inner; " +
+		"use a pointer, slice or map to end the cycle"
+
+	_, err := cdc.MarshalBinaryBare(cycNode{ID: 1})
+	require.Error(t, err)
+	assert.Equal(t, want, err.Error())
+	_, err = cdc.MarshalJSON(cycNode{ID: 1})
+	require.Error(t, err)
+	assert.Equal(t, want, err.Error())
+	_, err = cdc.MarshalBinaryBare(struct{ Node cycNode }{})
+	require.Error(t, err)
+	assert.Equal(t, want, err.Error())
+
+	err = cdc.TryRegisterConcrete(cycNode{}, "cyc/node", nil)
+	require.Error(t, err)
+	assert.Equal(t, "cannot register amino_test.cycNode: "+want, err.Error())
+
+	// Self-references through pointers and slices are fine.
+	assert.NoError(t, cdc.TryRegisterConcrete(cycTree{}, "cyc/tree", nil))
+	_, err = cdc.MarshalBinaryBare(cycTree{ID: 1})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,155 @@
+package amino
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Columnar encoding
+
+// MarshalColumnar encodes items, a slice of structs []T, column by column:
+// the number of items is written as a uvarint, followed by one byte-length
+// prefixed list per field of T, holding that field's values across all
+// items in field number order.  Similar values are thus stored together,
+// which usually compresses better than MarshalBinaryBare.  The lists are
+// encoded as for a []F field with the options of the field (of type F),
+// except that since lists of lists aren't supported, the values of list
+// fields are each wrapped in a struct, as its field 1.  Use
+// UnmarshalColumnar to decode.
+func (cdc *Codec) MarshalColumnar(items interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv := reflect.ValueOf(items)
+	if rv.Kind() != reflect.Slice {
+		return nil, errors.Errorf("MarshalColumnar expects a slice, got %T", items)
+	}
+	info, err := cdc.getColumnarTypeInfo(rv.Type().Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = EncodeUvarint(buf, uint64(rv.Len())); err != nil {
+		return nil, err
+	}
+	for _, field := range info.Fields {
+		// Gather the column into a []F.
+		var ert = columnElemType(info.Type, field)
+		var crt = reflect.SliceOf(ert)
+		var crv = reflect.MakeSlice(crt, rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			columnElem(crv.Index(i), ert != field.Type).Set(field.valueOf(rv.Index(i)))
+		}
+		var cinfo *TypeInfo
+		cinfo, err = cdc.getTypeInfoWlock(crt)
+		if err != nil {
+			return nil, err
+		}
+		err = cdc.encodeReflectBinary(buf, cinfo, crv, field.FieldOptions, false, encodeOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding column %v", field.Name)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalColumnar decodes bz, as written by MarshalColumnar, into the
+// slice of structs pointed to by ptr.
+func (cdc *Codec) UnmarshalColumnar(bz []byte, ptr interface{}) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("UnmarshalColumnar expects a pointer to a slice, got %T", ptr)
+	}
+	rv = rv.Elem()
+	info, err := cdc.getColumnarTypeInfo(rv.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	count, n, err := cdc.decodeUvarint(bz)
+	if err != nil {
+		return errors.Wrap(err, "could not decode number of items")
+	}
+	bz = bz[n:]
+	// Decode all columns before allocating the items, since count can't be
+	// trusted until the columns agree with it.
+	var columns = make([]reflect.Value, len(info.Fields))
+	for i, field := range info.Fields {
+		var crt = reflect.SliceOf(columnElemType(info.Type, field))
+		var cinfo *TypeInfo
+		cinfo, err = cdc.getTypeInfoWlock(crt)
+		if err != nil {
+			return err
+		}
+		columns[i] = reflect.New(crt).Elem()
+		n, err = cdc.decodeReflectBinary(bz, cinfo, columns[i], field.FieldOptions, false, decodeOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "decoding column %v", field.Name)
+		}
+		bz = bz[n:]
+		if uint64(columns[i].Len()) != count {
+			return fmt.Errorf("column %v has %v values, expected %v", field.Name, columns[i].Len(), count)
+		}
+	}
+	if len(bz) > 0 {
+		return fmt.Errorf("%v bytes left over after reading columns", len(bz))
+	}
+
+	var srv = reflect.MakeSlice(rv.Type(), int(count), int(count))
+	for i, field := range info.Fields {
+		var wrapped = columns[i].Type().Elem() != field.Type
+		for j := 0; j < int(count); j++ {
+			field.valueOf(srv.Index(j)).Set(columnElem(columns[i].Index(j), wrapped))
+		}
+	}
+	rv.Set(srv)
+	return nil
+}
+
+func (cdc *Codec) getColumnarTypeInfo(rt reflect.Type) (*TypeInfo, error) {
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		return nil, err
+	}
+	if rt.Kind() != reflect.Struct || info.Type == timeType || info.IsAminoMarshaler {
+		return nil, fmt.Errorf("columnar encoding is only supported for slices of plain structs, got %v", rt)
+	}
+	for _, field := range info.Fields {
+		if field.WrapperType != nil || field.DynamicResolver != nil || field.FieldCodec != nil ||
+			field.union != nil {
+			return nil, fmt.Errorf("columnar encoding does not support field %v of %v", field.Name, rt)
+		}
+	}
+	return info, nil
+}
+
+// Returns the element type of the column of field, a field of the struct
+// type rt: the field type itself, or for lists a struct wrapping it.
+func columnElemType(rt reflect.Type, field FieldInfo) reflect.Type {
+	var ft = field.Type
+	for ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	if (ft.Kind() != reflect.Slice && ft.Kind() != reflect.Array) || ft.Elem().Kind() == reflect.Uint8 {
+		return field.Type
+	}
+	return reflect.StructOf([]reflect.StructField{
+		{Name: "Value", Type: field.Type, Tag: field.structField(rt).Tag},
+	})
+}
+
+// Returns the field value held by the column element erv.
+func columnElem(erv reflect.Value, wrapped bool) reflect.Value {
+	if wrapped {
+		return erv.Field(0)
+	}
+	return erv
+}
@@ -0,0 +1,52 @@
+package amino_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+func TestMarshalColumnar(t *testing.T) {
+	type Reading struct {
+		Sensor string
+		Value  int64 `binary:"fixed64"`
+		Flags  uint8
+		At     time.Time
+		Notes  []string
+		Next   *Reading
+	}
+
+	cdc := amino.NewCodec()
+	at := time.Unix(1500000000, 0).UTC()
+	items := []Reading{
+		{Sensor: "a", Value: 1, Flags: 3, At: at, Notes: []string{"x"}},
+		{Sensor: "b", Value: -2, At: at.Add(time.Second), Next: &Reading{Sensor: "c", At: at}},
+		{Sensor: "a", Value: 0, Flags: 1, At: at.Add(2 * time.Second)},
+	}
+	bz, err := cdc.MarshalColumnar(items)
+	require.NoError(t, err)
+
+	// Row-major encoding differs.
+	rowBz, err := cdc.MarshalBinaryBare(items)
+	require.NoError(t, err)
+	assert.NotEqual(t, rowBz, bz)
+	// The first column holds all Sensor values.
+	assert.Equal(t, []byte{0x03, 0x09, 0x0A, 0x01, 'a', 0x0A, 0x01, 'b', 0x0A, 0x01, 'a'}, bz[:11])
+
+	var items2 []Reading
+	err = cdc.UnmarshalColumnar(bz, &items2)
+	require.NoError(t, err)
+	assert.Equal(t, items, items2)
+
+	// Columns must agree on the number of items.
+	bz[0] = 0x02
+	err = cdc.UnmarshalColumnar(bz, &items2)
+	assert.Error(t, err)
+
+	_, err = cdc.MarshalColumnar([]int{1})
+	assert.Error(t, err)
+}
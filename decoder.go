@@ -1,9 +1,13 @@
 package amino
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"time"
 )
@@ -190,12 +194,61 @@ func DecodeFloat64(bz []byte) (f float64, n int, err error) {
 	return
 }
 
+// The inverse of unixMillis().
+func timeFromUnixMillis(ms int64) time.Time {
+	return time.Unix(ms/1e3, (ms%1e3)*1e6).UTC()
+}
+
+// The inverse of gzipPayload().  Fails if the value is longer than
+// maxSize, unless maxSize is 0.
+func gunzipPayload(payload []byte, maxSize int) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	switch payload[0] {
+	case gzipFlagRaw:
+		return payload[1:], nil
+	case gzipFlagGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(payload[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if maxSize == 0 {
+			return ioutil.ReadAll(zr)
+		}
+		// Read one byte more than allowed, to tell if there are more.
+		bz, err := ioutil.ReadAll(io.LimitReader(zr, int64(maxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(bz) > maxSize {
+			return nil, fmt.Errorf("gzip field value exceeds limit of %v bytes", maxSize)
+		}
+		return bz, nil
+	default:
+		return nil, fmt.Errorf("invalid gzip field flag %X", payload[0])
+	}
+}
+
+// The inverse of dateOnlyDays(), at midnight UTC.
+func timeFromDateOnlyDays(days int64) time.Time {
+	return time.Unix(days*86400, 0).UTC()
+}
+
 // DecodeTime decodes seconds (int64) and nanoseconds (int32) since January 1,
 // 1970 UTC, and returns the corresponding time.  If nanoseconds is not in the
 // range [0, 999999999], or if seconds is too large, the behavior is
 // undefined.
 // TODO return error if behavior is undefined.
 func DecodeTime(bz []byte) (t time.Time, n int, err error) {
+	return decodeTime(bz, true)
+}
+
+// Like DecodeTime, but if strict, as for `amino:"time_nanos"` fields, bz
+// must hold nothing but the seconds and nanoseconds, so that nanoseconds
+// with another encoding aren't silently dropped.  Seconds must be within
+// years 1 to 9999, and nanoseconds in [0, 999999999] either way.
+func decodeTime(bz []byte, strict bool) (t time.Time, n int, err error) {
 	// Defensively set default to to zeroTime (1970, not 0001)
 	t = zeroTime
 
@@ -214,6 +267,10 @@ func DecodeTime(bz []byte) (t time.Time, n int, err error) {
 			return
 		}
 	}
+	if strict && len(bz) > 0 {
+		err = InvalidTimeErr(fmt.Sprintf("expected only seconds and nanoseconds, got %X", bz))
+		return
+	}
 
 	// Construct time.
 	t = time.Unix(sec, int64(nsec))
@@ -104,7 +104,11 @@ func _deepCopy(src, dst reflect.Value) {
 			return
 		default:
 			for i := 0; i < src.NumField(); i++ {
-				if !isExported(src.Type().Field(i)) {
+				if sfield := src.Type().Field(i); !isExported(sfield) {
+					if sfield.Anonymous && sfield.Type.Kind() == reflect.Struct {
+						// Copy its exported fields, see structFields().
+						deepCopy(src.Field(i), dst.Field(i))
+					}
 					continue // field is unexported
 				}
 				srcf := src.Field(i)
@@ -181,7 +185,7 @@ func callDeepCopy(src, dst reflect.Value) bool {
 // CONTRACT: src and dst are of equal types.
 func callAminoCopy(src, dst reflect.Value) bool {
 	if src.Type() != dst.Type() {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	switch {
 	case src.Kind() == reflect.Ptr:
@@ -189,7 +193,7 @@ func callAminoCopy(src, dst reflect.Value) bool {
 		dst.Set(cpy)
 	case src.CanAddr():
 		if !dst.CanAddr() {
-			panic("should not happen")
+			panic(internalError("should not happen"))
 		}
 		src = src.Addr()
 		dst = dst.Addr()
@@ -251,3 +255,184 @@ func canAminoCopy(rv reflect.Value) bool {
 	}
 	return true
 }
+
+//----------------------------------------
+// Codec.DeepCopy
+
+// DeepCopy deeply copies src into the value pointed to by dst, which must
+// be of the same type as src (or, if src is a pointer, as what it points
+// to).  Unlike the DeepCopy function, it copies what would be encoded,
+// following the TypeInfo of each type: only the encoded fields of structs
+// are copied, types which implement MarshalAmino and UnmarshalAmino are
+// copied via their repr types, and interface values must hold registered
+// concrete types.  Slices and maps are never shared with src.
+func (cdc *Codec) DeepCopy(src, dst interface{}) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	drv := reflect.ValueOf(dst)
+	if drv.Kind() != reflect.Ptr || drv.IsNil() {
+		return fmt.Errorf("DeepCopy expects a non-nil pointer, got %T", dst)
+	}
+	drv = drv.Elem()
+	srv := reflect.ValueOf(src)
+	if srv.IsValid() && srv.Type() != drv.Type() && srv.Kind() == reflect.Ptr {
+		if srv.IsNil() {
+			drv.Set(reflect.Zero(drv.Type()))
+			return nil
+		}
+		srv = srv.Elem()
+	}
+	if !srv.IsValid() || srv.Type() != drv.Type() {
+		return fmt.Errorf("DeepCopy cannot copy %T into %T", src, dst)
+	}
+	cpy := reflect.New(drv.Type()).Elem()
+	err = cdc.deepCopyValue(srv, cpy)
+	if err != nil {
+		return err
+	}
+	drv.Set(cpy)
+	return nil
+}
+
+// Copies src into dst, a zero value of the same type.
+func (cdc *Codec) deepCopyValue(src, dst reflect.Value) (err error) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return nil
+		}
+		cpy := reflect.New(src.Type().Elem())
+		err = cdc.deepCopyValue(src.Elem(), cpy.Elem())
+		if err != nil {
+			return
+		}
+		dst.Set(cpy)
+		return nil
+
+	case reflect.Interface:
+		if src.IsNil() {
+			return nil
+		}
+		crv := src.Elem()
+		var cinfo *TypeInfo
+		cinfo, err = cdc.getTypeInfoWlock(derefType(crv.Type()))
+		if err != nil {
+			return
+		}
+		if !cinfo.Registered {
+			return fmt.Errorf("cannot copy unregistered concrete type %v", crv.Type())
+		}
+		cpy := reflect.New(crv.Type()).Elem()
+		err = cdc.deepCopyValue(crv, cpy)
+		if err != nil {
+			return
+		}
+		dst.Set(cpy)
+		return nil
+	}
+
+	info, err := cdc.getTypeInfoWlock(src.Type())
+	if err != nil {
+		return
+	}
+	return cdc.deepCopyReflect(src, dst, info)
+}
+
+// Copies src, which is not a pointer or interface, into dst.
+// CONTRACT: dst is a settable zero value of the same type as src.
+func (cdc *Codec) deepCopyReflect(src, dst reflect.Value, info *TypeInfo) (err error) {
+	if info.IsAminoMarshaler {
+		if !info.IsAminoUnmarshaler || info.AminoUnmarshalReprType != info.AminoMarshalReprType {
+			return fmt.Errorf("cannot copy %v without a matching UnmarshalAmino", info.Type)
+		}
+		var rrv reflect.Value
+		rrv, err = toReprObject(src)
+		if err != nil {
+			return
+		}
+		rcpy := reflect.New(info.AminoMarshalReprType).Elem()
+		err = cdc.deepCopyValue(rrv, rcpy)
+		if err != nil {
+			return
+		}
+		outs := dst.Addr().MethodByName("UnmarshalAmino").Call([]reflect.Value{rcpy})
+		if erri := outs[0].Interface(); erri != nil {
+			err = erri.(error)
+		}
+		return
+	}
+
+	switch src.Kind() {
+	case reflect.Struct:
+		if info.Type == timeType {
+			dst.Set(src)
+			return nil
+		}
+		for _, field := range info.Fields {
+			err = cdc.deepCopyValue(field.valueOf(src), field.valueOf(dst))
+			if err != nil {
+				return
+			}
+		}
+		return nil
+
+	case reflect.Slice:
+		if src.IsNil() {
+			return nil
+		}
+		cpy := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		err = cdc.deepCopyElems(src, cpy)
+		if err != nil {
+			return
+		}
+		dst.Set(cpy)
+		return nil
+
+	case reflect.Array:
+		return cdc.deepCopyElems(src, dst)
+
+	case reflect.Map:
+		if src.IsNil() {
+			return nil
+		}
+		cpy := reflect.MakeMapWithSize(src.Type(), src.Len())
+		for _, key := range src.MapKeys() {
+			vcpy := reflect.New(src.Type().Elem()).Elem()
+			err = cdc.deepCopyValue(src.MapIndex(key), vcpy)
+			if err != nil {
+				return
+			}
+			cpy.SetMapIndex(key, vcpy)
+		}
+		dst.Set(cpy)
+		return nil
+
+	default:
+		dst.Set(src)
+		return nil
+	}
+}
+
+// Copies the elements of the slice or array src into dst, of equal length.
+func (cdc *Codec) deepCopyElems(src, dst reflect.Value) (err error) {
+	switch src.Type().Elem().Kind() {
+	case reflect.Int64, reflect.Int32, reflect.Int16,
+		reflect.Int8, reflect.Int, reflect.Uint64,
+		reflect.Uint32, reflect.Uint16, reflect.Uint8,
+		reflect.Uint, reflect.Bool, reflect.Float64,
+		reflect.Float32, reflect.String:
+
+		if _, ok := src.Type().Elem().MethodByName("MarshalAmino"); !ok {
+			reflect.Copy(dst, src)
+			return nil
+		}
+	}
+	for i := 0; i < src.Len(); i++ {
+		err = cdc.deepCopyValue(src.Index(i), dst.Index(i))
+		if err != nil {
+			return
+		}
+	}
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	amino "github.com/tendermint/go-amino"
 )
 
@@ -129,3 +131,64 @@ func TestDeepCopyInterface2(t *testing.T) {
 	dci2 := amino.DeepCopy(dci1).(DCInterface1)
 	assert.Equal(t, "foo", dci2.Foo)
 }
+
+type DCShape interface{}
+
+type DCSquare struct {
+	Side int64
+}
+
+type DCCircle struct {
+	Radius int64
+	Labels []string
+}
+
+type DCScene struct {
+	Name    string
+	Shapes  []DCShape
+	Points  [][]int64
+	Weights map[string]*DCSquare
+	Repr    DCFoo2
+	Extra   *DCCircle
+	hidden  []byte
+}
+
+func TestCodecDeepCopy(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*DCShape)(nil), nil)
+	cdc.RegisterConcrete(DCSquare{}, "test/DCSquare", nil)
+	cdc.RegisterConcrete(&DCCircle{}, "test/DCCircle", nil)
+
+	src := DCScene{
+		Name:    "scene",
+		Shapes:  []DCShape{DCSquare{2}, &DCCircle{3, []string{"a"}}, nil},
+		Points:  [][]int64{{1, 2}, {3}},
+		Weights: map[string]*DCSquare{"w": {4}},
+		Repr:    *newDCFoo2("repr"),
+		Extra:   &DCCircle{Radius: 5},
+		hidden:  []byte("hidden"),
+	}
+	var dst DCScene
+	err := cdc.DeepCopy(&src, &dst)
+	require.Nil(t, err)
+	want := src
+	want.hidden = nil // Unexported fields are not encoded, so not copied.
+	assert.Equal(t, want, dst)
+
+	// Nothing is shared.
+	src.Points[0][0] = 100
+	src.Shapes[1].(*DCCircle).Labels[0] = "b"
+	src.Weights["w"].Side = 400
+	src.Extra.Radius = 500
+	assert.Equal(t, int64(1), dst.Points[0][0])
+	assert.Equal(t, "a", dst.Shapes[1].(*DCCircle).Labels[0])
+	assert.Equal(t, int64(4), dst.Weights["w"].Side)
+	assert.Equal(t, int64(5), dst.Extra.Radius)
+
+	// Unregistered concrete types are rejected.
+	err = cdc.DeepCopy(DCScene{Shapes: []DCShape{"square"}}, &dst)
+	assert.NotNil(t, err)
+	// As are mismatched types.
+	err = cdc.DeepCopy(DCSquare{}, &dst)
+	assert.NotNil(t, err)
+}
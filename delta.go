@@ -0,0 +1,138 @@
+package amino
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Delta encoding
+
+// binaryDelta is the wire form of a patch produced by MarshalBinaryDelta.
+// Fields lists the field numbers that changed, including fields which were
+// reset to their default value, and Value holds the bare struct encoding of
+// only those fields.
+type binaryDelta struct {
+	Fields []uint32
+	Value  []byte
+}
+
+// MarshalBinaryDelta encodes only the fields of updated which differ from
+// base.  Both must be (pointers to) structs of the same type.  Fields are
+// compared by their binary encoding, and the resulting patch can be applied
+// to a copy of base with MergeBinary to reconstruct updated.
+//
+// Lists (and fields of any other type) are never diffed element-wise: if any
+// element was added, removed or changed, the whole field is included in the
+// patch and replaces the base field on merge.  A field which was reset to its
+// default value (e.g. a list which became empty) is recorded as changed, and
+// is reset on merge as well.
+func (cdc *Codec) MarshalBinaryDelta(base, updated interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	brv, _, isNilPtr := derefPointers(reflect.ValueOf(base))
+	if isNilPtr {
+		return nil, errors.New("MarshalBinaryDelta cannot diff a nil pointer base")
+	}
+	urv, _, isNilPtr := derefPointers(reflect.ValueOf(updated))
+	if isNilPtr {
+		return nil, errors.New("MarshalBinaryDelta cannot diff a nil pointer update")
+	}
+	if brv.Type() != urv.Type() {
+		return nil, errors.Errorf("MarshalBinaryDelta expects values of the same type, got %v and %v",
+			brv.Type(), urv.Type())
+	}
+	info, err := cdc.getDeltaTypeInfo(brv.Type())
+	if err != nil {
+		return
+	}
+
+	var delta binaryDelta
+	var value = new(bytes.Buffer)
+	var bbuf, ubuf = new(bytes.Buffer), new(bytes.Buffer)
+	for _, field := range info.Fields {
+		bbuf.Reset()
+		ubuf.Reset()
+		if err = cdc.encodeReflectBinaryStructField(bbuf, field, brv, FieldOptions{}, encodeOptions{}); err != nil {
+			return nil, err
+		}
+		if err = cdc.encodeReflectBinaryStructField(ubuf, field, urv, FieldOptions{}, encodeOptions{}); err != nil {
+			return nil, err
+		}
+		if bytes.Equal(bbuf.Bytes(), ubuf.Bytes()) {
+			continue
+		}
+		delta.Fields = append(delta.Fields, field.BinFieldNum)
+		value.Write(ubuf.Bytes())
+	}
+	delta.Value = value.Bytes()
+	return cdc.MarshalBinaryBare(delta)
+}
+
+// MergeBinary applies a patch produced by MarshalBinaryDelta to the struct
+// pointed to by ptr.  Only the fields recorded in the patch are overwritten.
+func (cdc *Codec) MergeBinary(patch []byte, ptr interface{}) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr {
+		return ErrNoPointer
+	}
+	rv, _, isNilPtr := derefPointers(rv)
+	if isNilPtr {
+		return errors.New("MergeBinary cannot merge into a nil pointer")
+	}
+	info, err := cdc.getDeltaTypeInfo(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	var delta binaryDelta
+	if err = cdc.UnmarshalBinaryBare(patch, &delta); err != nil {
+		return errors.Wrap(err, "could not decode delta")
+	}
+
+	// Decode the changed fields into a fresh value, then copy them over.
+	var nrv = reflect.New(info.Type).Elem()
+	n, err := cdc.decodeReflectBinaryStruct(delta.Value, info, nrv, FieldOptions{}, true, decodeOptions{})
+	if err != nil {
+		return errors.Wrap(err, "could not decode delta value")
+	}
+	if n != len(delta.Value) {
+		return fmt.Errorf("delta value for %v didn't read all bytes. Expected to read %v, only read %v",
+			info.Type, len(delta.Value), n)
+	}
+	for _, fnum := range delta.Fields {
+		field, ok := info.fieldByBinFieldNum(fnum)
+		if !ok {
+			return fmt.Errorf("delta references unknown field # %v of %v", fnum, info.Type)
+		}
+		field.valueOf(rv).Set(field.valueOf(nrv))
+	}
+	return nil
+}
+
+func (cdc *Codec) getDeltaTypeInfo(rt reflect.Type) (*TypeInfo, error) {
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		return nil, err
+	}
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType || info.IsAminoMarshaler {
+		return nil, fmt.Errorf("binary deltas are only supported for plain structs, got %v", info.Type)
+	}
+	return info, nil
+}
+
+func (sinfo StructInfo) fieldByBinFieldNum(fnum uint32) (FieldInfo, bool) {
+	for _, field := range sinfo.Fields {
+		if field.BinFieldNum == fnum {
+			return field, true
+		}
+	}
+	return FieldInfo{}, false
+}
@@ -0,0 +1,53 @@
+package amino_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+func TestMarshalBinaryDelta(t *testing.T) {
+	type Account struct {
+		Name    string
+		Balance uint64
+		Tags    []string
+	}
+
+	cdc := amino.NewCodec()
+	base := Account{Name: "alice", Balance: 10, Tags: []string{"a", "b"}}
+	updated := Account{Name: "alice", Balance: 20, Tags: []string{"a", "b"}}
+
+	patch, err := cdc.MarshalBinaryDelta(base, updated)
+	require.NoError(t, err)
+	full, err := cdc.MarshalBinaryBare(updated)
+	require.NoError(t, err)
+	assert.True(t, len(patch) < len(full), "patch should be smaller than the full encoding")
+
+	merged := base
+	err = cdc.MergeBinary(patch, &merged)
+	require.NoError(t, err)
+	assert.Equal(t, updated, merged)
+
+	// Fields reset to their default value are carried by the patch too.
+	cleared := Account{Name: "alice"}
+	patch, err = cdc.MarshalBinaryDelta(base, &cleared)
+	require.NoError(t, err)
+	merged = base
+	err = cdc.MergeBinary(patch, &merged)
+	require.NoError(t, err)
+	assert.Equal(t, cleared, merged)
+
+	// No changes yield an empty patch which leaves the base untouched.
+	patch, err = cdc.MarshalBinaryDelta(base, base)
+	require.NoError(t, err)
+	merged = base
+	err = cdc.MergeBinary(patch, &merged)
+	require.NoError(t, err)
+	assert.Equal(t, base, merged)
+
+	_, err = cdc.MarshalBinaryDelta(base, struct{ Name string }{"bob"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,262 @@
+package amino
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	descriptorpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+//----------------------------------------
+// Proto descriptors
+
+// DescriptorFor returns the protobuf message descriptor of the struct type
+// rt (or of its repr type, if it implements MarshalAmino()), as derived from
+// its fields, e.g. for gRPC server reflection.  Referenced struct types are
+// named by their Go type, e.g. ".pkg.Type", so their own descriptors must be
+// served along with it, except for anonymous struct types, which are nested.
+// Interface fields are google.protobuf.Any, and time.Time fields are
+// google.protobuf.Timestamp.  Returns an error for types which can't be
+// described, e.g. nested lists.
+func (cdc *Codec) DescriptorFor(rt reflect.Type) (desc *descriptorpb.DescriptorProto, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsAminoMarshaler {
+		if info, err = cdc.getTypeInfoWlock(info.AminoMarshalReprType); err != nil {
+			return nil, err
+		}
+	}
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType {
+		return nil, fmt.Errorf("DescriptorFor expects a struct type, got %v", rt)
+	}
+	if info.Type.Name() == "" {
+		return nil, fmt.Errorf("DescriptorFor expects a named struct type, got %v", rt)
+	}
+	return cdc.messageDescriptor(info, info.Type.Name(), "."+info.Type.String())
+}
+
+// Returns the descriptor of the struct info, whose full name is fullName.
+func (cdc *Codec) messageDescriptor(info *TypeInfo, name, fullName string) (*descriptorpb.DescriptorProto, error) {
+	desc := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	for _, field := range info.Fields {
+		fdesc := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(field.Name),
+			Number:   proto.Int32(int32(field.BinFieldNum)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(field.JSONName),
+		}
+		var ftype = derefType(field.Type)
+		var _, isRepr = reflect.PtrTo(ftype).MethodByName("MarshalAmino")
+		switch {
+		case field.FieldCodec != nil:
+			fdesc.Type = descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum()
+		case field.Wrapper:
+			fdesc.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fdesc.TypeName = proto.String(wrapperTypeName(ftype))
+		case ftype.Kind() == reflect.Map:
+			entry, err := cdc.mapEntryDescriptor(field, ftype, fullName)
+			if err != nil {
+				return nil, err
+			}
+			desc.NestedType = append(desc.NestedType, entry)
+			fdesc.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			fdesc.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fdesc.TypeName = proto.String(fullName + "." + entry.GetName())
+		case isListType(ftype) && !isBinaryMarshalerType(ftype) && !isRepr:
+			if ftype.Elem().Kind() == reflect.Uint8 {
+				fdesc.Type = descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum()
+				break
+			}
+			var etype = derefType(ftype.Elem())
+			if isListType(etype) && etype.Elem().Kind() != reflect.Uint8 {
+				return nil, fmt.Errorf("field %v of %v is a nested list, which has no descriptor", field.Name, info.Type)
+			}
+			fdesc.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			nested, err := cdc.setFieldType(fdesc, etype, field, fullName)
+			if err != nil {
+				return nil, err
+			}
+			if nested != nil {
+				desc.NestedType = append(desc.NestedType, nested)
+			}
+		default:
+			nested, err := cdc.setFieldType(fdesc, ftype, field, fullName)
+			if err != nil {
+				return nil, err
+			}
+			if nested != nil {
+				desc.NestedType = append(desc.NestedType, nested)
+			}
+		}
+		desc.Field = append(desc.Field, fdesc)
+	}
+	return desc, nil
+}
+
+// Returns the nested map entry message of the map field.
+func (cdc *Codec) mapEntryDescriptor(field FieldInfo, rt reflect.Type, fullName string) (*descriptorpb.DescriptorProto, error) {
+	entry := &descriptorpb.DescriptorProto{
+		Name:    proto.String(field.Name + "Entry"),
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+	}
+	var entryName = fullName + "." + entry.GetName()
+	for i, part := range []struct {
+		name string
+		rt   reflect.Type
+	}{{"key", rt.Key()}, {"value", rt.Elem()}} {
+		fdesc := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(part.name),
+			Number:   proto.Int32(int32(i + 1)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(part.name),
+		}
+		var prt = derefType(part.rt)
+		if isListType(prt) && prt.Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("map field %v has a list %v, which has no descriptor", field.Name, part.name)
+		}
+		nested, err := cdc.setFieldType(fdesc, prt, FieldInfo{Name: part.name}, entryName)
+		if err != nil {
+			return nil, err
+		}
+		if nested != nil {
+			entry.NestedType = append(entry.NestedType, nested)
+		}
+		entry.Field = append(entry.Field, fdesc)
+	}
+	return entry, nil
+}
+
+// Sets the type of fdesc to that of the non-pointer, non-list type rt, and
+// returns the descriptor of rt if it has to be nested in the message named
+// fullName, i.e. if it is an anonymous struct.
+func (cdc *Codec) setFieldType(fdesc *descriptorpb.FieldDescriptorProto, rt reflect.Type,
+	field FieldInfo, fullName string) (nested *descriptorpb.DescriptorProto, err error) {
+	var setType = func(typ descriptorpb.FieldDescriptorProto_Type) {
+		fdesc.Type = typ.Enum()
+	}
+	var setMessage = func(typeName string) {
+		fdesc.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		fdesc.TypeName = proto.String(typeName)
+	}
+	if rt == timeType {
+		if field.UnixMillis || field.DateOnly {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_INT64)
+		} else {
+			setMessage(".google.protobuf.Timestamp")
+		}
+		return nil, nil
+	}
+	if field.Gzip || isBinaryMarshalerType(rt) {
+		setType(descriptorpb.FieldDescriptorProto_TYPE_BYTES)
+		return nil, nil
+	}
+	if rt.Kind() == reflect.Interface {
+		setMessage(".google.protobuf.Any")
+		return nil, nil
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsAminoMarshaler {
+		var rrt = derefType(info.AminoMarshalReprType)
+		if isListType(rrt) && rrt.Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("field %v has repr type %v, which has no descriptor", field.Name, rrt)
+		}
+		return cdc.setFieldType(fdesc, rrt, field, fullName)
+	}
+	if usesIntCodec(info, field.FieldOptions) {
+		setType(descriptorpb.FieldDescriptorProto_TYPE_BYTES)
+		return nil, nil
+	}
+	switch rt.Kind() {
+	case reflect.Struct:
+		if rt.Name() != "" {
+			setMessage("." + rt.String())
+			return nil, nil
+		}
+		nested, err = cdc.messageDescriptor(info, field.Name, fullName+"."+field.Name)
+		if err != nil {
+			return nil, err
+		}
+		setMessage(fullName + "." + field.Name)
+		return nested, nil
+	case reflect.Array, reflect.Slice:
+		setType(descriptorpb.FieldDescriptorProto_TYPE_BYTES) // CONTRACT: a byte list.
+	case reflect.Int64, reflect.Int:
+		if field.BinFixed64 {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_SFIXED64)
+		} else {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_INT64)
+		}
+	case reflect.Int32:
+		if field.BinFixed32 {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_SFIXED32)
+		} else {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_INT32)
+		}
+	case reflect.Int16, reflect.Int8:
+		setType(descriptorpb.FieldDescriptorProto_TYPE_SINT32) // See EncodeInt16().
+	case reflect.Uint64, reflect.Uint:
+		if field.BinFixed64 {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_FIXED64)
+		} else {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_UINT64)
+		}
+	case reflect.Uint32:
+		if field.BinFixed32 {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_FIXED32)
+		} else {
+			setType(descriptorpb.FieldDescriptorProto_TYPE_UINT32)
+		}
+	case reflect.Uint16, reflect.Uint8:
+		setType(descriptorpb.FieldDescriptorProto_TYPE_UINT32)
+	case reflect.Bool:
+		setType(descriptorpb.FieldDescriptorProto_TYPE_BOOL)
+	case reflect.Float64:
+		setType(descriptorpb.FieldDescriptorProto_TYPE_DOUBLE)
+	case reflect.Float32:
+		setType(descriptorpb.FieldDescriptorProto_TYPE_FLOAT)
+	case reflect.String:
+		setType(descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	default:
+		return nil, fmt.Errorf("field %v of type %v has no descriptor", field.Name, rt)
+	}
+	return nil, nil
+}
+
+// Returns the name of the google.protobuf wrapper message of an
+// `amino:"wrapper"` field of type *rt, see wrapperType().
+func wrapperTypeName(rt reflect.Type) string {
+	switch rt.Kind() {
+	case reflect.Float64:
+		return ".google.protobuf.DoubleValue"
+	case reflect.Float32:
+		return ".google.protobuf.FloatValue"
+	case reflect.Int64:
+		return ".google.protobuf.Int64Value"
+	case reflect.Uint64:
+		return ".google.protobuf.UInt64Value"
+	case reflect.Int32:
+		return ".google.protobuf.Int32Value"
+	case reflect.Uint32:
+		return ".google.protobuf.UInt32Value"
+	case reflect.Bool:
+		return ".google.protobuf.BoolValue"
+	case reflect.String:
+		return ".google.protobuf.StringValue"
+	default:
+		return ".google.protobuf.BytesValue"
+	}
+}
+
+// Returns true iff rt is a slice or array.
+func isListType(rt reflect.Type) bool {
+	return rt.Kind() == reflect.Slice || rt.Kind() == reflect.Array
+}
@@ -0,0 +1,99 @@
+package amino_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	descriptorpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+type descPoint struct {
+	X, Y int32
+}
+
+type descShape struct {
+	Name    string `json:"name"`
+	Size    uint64 `binary:"fixed64"`
+	Small   int8
+	Data    []byte
+	Tags    []string
+	Points  []descPoint
+	Center  *descPoint
+	Any     interface{}
+	Created time.Time
+	Meta    struct {
+		Note string
+	}
+	Counts map[string]int64
+}
+
+func TestDescriptorFor(t *testing.T) {
+	cdc := amino.NewCodec()
+	desc, err := cdc.DescriptorFor(reflect.TypeOf(descShape{}))
+	require.NoError(t, err)
+
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	field := func(name string, num int32, label *descriptorpb.FieldDescriptorProto_Label,
+		typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		fdesc := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(num),
+			Label:    label,
+			Type:     typ.Enum(),
+			JsonName: proto.String(name),
+		}
+		if typeName != "" {
+			fdesc.TypeName = proto.String(typeName)
+		}
+		return fdesc
+	}
+	message := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	nameField := field("Name", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")
+	nameField.JsonName = proto.String("name")
+	want := &descriptorpb.DescriptorProto{
+		Name: proto.String("descShape"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			nameField,
+			field("Size", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_FIXED64, ""),
+			field("Small", 3, optional, descriptorpb.FieldDescriptorProto_TYPE_SINT32, ""),
+			field("Data", 4, optional, descriptorpb.FieldDescriptorProto_TYPE_BYTES, ""),
+			field("Tags", 5, repeated, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			field("Points", 6, repeated, message, ".amino_test.descPoint"),
+			field("Center", 7, optional, message, ".amino_test.descPoint"),
+			field("Any", 8, optional, message, ".google.protobuf.Any"),
+			field("Created", 9, optional, message, ".google.protobuf.Timestamp"),
+			field("Meta", 10, optional, message, ".amino_test.descShape.Meta"),
+			field("Counts", 11, repeated, message, ".amino_test.descShape.CountsEntry"),
+		},
+		NestedType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("Meta"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("Note", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")},
+			},
+			{
+				Name: proto.String("CountsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("key", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("value", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			},
+		},
+	}
+	assert.True(t, proto.Equal(want, desc), "got %v", proto.MarshalTextString(desc))
+
+	type Nested struct {
+		Grid [][]int32
+	}
+	_, err = cdc.DescriptorFor(reflect.TypeOf(Nested{}))
+	assert.Error(t, err)
+	_, err = cdc.DescriptorFor(reflect.TypeOf(int64(0)))
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package amino
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+//----------------------------------------
+// Deterministic encoding
+
+// MarshalBinaryDeterministic is like MarshalBinaryBare (which already sorts
+// map entries), but first checks that o holds nothing whose encoding may not
+// be reproducible, for consensus-critical messages.  It rejects:
+//
+//   - a time.Time with a monotonic clock reading, which is not encoded, and
+//     so doesn't survive a round trip (see time.Time.Round(0)),
+//   - a NaN or infinite float, since NaN has many encodings, even though
+//     floats are still only encoded with `amino:"unsafe"`,
+//   - an interface value whose concrete type isn't registered, including
+//     errors which would be encoded as an amino.Error, see RegisterStdError().
+//
+// The value of an AminoMarshaler is checked by its repr.  The returned error
+// names the offending value by its path in o, e.g. "Votes[2].Time".
+func (cdc *Codec) MarshalBinaryDeterministic(o interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	err = cdc.checkDeterministic(reflect.ValueOf(o), "", false)
+	if err != nil {
+		return nil, err
+	}
+	return cdc.MarshalBinaryBare(o)
+}
+
+// Returns an error if rv can't be encoded deterministically, see
+// MarshalBinaryDeterministic().  path names rv in the error.  If anyConcrete,
+// the concrete types of interface values needn't be registered, e.g. for a
+// union payload.
+func (cdc *Codec) checkDeterministic(rv reflect.Value, path string, anyConcrete bool) (err error) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		if rv.Kind() == reflect.Interface && !anyConcrete {
+			var crt = rv.Elem().Type()
+			for crt.Kind() == reflect.Ptr {
+				crt = crt.Elem()
+			}
+			var cinfo *TypeInfo
+			cinfo, err = cdc.getTypeInfoWlock(crt)
+			if err != nil {
+				return
+			}
+			if !cinfo.Registered {
+				return fmt.Errorf("unregistered concrete type %v at %v", crt, pathOrRoot(path))
+			}
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return
+	}
+	if info.IsAminoMarshaler {
+		var rrv reflect.Value
+		rrv, err = toReprObject(rv)
+		if err != nil {
+			return
+		}
+		return cdc.checkDeterministic(rrv, path, false)
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		if info.Type == timeType {
+			var t = rv.Interface().(time.Time)
+			if t != t.Round(0) {
+				return fmt.Errorf("time with monotonic clock reading at %v", pathOrRoot(path))
+			}
+			return nil
+		}
+		for _, field := range info.Fields {
+			anyConcrete := field.union != nil || field.DynamicResolver != nil
+			err = cdc.checkDeterministic(field.valueOf(rv), joinPath(path, field.Name), anyConcrete)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for i := 0; i < rv.Len(); i++ {
+			err = cdc.checkDeterministic(rv.Index(i), fmt.Sprintf("%v[%v]", path, i), false)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		for _, krv := range rv.MapKeys() {
+			err = cdc.checkDeterministic(rv.MapIndex(krv), fmt.Sprintf("%v[%v]", path, krv), false)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if f := rv.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("non-finite float %v at %v", f, pathOrRoot(path))
+		}
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "root"
+	}
+	return path
+}
@@ -0,0 +1,80 @@
+package amino_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+type detVote struct {
+	Height int64
+	Time   time.Time
+	Weight float64 `amino:"unsafe"`
+}
+
+type detBlock struct {
+	Votes    []detVote
+	Tallies  map[string]int64
+	Evidence interface{}
+	Err      error
+}
+
+type detEvidence struct {
+	Height int64
+}
+
+func TestMarshalBinaryDeterministic(t *testing.T) {
+	cdc := amino.NewCodec()
+	cdc.RegisterInterface((*interface{})(nil), nil)
+	cdc.RegisterConcrete(detEvidence{}, "test/detEvidence", nil)
+	cdc.RegisterStdError()
+
+	now := time.Unix(1500000000, 0).UTC()
+	b := detBlock{
+		Votes:    []detVote{{1, now, 0.5}, {2, now, -1}},
+		Tallies:  map[string]int64{"b": 2, "a": 1},
+		Evidence: detEvidence{3},
+	}
+	bz, err := cdc.MarshalBinaryDeterministic(b)
+	require.NoError(t, err)
+	assert.Equal(t, cdc.MustMarshalBinaryBare(b), bz)
+
+	cases := []struct {
+		name string
+		mod  func(b *detBlock)
+		want string
+	}{
+		{"monotonic time", func(b *detBlock) { b.Votes[1].Time = time.Now() },
+			"time with monotonic clock reading at Votes[1].Time"},
+		{"NaN", func(b *detBlock) { b.Votes[0].Weight = math.NaN() },
+			"non-finite float NaN at Votes[0].Weight"},
+		{"infinity", func(b *detBlock) { b.Votes[1].Weight = math.Inf(-1) },
+			"non-finite float -Inf at Votes[1].Weight"},
+		{"unregistered concrete", func(b *detBlock) { b.Evidence = struct{ X int }{1} },
+			"unregistered concrete type struct { X int } at Evidence"},
+		{"std error", func(b *detBlock) { b.Err = errors.New("oops") },
+			"unregistered concrete type errors.errorString at Err"},
+	}
+	for _, tc := range cases {
+		c := detBlock{
+			Votes:    append([]detVote(nil), b.Votes...),
+			Tallies:  b.Tallies,
+			Evidence: b.Evidence,
+		}
+		tc.mod(&c)
+		_, err := cdc.MarshalBinaryDeterministic(c)
+		require.Error(t, err, tc.name)
+		assert.Equal(t, tc.want, err.Error(), tc.name)
+	}
+
+	// A time without its monotonic clock reading is fine.
+	b.Votes[0].Time = time.Now().Round(0)
+	_, err = cdc.MarshalBinaryDeterministic(b)
+	require.NoError(t, err)
+}
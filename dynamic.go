@@ -0,0 +1,175 @@
+package amino
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Dynamic fields
+
+// dynamicAny is the binary wire form of a dynamic field, see
+// RegisterDynamicField.  Name is the registered name of the concrete type
+// returned by the resolver, and Value holds its bare encoding, including
+// prefix bytes.
+type dynamicAny struct {
+	Name  string
+	Value []byte
+}
+
+var dynamicAnyType = reflect.TypeOf(dynamicAny{})
+
+// RegisterDynamicField marks field number fieldNum of the struct type rt,
+// which must be an interface field (e.g. interface{}), as dynamic.  When
+// encoding, resolve is called with the field's concrete value and returns
+// its type and registered name; the value is then written along with the
+// name, which is used to reconstruct it when decoding.  The resolved type
+// must be registered with RegisterConcrete, under the returned name.
+func (cdc *Codec) RegisterDynamicField(rt reflect.Type, fieldNum uint32,
+	resolve func(v reflect.Value) (reflect.Type, string)) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType {
+		panic(fmt.Sprintf("RegisterDynamicField expects a struct, got %v", rt))
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		for i, field := range info.Fields {
+			if field.BinFieldNum != fieldNum {
+				continue
+			}
+			if field.Type.Kind() != reflect.Interface {
+				panic(fmt.Sprintf("dynamic field %v of %v must be an interface, got %v",
+					field.Name, info.Type, field.Type))
+			}
+			info.Fields[i].DynamicResolver = resolve
+			return
+		}
+		panic(fmt.Sprintf("%v has no field # %v", info.Type, fieldNum))
+	}()
+}
+
+// Calls the resolver of the dynamic field on crv, the field's (non-nil)
+// value, and returns the info of the resolved concrete type along with the
+// dereferenced value.
+func (cdc *Codec) resolveDynamicField(field FieldInfo,
+	crv reflect.Value) (cinfo *TypeInfo, drv reflect.Value, err error) {
+	rt, name := field.DynamicResolver(crv)
+	if rt == nil {
+		err = fmt.Errorf("dynamic field %v resolved %v to no type", field.Name, crv.Type())
+		return
+	}
+	if rt != crv.Type() {
+		err = fmt.Errorf("dynamic field %v resolved %v to type %v", field.Name, crv.Type(), rt)
+		return
+	}
+	drv, _, _ = derefPointers(crv)
+	cinfo, err = cdc.getTypeInfoWlock(drv.Type())
+	if err != nil {
+		return
+	}
+	if !cinfo.Registered {
+		err = fmt.Errorf("dynamic field %v resolved to unregistered type %v", field.Name, rt)
+		return
+	}
+	if cinfo.Name != name {
+		err = fmt.Errorf("dynamic field %v resolved %v to name %s, but it is registered as %s",
+			field.Name, rt, name, cinfo.Name)
+		return
+	}
+	return
+}
+
+// Returns the wire form of the dynamic field value crv.
+func (cdc *Codec) encodeDynamicAny(field FieldInfo,
+	crv reflect.Value, eopts encodeOptions) (any dynamicAny, err error) {
+	cinfo, drv, err := cdc.resolveDynamicField(field, crv)
+	if err != nil {
+		return
+	}
+	any.Name = cinfo.Name
+	any.Value, err = cdc.marshalBinaryBare(drv.Interface(), eopts)
+	return
+}
+
+// Reconstructs the value of a dynamic field from its wire form.
+func (cdc *Codec) decodeDynamicAny(field FieldInfo,
+	any dynamicAny, dopts decodeOptions) (irvSet reflect.Value, err error) {
+	cinfo, err := cdc.getTypeInfoFromNameRlock(any.Name)
+	if err != nil {
+		return
+	}
+	if err = dopts.checkAllowed(cinfo.Type); err != nil {
+		return
+	}
+	if dopts, err = cdc.enterAny(dopts); err != nil {
+		return
+	}
+	crv, irvSet := cdc.constructConcreteType(cinfo)
+	if !irvSet.Type().AssignableTo(field.Type) {
+		err = fmt.Errorf("dynamic field %v cannot hold %v", field.Name, irvSet.Type())
+		return
+	}
+	err = cdc.unmarshalBinaryBare(any.Value, crv.Addr().Interface(), dopts)
+	if err != nil {
+		err = errors.Wrapf(err, "decoding dynamic field %v", field.Name)
+	}
+	return
+}
+
+// Writes the dynamic field value crv like MarshalJSON would, i.e. as
+// {"type":name,"value":...}.
+func (cdc *Codec) encodeDynamicFieldJSON(w io.Writer, field FieldInfo, crv reflect.Value) (err error) {
+	cinfo, drv, err := cdc.resolveDynamicField(field, crv)
+	if err != nil {
+		return
+	}
+	err = cdc.writeAnyPrefixJSON(w, cinfo.Name)
+	if err != nil {
+		return
+	}
+	err = cdc.encodeReflectJSON(w, cinfo, drv, FieldOptions{})
+	if err != nil {
+		return
+	}
+	return writeStr(w, `}`)
+}
+
+// Decodes the dynamic field value written by encodeDynamicFieldJSON into frv.
+func (cdc *Codec) decodeDynamicFieldJSON(bz []byte,
+	field FieldInfo, frv reflect.Value, dopts decodeOptions) (err error) {
+	name, data, err := cdc.decodeInterfaceJSON(bz)
+	if err != nil {
+		return
+	}
+	cinfo, err := cdc.getTypeInfoFromNameRlock(name)
+	if err != nil {
+		return
+	}
+	if dopts, err = cdc.enterAny(dopts); err != nil {
+		return
+	}
+	crv, irvSet := cdc.constructConcreteType(cinfo)
+	if !irvSet.Type().AssignableTo(field.Type) {
+		return fmt.Errorf("dynamic field %v cannot hold %v", field.Name, irvSet.Type())
+	}
+	err = cdc.decodeReflectJSON(data, cinfo, crv, FieldOptions{}, dopts)
+	if err != nil {
+		return
+	}
+	frv.Set(irvSet)
+	return
+}
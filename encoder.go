@@ -1,6 +1,8 @@
 package amino
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -121,6 +123,9 @@ const (
 
 	// nanos have to be in interval: [0, 999999999]
 	maxNanos = 999999999
+
+	// seconds of the last time.Time
+	maxTimeNanosSeconds int64 = math.MaxInt64 + minSeconds
 )
 
 type InvalidTimeErr string
@@ -129,6 +134,52 @@ func (e InvalidTimeErr) Error() string {
 	return "invalid time: " + string(e)
 }
 
+// Returns the number of milliseconds since January 1, 1970 UTC, rounded
+// down, as written for `amino:"unixmillis"` fields.
+func unixMillis(t time.Time) int64 {
+	return t.Unix()*1e3 + int64(t.Nanosecond())/1e6
+}
+
+// Returns the number of days between January 1, 1970 and the calendar date
+// of t in its location, as written for `amino:"dateonly"` fields.  The
+// time of day and the location are lost.
+func dateOnlyDays(t time.Time) int64 {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// Values of `amino:"gzip"` fields shorter than this aren't compressed, as
+// the gzip header and footer would outweigh the savings.
+const gzipMinSize = 128
+
+// Flags prepended to the payload of `amino:"gzip"` fields.
+const (
+	gzipFlagRaw  = 0x00
+	gzipFlagGzip = 0x01
+)
+
+// Returns the payload of an `amino:"gzip"` field with the value bz: a flag
+// byte followed by bz, compressed if that makes it smaller.  Empty values
+// have an empty payload.
+func gzipPayload(bz []byte) ([]byte, error) {
+	if len(bz) == 0 {
+		return nil, nil
+	}
+	if len(bz) >= gzipMinSize {
+		buf := bytes.NewBuffer([]byte{gzipFlagGzip})
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(bz); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < 1+len(bz) {
+			return buf.Bytes(), nil
+		}
+	}
+	return append([]byte{gzipFlagRaw}, bz...), nil
+}
+
 // EncodeTime writes the number of seconds (int64) and nanoseconds (int32),
 // with millisecond resolution since January 1, 1970 UTC to the Writer as an
 // UInt64.
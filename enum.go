@@ -0,0 +1,99 @@
+package amino
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Enums
+
+// RegisterEnum makes the JSON encoding of values of the int32 type rt their
+// name in names, like proto3 enums, rather than their number.  Values not in
+// names are still written as numbers, and both forms are accepted when
+// decoding.  The binary encoding of values is unaffected, except that a
+// non-nil pointer to the zero value is written rather than omitted, so that
+// optional (pointer) enum fields keep their presence.
+func (cdc *Codec) RegisterEnum(rt reflect.Type, names map[int32]string) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	if rt.Kind() != reflect.Int32 {
+		panic(fmt.Sprintf("RegisterEnum expects an int32 type, got %v", rt))
+	}
+	// Copy names, so that the caller may modify it.
+	var copied = make(map[int32]string, len(names))
+	var values = make(map[string]int32, len(names))
+	for value, name := range names {
+		if name == "" {
+			panic(fmt.Sprintf("RegisterEnum expects non-empty names, but %v has none for %v", rt, value))
+		}
+		if other, ok := values[name]; ok {
+			panic(fmt.Sprintf("RegisterEnum expects unique names, but %v has %q for both %v and %v",
+				rt, name, other, value))
+		}
+		values[name] = value
+		copied[value] = name
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+	if info.IsAminoMarshaler {
+		panic(fmt.Sprintf("RegisterEnum cannot be used with amino marshaler %v", rt))
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		if info.EnumNames != nil {
+			panic(fmt.Sprintf("enum already registered for %v", rt))
+		}
+		info.EnumNames = copied
+		info.EnumValues = values
+	}()
+}
+
+// Returns whether info is an enum, see RegisterEnum().
+func isEnum(info *TypeInfo) bool {
+	return info.EnumNames != nil
+}
+
+// Returns true if frv is a non-nil pointer to an enum of type info, which is
+// written in binary even if it points to the zero value, so that it decodes
+// as set rather than nil.  Pointers to other types are omitted as usual.
+func isPresentEnum(info *TypeInfo, frv reflect.Value) bool {
+	return frv.Kind() == reflect.Ptr && !frv.IsNil() && isEnum(info)
+}
+
+// CONTRACT: isEnum(info)
+func encodeEnumJSON(w io.Writer, info *TypeInfo, rv reflect.Value) error {
+	if name, ok := info.EnumNames[int32(rv.Int())]; ok {
+		return invokeStdlibJSONMarshal(w, name)
+	}
+	return invokeStdlibJSONMarshal(w, rv.Interface())
+}
+
+// CONTRACT: isEnum(info)
+func decodeEnumJSON(bz []byte, info *TypeInfo, rv reflect.Value) error {
+	if len(bz) == 0 || bz[0] != '"' {
+		return invokeStdlibJSONUnmarshal(bz, rv, FieldOptions{})
+	}
+	var name string
+	if err := json.Unmarshal(bz, &name); err != nil {
+		return err
+	}
+	value, ok := info.EnumValues[name]
+	if !ok {
+		return errors.Errorf("unknown name %q for enum %v", name, info.Type)
+	}
+	rv.SetInt(int64(value))
+	return nil
+}
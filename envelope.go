@@ -0,0 +1,73 @@
+package amino
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Envelopes
+
+// The binary layout written by MarshalEnvelope.
+type envelope struct {
+	TypeURL string
+	Version uint32
+	Value   []byte
+}
+
+// MarshalEnvelope encodes the registered concrete value o along with its
+// TypeURL and the SchemaVersion it was registered with (see
+// ConcreteOptions), so that UnmarshalEnvelope can decode it without knowing
+// its type ahead of time.  This is like a top-level protobuf Any, plus a
+// version.  The value itself is encoded as by MarshalBinaryBare, without its
+// prefix bytes.
+func (cdc *Codec) MarshalEnvelope(o interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv, _, isNil := derefPointers(reflect.ValueOf(o))
+	if !rv.IsValid() || isNil {
+		return nil, errors.New("MarshalEnvelope cannot encode nil")
+	}
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	if !info.Registered {
+		return nil, errors.Errorf("MarshalEnvelope expects a registered concrete type, got %v", rv.Type())
+	}
+	vbz, err := cdc.MarshalBinaryBare(o)
+	if err != nil {
+		return nil, err
+	}
+	return cdc.MarshalBinaryBare(envelope{
+		TypeURL: nameToTypeURL(info.Name),
+		Version: info.SchemaVersion,
+		Value:   vbz[len(info.Prefix.Bytes()):],
+	})
+}
+
+// UnmarshalEnvelope decodes bz as written by MarshalEnvelope into a new
+// value of the registered concrete type named by its TypeURL, as a pointer if
+// the type was registered as such, and returns it with the schema version
+// it was encoded with.  It is up to the caller to handle values from older
+// or newer versions, as fields which no longer exist are skipped as usual.
+func (cdc *Codec) UnmarshalEnvelope(bz []byte) (o interface{}, version uint32, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	var env envelope
+	if err = cdc.UnmarshalBinaryBare(bz, &env); err != nil {
+		return nil, 0, errors.Wrap(err, "invalid envelope")
+	}
+	if !strings.HasPrefix(env.TypeURL, "/") {
+		return nil, 0, errors.Errorf("invalid envelope TypeURL %q", env.TypeURL)
+	}
+	o, err = cdc.UnmarshalBinaryBareWithTypeURL(env.TypeURL, env.Value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return o, env.Version, nil
+}
@@ -0,0 +1,112 @@
+package amino
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Field codecs
+
+// FieldCodec encodes a single struct field in a custom binary format, see
+// RegisterFieldCodec.
+type FieldCodec interface {
+	// EncodeField writes the encoding of the field value v to w.  Nothing
+	// need be written for empty values.
+	EncodeField(w io.Writer, v reflect.Value) error
+	// DecodeField decodes bz into the settable field value v, and returns
+	// the number of bytes read, which must be all of bz.
+	DecodeField(bz []byte, v reflect.Value) (n int, err error)
+}
+
+// RegisterFieldCodec makes the binary encoding of field number fieldNum of
+// the struct type rt use fc.  The field is written as a byte-length
+// prefixed field holding whatever fc writes, or omitted if fc writes
+// nothing (unless it is tagged `amino:"write_empty"`).  Decoding passes
+// those bytes to fc.  JSON is unaffected.
+func (cdc *Codec) RegisterFieldCodec(rt reflect.Type, fieldNum uint32, fc FieldCodec) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType {
+		panic(fmt.Sprintf("RegisterFieldCodec expects a struct, got %v", rt))
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		for i, field := range info.Fields {
+			if field.BinFieldNum != fieldNum {
+				continue
+			}
+			info.Fields[i].FieldCodec = fc
+			info.FixedWidth = false // fc decides the width.
+			return
+		}
+		panic(fmt.Sprintf("%v has no field # %v", info.Type, fieldNum))
+	}()
+}
+
+// Writes the field frv, including its field key, with its field codec.
+func (cdc *Codec) encodeFieldCodecField(buf *bytes.Buffer, field FieldInfo, frv reflect.Value) (err error) {
+	payload := new(bytes.Buffer)
+	err = field.FieldCodec.EncodeField(payload, frv)
+	if err != nil {
+		return errors.Wrapf(err, "field codec for %v failed", field.Name)
+	}
+	if payload.Len() == 0 && !field.WriteEmpty && !cdc.alwaysWriteEmpty {
+		return
+	}
+	err = encodeFieldNumberAndTyp3(buf, field.BinFieldNum, Typ3ByteLength)
+	if err != nil {
+		return
+	}
+	return EncodeByteSlice(buf, payload.Bytes())
+}
+
+// A FieldCodec of this package which decodes with the options of the
+// enclosing decoding, so that e.g. SetMaxDecodeDepth and
+// UnmarshalBinaryAllowing also apply to the field value.
+type fieldCodecWithOptions interface {
+	decodeFieldWithOptions(bz []byte, v reflect.Value, dopts decodeOptions) (n int, err error)
+}
+
+// A FieldCodec of this package which encodes with the codec which
+// registered it, and so is rebound to clones of that codec, see Clone().
+type codecBoundFieldCodec interface {
+	withCodec(cdc *Codec) FieldCodec
+}
+
+// Decodes the value written by encodeFieldCodecField, after the field key,
+// into frv.
+func decodeFieldCodecValue(bz []byte, field FieldInfo, frv reflect.Value, dopts decodeOptions) (n int, err error) {
+	payload, n, err := DecodeByteSlice(bz)
+	if err != nil {
+		return
+	}
+	var _n int
+	if fc, ok := field.FieldCodec.(fieldCodecWithOptions); ok {
+		_n, err = fc.decodeFieldWithOptions(payload, frv, dopts)
+	} else {
+		_n, err = field.FieldCodec.DecodeField(payload, frv)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "field codec for %v failed", field.Name)
+		return
+	}
+	if _n != len(payload) {
+		err = fmt.Errorf("field codec for %v read %v of %v bytes", field.Name, _n, len(payload))
+	}
+	return
+}
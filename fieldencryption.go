@@ -0,0 +1,89 @@
+package amino
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Field encryption
+
+// The first byte of an encrypted field's payload, before the ciphertext.
+const encryptedFieldFlag = byte(0x01)
+
+// RegisterFieldEncryption makes the binary encoding of field number fieldNum
+// of the struct type rt encrypted.  The field value is encoded as by
+// MarshalBinaryBare, encrypted with enc, and written as a byte-length
+// prefixed field holding a flag byte (0x01) followed by the ciphertext.
+// Decoding checks the flag, decrypts with dec, and decodes the plaintext as
+// by UnmarshalBinaryBare.  Empty values are omitted as usual, so only the
+// emptiness of the field is visible on the wire.  This is built on
+// RegisterFieldCodec, and likewise leaves JSON (and so the plaintext)
+// unaffected.
+func (cdc *Codec) RegisterFieldEncryption(rt reflect.Type, fieldNum uint32,
+	enc func([]byte) ([]byte, error), dec func([]byte) ([]byte, error)) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.RegisterFieldCodec(rt, fieldNum, encryptedFieldCodec{cdc: cdc, enc: enc, dec: dec})
+}
+
+type encryptedFieldCodec struct {
+	cdc *Codec
+	enc func([]byte) ([]byte, error)
+	dec func([]byte) ([]byte, error)
+}
+
+var _ FieldCodec = encryptedFieldCodec{}
+var _ fieldCodecWithOptions = encryptedFieldCodec{}
+var _ codecBoundFieldCodec = encryptedFieldCodec{}
+
+func (efc encryptedFieldCodec) withCodec(cdc *Codec) FieldCodec {
+	efc.cdc = cdc
+	return efc
+}
+
+func (efc encryptedFieldCodec) EncodeField(w io.Writer, v reflect.Value) (err error) {
+	if _, isDefault := isDefaultValue(v); isDefault {
+		return
+	}
+	plaintext, err := efc.cdc.MarshalBinaryBare(v.Interface())
+	if err != nil {
+		return
+	}
+	ciphertext, err := efc.enc(plaintext)
+	if err != nil {
+		return errors.Wrap(err, "encryption failed")
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(encryptedFieldFlag)
+	buf.Write(ciphertext)
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+func (efc encryptedFieldCodec) DecodeField(bz []byte, v reflect.Value) (n int, err error) {
+	return efc.decodeFieldWithOptions(bz, v, decodeOptions{})
+}
+
+func (efc encryptedFieldCodec) decodeFieldWithOptions(bz []byte, v reflect.Value,
+	dopts decodeOptions) (n int, err error) {
+	if len(bz) == 0 || bz[0] != encryptedFieldFlag {
+		err = fmt.Errorf("expected an encrypted field, got %X", bz)
+		return
+	}
+	plaintext, err := efc.dec(bz[1:])
+	if err != nil {
+		err = errors.Wrap(err, "decryption failed")
+		return
+	}
+	err = efc.cdc.unmarshalBinaryBare(plaintext, v.Addr().Interface(), dopts)
+	if err != nil {
+		return
+	}
+	return len(bz), nil
+}
@@ -0,0 +1,166 @@
+package amino
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Field records
+
+// FieldRecord holds the encoding of a single struct field, see
+// MarshalFieldRecords.
+type FieldRecord struct {
+	Number   uint32 // The binary field number.
+	JSONName string // The JSON field name.
+	Value    []byte // The field's encoding, including its field key.
+}
+
+// MarshalFieldRecords encodes each field of the struct o separately, e.g.
+// to store and index the fields individually.  Each record holds the bytes
+// which MarshalBinaryBare writes for the field (without prefix bytes), so
+// concatenating them in field number order yields the bare encoding of o.
+// As there, nothing is written for empty fields, which thus have no
+// record.  Use UnmarshalFieldRecords to decode any subset of the records.
+func (cdc *Codec) MarshalFieldRecords(o interface{}) (records []FieldRecord, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv, _, isNilPtr := derefPointers(reflect.ValueOf(o))
+	if isNilPtr {
+		return nil, errors.New("MarshalFieldRecords cannot marshal a nil pointer")
+	}
+	info, err := cdc.getFieldRecordsTypeInfo(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range info.Fields {
+		buf := new(bytes.Buffer)
+		err = cdc.encodeReflectBinaryStructField(buf, field, rv, FieldOptions{BinFieldNum: 1}, encodeOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding field %v", field.Name)
+		}
+		if buf.Len() == 0 {
+			continue
+		}
+		records = append(records, FieldRecord{
+			Number:   field.BinFieldNum,
+			JSONName: field.JSONName,
+			Value:    buf.Bytes(),
+		})
+	}
+	return records, nil
+}
+
+// UnmarshalFieldRecords decodes records, as returned by MarshalFieldRecords,
+// into the struct pointed to by ptr.  Fields without a record are left
+// zero, so a single record can be decoded on its own.  Each record must hold
+// only field keys of its Number, and at most one record may have a Number.
+func (cdc *Codec) UnmarshalFieldRecords(records []FieldRecord, ptr interface{}) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.Errorf("UnmarshalFieldRecords expects a pointer, got %T", ptr)
+	}
+	rv = rv.Elem()
+	info, err := cdc.getFieldRecordsTypeInfo(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	// Reassemble the bare encoding, in field number order.
+	sorted := append([]FieldRecord(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+	buf := new(bytes.Buffer)
+	for i, record := range sorted {
+		if i > 0 && record.Number == sorted[i-1].Number {
+			return fmt.Errorf("duplicate record for field # %v", record.Number)
+		}
+		if err = checkFieldRecord(info, record); err != nil {
+			return err
+		}
+		buf.Write(record.Value)
+	}
+	_, err = cdc.decodeReflectBinary(buf.Bytes(), info, rv, FieldOptions{BinFieldNum: 1}, true, decodeOptions{})
+	return err
+}
+
+// SetFields returns the numbers of the fields of the struct o which
+// MarshalBinaryBare writes, i.e. those which are not empty (or are tagged
+// `amino:"write_empty"`), in field number order.  Fields are only encoded
+// where needed to tell, as by SizeBinary.
+func (cdc *Codec) SetFields(o interface{}) (nums []uint32, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv, _, isNilPtr := derefPointers(reflect.ValueOf(o))
+	if isNilPtr {
+		return nil, errors.New("SetFields cannot inspect a nil pointer")
+	}
+	info, err := cdc.getFieldRecordsTypeInfo(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range info.Fields {
+		var n int
+		if n, err = cdc.sizeReflectBinaryStructField(field, rv, FieldOptions{BinFieldNum: 1}); err != nil {
+			return nil, errors.Wrapf(err, "sizing field %v", field.Name)
+		}
+		if n > 0 {
+			nums = append(nums, field.BinFieldNum)
+		}
+	}
+	return nums, nil
+}
+
+// Returns an error unless the value of record is a field numbered
+// record.Number of the struct info, or its entries if it's an unpacked list.
+func checkFieldRecord(info *TypeInfo, record FieldRecord) error {
+	bz := record.Value
+	if len(bz) == 0 {
+		return fmt.Errorf("empty record for field # %v", record.Number)
+	}
+	var repeated bool
+	for _, field := range info.Fields {
+		if field.BinFieldNum == record.Number {
+			repeated = field.UnpackedList
+		}
+	}
+	for i := 0; len(bz) > 0; i++ {
+		if i > 0 && !repeated {
+			return fmt.Errorf("record for field # %v holds it more than once", record.Number)
+		}
+		fnum, typ3, n, err := decodeFieldNumberAndTyp3(bz)
+		if err != nil {
+			return errors.Wrapf(err, "record for field # %v", record.Number)
+		}
+		if fnum != record.Number {
+			return fmt.Errorf("record for field # %v holds field # %v", record.Number, fnum)
+		}
+		bz = bz[n:]
+		if n, err = consumeAny(typ3, bz); err != nil {
+			return errors.Wrapf(err, "record for field # %v", record.Number)
+		}
+		bz = bz[n:]
+	}
+	return nil
+}
+
+func (cdc *Codec) getFieldRecordsTypeInfo(rt reflect.Type) (*TypeInfo, error) {
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		return nil, err
+	}
+	if rt.Kind() != reflect.Struct || info.Type == timeType || info.IsAminoMarshaler {
+		return nil, fmt.Errorf("field records are only supported for plain structs, got %v", rt)
+	}
+	return info, nil
+}
@@ -0,0 +1,127 @@
+package amino_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+func TestMarshalFieldRecords(t *testing.T) {
+	type Account struct {
+		Name    string `json:"name"`
+		Balance int64  `binary:"fixed64" json:"balance"`
+		Tags    []string
+		Created time.Time
+		Owner   *Account
+	}
+
+	cdc := amino.NewCodec()
+	acc := Account{
+		Name:    "alice",
+		Balance: 100,
+		Tags:    []string{"a", "b"},
+		Created: time.Unix(1500000000, 0).UTC(),
+		Owner:   &Account{Name: "bob", Created: time.Unix(0, 0).UTC()},
+	}
+	records, err := cdc.MarshalFieldRecords(&acc)
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	assert.Equal(t, amino.FieldRecord{Number: 1, JSONName: "name", Value: []byte{0x0A, 0x05, 'a', 'l', 'i', 'c', 'e'}},
+		records[0])
+	assert.Equal(t, uint32(2), records[1].Number)
+	assert.Equal(t, "balance", records[1].JSONName)
+	assert.Equal(t, "Tags", records[2].JSONName)
+
+	// The records make up the bare encoding.
+	bz, err := cdc.MarshalBinaryBare(acc)
+	require.NoError(t, err)
+	var joined []byte
+	for _, record := range records {
+		joined = append(joined, record.Value...)
+	}
+	assert.Equal(t, bz, joined)
+
+	// Each record decodes on its own.
+	var acc2 Account
+	require.NoError(t, cdc.UnmarshalFieldRecords(records[1:2], &acc2))
+	assert.Equal(t, Account{Balance: 100, Created: time.Unix(0, 0).UTC()}, acc2)
+	acc2 = Account{}
+	require.NoError(t, cdc.UnmarshalFieldRecords(records[4:], &acc2))
+	assert.Equal(t, "bob", acc2.Owner.Name)
+	assert.Equal(t, "", acc2.Name)
+
+	// All records, in any order, reassemble the struct.
+	shuffled := []amino.FieldRecord{records[3], records[0], records[4], records[2], records[1]}
+	acc2 = Account{}
+	require.NoError(t, cdc.UnmarshalFieldRecords(shuffled, &acc2))
+	assert.Equal(t, acc, acc2)
+
+	// Empty fields have no record.
+	records, err = cdc.MarshalFieldRecords(Account{Name: "carol"})
+	require.NoError(t, err)
+	for _, record := range records {
+		assert.NotEqual(t, uint32(2), record.Number)
+		assert.False(t, bytes.Equal(nil, record.Value))
+	}
+
+	err = cdc.UnmarshalFieldRecords([]amino.FieldRecord{records[0], records[0]}, &acc2)
+	assert.Error(t, err)
+
+	// Records must hold their own field, and only once.
+	mislabeled := records[0]
+	mislabeled.Number = 3
+	err = cdc.UnmarshalFieldRecords([]amino.FieldRecord{mislabeled}, &acc2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "record for field # 3 holds field # 1")
+	doubled := records[0]
+	doubled.Value = append(append([]byte(nil), doubled.Value...), doubled.Value...)
+	err = cdc.UnmarshalFieldRecords([]amino.FieldRecord{doubled}, &acc2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "more than once")
+	_, err = cdc.MarshalFieldRecords(time.Now())
+	assert.Error(t, err)
+}
+
+func TestSetFields(t *testing.T) {
+	type Profile struct {
+		Name     string
+		Age      int32
+		Verified bool
+		Tags     []string
+		Manager  *Profile
+		Score    int64 `amino:"write_empty"`
+	}
+
+	cdc := amino.NewCodec()
+	nums, err := cdc.SetFields(Profile{Name: "carol", Verified: true, Manager: &Profile{}})
+	require.NoError(t, err)
+	// Age and Tags are empty; the non-nil pointer and the write_empty field are written.
+	assert.Equal(t, []uint32{1, 3, 5, 6}, nums)
+
+	nums, err = cdc.SetFields(&Profile{Age: 30, Tags: []string{"x"}})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2, 4, 6}, nums)
+
+	// Non-nil pointers to enums are written even if zero, to keep presence.
+	type Flagged struct {
+		Ptr   *testEnum
+		Value testEnum
+	}
+	cdc.RegisterEnum(reflect.TypeOf(testEnum(0)), map[int32]string{0: "NONE"})
+	zero := testEnum(0)
+	nums, err = cdc.SetFields(Flagged{Ptr: &zero})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1}, nums)
+	bz, err := cdc.MarshalBinaryBare(Flagged{Ptr: &zero})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x08, 0x00}, bz)
+
+	_, err = cdc.SetFields("not a struct")
+	assert.Error(t, err)
+}
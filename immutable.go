@@ -0,0 +1,193 @@
+package amino
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sync"
+)
+
+//----------------------------------------
+// Immutable types
+
+// The number of encodings cached by default, see SetImmutableCacheSize.
+const defaultImmutableCacheSize = 1024
+
+// RegisterImmutable marks the type rt as immutable: MarshalBinaryBare then
+// caches the encodings of its values, and returns the cached bytes (a copy)
+// for values which are deeply equal to a previously encoded one.  Values
+// are looked up by a hash of their contents, which is usually cheaper than
+// encoding them.  The cache is bounded, see SetImmutableCacheSize.
+//
+// CONTRACT: Values of rt, including anything they point to, must not be
+// modified after they were encoded, or else stale bytes may be returned.
+func (cdc *Codec) RegisterImmutable(rt reflect.Type) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		info.Immutable = true
+	}()
+}
+
+// SetImmutableCacheSize sets the maximum number of encodings of immutable
+// types which are cached, see RegisterImmutable.  The least recently used
+// encodings are evicted first.  The default is 1024.
+func (cdc *Codec) SetImmutableCacheSize(size int) {
+	cdc.assertNotSealed()
+	if size <= 0 {
+		panic(fmt.Sprintf("invalid immutable cache size %v", size))
+	}
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.immutableCache = newEncodingCache(size)
+}
+
+// encodingCache is an LRU cache of the encodings of immutable values.
+type encodingCache struct {
+	mtx     sync.Mutex
+	size    int
+	lru     *list.List // Of *encodingCacheEntry, most recently used first.
+	entries map[uint64]*list.Element
+}
+
+type encodingCacheEntry struct {
+	hash  uint64
+	value interface{}
+	bz    []byte
+}
+
+func newEncodingCache(size int) *encodingCache {
+	return &encodingCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[uint64]*list.Element),
+	}
+}
+
+// Returns a copy of the cached encoding of rv, if any, and the hash of rv.
+func (ec *encodingCache) get(rv reflect.Value) (bz []byte, hash uint64, ok bool) {
+	hash = contentHash(rv)
+	ec.mtx.Lock()
+	defer ec.mtx.Unlock()
+
+	elem, ok := ec.entries[hash]
+	if !ok {
+		return nil, hash, false
+	}
+	entry := elem.Value.(*encodingCacheEntry)
+	if !reflect.DeepEqual(entry.value, rv.Interface()) {
+		return nil, hash, false // Hash collision.
+	}
+	ec.lru.MoveToFront(elem)
+	return append([]byte(nil), entry.bz...), hash, true
+}
+
+// Caches a copy of the encoding bz of rv, which has the given hash.
+func (ec *encodingCache) put(rv reflect.Value, hash uint64, bz []byte) {
+	ec.mtx.Lock()
+	defer ec.mtx.Unlock()
+
+	entry := &encodingCacheEntry{hash: hash, value: rv.Interface(), bz: append([]byte(nil), bz...)}
+	if elem, ok := ec.entries[hash]; ok {
+		elem.Value = entry
+		ec.lru.MoveToFront(elem)
+		return
+	}
+	ec.entries[hash] = ec.lru.PushFront(entry)
+	if ec.lru.Len() > ec.size {
+		oldest := ec.lru.Back()
+		ec.lru.Remove(oldest)
+		delete(ec.entries, oldest.Value.(*encodingCacheEntry).hash)
+	}
+}
+
+// Returns a hash of the contents of rv, such that deeply equal values have
+// the same hash.
+func contentHash(rv reflect.Value) uint64 {
+	h := fnv.New64a()
+	writeContentHash(h, rv)
+	return h.Sum64()
+}
+
+func writeContentHash(h hash.Hash64, rv reflect.Value) {
+	var buf [8]byte
+	writeUint := func(u uint64) {
+		binary.BigEndian.PutUint64(buf[:], u)
+		h.Write(buf[:]) // nolint: errcheck
+	}
+	switch rv.Kind() {
+	case reflect.Invalid:
+		writeUint(0)
+	case reflect.Bool:
+		if rv.Bool() {
+			writeUint(1)
+		} else {
+			writeUint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint(uint64(rv.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeUint(math.Float64bits(rv.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		writeUint(math.Float64bits(real(rv.Complex())))
+		writeUint(math.Float64bits(imag(rv.Complex())))
+	case reflect.String:
+		writeUint(uint64(rv.Len()))
+		h.Write([]byte(rv.String())) // nolint: errcheck
+	case reflect.Slice, reflect.Array:
+		writeUint(uint64(rv.Len()))
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			h.Write(rv.Bytes()) // nolint: errcheck
+			return
+		}
+		for i := 0; i < rv.Len(); i++ {
+			writeContentHash(h, rv.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			writeContentHash(h, rv.Field(i))
+		}
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			writeUint(0)
+			return
+		}
+		writeUint(1)
+		writeContentHash(h, rv.Elem())
+	case reflect.Map:
+		// Combine the entry hashes independently of the iteration order.
+		var sum uint64
+		for _, key := range rv.MapKeys() {
+			eh := fnv.New64a()
+			writeContentHash(eh, key)
+			writeContentHash(eh, rv.MapIndex(key))
+			sum += eh.Sum64()
+		}
+		writeUint(uint64(rv.Len()))
+		writeUint(sum)
+	default:
+		// Chan, Func, UnsafePointer: equal only if identical.
+		writeUint(uint64(rv.Pointer()))
+	}
+}
@@ -0,0 +1,139 @@
+package amino
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+//----------------------------------------
+// Indexed Any
+
+// The number of bytes of SchemaHash() written at the start of each message in
+// indexed Any mode, so that codecs with different registrations are told
+// apart but for a 2^-64 chance of collision.
+const indexedAnyTagLen = 8
+
+// The registered concrete types sorted by name, as indexed by
+// SetIndexedAnyMode(), and the schema hash of their names.
+type anyIndex struct {
+	infos []*TypeInfo
+	index map[*TypeInfo]uint64
+	hash  []byte
+}
+
+// SetIndexedAnyMode sets whether to write the concrete type of interface
+// values in binary as its index among all registered concrete types sorted
+// by name, instead of its prefix (and disambiguation) bytes.  Each message is
+// tagged once with the first 8 bytes of SchemaHash(), after any prefix bytes
+// of its own type, so that decoding with a codec with different
+// registrations (where the same index may be another type) fails with a
+// schema hash mismatch, rather than decoding the wrong type.  Only
+// MarshalBinaryBare and the functions built on it tag messages, not e.g.
+// MarshalBinaryDelta or MarshalColumnar.
+// Both codecs must use this mode, as the encoding is otherwise incompatible.
+func (cdc *Codec) SetIndexedAnyMode(indexed bool) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	cdc.indexedAny = indexed
+}
+
+// SchemaHash returns a SHA256 hash of the names of all registered concrete
+// types, which is equal for two codecs iff they index concrete types the
+// same way, see SetIndexedAnyMode().
+func (cdc *Codec) SchemaHash() []byte {
+	return append([]byte(nil), cdc.getAnyIndexWlock().hash...)
+}
+
+// Returns the index of registered concrete types, which is reset by
+// registering another one.
+func (cdc *Codec) getAnyIndexWlock() *anyIndex {
+	cdc.mtx.RLock()
+	idx := cdc.anyIndex
+	cdc.mtx.RUnlock()
+	if idx != nil {
+		return idx
+	}
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	if cdc.anyIndex != nil {
+		return cdc.anyIndex
+	}
+	idx = &anyIndex{
+		infos: append([]*TypeInfo(nil), cdc.concreteInfos...),
+		index: make(map[*TypeInfo]uint64, len(cdc.concreteInfos)),
+	}
+	sort.Slice(idx.infos, func(i, j int) bool { return idx.infos[i].Name < idx.infos[j].Name })
+	h := sha256.New()
+	for i, cinfo := range idx.infos {
+		idx.index[cinfo] = uint64(i)
+		fmt.Fprintf(h, "/%s\n", cinfo.Name)
+	}
+	idx.hash = h.Sum(nil)
+	cdc.anyIndex = idx
+	return idx
+}
+
+// Writes the schema hash tag of a message, see SetIndexedAnyMode().
+func (cdc *Codec) writeIndexedAnyTag(w io.Writer) (err error) {
+	_, err = w.Write(cdc.getAnyIndexWlock().hash[:indexedAnyTagLen])
+	return
+}
+
+// Reads the schema hash tag written by writeIndexedAnyTag(), which must be
+// that of cdc.
+func (cdc *Codec) readIndexedAnyTag(bz []byte) (n int, err error) {
+	idx := cdc.getAnyIndexWlock()
+	if len(bz) < indexedAnyTagLen {
+		err = fmt.Errorf("expected %v schema hash bytes of a message with indexed Anys, got %X",
+			indexedAnyTagLen, bz)
+		return
+	}
+	if tag := bz[:indexedAnyTagLen]; string(tag) != string(idx.hash[:indexedAnyTagLen]) {
+		err = fmt.Errorf("schema hash mismatch: message was written with schema %X..., "+
+			"but the registrations of this codec have schema %X..., see SchemaHash",
+			tag, idx.hash[:indexedAnyTagLen])
+		return
+	}
+	return indexedAnyTagLen, nil
+}
+
+// Writes the index of cinfo, see SetIndexedAnyMode().
+func (cdc *Codec) writeIndexedAnyPrefix(w io.Writer, cinfo *TypeInfo) (err error) {
+	return EncodeUvarint(w, cdc.getAnyIndexWlock().index[cinfo])
+}
+
+// Returns the number of bytes written by writeIndexedAnyPrefix().
+func (cdc *Codec) indexedAnyPrefixSize(cinfo *TypeInfo) int {
+	return UvarintSize(cdc.getAnyIndexWlock().index[cinfo])
+}
+
+// Reads the index written by writeIndexedAnyPrefix(), and returns the
+// TypeInfo of the concrete type, which must implement iinfo.
+func (cdc *Codec) readIndexedAnyPrefix(bz []byte, iinfo *TypeInfo) (cinfo *TypeInfo, n int, err error) {
+	idx := cdc.getAnyIndexWlock()
+	i, _n := binary.Uvarint(bz)
+	if _n <= 0 {
+		err = fmt.Errorf("invalid index of an indexed Any: %X", bz)
+		return
+	}
+	if err = cdc.checkCanonical(_n, UvarintSize(i)); err != nil {
+		return
+	}
+	if i >= uint64(len(idx.infos)) {
+		err = fmt.Errorf("index %v of an indexed Any is out of range, only %v types are registered",
+			i, len(idx.infos))
+		return
+	}
+	cinfo = idx.infos[i]
+	if !cinfo.PtrToType.Implements(iinfo.Type) {
+		err = fmt.Errorf("indexed Any type %v does not implement %v", cinfo.Type, iinfo.Type)
+		return
+	}
+	return cinfo, _n, nil
+}
@@ -2,9 +2,13 @@ package amino
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -15,12 +19,13 @@ import (
 // cdc.decodeReflectJSON
 
 // CONTRACT: rv.CanAddr() is true.
-func (cdc *Codec) decodeReflectJSON(bz []byte, info *TypeInfo, rv reflect.Value, fopts FieldOptions) (err error) {
+func (cdc *Codec) decodeReflectJSON(bz []byte, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, dopts decodeOptions) (err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if info.Type.Kind() == reflect.Interface && rv.Kind() == reflect.Ptr {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	if printLog {
 		spew.Printf("(D) decodeReflectJSON(bz: %s, info: %v, rv: %#v (%v), fopts: %v)\n",
@@ -33,6 +38,10 @@ func (cdc *Codec) decodeReflectJSON(bz []byte, info *TypeInfo, rv reflect.Value,
 	// Special case for null for either interface, pointer, slice
 	// NOTE: This doesn't match the binary implementation completely.
 	if nullBytes(bz) {
+		if info.JSONNewNull != nil && rv.Type() == info.Type {
+			rv.Set(info.JSONNewNull())
+			return
+		}
 		rv.Set(reflect.Zero(rv.Type()))
 		return
 	}
@@ -41,12 +50,36 @@ func (cdc *Codec) decodeReflectJSON(bz []byte, info *TypeInfo, rv reflect.Value,
 	// This works for pointer-pointers.
 	for rv.Kind() == reflect.Ptr {
 		if rv.IsNil() {
-			newPtr := reflect.New(rv.Type().Elem())
+			newPtr := cdc.newValue(rv.Type().Elem())
 			rv.Set(newPtr)
 		}
 		rv = rv.Elem()
 	}
 
+	// Special case: time.Time as milliseconds, see unixMillis().
+	if rv.Type() == timeType && fopts.UnixMillis {
+		var ms int64
+		ms, err = strconv.ParseInt(string(bz), 10, 64)
+		if err != nil {
+			err = errors.Errorf("amino:JSON unixmillis time must be an integer, but got %s", bz)
+			return
+		}
+		rv.Set(reflect.ValueOf(timeFromUnixMillis(ms)))
+		return
+	}
+	// Special case: time.Time as a date, see dateOnlyDays().
+	if rv.Type() == timeType && fopts.DateOnly {
+		var t time.Time
+		if len(bz) >= 2 && bz[0] == '"' && bz[len(bz)-1] == '"' {
+			t, err = time.Parse(dateOnlyLayout, string(bz[1:len(bz)-1]))
+		}
+		if len(bz) < 2 || err != nil {
+			err = errors.Errorf("amino:JSON dateonly time must be a YYYY-MM-DD string, but got %s", bz)
+			return
+		}
+		rv.Set(reflect.ValueOf(t))
+		return
+	}
 	// Special case:
 	if rv.Type() == timeType {
 		// Amino time strips the timezone, so must end with Z.
@@ -61,6 +94,12 @@ func (cdc *Codec) decodeReflectJSON(bz []byte, info *TypeInfo, rv reflect.Value,
 		}
 	}
 
+	// Special case: raw JSON, see `amino:"json_raw"`.
+	if fopts.JSONRaw && rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+		rv.SetBytes(append([]byte(nil), bz...))
+		return
+	}
+
 	// Handle override if a pointer to rv implements json.Unmarshaler.
 	if rv.Addr().Type().Implements(jsonUnmarshalerType) {
 		err = rv.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(bz)
@@ -76,7 +115,7 @@ func (cdc *Codec) decodeReflectJSON(bz []byte, info *TypeInfo, rv reflect.Value,
 		if err != nil {
 			return
 		}
-		err = cdc.decodeReflectJSON(bz, rinfo, rrv, fopts)
+		err = cdc.decodeReflectJSON(bz, rinfo, rrv, fopts, dopts)
 		if err != nil {
 			return
 		}
@@ -90,25 +129,35 @@ func (cdc *Codec) decodeReflectJSON(bz []byte, info *TypeInfo, rv reflect.Value,
 		return
 	}
 
+	// Special case: enums by name, see RegisterEnum().
+	if isEnum(info) {
+		return decodeEnumJSON(bz, info, rv)
+	}
+
+	// Count nested values, see SetMaxDecodeDepth().
+	if dopts, err = cdc.enterComplex(info.Type.Kind(), dopts); err != nil {
+		return
+	}
+
 	switch ikind := info.Type.Kind(); ikind {
 
 	//----------------------------------------
 	// Complex
 
 	case reflect.Interface:
-		err = cdc.decodeReflectJSONInterface(bz, info, rv, fopts)
+		err = cdc.decodeReflectJSONInterface(bz, info, rv, fopts, dopts)
 
 	case reflect.Array:
-		err = cdc.decodeReflectJSONArray(bz, info, rv, fopts)
+		err = cdc.decodeReflectJSONArray(bz, info, rv, fopts, dopts)
 
 	case reflect.Slice:
-		err = cdc.decodeReflectJSONSlice(bz, info, rv, fopts)
+		err = cdc.decodeReflectJSONSlice(bz, info, rv, fopts, dopts)
 
 	case reflect.Struct:
-		err = cdc.decodeReflectJSONStruct(bz, info, rv, fopts)
+		err = cdc.decodeReflectJSONStruct(bz, info, rv, fopts, dopts)
 
 	case reflect.Map:
-		err = cdc.decodeReflectJSONMap(bz, info, rv, fopts)
+		err = cdc.decodeReflectJSONMap(bz, info, rv, fopts, dopts)
 
 	//----------------------------------------
 	// Signed, Unsigned
@@ -154,7 +203,7 @@ func (cdc *Codec) decodeReflectJSON(bz []byte, info *TypeInfo, rv reflect.Value,
 
 func invokeStdlibJSONUnmarshal(bz []byte, rv reflect.Value, fopts FieldOptions) error {
 	if !rv.CanAddr() && rv.Kind() != reflect.Ptr {
-		panic("rv not addressable nor pointer")
+		panic(internalError("rv not addressable nor pointer"))
 	}
 
 	rrv := rv
@@ -171,9 +220,9 @@ func invokeStdlibJSONUnmarshal(bz []byte, rv reflect.Value, fopts FieldOptions)
 
 // CONTRACT: rv.CanAddr() is true.
 func (cdc *Codec) decodeReflectJSONInterface(bz []byte, iinfo *TypeInfo, rv reflect.Value,
-	fopts FieldOptions) (err error) {
+	fopts FieldOptions, dopts decodeOptions) (err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectJSONInterface")
@@ -197,29 +246,44 @@ func (cdc *Codec) decodeReflectJSONInterface(bz []byte, iinfo *TypeInfo, rv refl
 	}
 
 	// Consume type wrapper info.
-	name, bz, err := decodeInterfaceJSON(bz)
-	if err != nil {
-		return
-	}
+	name, data, err := cdc.decodeInterfaceJSON(bz)
 	// XXX: Check name against interface to make sure that it actually
 	// matches, and return an error if it doesn't.
 
 	// NOTE: Unlike decodeReflectBinaryInterface, we already dealt with nil in decodeReflectJSON.
-	// NOTE: We also "consumed" the interface wrapper by replacing `bz` above.
+	// NOTE: We also "consumed" the interface wrapper by replacing `bz` below.
 
 	// Get concrete type info.
 	// NOTE: Unlike decodeReflectBinaryInterface, uses the full name string.
 	var cinfo *TypeInfo
-	cinfo, err = cdc.getTypeInfoFromNameRlock(name)
+	if err == nil {
+		cinfo, err = cdc.resolveConcreteTypeInfo(iinfo, fopts.JSONName, name)
+	}
+	if err != nil && iinfo.DefaultConcrete != nil {
+		// Fall back to the default, see RegisterInterfaceDefault().
+		cinfo, data, err = iinfo.DefaultConcrete, cdc.defaultInterfaceJSONValue(bz), nil
+	}
+	if err != nil && cinfo == nil && name != "" && cdc.skipsUnknownIface(dopts) {
+		// Leave rv nil, see SetSkipUnknownInterfaceValues().
+		dopts.addSkipped(UnknownInterfaceValue{Interface: iinfo.Type, Name: name})
+		return nil
+	}
 	if err != nil {
 		return
 	}
+	if err = checkOneof(iinfo, cinfo); err != nil {
+		return
+	}
+	if dopts, err = cdc.enterAny(dopts); err != nil {
+		return
+	}
+	bz = data
 
 	// Construct the concrete type.
-	var crv, irvSet = constructConcreteType(cinfo)
+	var crv, irvSet = cdc.constructConcreteType(cinfo)
 
 	// Decode into the concrete type.
-	err = cdc.decodeReflectJSON(bz, cinfo, crv, fopts)
+	err = cdc.decodeReflectJSON(bz, cinfo, crv, fopts, dopts)
 	if err != nil {
 		rv.Set(irvSet) // Helps with debugging
 		return
@@ -233,9 +297,10 @@ func (cdc *Codec) decodeReflectJSONInterface(bz []byte, iinfo *TypeInfo, rv refl
 }
 
 // CONTRACT: rv.CanAddr() is true.
-func (cdc *Codec) decodeReflectJSONArray(bz []byte, info *TypeInfo, rv reflect.Value, fopts FieldOptions) (err error) {
+func (cdc *Codec) decodeReflectJSONArray(bz []byte, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, dopts decodeOptions) (err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectJSONArray")
@@ -250,7 +315,11 @@ func (cdc *Codec) decodeReflectJSONArray(bz []byte, info *TypeInfo, rv reflect.V
 
 	case reflect.Uint8: // Special case: byte array
 		var buf []byte
-		err = json.Unmarshal(bz, &buf)
+		if fopts.JSONHex {
+			buf, err = decodeJSONHex(bz, fopts)
+		} else {
+			err = json.Unmarshal(bz, &buf)
+		}
 		if err != nil {
 			return
 		}
@@ -282,7 +351,7 @@ func (cdc *Codec) decodeReflectJSONArray(bz []byte, info *TypeInfo, rv reflect.V
 		for i := 0; i < length; i++ {
 			erv := rv.Index(i)
 			ebz := rawSlice[i]
-			err = cdc.decodeReflectJSON(ebz, einfo, erv, fopts)
+			err = cdc.decodeReflectJSON(ebz, einfo, erv, fopts, dopts)
 			if err != nil {
 				return
 			}
@@ -292,9 +361,10 @@ func (cdc *Codec) decodeReflectJSONArray(bz []byte, info *TypeInfo, rv reflect.V
 }
 
 // CONTRACT: rv.CanAddr() is true.
-func (cdc *Codec) decodeReflectJSONSlice(bz []byte, info *TypeInfo, rv reflect.Value, fopts FieldOptions) (err error) {
+func (cdc *Codec) decodeReflectJSONSlice(bz []byte, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, dopts decodeOptions) (err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectJSONSlice")
@@ -308,9 +378,18 @@ func (cdc *Codec) decodeReflectJSONSlice(bz []byte, info *TypeInfo, rv reflect.V
 	switch ert.Kind() {
 
 	case reflect.Uint8: // Special case: byte slice
-		err = json.Unmarshal(bz, rv.Addr().Interface())
-		if err != nil {
-			return
+		if fopts.JSONHex {
+			var buf []byte
+			buf, err = decodeJSONHex(bz, fopts)
+			if err != nil {
+				return
+			}
+			rv.Set(reflect.ValueOf(buf).Convert(info.Type))
+		} else {
+			err = json.Unmarshal(bz, rv.Addr().Interface())
+			if err != nil {
+				return
+			}
 		}
 		if rv.Len() == 0 {
 			// Special case when length is 0.
@@ -349,7 +428,7 @@ func (cdc *Codec) decodeReflectJSONSlice(bz []byte, info *TypeInfo, rv reflect.V
 		for i := 0; i < length; i++ {
 			erv := srv.Index(i)
 			ebz := rawSlice[i]
-			err = cdc.decodeReflectJSON(ebz, einfo, erv, fopts)
+			err = cdc.decodeReflectJSON(ebz, einfo, erv, fopts, dopts)
 			if err != nil {
 				return
 			}
@@ -362,9 +441,10 @@ func (cdc *Codec) decodeReflectJSONSlice(bz []byte, info *TypeInfo, rv reflect.V
 }
 
 // CONTRACT: rv.CanAddr() is true.
-func (cdc *Codec) decodeReflectJSONStruct(bz []byte, info *TypeInfo, rv reflect.Value, fopts FieldOptions) (err error) {
+func (cdc *Codec) decodeReflectJSONStruct(bz []byte, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, dopts decodeOptions) (err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectJSONStruct")
@@ -381,15 +461,21 @@ func (cdc *Codec) decodeReflectJSONStruct(bz []byte, info *TypeInfo, rv reflect.
 	if err != nil {
 		return
 	}
+	// Rename legacy keys, see UnmarshalJSONWithRenames.
+	if err = renameJSONKeys(rawMap, dopts.Renames); err != nil {
+		return
+	}
 
 	for _, field := range info.Fields {
 
 		// Get field rv and info.
-		var frv = rv.Field(field.Index)
+		var frv = field.valueOf(rv)
 		var finfo *TypeInfo
-		finfo, err = cdc.getTypeInfoWlock(field.Type)
-		if err != nil {
-			return
+		if field.DynamicResolver == nil && !field.isUnionPayload() {
+			finfo, err = cdc.getTypeInfoWlock(field.Type)
+			if err != nil {
+				return
+			}
 		}
 
 		// Get value from rawMap.
@@ -413,7 +499,21 @@ func (cdc *Codec) decodeReflectJSONStruct(bz []byte, info *TypeInfo, rv reflect.
 		}
 
 		// Decode into field rv.
-		err = cdc.decodeReflectJSON(valueBytes, finfo, frv, fopts)
+		if field.isUnionPayload() {
+			if string(valueBytes) == "null" {
+				frv.Set(reflect.Zero(frv.Type()))
+				continue
+			}
+			err = cdc.decodeUnionPayloadJSON(valueBytes, field, frv, rawMap, dopts)
+		} else if field.DynamicResolver != nil {
+			if string(valueBytes) == "null" {
+				frv.Set(reflect.Zero(frv.Type()))
+				continue
+			}
+			err = cdc.decodeDynamicFieldJSON(valueBytes, field, frv, dopts)
+		} else {
+			err = cdc.decodeReflectJSON(valueBytes, finfo, frv, field.FieldOptions, dopts)
+		}
 		if err != nil {
 			return
 		}
@@ -422,10 +522,53 @@ func (cdc *Codec) decodeReflectJSONStruct(bz []byte, info *TypeInfo, rv reflect.
 	return nil
 }
 
+// Returns an error if a key of renames is renamed to a key which is itself
+// renamed, as the result would depend on the order of renaming.
+func checkJSONRenames(renames map[string]string) error {
+	for old, name := range renames {
+		if _, ok := renames[name]; ok {
+			return fmt.Errorf("JSON key %q is renamed to %q, which is itself renamed", old, name)
+		}
+	}
+	return nil
+}
+
+// Renames the legacy keys of rawMap in one pass, see UnmarshalJSONWithRenames.
+// CONTRACT: renames passed checkJSONRenames().
+func renameJSONKeys(rawMap map[string]json.RawMessage, renames map[string]string) error {
+	if len(renames) == 0 {
+		return nil
+	}
+	var olds = make([]string, 0, len(renames))
+	for old := range renames {
+		if _, ok := rawMap[old]; ok {
+			olds = append(olds, old)
+		}
+	}
+	sort.Strings(olds)
+	var renamed = make(map[string]string, len(olds)) // name -> old
+	for _, old := range olds {
+		name := renames[old]
+		if _, ok := rawMap[name]; ok {
+			return fmt.Errorf("JSON keys %q and %q (renamed to %q) are both set", name, old, name)
+		}
+		if other, ok := renamed[name]; ok {
+			return fmt.Errorf("JSON keys %q and %q are both renamed to %q", other, old, name)
+		}
+		renamed[name] = old
+	}
+	for name, old := range renamed {
+		rawMap[name] = rawMap[old]
+		delete(rawMap, old)
+	}
+	return nil
+}
+
 // CONTRACT: rv.CanAddr() is true.
-func (cdc *Codec) decodeReflectJSONMap(bz []byte, info *TypeInfo, rv reflect.Value, fopts FieldOptions) (err error) {
+func (cdc *Codec) decodeReflectJSONMap(bz []byte, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, dopts decodeOptions) (err error) {
 	if !rv.CanAddr() {
-		panic("rv not addressable")
+		panic(internalError("rv not addressable"))
 	}
 	if printLog {
 		fmt.Println("(d) decodeReflectJSONMap")
@@ -444,8 +587,8 @@ func (cdc *Codec) decodeReflectJSONMap(bz []byte, info *TypeInfo, rv reflect.Val
 	}
 
 	var krt = rv.Type().Key()
-	if krt.Kind() != reflect.String {
-		err = fmt.Errorf("decodeReflectJSONMap: key type must be string") // TODO also support []byte and maybe others
+	if !isJSONMapKeyKind(krt.Kind()) {
+		err = fmt.Errorf("decodeReflectJSONMap: key type must be a string, integer or bool")
 		return
 	}
 	var vinfo *TypeInfo
@@ -461,14 +604,17 @@ func (cdc *Codec) decodeReflectJSONMap(bz []byte, info *TypeInfo, rv reflect.Val
 		vrv := reflect.New(mrv.Type().Elem()).Elem()
 
 		// Decode valueBytes into vrv.
-		err = cdc.decodeReflectJSON(valueBytes, vinfo, vrv, fopts)
+		err = cdc.decodeReflectJSON(valueBytes, vinfo, vrv, fopts, dopts)
 		if err != nil {
 			return
 		}
 
 		// And set.
-		krv := reflect.New(reflect.TypeOf("")).Elem()
-		krv.SetString(key)
+		var krv reflect.Value
+		krv, err = decodeJSONMapKey(key, krt)
+		if err != nil {
+			return
+		}
 		mrv.SetMapIndex(krv, vrv)
 	}
 	rv.Set(mrv)
@@ -479,9 +625,30 @@ func (cdc *Codec) decodeReflectJSONMap(bz []byte, info *TypeInfo, rv reflect.Val
 //----------------------------------------
 // Misc.
 
-type disfixWrapper struct {
-	Name string          `json:"type"`
-	Data json.RawMessage `json:"value"`
+// Parses a map key written by jsonMapKeyString.
+// CONTRACT: isJSONMapKeyKind(krt.Kind())
+func decodeJSONMapKey(key string, krt reflect.Type) (krv reflect.Value, err error) {
+	krv = reflect.New(krt).Elem()
+	switch krt.Kind() {
+	case reflect.String:
+		krv.SetString(key)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(key)
+		krv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(key, 10, krt.Bits())
+		krv.SetInt(i)
+	default:
+		var u uint64
+		u, err = strconv.ParseUint(key, 10, krt.Bits())
+		krv.SetUint(u)
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid map key %q for %v: %v", key, krt, err)
+	}
+	return
 }
 
 // decodeInterfaceJSON helps unravel the type name and
@@ -490,30 +657,71 @@ type disfixWrapper struct {
 //    "type": "<canonical concrete type name>",
 //    "value":  {}
 // }
-func decodeInterfaceJSON(bz []byte) (name string, data []byte, err error) {
-	dfw := new(disfixWrapper)
-	err = json.Unmarshal(bz, dfw)
+// where "type" is the key set with SetAnyTypeKey.
+func (cdc *Codec) decodeInterfaceJSON(bz []byte) (name string, data []byte, err error) {
+	var dfw map[string]json.RawMessage
+	err = json.Unmarshal(bz, &dfw)
 	if err != nil {
 		err = fmt.Errorf("cannot parse disfix JSON wrapper: %v", err)
 		return
 	}
 
 	// Get name.
-	if dfw.Name == "" {
-		err = errors.New("JSON encoding of interfaces require non-empty type field")
+	if nbz, ok := dfw[cdc.anyTypeKey]; ok && !nullBytes(nbz) {
+		err = json.Unmarshal(nbz, &name)
+		if err != nil {
+			err = fmt.Errorf("cannot parse disfix JSON wrapper %v field: %v", cdc.anyTypeKey, err)
+			return
+		}
+	}
+	if name == "" {
+		err = fmt.Errorf("JSON encoding of interfaces require non-empty %v field", cdc.anyTypeKey)
 		return
 	}
-	name = dfw.Name
 
 	// Get data.
-	if len(dfw.Data) == 0 {
+	data = dfw["value"]
+	if len(data) == 0 {
 		err = errors.New("interface JSON wrapper should have non-empty value field")
 		return
 	}
-	data = dfw.Data
 	return
 }
 
+// Returns the value to decode as the default concrete type of an interface,
+// i.e. the "value" of a wrapper without a (valid) type, or else bz itself.
+func (cdc *Codec) defaultInterfaceJSONValue(bz []byte) []byte {
+	var dfw map[string]json.RawMessage
+	if err := json.Unmarshal(bz, &dfw); err != nil {
+		return bz
+	}
+	value, ok := dfw["value"]
+	if _, hasType := dfw[cdc.anyTypeKey]; !ok || len(value) == 0 || len(dfw) > 2 || (len(dfw) == 2 && !hasType) {
+		return bz
+	}
+	return value
+}
+
 func nullBytes(b []byte) bool {
 	return bytes.Equal(b, []byte(`null`))
 }
+
+// Decodes the JSON hex string bz of a field tagged `amino:"json_hex"`,
+// in either case.
+func decodeJSONHex(bz []byte, fopts FieldOptions) (buf []byte, err error) {
+	var str string
+	if err = json.Unmarshal(bz, &str); err != nil {
+		return nil, errors.Errorf("amino:JSON json_hex field %v must be a hex string, but got %s",
+			fopts.JSONName, bz)
+	}
+	if len(str)%2 != 0 {
+		return nil, errors.Errorf("amino:JSON json_hex field %v has odd length %v: %q",
+			fopts.JSONName, len(str), str)
+	}
+	buf, err = hex.DecodeString(str)
+	if err != nil {
+		return nil, errors.Errorf("amino:JSON json_hex field %v is not valid hex: %q",
+			fopts.JSONName, str)
+	}
+	return buf, nil
+}
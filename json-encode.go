@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
@@ -22,7 +24,7 @@ import (
 // CONTRACT: rv is valid.
 func (cdc *Codec) encodeReflectJSON(w io.Writer, info *TypeInfo, rv reflect.Value, fopts FieldOptions) (err error) {
 	if !rv.IsValid() {
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	if printLog {
 		spew.Printf("(E) encodeReflectJSON(info: %v, rv: %#v (%v), fopts: %v)\n",
@@ -41,7 +43,23 @@ func (cdc *Codec) encodeReflectJSON(w io.Writer, info *TypeInfo, rv reflect.Valu
 		err = writeStr(w, `null`)
 		return
 	}
+	// Special case: registered null values, see RegisterJSONNull().
+	if info.JSONIsNull != nil && info.JSONIsNull(rv) {
+		err = writeStr(w, `null`)
+		return
+	}
 
+	// Special case: time.Time as milliseconds, see unixMillis().
+	if rv.Type() == timeType && fopts.UnixMillis {
+		_, err = fmt.Fprintf(w, `%d`, unixMillis(rv.Interface().(time.Time)))
+		return
+	}
+	// Special case: time.Time as a date, see dateOnlyDays().
+	if rv.Type() == timeType && fopts.DateOnly {
+		t := timeFromDateOnlyDays(dateOnlyDays(rv.Interface().(time.Time)))
+		_, err = fmt.Fprintf(w, `"%s"`, t.Format(dateOnlyLayout))
+		return
+	}
 	// Special case:
 	if rv.Type() == timeType {
 		// Amino time strips the timezone.
@@ -49,6 +67,13 @@ func (cdc *Codec) encodeReflectJSON(w io.Writer, info *TypeInfo, rv reflect.Valu
 		ct := rv.Interface().(time.Time).Round(0).UTC()
 		rv = reflect.ValueOf(ct)
 	}
+	// Special case: raw JSON, see `amino:"json_raw"`.
+	// NOTE: This must be done before json.Marshaler override below, as
+	// json.RawMessage doesn't validate itself.
+	if fopts.JSONRaw && rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+		err = writeJSONRaw(w, rv.Bytes())
+		return
+	}
 	// Handle override if rv implements json.Marshaler.
 	if rv.CanAddr() { // Try pointer first.
 		if rv.Addr().Type().Implements(jsonMarshalerType) {
@@ -80,6 +105,11 @@ func (cdc *Codec) encodeReflectJSON(w io.Writer, info *TypeInfo, rv reflect.Valu
 		return
 	}
 
+	// Special case: enums by name, see RegisterEnum().
+	if isEnum(info) {
+		return encodeEnumJSON(w, info, rv)
+	}
+
 	switch info.Type.Kind() {
 
 	//----------------------------------------
@@ -150,7 +180,7 @@ func (cdc *Codec) encodeReflectJSONInterface(w io.Writer, iinfo *TypeInfo, rv re
 	var crv, isPtr, isNilPtr = derefPointers(rv.Elem())
 	if isPtr && crv.Kind() == reflect.Interface {
 		// See "MARKER: No interface-pointers" in codec.go
-		panic("should not happen")
+		panic(internalError("should not happen"))
 	}
 	if isNilPtr {
 		panic(fmt.Sprintf("Illegal nil-pointer of type %v for registered interface %v. "+
@@ -165,13 +195,19 @@ func (cdc *Codec) encodeReflectJSONInterface(w io.Writer, iinfo *TypeInfo, rv re
 		return
 	}
 	if !cinfo.Registered {
-		err = errors.Errorf("cannot encode unregistered concrete type %v", crt)
+		var ok bool
+		if crv, cinfo, ok = cdc.toStdError(iinfo, rv); !ok {
+			err = ErrUnregisteredConcrete{crt}
+			return
+		}
+	}
+	if err = checkOneof(iinfo, cinfo); err != nil {
 		return
 	}
 
 	// Write interface wrapper.
 	// Part 1:
-	err = writeStr(w, _fmt(`{"type":"%s","value":`, cinfo.Name))
+	err = cdc.writeAnyPrefixJSON(w, cinfo.Name)
 	if err != nil {
 		return
 	}
@@ -223,6 +259,11 @@ func (cdc *Codec) encodeReflectJSONList(w io.Writer, info *TypeInfo, rv reflect.
 			bz = make([]byte, length)
 			reflect.Copy(reflect.ValueOf(bz), rv) // XXX: looks expensive!
 		}
+		if fopts.JSONHex {
+			// Write bytes in lowercase hex instead, see `amino:"json_hex"`.
+			_, err = fmt.Fprintf(w, `"%x"`, bz)
+			return
+		}
 		var jsonBytes []byte
 		jsonBytes, err = json.Marshal(bz) // base64 encode
 		if err != nil {
@@ -295,16 +336,26 @@ func (cdc *Codec) encodeReflectJSONStruct(w io.Writer, info *TypeInfo, rv reflec
 	var writeComma = false
 	for _, field := range info.Fields {
 		// Get dereferenced field value and info.
-		var frv, _, isNil = derefPointers(rv.Field(field.Index))
+		var frv, _, isNil = derefPointers(field.valueOf(rv))
 		var finfo *TypeInfo
-		finfo, err = cdc.getTypeInfoWlock(field.Type)
-		if err != nil {
-			return
+		if field.DynamicResolver == nil && field.union == nil {
+			finfo, err = cdc.getTypeInfoWlock(field.Type)
+			if err != nil {
+				return
+			}
 		}
-		// If frv is empty and omitempty, skip it.
+		// If frv is empty and omitempty, skip it, unless SetJSONWriteEmpty.
 		// NOTE: Unlike Amino:binary, we don't skip null fields unless "omitempty".
-		if field.JSONOmitEmpty && isEmpty(frv, field.ZeroValue) {
-			continue
+		if field.JSONOmitEmpty && !cdc.jsonWriteEmpty {
+			var empty bool
+			if finfo != nil && finfo.OmitEmpty != nil && !isNil {
+				empty = finfo.OmitEmpty(frv)
+			} else {
+				empty = isEmpty(frv, field.ZeroValue)
+			}
+			if empty {
+				continue
+			}
 		}
 		// Now we know we're going to write something.
 		// Add a comma if we need to.
@@ -326,8 +377,12 @@ func (cdc *Codec) encodeReflectJSONStruct(w io.Writer, info *TypeInfo, rv reflec
 			return
 		}
 		// Write field value.
-		if isNil {
+		if field.union != nil {
+			err = cdc.encodeUnionFieldJSON(w, field, rv)
+		} else if isNil || (field.DynamicResolver != nil && frv.IsNil()) {
 			err = writeStr(w, `null`)
+		} else if field.DynamicResolver != nil {
+			err = cdc.encodeDynamicFieldJSON(w, field, frv.Elem())
 		} else {
 			err = cdc.encodeReflectJSON(w, finfo, frv, field.FieldOptions)
 		}
@@ -336,6 +391,39 @@ func (cdc *Codec) encodeReflectJSONStruct(w io.Writer, info *TypeInfo, rv reflec
 		}
 		writeComma = true
 	}
+	for _, vfield := range info.VirtualFields {
+		var v = vfield.Getter(rv)
+		if v == nil {
+			continue
+		}
+		if writeComma {
+			err = writeStr(w, `,`)
+			if err != nil {
+				return
+			}
+		}
+		// Write virtual field JSON name.
+		err = invokeStdlibJSONMarshal(w, vfield.JSONName)
+		if err != nil {
+			return
+		}
+		// Write colon.
+		err = writeStr(w, `:`)
+		if err != nil {
+			return
+		}
+		// Write virtual field value.
+		var vinfo *TypeInfo
+		vinfo, err = cdc.getTypeInfoWlock(reflect.TypeOf(v))
+		if err != nil {
+			return
+		}
+		err = cdc.encodeReflectJSON(w, vinfo, reflect.ValueOf(v), vfield.FieldOptions)
+		if err != nil {
+			return
+		}
+		writeComma = true
+	}
 	return err
 }
 
@@ -360,14 +448,16 @@ func (cdc *Codec) encodeReflectJSONMap(w io.Writer, info *TypeInfo, rv reflect.V
 		}
 	}()
 
-	// Ensure that the map key type is a string.
-	if rv.Type().Key().Kind() != reflect.String {
-		err = errors.New("encodeReflectJSONMap: map key type must be a string")
+	// Map keys are written as strings, in sorted order.
+	if !isJSONMapKeyKind(rv.Type().Key().Kind()) {
+		err = errors.New("encodeReflectJSONMap: map key type must be a string, integer or bool")
 		return
 	}
+	krvs := rv.MapKeys()
+	sortJSONMapKeys(krvs)
 
 	var writeComma = false
-	for _, krv := range rv.MapKeys() {
+	for _, krv := range krvs {
 		// Get dereferenced object value and info.
 		var vrv, _, isNil = derefPointers(rv.MapIndex(krv))
 
@@ -380,7 +470,7 @@ func (cdc *Codec) encodeReflectJSONMap(w io.Writer, info *TypeInfo, rv reflect.V
 			writeComma = false //nolint:ineffassign
 		}
 		// Write field name.
-		err = invokeStdlibJSONMarshal(w, krv.Interface())
+		err = invokeStdlibJSONMarshal(w, jsonMapKeyString(krv))
 		if err != nil {
 			return
 		}
@@ -434,6 +524,75 @@ func invokeStdlibJSONMarshal(w io.Writer, v interface{}) error {
 	return err
 }
 
+// Proto3 JSON map keys may be strings, integers or bools.
+func isJSONMapKeyKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// CONTRACT: isJSONMapKeyKind(krv.Kind())
+func jsonMapKeyString(krv reflect.Value) string {
+	switch krv.Kind() {
+	case reflect.String:
+		return krv.String()
+	case reflect.Bool:
+		return strconv.FormatBool(krv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(krv.Int(), 10)
+	default:
+		return strconv.FormatUint(krv.Uint(), 10)
+	}
+}
+
+// Sorts map keys by value, so that e.g. integer keys are in numeric order.
+// CONTRACT: isJSONMapKeyKind(krvs[i].Kind())
+func sortJSONMapKeys(krvs []reflect.Value) {
+	sort.Slice(krvs, func(i, j int) bool {
+		switch krvs[i].Kind() {
+		case reflect.String:
+			return krvs[i].String() < krvs[j].String()
+		case reflect.Bool:
+			return !krvs[i].Bool() && krvs[j].Bool()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return krvs[i].Int() < krvs[j].Int()
+		default:
+			return krvs[i].Uint() < krvs[j].Uint()
+		}
+	})
+}
+
+// Write the opening of an interface wrapper, up to the value.  The type key
+// and name are escaped like any other JSON strings.
+func (cdc *Codec) writeAnyPrefixJSON(w io.Writer, name string) (err error) {
+	key, err := json.Marshal(cdc.anyTypeKey)
+	if err != nil {
+		return
+	}
+	value, err := json.Marshal(name)
+	if err != nil {
+		return
+	}
+	return writeStr(w, _fmt(`{%s:%s,"value":`, key, value))
+}
+
+// Writes the raw JSON bz, or null if empty.
+func writeJSONRaw(w io.Writer, bz []byte) (err error) {
+	if len(bz) == 0 {
+		return writeStr(w, `null`)
+	}
+	if !json.Valid(bz) {
+		return errors.Errorf("amino:JSON raw field holds invalid JSON: %q", bz)
+	}
+	_, err = w.Write(bz)
+	return
+}
+
 func writeStr(w io.Writer, s string) (err error) {
 	_, err = w.Write([]byte(s))
 	return
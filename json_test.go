@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
@@ -573,6 +574,34 @@ func TestMarshalJSONMap(t *testing.T) {
 	assert.Equal(t, ms3, ms2)
 }
 
+func TestMarshalJSONNonStringMapKeys(t *testing.T) {
+	var cdc = amino.NewCodec()
+
+	type IntBoolMaps struct {
+		Ints  map[int64]string
+		Bools map[bool]int
+	}
+
+	// Keys are written as strings, in sorted order.
+	ms := IntBoolMaps{
+		Ints:  map[int64]string{10: "ten", -1: "minus one", 2: "two"},
+		Bools: map[bool]int{true: 1, false: 0},
+	}
+	b, err := cdc.MarshalJSON(ms)
+	require.Nil(t, err)
+	assert.Equal(t,
+		`{"Ints":{"-1":"minus one","2":"two","10":"ten"},"Bools":{"false":"0","true":"1"}}`,
+		string(b))
+
+	var ms2 IntBoolMaps
+	err = cdc.UnmarshalJSON(b, &ms2)
+	require.Nil(t, err)
+	assert.Equal(t, ms, ms2)
+
+	err = cdc.UnmarshalJSON([]byte(`{"Ints":{"ten":"10"}}`), &ms2)
+	assert.NotNil(t, err)
+}
+
 func TestMarshalJSONIndent(t *testing.T) {
 	var cdc = amino.NewCodec()
 	registerTransports(cdc)
@@ -587,3 +616,502 @@ func TestMarshalJSONIndent(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expected, string(blob))
 }
+
+func TestMarshalJSONIndentNested(t *testing.T) {
+	type Fleet struct {
+		Name   string     `json:"name,omitempty"`
+		Lead   *Transport `json:"lead"`
+		Spare  Vehicle    `json:"spare,omitempty"`
+		Others []Vehicle  `json:"others"`
+	}
+	var cdc = amino.NewCodec()
+	cdc.SetAnyTypeKey("@type")
+	registerTransports(cdc)
+
+	obj := Fleet{
+		Lead:   &Transport{Vehicle: Car("Tesla"), Capacity: 4},
+		Others: []Vehicle{Car("Volvo")},
+	}
+	bz, err := cdc.MarshalJSONIndent(obj, "", "  ")
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "lead": {
+    "Vehicle": {
+      "@type": "car",
+      "value": "Tesla"
+    },
+    "Capacity": "4"
+  },
+  "others": [
+    {
+      "@type": "car",
+      "value": "Volvo"
+    }
+  ]
+}`, string(bz))
+
+	// It's the same JSON as MarshalJSON.
+	compact, err := cdc.MarshalJSON(obj)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, json.Compact(&buf, bz))
+	assert.Equal(t, string(compact), buf.String())
+}
+
+func TestSetAnyTypeKey(t *testing.T) {
+	var cdc = amino.NewCodec()
+	cdc.SetAnyTypeKey("_type")
+	registerTransports(cdc)
+
+	// The key is used for the top-level wrapper as well as for interfaces
+	// within the value.
+	obj := &Transport{Vehicle: Car("Tesla"), Capacity: 4}
+	blob, err := cdc.MarshalJSON(obj)
+	require.Nil(t, err)
+	assert.Equal(t,
+		`{"_type":"our/transport","value":{"Vehicle":{"_type":"car","value":"Tesla"},"Capacity":"4"}}`,
+		string(blob))
+
+	obj2 := new(Transport)
+	err = cdc.UnmarshalJSON(blob, obj2)
+	require.Nil(t, err)
+	assert.Equal(t, obj, obj2)
+
+	// The default key is no longer understood.
+	err = cdc.UnmarshalJSON([]byte(`{"type":"car","value":"Tesla"}`), new(Vehicle))
+	assert.NotNil(t, err)
+
+	assert.Panics(t, func() { amino.NewCodec().SetAnyTypeKey("") })
+	assert.Panics(t, func() { amino.NewCodec().SetAnyTypeKey("value") })
+
+	// Keys and names which need escaping are escaped.
+	cdc = amino.NewCodec()
+	cdc.SetAnyTypeKey(`my"type`)
+	cdc.RegisterInterface((*Vehicle)(nil), nil)
+	cdc.RegisterConcrete(Car(""), `car\1`, nil)
+	blob, err = cdc.MarshalJSON(struct{ Vehicle Vehicle }{Car("Tesla")})
+	require.Nil(t, err)
+	assert.Equal(t, `{"Vehicle":{"my\"type":"car\\1","value":"Tesla"}}`, string(blob))
+	var holder struct{ Vehicle Vehicle }
+	require.Nil(t, cdc.UnmarshalJSON(blob, &holder))
+	assert.Equal(t, Car("Tesla"), holder.Vehicle)
+}
+
+func TestSetInterfaceResolver(t *testing.T) {
+	var cdc = amino.NewCodec()
+	registerTransports(cdc)
+	// "vessel" means a boat in the "Vehicle" field, and a car elsewhere.
+	cdc.SetInterfaceResolver(func(fieldContext string, name string) (reflect.Type, bool) {
+		if name != "vessel" {
+			return nil, false
+		}
+		if fieldContext == "Vehicle" {
+			return reflect.TypeOf(Boat("")), true
+		}
+		return reflect.TypeOf(Car("")), true
+	})
+
+	tr := new(Transport)
+	err := cdc.UnmarshalJSON([]byte(`{"type":"our/transport","value":{"Vehicle":{"type":"vessel","value":"Titanic"},"Capacity":"2"}}`), tr)
+	require.Nil(t, err)
+	assert.Equal(t, &Transport{Vehicle: Boat("Titanic"), Capacity: 2}, tr)
+
+	var v Vehicle
+	err = cdc.UnmarshalJSON([]byte(`{"type":"vessel","value":"Tesla"}`), &v)
+	require.Nil(t, err)
+	assert.Equal(t, Car("Tesla"), v)
+
+	// Names the resolver doesn't handle fall back to the registered names.
+	err = cdc.UnmarshalJSON([]byte(`{"type":"boat","value":"Titanic"}`), &v)
+	require.Nil(t, err)
+	assert.Equal(t, Boat("Titanic"), v)
+
+	// Resolved types must implement the interface.
+	cdc2 := amino.NewCodec()
+	registerTransports(cdc2)
+	cdc2.SetInterfaceResolver(func(string, string) (reflect.Type, bool) {
+		return reflect.TypeOf(insurancePlan(0)), true
+	})
+	err = cdc2.UnmarshalJSON([]byte(`{"type":"car","value":"Tesla"}`), &v)
+	assert.NotNil(t, err)
+}
+
+type jsonReading float64
+
+func TestRegisterOmitEmptyFunc(t *testing.T) {
+	type Sample struct {
+		Value jsonReading  `json:"v,omitempty" amino:"unsafe"`
+		Ptr   *jsonReading `json:"p,omitempty" amino:"unsafe"`
+	}
+	negZero := jsonReading(math.Copysign(0, -1))
+	nan := jsonReading(math.NaN())
+
+	// By default, -0.0 is omitted as it equals 0.0, and NaN isn't.
+	cdc := amino.NewCodec()
+	bz, err := cdc.MarshalJSON(Sample{Value: negZero})
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(bz))
+	_, err = cdc.MarshalJSON(Sample{Value: nan})
+	assert.Error(t, err)
+
+	// Only +0.0 and NaN are empty, by their bits.
+	cdc = amino.NewCodec()
+	cdc.RegisterOmitEmptyFunc(reflect.TypeOf(jsonReading(0)), func(v reflect.Value) bool {
+		f := v.Float()
+		return math.IsNaN(f) || math.Float64bits(f) == 0
+	})
+	cases := []struct {
+		in   Sample
+		want string
+	}{
+		{Sample{}, `{}`},
+		{Sample{Value: negZero}, `{"v":-0}`},
+		{Sample{Value: nan, Ptr: &nan}, `{}`},
+		{Sample{Value: 1.5, Ptr: &negZero}, `{"v":1.5,"p":-0}`},
+	}
+	for i, tc := range cases {
+		bz, err := cdc.MarshalJSON(tc.in)
+		require.NoError(t, err, "case %v", i)
+		assert.Equal(t, tc.want, string(bz), "case %v", i)
+	}
+
+	assert.Panics(t, func() {
+		cdc.RegisterOmitEmptyFunc(reflect.TypeOf(jsonReading(0)), func(reflect.Value) bool { return false })
+	}, "already registered")
+}
+
+type jsonLevel int32
+
+const jsonLevelInvalid = jsonLevel(-1)
+
+func TestRegisterJSONNull(t *testing.T) {
+	type Sample struct {
+		Level jsonLevel  `json:"level"`
+		Ptr   *jsonLevel `json:"ptr"`
+	}
+	cdc := amino.NewCodec()
+	cdc.RegisterJSONNull(reflect.TypeOf(jsonLevel(0)),
+		func(v reflect.Value) bool { return jsonLevel(v.Int()) == jsonLevelInvalid },
+		func() reflect.Value { return reflect.ValueOf(jsonLevelInvalid) })
+
+	invalid := jsonLevelInvalid
+	cases := []struct {
+		in   Sample
+		want string
+	}{
+		{Sample{Level: 3}, `{"level":3,"ptr":null}`},
+		{Sample{Level: jsonLevelInvalid, Ptr: &invalid}, `{"level":null,"ptr":null}`},
+	}
+	for i, tc := range cases {
+		bz, err := cdc.MarshalJSON(tc.in)
+		require.NoError(t, err, "case %v", i)
+		assert.Equal(t, tc.want, string(bz), "case %v", i)
+	}
+
+	// Null decodes to the sentinel, but to nil for pointers.
+	var s Sample
+	require.NoError(t, cdc.UnmarshalJSON([]byte(`{"level":null,"ptr":null}`), &s))
+	assert.Equal(t, Sample{Level: jsonLevelInvalid}, s)
+	var lv jsonLevel
+	require.NoError(t, cdc.UnmarshalJSON([]byte(`null`), &lv))
+	assert.Equal(t, jsonLevelInvalid, lv)
+
+	// The binary encoding is unaffected.
+	bz, err := cdc.MarshalBinaryBare(Sample{Level: jsonLevelInvalid})
+	require.NoError(t, err)
+	var s2 Sample
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &s2))
+	assert.Equal(t, Sample{Level: jsonLevelInvalid}, s2)
+
+	assert.Panics(t, func() {
+		cdc.RegisterJSONNull(reflect.TypeOf(jsonLevel(0)),
+			func(reflect.Value) bool { return false },
+			func() reflect.Value { return reflect.ValueOf(jsonLevel(0)) })
+	}, "already registered")
+	assert.Panics(t, func() {
+		amino.NewCodec().RegisterJSONNull(reflect.TypeOf(jsonLevel(0)),
+			func(reflect.Value) bool { return false },
+			func() reflect.Value { return reflect.ValueOf(int32(0)) })
+	}, "wrong sentinel type")
+}
+
+type jsonStatus int32
+
+func TestRegisterEnum(t *testing.T) {
+	type Sample struct {
+		Status jsonStatus
+		List   []jsonStatus
+		Ptr    *jsonStatus
+	}
+	cdc := amino.NewCodec()
+	names := map[int32]string{
+		0: "STATUS_UNKNOWN",
+		1: "STATUS_ACTIVE",
+		2: "STATUS_CLOSED",
+	}
+	cdc.RegisterEnum(reflect.TypeOf(jsonStatus(0)), names)
+	// The codec keeps its own copy of names.
+	names[1] = "STATUS_CHANGED"
+	delete(names, 2)
+
+	closed, unnamed := jsonStatus(2), jsonStatus(9)
+	in := Sample{Status: 1, List: []jsonStatus{0, 9}, Ptr: &closed}
+	bz, err := cdc.MarshalJSON(in)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Status":"STATUS_ACTIVE","List":["STATUS_UNKNOWN",9],"Ptr":"STATUS_CLOSED"}`, string(bz))
+	var out Sample
+	require.NoError(t, cdc.UnmarshalJSON(bz, &out))
+	assert.Equal(t, in, out)
+
+	// Numbers are accepted for both named and unnamed values.
+	out = Sample{}
+	require.NoError(t, cdc.UnmarshalJSON([]byte(`{"Status":2,"Ptr":9}`), &out))
+	assert.Equal(t, Sample{Status: 2, Ptr: &unnamed}, out)
+	err = cdc.UnmarshalJSON([]byte(`{"Status":"STATUS_OPEN"}`), &out)
+	assert.Error(t, err)
+
+	// The binary encoding keeps the integer.
+	bz, err = cdc.MarshalBinaryBare(Sample{Status: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x08, 0x01}, bz)
+
+	assert.Panics(t, func() {
+		cdc.RegisterEnum(reflect.TypeOf(jsonStatus(0)), map[int32]string{})
+	}, "already registered")
+	assert.Panics(t, func() {
+		amino.NewCodec().RegisterEnum(reflect.TypeOf(jsonStatus(0)), map[int32]string{1: "A", 2: "A"})
+	}, "duplicate names")
+	assert.Panics(t, func() {
+		amino.NewCodec().RegisterEnum(reflect.TypeOf(int64(0)), map[int32]string{1: "A"})
+	}, "not int32")
+}
+
+func TestJSONRawFields(t *testing.T) {
+	type Doc struct {
+		Name  string
+		Meta  json.RawMessage
+		Extra []byte `amino:"json_raw"`
+		Ptr   *json.RawMessage
+	}
+	cdc := amino.NewCodec()
+
+	ptr := json.RawMessage(`[1, 2]`)
+	in := Doc{Name: "a", Meta: json.RawMessage(`{"b":[1,"x"]}`), Extra: []byte(`"s"`), Ptr: &ptr}
+	bz, err := cdc.MarshalJSON(in)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Name":"a","Meta":{"b":[1,"x"]},"Extra":"s","Ptr":[1, 2]}`, string(bz))
+	var out Doc
+	require.NoError(t, cdc.UnmarshalJSON(bz, &out))
+	assert.Equal(t, in, out)
+
+	// Empty raw fields are null.
+	bz, err = cdc.MarshalJSON(Doc{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"Name":"","Meta":null,"Extra":null,"Ptr":null}`, string(bz))
+	out = Doc{}
+	require.NoError(t, cdc.UnmarshalJSON(bz, &out))
+	assert.Equal(t, Doc{}, out)
+
+	// Invalid JSON is rejected when encoding.
+	_, err = cdc.MarshalJSON(Doc{Meta: json.RawMessage(`{"b":`)})
+	assert.Error(t, err)
+	_, err = cdc.MarshalJSON(Doc{Extra: []byte(`not json`)})
+	assert.Error(t, err)
+
+	// The binary encoding is still a byte slice.
+	bz, err = cdc.MarshalBinaryBare(in)
+	require.NoError(t, err)
+	out = Doc{}
+	require.NoError(t, cdc.UnmarshalBinaryBare(bz, &out))
+	assert.Equal(t, in, out)
+
+	type BadTag struct {
+		S string `amino:"json_raw"`
+	}
+	assert.Panics(t, func() { cdc.MarshalJSON(BadTag{}) }) // nolint: errcheck
+}
+
+func TestUnmarshalJSONWithRenames(t *testing.T) {
+	type Address struct {
+		Street string `json:"street"`
+		City   string `json:"city"`
+	}
+	type Account struct {
+		Owner     string    `json:"owner"`
+		Balance   int64     `json:"balance"`
+		Addresses []Address `json:"addresses"`
+	}
+	renames := map[string]string{
+		"owner_name": "owner",
+		"addrs":      "addresses",
+		"town":       "city",
+	}
+
+	cdc := amino.NewCodec()
+	legacy := `{"owner_name":"alice","balance":"5","addrs":[{"street":"main","town":"x"}],"extra":1}`
+	var acc Account
+	err := cdc.UnmarshalJSONWithRenames([]byte(legacy), &acc, renames)
+	require.NoError(t, err)
+	assert.Equal(t, Account{"alice", 5, []Address{{"main", "x"}}}, acc)
+
+	// Current names still work, but not alongside legacy ones.
+	acc = Account{}
+	err = cdc.UnmarshalJSONWithRenames([]byte(`{"owner":"bob"}`), &acc, renames)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", acc.Owner)
+	err = cdc.UnmarshalJSONWithRenames([]byte(`{"owner":"bob","owner_name":"alice"}`), &acc, renames)
+	assert.Error(t, err)
+
+	// Keys of maps are user data, so are not renamed.
+	var m map[string]string
+	err = cdc.UnmarshalJSONWithRenames([]byte(`{"owner_name":"alice"}`), &m, renames)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner_name": "alice"}, m)
+
+	// Chained and cyclic renames are ambiguous.
+	for _, bad := range []map[string]string{
+		{"owner_name": "name", "name": "owner"},
+		{"owner": "name", "name": "owner"},
+		{"owner": "owner"},
+	} {
+		err = cdc.UnmarshalJSONWithRenames([]byte(`{"owner_name":"alice"}`), &acc, bad)
+		assert.Error(t, err, "%v", bad)
+	}
+
+	// Without renames, legacy names are unknown and ignored.
+	acc = Account{}
+	err = cdc.UnmarshalJSON([]byte(legacy), &acc)
+	require.NoError(t, err)
+	assert.Equal(t, Account{Balance: 5}, acc)
+}
+
+func TestJSONHexField(t *testing.T) {
+	type Block struct {
+		Hash   []byte   `json:"hash" amino:"json_hex"`
+		Parent [4]byte  `json:"parent" amino:"json_hex"`
+		Data   []byte   `json:"data"`
+		Empty  []byte   `json:"empty" amino:"json_hex"`
+		Sigs   [][]byte `json:"sigs" amino:"json_hex"`
+	}
+
+	cdc := amino.NewCodec()
+	b := Block{
+		Hash:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
+		Parent: [4]byte{0x01, 0x02, 0x0A, 0xFF},
+		Data:   []byte{0xDE, 0xAD},
+		Sigs:   [][]byte{{0xAB}, {0xCD, 0xEF}},
+	}
+	bz, err := cdc.MarshalJSON(b)
+	require.NoError(t, err)
+	assert.Equal(t, `{"hash":"deadbeef","parent":"01020aff","data":"3q0=","empty":null,"sigs":["ab","cdef"]}`,
+		string(bz))
+
+	var b2 Block
+	require.NoError(t, cdc.UnmarshalJSON(bz, &b2))
+	assert.Equal(t, b, b2)
+
+	// Upper case is accepted.
+	b2 = Block{}
+	err = cdc.UnmarshalJSON([]byte(`{"hash":"DEADBEEF","parent":"01020AFF","empty":""}`), &b2)
+	require.NoError(t, err)
+	assert.Equal(t, b.Hash, b2.Hash)
+	assert.Equal(t, b.Parent, b2.Parent)
+	assert.Nil(t, b2.Empty)
+
+	// Binary encoding is unchanged.
+	type PlainBlock struct {
+		Hash   []byte
+		Parent [4]byte
+		Data   []byte
+		Empty  []byte
+		Sigs   [][]byte
+	}
+	assert.Equal(t, cdc.MustMarshalBinaryBare(PlainBlock(b)), cdc.MustMarshalBinaryBare(b))
+
+	cases := []struct {
+		json string
+		want string
+	}{
+		{`{"hash":"abc"}`, `amino:JSON json_hex field hash has odd length 3: "abc"`},
+		{`{"hash":"zz"}`, `amino:JSON json_hex field hash is not valid hex: "zz"`},
+		{`{"hash":"3q0="}`, `amino:JSON json_hex field hash is not valid hex: "3q0="`},
+		{`{"parent":12}`, `amino:JSON json_hex field parent must be a hex string, but got 12`},
+	}
+	for _, tc := range cases {
+		err = cdc.UnmarshalJSON([]byte(tc.json), &b2)
+		require.Error(t, err, tc.json)
+		assert.Equal(t, tc.want, err.Error(), tc.json)
+	}
+}
+
+func TestSetJSONNamePolicy(t *testing.T) {
+	type Account struct {
+		UserID      uint64
+		HTTPServer  string
+		DisplayName string
+		Nonce2      int64
+		Explicit    string `json:"MyKey"`
+		Omitted     string `json:",omitempty"`
+	}
+
+	cdc := amino.NewCodec()
+	cdc.SetJSONNamePolicy(amino.JSONNameSnakeCase)
+	a := Account{UserID: 1, HTTPServer: "h", DisplayName: "d", Nonce2: 2, Explicit: "e"}
+	bz, err := cdc.MarshalJSON(a)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"user_id":"1","http_server":"h","display_name":"d","nonce2":"2","MyKey":"e"}`,
+		string(bz))
+	var a2 Account
+	require.NoError(t, cdc.UnmarshalJSON(bz, &a2))
+	assert.Equal(t, a, a2)
+
+	cdc = amino.NewCodec()
+	cdc.SetJSONNamePolicy(amino.JSONNameCamelCase)
+	bz, err = cdc.MarshalJSON(a)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"userID":"1","httpServer":"h","displayName":"d","nonce2":"2","MyKey":"e"}`,
+		string(bz))
+
+	// Names are derived when types are first used.
+	assert.Panics(t, func() { cdc.SetJSONNamePolicy(nil) })
+
+	for name, want := range map[string]string{
+		"ID": "id", "A": "a", "URLPath": "url_path", "Height": "height", "ABCDef2Ghi": "abc_def2_ghi",
+	} {
+		assert.Equal(t, want, amino.JSONNameSnakeCase(name), name)
+	}
+	for name, want := range map[string]string{
+		"ID": "id", "A": "a", "URLPath": "urlPath", "Height": "height", "userID": "userID",
+	} {
+		assert.Equal(t, want, amino.JSONNameCamelCase(name), name)
+	}
+}
+
+func TestSetJSONWriteEmpty(t *testing.T) {
+	type Inner struct{ A int }
+	type Doc struct {
+		Name  string   `json:"name,omitempty"`
+		Inner Inner    `json:"inner,omitempty"`
+		Ptr   *Inner   `json:"ptr,omitempty"`
+		List  []string `json:"list,omitempty"`
+		Plain int      `json:"plain"`
+	}
+
+	cdc := amino.NewCodec()
+	bz, err := cdc.MarshalJSON(Doc{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"plain":"0"}`, string(bz))
+
+	cdc.SetJSONWriteEmpty(true)
+	bz, err = cdc.MarshalJSON(Doc{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"","inner":{"A":"0"},"ptr":null,"list":null,"plain":"0"}`, string(bz))
+	var d Doc
+	require.NoError(t, cdc.UnmarshalJSON(bz, &d))
+	assert.Equal(t, Doc{}, d)
+
+	// Binary is unaffected.
+	assert.Equal(t, amino.NewCodec().MustMarshalBinaryBare(Doc{Plain: 1}), cdc.MustMarshalBinaryBare(Doc{Plain: 1}))
+}
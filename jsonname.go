@@ -0,0 +1,63 @@
+package amino
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+//----------------------------------------
+// JSON field name policy
+
+// SetJSONNamePolicy sets a function which derives the JSON name of struct
+// fields from their Go name, e.g. JSONNameSnakeCase.  It only applies to
+// fields without a name in their json tag, as explicit names always win.
+// Field names are derived when a struct type is first registered or used,
+// so the policy must be set before any struct type is, or else this
+// panics.  A nil policy keeps Go field names, which is the default.
+func (cdc *Codec) SetJSONNamePolicy(policy func(goFieldName string) string) {
+	cdc.assertNotSealed()
+
+	cdc.mtx.Lock()
+	defer cdc.mtx.Unlock()
+	for rt := range cdc.typeInfos {
+		if rt.Kind() == reflect.Struct {
+			panic(fmt.Sprintf("SetJSONNamePolicy must be called before struct types are used, but %v already was", rt))
+		}
+	}
+	cdc.jsonNamePolicy = policy
+}
+
+// JSONNameSnakeCase is a JSON name policy which converts Go field names
+// to snake_case, keeping acronyms together, e.g. "HTTPServer" to
+// "http_server" and "UserID" to "user_id".
+func JSONNameSnakeCase(name string) string {
+	var rs = []rune(name)
+	var sb strings.Builder
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) {
+			var prev = rs[i-1]
+			var nextLower = i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// JSONNameCamelCase is a JSON name policy which converts Go field names
+// to camelCase by lower-casing their leading capitals, keeping acronyms
+// together, e.g. "HTTPServer" to "httpServer" and "ID" to "id".
+func JSONNameCamelCase(name string) string {
+	var rs = []rune(name)
+	for i := 0; i < len(rs) && unicode.IsUpper(rs[i]); i++ {
+		if i > 0 && i+1 < len(rs) && unicode.IsLower(rs[i+1]) {
+			break // Start of the next word.
+		}
+		rs[i] = unicode.ToLower(rs[i])
+	}
+	return string(rs)
+}
@@ -0,0 +1,43 @@
+package amino
+
+import (
+	"reflect"
+)
+
+//----------------------------------------
+// Lazy interface values
+
+var lazyAnyPtrType = reflect.TypeOf((*LazyAny)(nil))
+
+// LazyAny holds an interface value which is yet to be decoded, see
+// SetLazyAny.  It is not safe for concurrent use.
+type LazyAny struct {
+	Name string // The registered name of the concrete type.
+
+	value    []byte // The encoding of the concrete value, without prefix bytes.
+	fopts    FieldOptions
+	dopts    decodeOptions
+	resolved interface{}
+}
+
+// Resolve decodes the concrete value with cdc on the first call, and
+// returns it.  Interface values within it are again decoded lazily if cdc
+// is in lazy mode.
+func (la *LazyAny) Resolve(cdc *Codec) (o interface{}, err error) {
+	if la.resolved != nil {
+		return la.resolved, nil
+	}
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	cinfo, err := cdc.getTypeInfoFromNameRlock(la.Name)
+	if err != nil {
+		return nil, err
+	}
+	irvSet, _, err := cdc.decodeReflectBinaryConcrete(la.value, cinfo, la.fopts, la.dopts)
+	if err != nil {
+		return nil, err
+	}
+	la.resolved, la.value = irvSet.Interface(), nil
+	return la.resolved, nil
+}
@@ -0,0 +1,1008 @@
+package amino
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// MessagePack
+
+// MessagePack extension types, see MarshalMsgpack.
+const (
+	MsgpackExtTimestamp int8 = -1 // The MessagePack timestamp extension type.
+	MsgpackExtDuration  int8 = 1  // An int64 of nanoseconds, big-endian.
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// MarshalMsgpack encodes o in MessagePack, following the type info of the
+// codec like MarshalJSON: structs are maps keyed by the JSON names of their
+// fields, interface values are maps of the type key (see SetAnyTypeKey) to
+// the registered name and "value" to the concrete value, and registered
+// concrete values are wrapped the same way at the top level.  time.Time is
+// the MessagePack timestamp extension type, and time.Duration the
+// MsgpackExtDuration extension type.  Byte slices and arrays are bin, and
+// other lists are arrays.  Options which only affect the binary encoding of
+// fields are ignored, and dynamic and union fields are not supported.
+func (cdc *Codec) MarshalMsgpack(o interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	var buf = new(bytes.Buffer)
+	rv := reflect.ValueOf(o)
+	if !rv.IsValid() {
+		writeMsgpackNil(buf)
+		return buf.Bytes(), nil
+	}
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	if info.Registered {
+		writeMsgpackMapHeader(buf, 2)
+		writeMsgpackStr(buf, cdc.anyTypeKey)
+		writeMsgpackStr(buf, info.Name)
+		writeMsgpackStr(buf, "value")
+	}
+	if err = cdc.encodeMsgpack(buf, info, rv, FieldOptions{}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalMsgpack decodes bz, as written by MarshalMsgpack, into ptr.
+// Map keys which match no field are ignored.
+func (cdc *Codec) UnmarshalMsgpack(bz []byte, ptr interface{}) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr {
+		return ErrNoPointer
+	}
+	rv = rv.Elem()
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return err
+	}
+	var r = &msgpackReader{bz: bz}
+	if info.Registered {
+		var name string
+		var value []byte
+		if name, value, err = cdc.readMsgpackAny(r); err != nil {
+			return err
+		}
+		if name != info.Name {
+			return errors.Errorf("wanted to decode %v but found %v", info.Name, name)
+		}
+		if value == nil {
+			return errors.Errorf("missing value of %v", name)
+		}
+		if len(r.bz) != 0 {
+			return errors.Errorf("%v bytes left over after MessagePack value", len(r.bz))
+		}
+		r = &msgpackReader{bz: value}
+	}
+	if err = cdc.decodeMsgpack(r, info, rv, FieldOptions{}, decodeOptions{}); err != nil {
+		return err
+	}
+	if len(r.bz) != 0 {
+		return errors.Errorf("%v bytes left over after MessagePack value", len(r.bz))
+	}
+	return nil
+}
+
+func (cdc *Codec) encodeMsgpack(buf *bytes.Buffer, info *TypeInfo, rv reflect.Value, fopts FieldOptions) (err error) {
+	// Dereference value if pointer.
+	var isNilPtr bool
+	rv, _, isNilPtr = derefPointers(rv)
+	if isNilPtr {
+		writeMsgpackNil(buf)
+		return
+	}
+
+	switch info.Type {
+	case timeType:
+		writeMsgpackTime(buf, rv.Interface().(time.Time))
+		return
+	case durationType:
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], uint64(rv.Int()))
+		writeMsgpackExt(buf, MsgpackExtDuration, data[:])
+		return
+	}
+
+	if info.IsAminoMarshaler {
+		var (
+			rrv   reflect.Value
+			rinfo *TypeInfo
+		)
+		if rrv, err = toReprObject(rv); err != nil {
+			return
+		}
+		if rinfo, err = cdc.getTypeInfoWlock(info.AminoMarshalReprType); err != nil {
+			return
+		}
+		return cdc.encodeMsgpack(buf, rinfo, rrv, fopts)
+	}
+
+	switch info.Type.Kind() {
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			writeMsgpackNil(buf)
+			return
+		}
+		var crv, _, isNilPtr = derefPointers(rv.Elem())
+		if isNilPtr {
+			return errors.Errorf("illegal nil-pointer of type %v for interface %v", crv.Type(), info.Type)
+		}
+		var crt = crv.Type()
+		var cinfo *TypeInfo
+		if cinfo, err = cdc.getTypeInfoWlock(crt); err != nil {
+			return
+		}
+		if !cinfo.Registered {
+			var ok bool
+			if crv, cinfo, ok = cdc.toStdError(info, rv); !ok {
+				return ErrUnregisteredConcrete{crt}
+			}
+		}
+		writeMsgpackMapHeader(buf, 2)
+		writeMsgpackStr(buf, cdc.anyTypeKey)
+		writeMsgpackStr(buf, cinfo.Name)
+		writeMsgpackStr(buf, "value")
+		return cdc.encodeMsgpack(buf, cinfo, crv, fopts)
+
+	case reflect.Array, reflect.Slice:
+		if info.Type.Elem().Kind() == reflect.Uint8 {
+			var bz = make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(bz), rv)
+			writeMsgpackBin(buf, bz)
+			return
+		}
+		var einfo *TypeInfo
+		if einfo, err = cdc.getTypeInfoWlock(info.Type.Elem()); err != nil {
+			return
+		}
+		writeMsgpackArrayHeader(buf, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err = cdc.encodeMsgpack(buf, einfo, rv.Index(i), fopts); err != nil {
+				return
+			}
+		}
+		return
+
+	case reflect.Struct:
+		writeMsgpackMapHeader(buf, len(info.Fields))
+		for _, field := range info.Fields {
+			if field.DynamicResolver != nil || field.union != nil {
+				return errors.Errorf("field %v of %v is not supported in MessagePack", field.Name, info.Type)
+			}
+			var finfo *TypeInfo
+			if finfo, err = cdc.getTypeInfoWlock(field.Type); err != nil {
+				return
+			}
+			writeMsgpackStr(buf, field.JSONName)
+			if err = cdc.encodeMsgpack(buf, finfo, field.valueOf(rv), field.FieldOptions); err != nil {
+				return
+			}
+		}
+		return
+
+	case reflect.Map:
+		if !isJSONMapKeyKind(info.Type.Key().Kind()) {
+			return errors.New("encodeMsgpack: map key type must be a string, integer or bool")
+		}
+		var kinfo, vinfo *TypeInfo
+		if kinfo, err = cdc.getTypeInfoWlock(info.Type.Key()); err != nil {
+			return
+		}
+		if vinfo, err = cdc.getTypeInfoWlock(info.Type.Elem()); err != nil {
+			return
+		}
+		krvs := rv.MapKeys()
+		sortJSONMapKeys(krvs)
+		writeMsgpackMapHeader(buf, len(krvs))
+		for _, krv := range krvs {
+			if err = cdc.encodeMsgpack(buf, kinfo, krv, FieldOptions{}); err != nil {
+				return
+			}
+			if err = cdc.encodeMsgpack(buf, vinfo, rv.MapIndex(krv), fopts); err != nil {
+				return
+			}
+		}
+		return
+
+	case reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Int:
+		writeMsgpackInt(buf, rv.Int())
+
+	case reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8, reflect.Uint:
+		writeMsgpackUint(buf, rv.Uint())
+
+	case reflect.Float64, reflect.Float32:
+		if !fopts.Unsafe {
+			return errors.New("amino MessagePack float* support requires `amino:\"unsafe\"`")
+		}
+		if info.Type.Kind() == reflect.Float32 {
+			buf.WriteByte(0xca)
+			var data [4]byte
+			binary.BigEndian.PutUint32(data[:], math.Float32bits(float32(rv.Float())))
+			buf.Write(data[:])
+		} else {
+			buf.WriteByte(0xcb)
+			var data [8]byte
+			binary.BigEndian.PutUint64(data[:], math.Float64bits(rv.Float()))
+			buf.Write(data[:])
+		}
+
+	case reflect.Bool:
+		if rv.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+
+	case reflect.String:
+		writeMsgpackStr(buf, rv.String())
+
+	default:
+		panic(fmt.Sprintf("unsupported type %v", info.Type.Kind()))
+	}
+	return
+}
+
+func (cdc *Codec) decodeMsgpack(r *msgpackReader, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, dopts decodeOptions) (err error) {
+	if !rv.CanAddr() {
+		panic(internalError("rv not addressable"))
+	}
+
+	// Special case for nil, for either interface, pointer or slice.
+	if r.readNil() {
+		rv.Set(reflect.Zero(rv.Type()))
+		return
+	}
+
+	// Dereference-and-construct pointers all the way.
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(cdc.newValue(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+
+	switch info.Type {
+	case timeType:
+		var t time.Time
+		if t, err = r.readTime(); err != nil {
+			return
+		}
+		rv.Set(reflect.ValueOf(t))
+		return
+	case durationType:
+		var data []byte
+		if data, err = r.readExt(MsgpackExtDuration); err != nil {
+			return
+		}
+		if len(data) != 8 {
+			return errors.Errorf("invalid MessagePack duration of %v bytes", len(data))
+		}
+		rv.SetInt(int64(binary.BigEndian.Uint64(data)))
+		return
+	}
+
+	if info.IsAminoUnmarshaler {
+		rrv := reflect.New(info.AminoUnmarshalReprType).Elem()
+		var rinfo *TypeInfo
+		if rinfo, err = cdc.getTypeInfoWlock(info.AminoUnmarshalReprType); err != nil {
+			return
+		}
+		if err = cdc.decodeMsgpack(r, rinfo, rrv, fopts, dopts); err != nil {
+			return
+		}
+		uwouts := rv.Addr().MethodByName("UnmarshalAmino").Call([]reflect.Value{rrv})
+		if erri := uwouts[0].Interface(); erri != nil {
+			err = erri.(error)
+		}
+		return
+	}
+
+	// Count nested values, see SetMaxDecodeDepth().
+	if dopts, err = cdc.enterComplex(info.Type.Kind(), dopts); err != nil {
+		return
+	}
+
+	switch info.Type.Kind() {
+
+	case reflect.Interface:
+		var name string
+		var value []byte
+		if name, value, err = cdc.readMsgpackAny(r); err != nil {
+			return
+		}
+		var cinfo *TypeInfo
+		if cinfo, err = cdc.getTypeInfoFromNameRlock(name); err != nil {
+			return
+		}
+		if !cinfo.PtrToType.Implements(info.Type) {
+			return errors.Errorf("expected concrete type %v to implement %v", cinfo.Type, info.Type)
+		}
+		if err = dopts.checkAllowed(cinfo.Type); err != nil {
+			return
+		}
+		if dopts, err = cdc.enterAny(dopts); err != nil {
+			return
+		}
+		if value == nil {
+			return errors.Errorf("missing value of %v", name)
+		}
+		var vr = &msgpackReader{bz: value}
+		crv, irvSet := cdc.constructConcreteType(cinfo)
+		if err = cdc.decodeMsgpack(vr, cinfo, crv, fopts, dopts); err != nil {
+			return
+		}
+		rv.Set(irvSet)
+
+	case reflect.Array, reflect.Slice:
+		if info.Type.Elem().Kind() == reflect.Uint8 {
+			var bz []byte
+			if bz, err = r.readBin(); err != nil {
+				return
+			}
+			if info.Type.Kind() == reflect.Array {
+				if len(bz) != info.Type.Len() {
+					return errors.Errorf("expected %v bytes for %v, got %v", info.Type.Len(), info.Type, len(bz))
+				}
+				reflect.Copy(rv, reflect.ValueOf(bz))
+			} else if len(bz) == 0 {
+				rv.Set(info.ZeroValue) // NOTE: We prefer nil slices.
+			} else {
+				rv.Set(reflect.ValueOf(append([]byte(nil), bz...)).Convert(info.Type))
+			}
+			return
+		}
+		var length int
+		if length, err = r.readArrayLen(); err != nil {
+			return
+		}
+		if info.Type.Kind() == reflect.Array {
+			if length != info.Type.Len() {
+				return errors.Errorf("expected %v elements for %v, got %v", info.Type.Len(), info.Type, length)
+			}
+		} else if length == 0 {
+			rv.Set(info.ZeroValue) // NOTE: We prefer nil slices.
+			return
+		} else {
+			rv.Set(reflect.MakeSlice(info.Type, length, length))
+		}
+		var einfo *TypeInfo
+		if einfo, err = cdc.getTypeInfoWlock(info.Type.Elem()); err != nil {
+			return
+		}
+		for i := 0; i < length; i++ {
+			if err = cdc.decodeMsgpack(r, einfo, rv.Index(i), fopts, dopts); err != nil {
+				return
+			}
+		}
+
+	case reflect.Struct:
+		var length int
+		if length, err = r.readMapLen(); err != nil {
+			return
+		}
+		rv.Set(reflect.Zero(info.Type))
+		var seen = make(map[string]bool, length)
+	KEYS:
+		for i := 0; i < length; i++ {
+			var key string
+			if key, err = r.readStr(); err != nil {
+				return
+			}
+			if seen[key] {
+				return errors.Errorf("duplicate key %q for %v", key, info.Type)
+			}
+			seen[key] = true
+			for _, field := range info.Fields {
+				if field.JSONName != key {
+					continue
+				}
+				if field.DynamicResolver != nil || field.union != nil {
+					return errors.Errorf("field %v of %v is not supported in MessagePack", field.Name, info.Type)
+				}
+				var finfo *TypeInfo
+				if finfo, err = cdc.getTypeInfoWlock(field.Type); err != nil {
+					return
+				}
+				if err = cdc.decodeMsgpack(r, finfo, field.valueOf(rv), field.FieldOptions, dopts); err != nil {
+					return errors.Wrapf(err, "field %v of %v", field.Name, info.Type)
+				}
+				continue KEYS
+			}
+			// Ignore unknown keys.
+			if _, err = r.skip(); err != nil {
+				return
+			}
+		}
+
+	case reflect.Map:
+		var length int
+		if length, err = r.readMapLen(); err != nil {
+			return
+		}
+		var kinfo, vinfo *TypeInfo
+		if kinfo, err = cdc.getTypeInfoWlock(info.Type.Key()); err != nil {
+			return
+		}
+		if vinfo, err = cdc.getTypeInfoWlock(info.Type.Elem()); err != nil {
+			return
+		}
+		if length == 0 {
+			rv.Set(info.ZeroValue) // NOTE: We prefer nil maps.
+			return
+		}
+		var mrv = reflect.MakeMapWithSize(info.Type, length)
+		for i := 0; i < length; i++ {
+			var krv = reflect.New(info.Type.Key()).Elem()
+			if err = cdc.decodeMsgpack(r, kinfo, krv, FieldOptions{}, dopts); err != nil {
+				return
+			}
+			if mrv.MapIndex(krv).IsValid() {
+				return errors.Errorf("duplicate key %v for %v", krv.Interface(), info.Type)
+			}
+			var vrv = reflect.New(info.Type.Elem()).Elem()
+			if err = cdc.decodeMsgpack(r, vinfo, vrv, fopts, dopts); err != nil {
+				return
+			}
+			mrv.SetMapIndex(krv, vrv)
+		}
+		rv.Set(mrv)
+
+	case reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Int:
+		var i int64
+		if i, err = r.readInt(); err != nil {
+			return
+		}
+		if rv.OverflowInt(i) {
+			return errors.Errorf("integer %v overflows %v", i, info.Type)
+		}
+		rv.SetInt(i)
+
+	case reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8, reflect.Uint:
+		var u uint64
+		if u, err = r.readUint(); err != nil {
+			return
+		}
+		if rv.OverflowUint(u) {
+			return errors.Errorf("integer %v overflows %v", u, info.Type)
+		}
+		rv.SetUint(u)
+
+	case reflect.Float64, reflect.Float32:
+		if !fopts.Unsafe {
+			return errors.New("amino MessagePack float* support requires `amino:\"unsafe\"`")
+		}
+		var f float64
+		if f, err = r.readFloat(); err != nil {
+			return
+		}
+		rv.SetFloat(f)
+
+	case reflect.Bool:
+		var b bool
+		if b, err = r.readBool(); err != nil {
+			return
+		}
+		rv.SetBool(b)
+
+	case reflect.String:
+		var s string
+		if s, err = r.readStr(); err != nil {
+			return
+		}
+		rv.SetString(s)
+
+	default:
+		panic(fmt.Sprintf("unsupported type %v", info.Type.Kind()))
+	}
+	return
+}
+
+// Reads an interface value as written by encodeMsgpack(), and returns its
+// name and its encoded value, which is nil if missing.
+func (cdc *Codec) readMsgpackAny(r *msgpackReader) (name string, value []byte, err error) {
+	var length int
+	if length, err = r.readMapLen(); err != nil {
+		return
+	}
+	var hasName bool
+	for i := 0; i < length; i++ {
+		var key string
+		if key, err = r.readStr(); err != nil {
+			return
+		}
+		switch key {
+		case cdc.anyTypeKey:
+			if hasName {
+				err = errors.Errorf("duplicate key %q", key)
+				return
+			}
+			if name, err = r.readStr(); err != nil {
+				return
+			}
+			hasName = true
+		case "value":
+			if value != nil {
+				err = errors.New(`duplicate key "value"`)
+				return
+			}
+			if value, err = r.skip(); err != nil {
+				return
+			}
+		default:
+			err = errors.Errorf("unexpected key %q in an interface value", key)
+			return
+		}
+	}
+	if !hasName {
+		err = errors.Errorf("missing key %q in an interface value", cdc.anyTypeKey)
+	}
+	return
+}
+
+//----------------------------------------
+// MessagePack writing
+
+func writeMsgpackNil(buf *bytes.Buffer) {
+	buf.WriteByte(0xc0)
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		writeMsgpackUint(buf, uint64(i))
+	case i >= -32:
+		buf.WriteByte(byte(i)) // Negative fixint.
+	case i >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(i)})
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		writeBigEndian(buf, uint64(i), 2)
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		writeBigEndian(buf, uint64(i), 4)
+	default:
+		buf.WriteByte(0xd3)
+		writeBigEndian(buf, uint64(i), 8)
+	}
+}
+
+func writeMsgpackUint(buf *bytes.Buffer, u uint64) {
+	switch {
+	case u < 0x80:
+		buf.WriteByte(byte(u)) // Positive fixint.
+	case u <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(u)})
+	case u <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		writeBigEndian(buf, u, 2)
+	case u <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		writeBigEndian(buf, u, 4)
+	default:
+		buf.WriteByte(0xcf)
+		writeBigEndian(buf, u, 8)
+	}
+}
+
+func writeMsgpackStr(buf *bytes.Buffer, s string) {
+	writeMsgpackLen(buf, len(s), 0xa0, 32, 0xd9)
+	buf.WriteString(s)
+}
+
+func writeMsgpackBin(buf *bytes.Buffer, bz []byte) {
+	writeMsgpackLen(buf, len(bz), 0, 0, 0xc4)
+	buf.Write(bz)
+}
+
+func writeMsgpackArrayHeader(buf *bytes.Buffer, length int) {
+	writeMsgpackLen(buf, length, 0x90, 16, 0xdc)
+}
+
+func writeMsgpackMapHeader(buf *bytes.Buffer, length int) {
+	writeMsgpackLen(buf, length, 0x80, 16, 0xde)
+}
+
+// Writes a length with its fix format (fix|length if length < fixMax), or
+// else with the smallest of the formats starting at code.  Strings, bin and ext
+// have 8, 16 and 32 bit formats, while arrays and maps only have 16 and 32.
+func writeMsgpackLen(buf *bytes.Buffer, length int, fix byte, fixMax int, code byte) {
+	var has8 = code == 0xd9 || code == 0xc4 || code == 0xc7
+	switch {
+	case length < fixMax:
+		buf.WriteByte(fix | byte(length))
+	case has8 && length <= math.MaxUint8:
+		buf.Write([]byte{code, byte(length)})
+	case length <= math.MaxUint16:
+		if has8 {
+			code++
+		}
+		buf.WriteByte(code)
+		writeBigEndian(buf, uint64(length), 2)
+	default:
+		if has8 {
+			code++
+		}
+		buf.WriteByte(code + 1)
+		writeBigEndian(buf, uint64(length), 4)
+	}
+}
+
+func writeMsgpackExt(buf *bytes.Buffer, typ int8, data []byte) {
+	switch len(data) {
+	case 1:
+		buf.WriteByte(0xd4)
+	case 2:
+		buf.WriteByte(0xd5)
+	case 4:
+		buf.WriteByte(0xd6)
+	case 8:
+		buf.WriteByte(0xd7)
+	case 16:
+		buf.WriteByte(0xd8)
+	default:
+		writeMsgpackLen(buf, len(data), 0, 0, 0xc7)
+	}
+	buf.WriteByte(byte(typ))
+	buf.Write(data)
+}
+
+// Writes t with the MessagePack timestamp extension type, in its smallest
+// format.
+func writeMsgpackTime(buf *bytes.Buffer, t time.Time) {
+	var sec, nsec = t.Unix(), int64(t.Nanosecond())
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		var data [4]byte
+		binary.BigEndian.PutUint32(data[:], uint32(sec))
+		writeMsgpackExt(buf, MsgpackExtTimestamp, data[:])
+	case sec>>34 == 0:
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], uint64(nsec)<<34|uint64(sec))
+		writeMsgpackExt(buf, MsgpackExtTimestamp, data[:])
+	default:
+		var data [12]byte
+		binary.BigEndian.PutUint32(data[:4], uint32(nsec))
+		binary.BigEndian.PutUint64(data[4:], uint64(sec))
+		writeMsgpackExt(buf, MsgpackExtTimestamp, data[:])
+	}
+}
+
+func writeBigEndian(buf *bytes.Buffer, u uint64, size int) {
+	for i := size - 1; i >= 0; i-- {
+		buf.WriteByte(byte(u >> (8 * uint(i))))
+	}
+}
+
+//----------------------------------------
+// MessagePack reading
+
+// Reads MessagePack values from the front of bz.
+type msgpackReader struct {
+	bz []byte
+}
+
+// Consumes and returns the next n bytes.
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.bz) < n {
+		return nil, errors.Errorf("unexpected end of MessagePack, wanted %v more bytes but have %v", n, len(r.bz))
+	}
+	bz := r.bz[:n]
+	r.bz = r.bz[n:]
+	return bz, nil
+}
+
+// Consumes and returns the next byte, the format of the next value.
+func (r *msgpackReader) code() (byte, error) {
+	bz, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return bz[0], nil
+}
+
+// Consumes a big-endian unsigned integer of size bytes.
+func (r *msgpackReader) bigEndian(size int) (uint64, error) {
+	bz, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, b := range bz {
+		u = u<<8 | uint64(b)
+	}
+	return u, nil
+}
+
+// Consumes nil and returns true, if next.
+func (r *msgpackReader) readNil() bool {
+	if len(r.bz) > 0 && r.bz[0] == 0xc0 {
+		r.bz = r.bz[1:]
+		return true
+	}
+	return false
+}
+
+func (r *msgpackReader) readBool() (bool, error) {
+	c, err := r.code()
+	switch {
+	case err != nil:
+		return false, err
+	case c == 0xc2:
+		return false, nil
+	case c == 0xc3:
+		return true, nil
+	default:
+		return false, errors.Errorf("expected a MessagePack bool, got format 0x%02x", c)
+	}
+}
+
+func (r *msgpackReader) readInt() (int64, error) {
+	c, err := r.code()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0xcc && c <= 0xcf:
+		u, err := r.bigEndian(1 << (c - 0xcc))
+		if err == nil && u > math.MaxInt64 {
+			err = errors.Errorf("integer %v overflows int64", u)
+		}
+		return int64(u), err
+	case c >= 0xd0 && c <= 0xd3:
+		var size = 1 << (c - 0xd0)
+		u, err := r.bigEndian(size)
+		var shift = uint(64 - 8*size)
+		return int64(u<<shift) >> shift, err // Sign-extend.
+	default:
+		return 0, errors.Errorf("expected a MessagePack integer, got format 0x%02x", c)
+	}
+}
+
+func (r *msgpackReader) readUint() (uint64, error) {
+	if len(r.bz) > 0 && r.bz[0] >= 0xcc && r.bz[0] <= 0xcf {
+		c, _ := r.code()
+		return r.bigEndian(1 << (c - 0xcc))
+	}
+	i, err := r.readInt()
+	if err == nil && i < 0 {
+		err = errors.Errorf("expected an unsigned integer, got %v", i)
+	}
+	return uint64(i), err
+}
+
+func (r *msgpackReader) readFloat() (float64, error) {
+	c, err := r.code()
+	switch {
+	case err != nil:
+		return 0, err
+	case c == 0xca:
+		u, err := r.bigEndian(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case c == 0xcb:
+		u, err := r.bigEndian(8)
+		return math.Float64frombits(u), err
+	default:
+		return 0, errors.Errorf("expected a MessagePack float, got format 0x%02x", c)
+	}
+}
+
+func (r *msgpackReader) readStr() (string, error) {
+	c, err := r.code()
+	if err != nil {
+		return "", err
+	}
+	var length uint64
+	switch {
+	case c >= 0xa0 && c <= 0xbf:
+		length = uint64(c & 0x1f)
+	case c >= 0xd9 && c <= 0xdb:
+		length, err = r.bigEndian(1 << (c - 0xd9))
+	default:
+		return "", errors.Errorf("expected a MessagePack string, got format 0x%02x", c)
+	}
+	if err != nil {
+		return "", err
+	}
+	bz, err := r.next(r.checkLen(length))
+	return string(bz), err
+}
+
+// Returns the bytes of bin, which alias the input.
+func (r *msgpackReader) readBin() ([]byte, error) {
+	c, err := r.code()
+	if err != nil {
+		return nil, err
+	}
+	if c < 0xc4 || c > 0xc6 {
+		return nil, errors.Errorf("expected MessagePack bin, got format 0x%02x", c)
+	}
+	length, err := r.bigEndian(1 << (c - 0xc4))
+	if err != nil {
+		return nil, err
+	}
+	return r.next(r.checkLen(length))
+}
+
+func (r *msgpackReader) readArrayLen() (int, error) {
+	c, err := r.code()
+	switch {
+	case err != nil:
+		return 0, err
+	case c >= 0x90 && c <= 0x9f:
+		return int(c & 0x0f), nil
+	case c == 0xdc || c == 0xdd:
+		length, err := r.bigEndian(2 << (c - 0xdc))
+		if err == nil && r.checkLen(length) < 0 { // Each element is at least a byte.
+			err = errors.Errorf("MessagePack array of %v elements exceeds the bytes left", length)
+		}
+		return int(length), err
+	default:
+		return 0, errors.Errorf("expected a MessagePack array, got format 0x%02x", c)
+	}
+}
+
+func (r *msgpackReader) readMapLen() (int, error) {
+	c, err := r.code()
+	switch {
+	case err != nil:
+		return 0, err
+	case c >= 0x80 && c <= 0x8f:
+		return int(c & 0x0f), nil
+	case c == 0xde || c == 0xdf:
+		length, err := r.bigEndian(2 << (c - 0xde))
+		if err == nil && r.checkLen(2*length) < 0 { // Each entry is at least two bytes.
+			err = errors.Errorf("MessagePack map of %v entries exceeds the bytes left", length)
+		}
+		return int(length), err
+	default:
+		return 0, errors.Errorf("expected a MessagePack map, got format 0x%02x", c)
+	}
+}
+
+// Returns the data of an extension value of type typ.
+func (r *msgpackReader) readExt(typ int8) ([]byte, error) {
+	c, err := r.code()
+	if err != nil {
+		return nil, err
+	}
+	var length uint64
+	switch {
+	case c >= 0xd4 && c <= 0xd8:
+		length = 1 << (c - 0xd4)
+	case c >= 0xc7 && c <= 0xc9:
+		if length, err = r.bigEndian(1 << (c - 0xc7)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("expected a MessagePack extension, got format 0x%02x", c)
+	}
+	t, err := r.code()
+	if err != nil {
+		return nil, err
+	}
+	if int8(t) != typ {
+		return nil, errors.Errorf("expected MessagePack extension type %v, got %v", typ, int8(t))
+	}
+	return r.next(r.checkLen(length))
+}
+
+// Reads a time written with the MessagePack timestamp extension type.
+func (r *msgpackReader) readTime() (time.Time, error) {
+	data, err := r.readExt(MsgpackExtTimestamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var sec, nsec int64
+	switch len(data) {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(data))
+	case 8:
+		u := binary.BigEndian.Uint64(data)
+		sec, nsec = int64(u&(1<<34-1)), int64(u>>34)
+	case 12:
+		nsec = int64(binary.BigEndian.Uint32(data[:4]))
+		sec = int64(binary.BigEndian.Uint64(data[4:]))
+	default:
+		return time.Time{}, errors.Errorf("invalid MessagePack timestamp of %v bytes", len(data))
+	}
+	if nsec >= 1e9 {
+		return time.Time{}, errors.Errorf("invalid MessagePack timestamp with %v nanoseconds", nsec)
+	}
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// Consumes the next value, and returns its encoding.
+func (r *msgpackReader) skip() ([]byte, error) {
+	var start = r.bz
+	var pending = 1 // Values left to skip.
+	for ; pending > 0; pending-- {
+		c, err := r.code()
+		if err != nil {
+			return nil, err
+		}
+		var size uint64
+		switch {
+		case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		case c >= 0x80 && c <= 0x8f:
+			pending += 2 * int(c&0x0f)
+		case c >= 0x90 && c <= 0x9f:
+			pending += int(c & 0x0f)
+		case c >= 0xa0 && c <= 0xbf:
+			size = uint64(c & 0x1f)
+		case c >= 0xc4 && c <= 0xc6:
+			size, err = r.bigEndian(1 << (c - 0xc4))
+		case c >= 0xc7 && c <= 0xc9:
+			size, err = r.bigEndian(1 << (c - 0xc7))
+			size++ // The type.
+		case c == 0xca:
+			size = 4
+		case c == 0xcb:
+			size = 8
+		case c >= 0xcc && c <= 0xcf:
+			size = 1 << (c - 0xcc)
+		case c >= 0xd0 && c <= 0xd3:
+			size = 1 << (c - 0xd0)
+		case c >= 0xd4 && c <= 0xd8:
+			size = 1 + 1<<(c-0xd4)
+		case c >= 0xd9 && c <= 0xdb:
+			size, err = r.bigEndian(1 << (c - 0xd9))
+		case c == 0xdc || c == 0xdd:
+			var length uint64
+			length, err = r.bigEndian(2 << (c - 0xdc))
+			if err == nil && r.checkLen(length) < 0 {
+				err = errors.Errorf("MessagePack array of %v elements exceeds the bytes left", length)
+			}
+			pending += int(length)
+		case c == 0xde || c == 0xdf:
+			var length uint64
+			length, err = r.bigEndian(2 << (c - 0xde))
+			if err == nil && r.checkLen(2*length) < 0 {
+				err = errors.Errorf("MessagePack map of %v entries exceeds the bytes left", length)
+			}
+			pending += 2 * int(length)
+		default:
+			return nil, errors.Errorf("invalid MessagePack format 0x%02x", c)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pending > len(r.bz)+1 {
+			return nil, errors.New("unexpected end of MessagePack")
+		}
+		if _, err = r.next(r.checkLen(size)); err != nil {
+			return nil, err
+		}
+	}
+	return start[:len(start)-len(r.bz)], nil
+}
+
+// Returns length as an int, or -1 (which next() rejects) if more than the
+// bytes left, so that a corrupt length can't cause a large allocation.
+func (r *msgpackReader) checkLen(length uint64) int {
+	if length > uint64(len(r.bz)) {
+		return -1
+	}
+	return int(length)
+}
@@ -0,0 +1,560 @@
+package amino
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Named encoding
+
+// The kinds of namedValue.
+const (
+	namedNil uint8 = iota
+	namedInt
+	namedUint
+	namedFloat
+	namedBool
+	namedString
+	namedBytes
+	namedTime   // Int seconds and Uint nanoseconds since the epoch.
+	namedList   // List.
+	namedStruct // Fields, for structs and maps.
+	namedAny    // The concrete type name in String, and its value in List[0].
+)
+
+// A self-describing value, as written by MarshalBinaryNamed.  Only the
+// fields of its Kind are set.
+type namedValue struct {
+	Kind   uint8
+	Int    int64
+	Uint   uint64
+	Float  float64 `amino:"unsafe"`
+	Bool   bool
+	String string
+	Bytes  []byte
+	List   []namedValue `amino:"empty_elements"`
+	Fields []namedEntry `amino:"empty_elements"`
+}
+
+// A struct field or map entry of a namedValue.
+type namedEntry struct {
+	Name  string
+	Value namedValue
+}
+
+// MarshalBinaryNamed encodes o in binary along with the names of its struct
+// fields (their JSON names) and the kinds of its values, so that it can be
+// decoded without its type, see UnmarshalBinaryNamedGeneric.  This is much
+// larger than MarshalBinaryBare, e.g. for archival or debugging.  Maps are
+// encoded like structs, keyed by their keys as in JSON, and interface values
+// and registered concrete values are wrapped with their names as in JSON.
+// Options which only affect the binary encoding of fields are ignored, and
+// dynamic and union fields are not supported.
+func (cdc *Codec) MarshalBinaryNamed(o interface{}) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv := reflect.ValueOf(o)
+	if !rv.IsValid() {
+		return cdc.MarshalBinaryBare(namedValue{})
+	}
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	nv, err := cdc.toNamedValue(info, rv, FieldOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if info.Registered && nv.Kind != namedNil {
+		nv = namedValue{Kind: namedAny, String: info.Name, List: []namedValue{nv}}
+	}
+	return cdc.MarshalBinaryBare(nv)
+}
+
+// UnmarshalBinaryNamed decodes bz, as written by MarshalBinaryNamed, into
+// ptr.  Struct fields are matched by name, and names which match no field
+// are ignored.
+func (cdc *Codec) UnmarshalBinaryNamed(bz []byte, ptr interface{}) (err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr {
+		return ErrNoPointer
+	}
+	rv = rv.Elem()
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return err
+	}
+	var nv namedValue
+	if err = cdc.UnmarshalBinaryBare(bz, &nv); err != nil {
+		return err
+	}
+	if info.Registered && nv.Kind != namedNil {
+		if nv.Kind != namedAny || len(nv.List) != 1 {
+			return errors.Errorf("expected a named value of %v", info.Name)
+		}
+		if nv.String != info.Name {
+			return errors.Errorf("wanted to decode %v but found %v", info.Name, nv.String)
+		}
+		nv = nv.List[0]
+	}
+	return cdc.fromNamedValue(nv, info, rv, FieldOptions{}, decodeOptions{})
+}
+
+// UnmarshalBinaryNamedGeneric decodes bz, as written by MarshalBinaryNamed
+// for a struct, into a map of its field names to their values, without
+// knowing its type.  Values are nil, int64, uint64, float64, bool, string,
+// []byte, time.Time, []interface{} or map[string]interface{} (for structs
+// and maps).  Interface values and registered concrete values are maps of
+// the type key (see SetAnyTypeKey) to their name and "value" to their value.
+func (cdc *Codec) UnmarshalBinaryNamedGeneric(bz []byte) (m map[string]interface{}, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	var nv namedValue
+	if err = cdc.UnmarshalBinaryBare(bz, &nv); err != nil {
+		return nil, err
+	}
+	if nv.Kind != namedStruct && nv.Kind != namedAny {
+		return nil, errors.Errorf("expected a named struct, got kind %v", nv.Kind)
+	}
+	var v interface{}
+	if v, err = cdc.namedGeneric(nv); err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+func (cdc *Codec) toNamedValue(info *TypeInfo, rv reflect.Value, fopts FieldOptions) (nv namedValue, err error) {
+	// Dereference value if pointer.
+	var isNilPtr bool
+	rv, _, isNilPtr = derefPointers(rv)
+	if isNilPtr {
+		return
+	}
+
+	if info.Type == timeType {
+		t := rv.Interface().(time.Time)
+		return namedValue{Kind: namedTime, Int: t.Unix(), Uint: uint64(t.Nanosecond())}, nil
+	}
+
+	if info.IsAminoMarshaler {
+		var (
+			rrv   reflect.Value
+			rinfo *TypeInfo
+		)
+		if rrv, err = toReprObject(rv); err != nil {
+			return
+		}
+		if rinfo, err = cdc.getTypeInfoWlock(info.AminoMarshalReprType); err != nil {
+			return
+		}
+		return cdc.toNamedValue(rinfo, rrv, fopts)
+	}
+
+	switch info.Type.Kind() {
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			return
+		}
+		var crv, _, isNilPtr = derefPointers(rv.Elem())
+		if isNilPtr {
+			err = errors.Errorf("illegal nil-pointer of type %v for interface %v", crv.Type(), info.Type)
+			return
+		}
+		var crt = crv.Type()
+		var cinfo *TypeInfo
+		if cinfo, err = cdc.getTypeInfoWlock(crt); err != nil {
+			return
+		}
+		if !cinfo.Registered {
+			var ok bool
+			if crv, cinfo, ok = cdc.toStdError(info, rv); !ok {
+				err = ErrUnregisteredConcrete{crt}
+				return
+			}
+		}
+		var cnv namedValue
+		if cnv, err = cdc.toNamedValue(cinfo, crv, fopts); err != nil {
+			return
+		}
+		return namedValue{Kind: namedAny, String: cinfo.Name, List: []namedValue{cnv}}, nil
+
+	case reflect.Array, reflect.Slice:
+		if info.Type.Elem().Kind() == reflect.Uint8 {
+			nv = namedValue{Kind: namedBytes, Bytes: make([]byte, rv.Len())}
+			reflect.Copy(reflect.ValueOf(nv.Bytes), rv)
+			return
+		}
+		var einfo *TypeInfo
+		if einfo, err = cdc.getTypeInfoWlock(info.Type.Elem()); err != nil {
+			return
+		}
+		nv = namedValue{Kind: namedList, List: make([]namedValue, rv.Len())}
+		for i := 0; i < rv.Len(); i++ {
+			if nv.List[i], err = cdc.toNamedValue(einfo, rv.Index(i), fopts); err != nil {
+				return
+			}
+		}
+		return
+
+	case reflect.Struct:
+		nv = namedValue{Kind: namedStruct, Fields: make([]namedEntry, len(info.Fields))}
+		for i, field := range info.Fields {
+			if field.DynamicResolver != nil || field.union != nil {
+				err = errors.Errorf("field %v of %v is not supported in the named encoding", field.Name, info.Type)
+				return
+			}
+			var finfo *TypeInfo
+			if finfo, err = cdc.getTypeInfoWlock(field.Type); err != nil {
+				return
+			}
+			nv.Fields[i].Name = field.JSONName
+			if nv.Fields[i].Value, err = cdc.toNamedValue(finfo, field.valueOf(rv), field.FieldOptions); err != nil {
+				return
+			}
+		}
+		return
+
+	case reflect.Map:
+		if !isJSONMapKeyKind(info.Type.Key().Kind()) {
+			err = errors.New("toNamedValue: map key type must be a string, integer or bool")
+			return
+		}
+		var vinfo *TypeInfo
+		if vinfo, err = cdc.getTypeInfoWlock(info.Type.Elem()); err != nil {
+			return
+		}
+		krvs := rv.MapKeys()
+		sortJSONMapKeys(krvs)
+		nv = namedValue{Kind: namedStruct, Fields: make([]namedEntry, len(krvs))}
+		for i, krv := range krvs {
+			nv.Fields[i].Name = jsonMapKeyString(krv)
+			if nv.Fields[i].Value, err = cdc.toNamedValue(vinfo, rv.MapIndex(krv), fopts); err != nil {
+				return
+			}
+		}
+		return
+
+	case reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Int:
+		return namedValue{Kind: namedInt, Int: rv.Int()}, nil
+
+	case reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8, reflect.Uint:
+		return namedValue{Kind: namedUint, Uint: rv.Uint()}, nil
+
+	case reflect.Float64, reflect.Float32:
+		if !fopts.Unsafe {
+			err = errors.New("amino named float* support requires `amino:\"unsafe\"`")
+			return
+		}
+		return namedValue{Kind: namedFloat, Float: rv.Float()}, nil
+
+	case reflect.Bool:
+		return namedValue{Kind: namedBool, Bool: rv.Bool()}, nil
+
+	case reflect.String:
+		return namedValue{Kind: namedString, String: rv.String()}, nil
+
+	default:
+		panic(fmt.Sprintf("unsupported type %v", info.Type.Kind()))
+	}
+}
+
+func (cdc *Codec) fromNamedValue(nv namedValue, info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, dopts decodeOptions) (err error) {
+	if !rv.CanAddr() {
+		panic(internalError("rv not addressable"))
+	}
+
+	// Special case for nil, for either interface, pointer or slice.
+	if nv.Kind == namedNil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return
+	}
+
+	// Dereference-and-construct pointers all the way.
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(cdc.newValue(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+
+	if info.Type == timeType {
+		if err = nv.checkKind(namedTime, info); err != nil {
+			return
+		}
+		if nv.Uint > maxNanos {
+			return InvalidTimeErr(fmt.Sprintf("nanoseconds not in interval [0, 999999999] %v", nv.Uint))
+		}
+		if nv.Int > maxTimeNanosSeconds {
+			return InvalidTimeErr(fmt.Sprintf("seconds have to be <= %d, got: %d", maxTimeNanosSeconds, nv.Int))
+		}
+		rv.Set(reflect.ValueOf(time.Unix(nv.Int, int64(nv.Uint)).UTC()))
+		return
+	}
+
+	if info.IsAminoUnmarshaler {
+		rrv := reflect.New(info.AminoUnmarshalReprType).Elem()
+		var rinfo *TypeInfo
+		if rinfo, err = cdc.getTypeInfoWlock(info.AminoUnmarshalReprType); err != nil {
+			return
+		}
+		if err = cdc.fromNamedValue(nv, rinfo, rrv, fopts, dopts); err != nil {
+			return
+		}
+		uwouts := rv.Addr().MethodByName("UnmarshalAmino").Call([]reflect.Value{rrv})
+		if erri := uwouts[0].Interface(); erri != nil {
+			err = erri.(error)
+		}
+		return
+	}
+
+	// Count nested values, see SetMaxDecodeDepth().
+	if dopts, err = cdc.enterComplex(info.Type.Kind(), dopts); err != nil {
+		return
+	}
+
+	switch info.Type.Kind() {
+
+	case reflect.Interface:
+		if err = nv.checkKind(namedAny, info); err != nil {
+			return
+		}
+		if len(nv.List) != 1 {
+			return errors.Errorf("expected one value of %v, got %v", nv.String, len(nv.List))
+		}
+		var cinfo *TypeInfo
+		if cinfo, err = cdc.getTypeInfoFromNameRlock(nv.String); err != nil {
+			return
+		}
+		if !cinfo.PtrToType.Implements(info.Type) {
+			return errors.Errorf("expected concrete type %v to implement %v", cinfo.Type, info.Type)
+		}
+		if err = dopts.checkAllowed(cinfo.Type); err != nil {
+			return
+		}
+		if dopts, err = cdc.enterAny(dopts); err != nil {
+			return
+		}
+		crv, irvSet := cdc.constructConcreteType(cinfo)
+		if err = cdc.fromNamedValue(nv.List[0], cinfo, crv, fopts, dopts); err != nil {
+			return
+		}
+		rv.Set(irvSet)
+
+	case reflect.Array, reflect.Slice:
+		if info.Type.Elem().Kind() == reflect.Uint8 {
+			if err = nv.checkKind(namedBytes, info); err != nil {
+				return
+			}
+			if info.Type.Kind() == reflect.Array {
+				if len(nv.Bytes) != info.Type.Len() {
+					return errors.Errorf("expected %v bytes for %v, got %v", info.Type.Len(), info.Type, len(nv.Bytes))
+				}
+				reflect.Copy(rv, reflect.ValueOf(nv.Bytes))
+			} else if len(nv.Bytes) == 0 {
+				rv.Set(info.ZeroValue) // NOTE: We prefer nil slices.
+			} else {
+				rv.Set(reflect.ValueOf(nv.Bytes).Convert(info.Type))
+			}
+			return
+		}
+		if err = nv.checkKind(namedList, info); err != nil {
+			return
+		}
+		if info.Type.Kind() == reflect.Array {
+			if len(nv.List) != info.Type.Len() {
+				return errors.Errorf("expected %v elements for %v, got %v", info.Type.Len(), info.Type, len(nv.List))
+			}
+		} else if len(nv.List) == 0 {
+			rv.Set(info.ZeroValue) // NOTE: We prefer nil slices.
+			return
+		} else {
+			rv.Set(reflect.MakeSlice(info.Type, len(nv.List), len(nv.List)))
+		}
+		var einfo *TypeInfo
+		if einfo, err = cdc.getTypeInfoWlock(info.Type.Elem()); err != nil {
+			return
+		}
+		for i, env := range nv.List {
+			if err = cdc.fromNamedValue(env, einfo, rv.Index(i), fopts, dopts); err != nil {
+				return
+			}
+		}
+
+	case reflect.Struct:
+		if err = nv.checkKind(namedStruct, info); err != nil {
+			return
+		}
+		rv.Set(reflect.Zero(info.Type))
+		var seen = make(map[string]bool, len(nv.Fields))
+	ENTRIES:
+		for _, entry := range nv.Fields {
+			if seen[entry.Name] {
+				return errors.Errorf("duplicate field %q for %v", entry.Name, info.Type)
+			}
+			seen[entry.Name] = true
+			for _, field := range info.Fields {
+				if field.JSONName != entry.Name {
+					continue
+				}
+				if field.DynamicResolver != nil || field.union != nil {
+					return errors.Errorf("field %v of %v is not supported in the named encoding", field.Name, info.Type)
+				}
+				var finfo *TypeInfo
+				if finfo, err = cdc.getTypeInfoWlock(field.Type); err != nil {
+					return
+				}
+				if err = cdc.fromNamedValue(entry.Value, finfo, field.valueOf(rv), field.FieldOptions, dopts); err != nil {
+					return errors.Wrapf(err, "field %v of %v", field.Name, info.Type)
+				}
+				continue ENTRIES
+			}
+			// Ignore unknown fields.
+		}
+
+	case reflect.Map:
+		if err = nv.checkKind(namedStruct, info); err != nil {
+			return
+		}
+		if len(nv.Fields) == 0 {
+			rv.Set(info.ZeroValue) // NOTE: We prefer nil maps.
+			return
+		}
+		var vinfo *TypeInfo
+		if vinfo, err = cdc.getTypeInfoWlock(info.Type.Elem()); err != nil {
+			return
+		}
+		var mrv = reflect.MakeMapWithSize(info.Type, len(nv.Fields))
+		for _, entry := range nv.Fields {
+			var krv reflect.Value
+			if krv, err = decodeJSONMapKey(entry.Name, info.Type.Key()); err != nil {
+				return
+			}
+			if mrv.MapIndex(krv).IsValid() {
+				return errors.Errorf("duplicate key %q for %v", entry.Name, info.Type)
+			}
+			var vrv = reflect.New(info.Type.Elem()).Elem()
+			if err = cdc.fromNamedValue(entry.Value, vinfo, vrv, fopts, dopts); err != nil {
+				return
+			}
+			mrv.SetMapIndex(krv, vrv)
+		}
+		rv.Set(mrv)
+
+	case reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Int:
+		if err = nv.checkKind(namedInt, info); err != nil {
+			return
+		}
+		if rv.OverflowInt(nv.Int) {
+			return errors.Errorf("integer %v overflows %v", nv.Int, info.Type)
+		}
+		rv.SetInt(nv.Int)
+
+	case reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8, reflect.Uint:
+		if err = nv.checkKind(namedUint, info); err != nil {
+			return
+		}
+		if rv.OverflowUint(nv.Uint) {
+			return errors.Errorf("integer %v overflows %v", nv.Uint, info.Type)
+		}
+		rv.SetUint(nv.Uint)
+
+	case reflect.Float64, reflect.Float32:
+		if !fopts.Unsafe {
+			return errors.New("amino named float* support requires `amino:\"unsafe\"`")
+		}
+		if err = nv.checkKind(namedFloat, info); err != nil {
+			return
+		}
+		rv.SetFloat(nv.Float)
+
+	case reflect.Bool:
+		if err = nv.checkKind(namedBool, info); err != nil {
+			return
+		}
+		rv.SetBool(nv.Bool)
+
+	case reflect.String:
+		if err = nv.checkKind(namedString, info); err != nil {
+			return
+		}
+		rv.SetString(nv.String)
+
+	default:
+		panic(fmt.Sprintf("unsupported type %v", info.Type.Kind()))
+	}
+	return
+}
+
+// Returns nv as a value of a generic type, see UnmarshalBinaryNamedGeneric.
+func (cdc *Codec) namedGeneric(nv namedValue) (v interface{}, err error) {
+	switch nv.Kind {
+	case namedNil:
+		return nil, nil
+	case namedInt:
+		return nv.Int, nil
+	case namedUint:
+		return nv.Uint, nil
+	case namedFloat:
+		return nv.Float, nil
+	case namedBool:
+		return nv.Bool, nil
+	case namedString:
+		return nv.String, nil
+	case namedBytes:
+		return nv.Bytes, nil
+	case namedTime:
+		if nv.Uint > maxNanos || nv.Int > maxTimeNanosSeconds {
+			return nil, InvalidTimeErr(fmt.Sprintf("%v seconds and %v nanoseconds", nv.Int, nv.Uint))
+		}
+		return time.Unix(nv.Int, int64(nv.Uint)).UTC(), nil
+	case namedList:
+		var list = make([]interface{}, len(nv.List))
+		for i, env := range nv.List {
+			if list[i], err = cdc.namedGeneric(env); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case namedStruct:
+		var m = make(map[string]interface{}, len(nv.Fields))
+		for _, entry := range nv.Fields {
+			if _, ok := m[entry.Name]; ok {
+				return nil, errors.Errorf("duplicate field %q", entry.Name)
+			}
+			if m[entry.Name], err = cdc.namedGeneric(entry.Value); err != nil {
+				return nil, errors.Wrapf(err, "field %v", entry.Name)
+			}
+		}
+		return m, nil
+	case namedAny:
+		if len(nv.List) != 1 {
+			return nil, errors.Errorf("expected one value of %v, got %v", nv.String, len(nv.List))
+		}
+		var value interface{}
+		if value, err = cdc.namedGeneric(nv.List[0]); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{cdc.anyTypeKey: nv.String, "value": value}, nil
+	default:
+		return nil, errors.Errorf("unknown named value kind %v", nv.Kind)
+	}
+}
+
+// Returns an error unless nv is of the kind, for decoding into info.
+func (nv namedValue) checkKind(kind uint8, info *TypeInfo) error {
+	if nv.Kind != kind {
+		return errors.Errorf("expected named value kind %v for %v, got %v", kind, info.Type, nv.Kind)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package amino
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+//----------------------------------------
+// Field presence
+
+// MarshalBinaryWithPresence encodes the struct o like MarshalBinaryBare,
+// but with explicit field presence: only the fields whose numbers are in
+// present are written, even if they hold zero values, and the encoding is
+// preceded by a byte-length prefixed presence bitmap, where bit i (least
+// significant first) of byte i/8 is set iff field number i+1 is present.
+// Use UnmarshalBinaryWithPresence to decode it.
+func (cdc *Codec) MarshalBinaryWithPresence(o interface{}, present map[uint32]bool) (bz []byte, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv, _, isNilPtr := derefPointers(reflect.ValueOf(o))
+	if isNilPtr {
+		return nil, errors.New("MarshalBinaryWithPresence cannot marshal a nil pointer")
+	}
+	info, err := cdc.getFieldRecordsTypeInfo(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	var nums []uint32
+	for num, ok := range present {
+		if ok {
+			nums = append(nums, num)
+		}
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	for _, num := range nums {
+		if _, ok := fieldByNum(info, num); !ok {
+			return nil, fmt.Errorf("%v has no field # %v", info.Type, num)
+		}
+	}
+	var bitmap []byte
+	if len(nums) > 0 {
+		bitmap = make([]byte, (nums[len(nums)-1]+7)/8)
+	}
+	buf := new(bytes.Buffer)
+	for _, num := range nums {
+		field, _ := fieldByNum(info, num)
+		bitmap[(num-1)/8] |= 1 << ((num - 1) % 8)
+		field.WriteEmpty = true // Zero values are present too.
+		err = cdc.encodeReflectBinaryStructField(buf, field, rv, FieldOptions{BinFieldNum: 1}, encodeOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding field %v", field.Name)
+		}
+	}
+
+	out := new(bytes.Buffer)
+	err = EncodeByteSlice(out, bitmap)
+	if err != nil {
+		return nil, err
+	}
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+// UnmarshalBinaryWithPresence decodes bz, as written by
+// MarshalBinaryWithPresence, into the struct pointed to by ptr, and returns
+// the numbers of the fields which were present.  Fields which were not
+// present are left zero.
+func (cdc *Codec) UnmarshalBinaryWithPresence(bz []byte, ptr interface{}) (present map[uint32]bool, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, errors.Errorf("UnmarshalBinaryWithPresence expects a pointer, got %T", ptr)
+	}
+	rv = rv.Elem()
+	info, err := cdc.getFieldRecordsTypeInfo(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	bitmap, n, err := cdc.decodeByteSlice(bz)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding presence bitmap")
+	}
+	if max := maxPresenceBitmapLen(info); len(bitmap) > max {
+		return nil, fmt.Errorf("presence bitmap of %v bytes is longer than the %v bytes of %v",
+			len(bitmap), max, info.Type)
+	}
+	present = make(map[uint32]bool)
+	for i, b := range bitmap {
+		for j := uint32(0); j < 8; j++ {
+			if b&(1<<j) == 0 {
+				continue
+			}
+			num := uint32(i)*8 + j + 1
+			if _, ok := fieldByNum(info, num); !ok {
+				return nil, fmt.Errorf("%v has no field # %v", info.Type, num)
+			}
+			present[num] = true
+		}
+	}
+	_, err = cdc.decodeReflectBinary(bz[n:], info, rv, FieldOptions{BinFieldNum: 1}, true, decodeOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return present, nil
+}
+
+// Returns the length of the presence bitmap of struct info with all its
+// fields present.
+func maxPresenceBitmapLen(info *TypeInfo) int {
+	var max uint32
+	for _, field := range info.Fields {
+		if field.BinFieldNum > max {
+			max = field.BinFieldNum
+		}
+	}
+	return int((max + 7) / 8)
+}
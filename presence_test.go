@@ -0,0 +1,65 @@
+package amino_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+func TestMarshalBinaryWithPresence(t *testing.T) {
+	type Settings struct {
+		Volume  int32
+		Muted   bool
+		Name    string
+		Ratings []int64
+	}
+
+	cdc := amino.NewCodec()
+	s := Settings{Volume: 0, Muted: true, Name: "x"}
+	bz, err := cdc.MarshalBinaryWithPresence(s, map[uint32]bool{1: true, 2: true})
+	require.NoError(t, err)
+	// The bitmap, then only the present fields, including the zero Volume.
+	assert.Equal(t, []byte{0x01, 0x03, 0x08, 0x00, 0x10, 0x01}, bz)
+
+	var s2 Settings
+	present, err := cdc.UnmarshalBinaryWithPresence(bz, &s2)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32]bool{1: true, 2: true}, present)
+	assert.Equal(t, Settings{Muted: true}, s2)
+
+	// A zero field which is absent is distinguishable from a present one.
+	bz, err = cdc.MarshalBinaryWithPresence(s, map[uint32]bool{2: true, 3: true})
+	require.NoError(t, err)
+	s2 = Settings{}
+	present, err = cdc.UnmarshalBinaryWithPresence(bz, &s2)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32]bool{2: true, 3: true}, present)
+	assert.False(t, present[1])
+	assert.Equal(t, Settings{Muted: true, Name: "x"}, s2)
+
+	// Nothing present.
+	bz, err = cdc.MarshalBinaryWithPresence(&s, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00}, bz)
+	present, err = cdc.UnmarshalBinaryWithPresence(bz, &s2)
+	require.NoError(t, err)
+	assert.Empty(t, present)
+	assert.Equal(t, Settings{}, s2)
+
+	// Unknown field numbers are rejected.
+	_, err = cdc.MarshalBinaryWithPresence(s, map[uint32]bool{5: true})
+	assert.Error(t, err)
+	_, err = cdc.UnmarshalBinaryWithPresence([]byte{0x01, 0x10}, &s2)
+	assert.Error(t, err)
+
+	// Before allocating a bitmap for them.
+	_, err = cdc.MarshalBinaryWithPresence(s, map[uint32]bool{1: true, 1<<29 - 1: true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has no field # 536870911")
+	_, err = cdc.UnmarshalBinaryWithPresence([]byte{0x02, 0x00, 0x00}, &s2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "longer than the 1 bytes")
+}
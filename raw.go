@@ -0,0 +1,45 @@
+package amino
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//----------------------------------------
+// Raw types
+
+// RegisterRawType makes the binary encoding of the struct type rt, which
+// must have a single field, of a byte slice type, be the bytes of that
+// field, verbatim.  As a struct field, they are thus written like any
+// nested struct, i.e. length-prefixed, so rt can hold a message encoded by
+// another system (e.g. foreign protobuf) and nest it without re-encoding.
+// The bytes are not interpreted on decoding.  JSON is unaffected.
+func (cdc *Codec) RegisterRawType(rt reflect.Type) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+	if rt.Kind() != reflect.Struct || rt == timeType || info.IsAminoMarshaler || info.IsAminoUnmarshaler ||
+		rt.NumField() != 1 || len(info.Fields) != 1 || !isByteSliceType(rt.Field(0).Type) {
+		panic(fmt.Sprintf("RegisterRawType expects a struct with a single byte slice field, got %v", rt))
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		info.Raw = true
+	}()
+}
+
+func isByteSliceType(rt reflect.Type) bool {
+	return rt.Kind() == reflect.Slice && rt.Elem().Kind() == reflect.Uint8
+}
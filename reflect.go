@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
 	"time"
 )
 
@@ -14,6 +15,9 @@ const printLog = false
 
 var (
 	timeType            = reflect.TypeOf(time.Time{})
+	jsonNumberType      = reflect.TypeOf(json.Number(""))
+	jsonNumberReprType  = reflect.TypeOf(jsonNumberRepr{})
+	jsonRawMessageType  = reflect.TypeOf(json.RawMessage(nil))
 	jsonMarshalerType   = reflect.TypeOf(new(json.Marshaler)).Elem()
 	jsonUnmarshalerType = reflect.TypeOf(new(json.Unmarshaler)).Elem()
 	errorType           = reflect.TypeOf(new(error)).Elem()
@@ -23,6 +27,35 @@ var (
 // encode: see binary-encode.go and json-encode.go
 // decode: see binary-decode.go and json-decode.go
 
+//----------------------------------------
+// json.Number
+
+// jsonNumberRepr is the Amino:binary form of a json.Number, like a proto3
+// oneof: nonzero integers which fit in an int64 are written as a varint,
+// and any other number as its text (since a pointer to zero would be
+// omitted), so that it decodes to exactly the same text.  (Amino:JSON
+// writes the number text as is.)
+type jsonNumberRepr struct {
+	Int  *int64
+	Text string
+}
+
+func toJSONNumberRepr(s string) (repr jsonNumberRepr) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil && i != 0 && strconv.FormatInt(i, 10) == s {
+		repr.Int = &i
+	} else {
+		repr.Text = s
+	}
+	return
+}
+
+func fromJSONNumberRepr(repr jsonNumberRepr) json.Number {
+	if repr.Int != nil {
+		return json.Number(strconv.FormatInt(*repr.Int, 10))
+	}
+	return json.Number(repr.Text)
+}
+
 //----------------------------------------
 // Misc.
 
@@ -125,6 +158,14 @@ func isDefaultValue(rv reflect.Value) (erv reflect.Value, isDefaultValue bool) {
 	}
 }
 
+// Returns true if the struct field frv is a slice tagged
+// `amino:"nil_as_empty"`, so that nil and empty values are the same: both
+// are omitted when encoding, even if WriteEmpty, and decoded as an empty
+// non-nil slice.  This way both round-trip to a DeepEqual value.
+func nilAsEmpty(field FieldInfo, frv reflect.Value) bool {
+	return field.NilAsEmpty && frv.Kind() == reflect.Slice
+}
+
 // Returns the default value of a type.  For a time type or a pointer(s) to
 // time, the default value is not zero (or nil), but the time value of 1970.
 func defaultValue(rt reflect.Type) (rv reflect.Value) {
@@ -175,21 +216,123 @@ func isNil(rv reflect.Value) bool {
 // constructConcreteType creates the concrete value as
 // well as the corresponding settable value for it.
 // Return irvSet which should be set on caller's interface rv.
-func constructConcreteType(cinfo *TypeInfo) (crv, irvSet reflect.Value) {
+func (cdc *Codec) constructConcreteType(cinfo *TypeInfo) (crv, irvSet reflect.Value) {
 	// Construct new concrete type.
 	if cinfo.PointerPreferred {
-		cPtrRv := reflect.New(cinfo.Type)
+		cPtrRv := cdc.newValue(cinfo.Type)
 		crv = cPtrRv.Elem()
 		irvSet = cPtrRv
 	} else {
-		crv = reflect.New(cinfo.Type).Elem()
+		crv = cdc.newValue(cinfo.Type).Elem()
 		irvSet = crv
 	}
 	return
 }
 
+// newValue is like reflect.New, but uses the allocator when decoding, see
+// SetAllocator().
+func (cdc *Codec) newValue(rt reflect.Type) reflect.Value {
+	if cdc.allocator == nil {
+		return reflect.New(rt)
+	}
+	ptr := cdc.allocator(rt)
+	if ptr.Type() != reflect.PtrTo(rt) || ptr.IsNil() {
+		panic(fmt.Sprintf("allocator returned %v for %v, expected a non-nil %v", ptr, rt, reflect.PtrTo(rt)))
+	}
+	return ptr
+}
+
+// Returns the struct type of the entries of the map type rt, which are
+// encoded in its place, see encodeReflectBinaryMap().
+func mapEntryType(rt reflect.Type) reflect.Type {
+	return reflect.StructOf([]reflect.StructField{
+		{Name: "Key", Type: rt.Key()},
+		{Name: "Value", Type: rt.Elem()},
+	})
+}
+
+// Returns the infos of the key and entry types of the map type of info, or
+// an error if its keys are not strings, integers or bools.
+func (cdc *Codec) getMapEntryTypeInfo(info *TypeInfo) (kinfo, einfo *TypeInfo, err error) {
+	var rt = info.Type
+	switch rt.Key().Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Int,
+		reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8, reflect.Uint:
+	default:
+		return nil, nil, fmt.Errorf("unsupported map key type %v of %v", rt.Key(), rt)
+	}
+	kinfo, err = cdc.getTypeInfoWlock(rt.Key())
+	if err != nil {
+		return
+	}
+	einfo, err = cdc.getTypeInfoWlock(info.EntryType)
+	return
+}
+
+// Returns whether `amino:"gzip"` applies to values of type rt.
+func isGzipKind(rt reflect.Type) bool {
+	return rt.Kind() == reflect.String || (rt.Kind() == reflect.Slice && rt.Elem().Kind() == reflect.Uint8)
+}
+
+// WireTypeFor returns the Typ3 with which amino would binary encode a field
+// of type rt with options opts, e.g. for schema generators.  Pointers are
+// encoded as what they point to.  Returns an error for types amino can't
+// encode, e.g. channels and functions.  It ignores codec registrations, see
+// Codec.WireTypeFor() for the wire type of a particular codec.
+func WireTypeFor(rt reflect.Type, opts FieldOptions) (Typ3, error) {
+	if rt == nil {
+		return 0, fmt.Errorf("no wire type for nil type")
+	}
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	switch rt.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128,
+		reflect.Uintptr, reflect.UnsafePointer, reflect.Invalid:
+		return 0, fmt.Errorf("unsupported field type %v", rt)
+	}
+	return kindToTyp3(rt, opts), nil
+}
+
+// WireTypeFor is like the package function WireTypeFor(), but accounts for
+// types registered with cdc, e.g. by RegisterIntCodec().
+func (cdc *Codec) WireTypeFor(rt reflect.Type, opts FieldOptions) (Typ3, error) {
+	typ3, err := WireTypeFor(rt, opts)
+	if err != nil {
+		return 0, err
+	}
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		return 0, err
+	}
+	if usesIntCodec(info, opts) {
+		return Typ3ByteLength, nil
+	}
+	return typ3, nil
+}
+
+// CONTRACT: info.Type.Kind() != reflect.Ptr
+func typeToTyp3(info *TypeInfo, opts FieldOptions) Typ3 {
+	if usesIntCodec(info, opts) {
+		return Typ3ByteLength
+	}
+	return kindToTyp3(info.Type, opts)
+}
+
+// Like typeToTyp3(), but ignores codecs registered for rt, so that the
+// packing of lists only depends on the element type.
 // CONTRACT: rt.Kind() != reflect.Ptr
-func typeToTyp3(rt reflect.Type, opts FieldOptions) Typ3 {
+func kindToTyp3(rt reflect.Type, opts FieldOptions) Typ3 {
+	if rt == timeType && (opts.UnixMillis || opts.DateOnly) {
+		return Typ3Varint
+	}
+	if isBinaryMarshalerType(rt) {
+		return Typ3ByteLength
+	}
 	switch rt.Kind() {
 	case reflect.Interface:
 		return Typ3ByteLength
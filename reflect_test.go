@@ -1,6 +1,7 @@
 package amino
 
 import (
+	"bytes"
 	"math/rand"
 	"reflect"
 	"runtime/debug"
@@ -140,6 +141,91 @@ func _testDeepCopy(t *testing.T, rt reflect.Type) {
 	}
 }
 
+func TestSizeBinaryStruct(t *testing.T) {
+	for _, ptr := range tests.StructTypes {
+		rt := getTypeFromPointer(ptr)
+		t.Run(rt.Name()+":size", func(t *testing.T) { _testSizeBinary(t, rt) })
+	}
+}
+
+func TestSizeBinaryDef(t *testing.T) {
+	for _, ptr := range tests.DefTypes {
+		rt := getTypeFromPointer(ptr)
+		t.Run(rt.Name()+":size", func(t *testing.T) { _testSizeBinary(t, rt) })
+	}
+}
+
+func _testSizeBinary(t *testing.T, rt reflect.Type) {
+
+	cdc := NewCodec()
+	f := fuzz.New()
+	rv := reflect.New(rt)
+	ptr := rv.Interface()
+	rnd := rand.New(rand.NewSource(10))
+	f.RandSource(rnd)
+	f.Funcs(fuzzFuncs...)
+
+	for i := 0; i < 1e3; i++ {
+		f.Fuzz(ptr)
+
+		bz := cdc.MustMarshalBinaryBare(ptr)
+		size, err := cdc.SizeBinary(ptr)
+		require.NoError(t, err)
+		require.Equal(t, len(bz), size, "size mismatch for %v", spw(ptr))
+
+		// MarshalBinaryLengthPrefixedWriter writes its length prefix from
+		// SizeBinary.
+		w := new(bytes.Buffer)
+		_, err = cdc.MarshalBinaryLengthPrefixedWriter(w, ptr)
+		require.NoError(t, err)
+		require.Equal(t, cdc.MustMarshalBinaryLengthPrefixed(ptr), w.Bytes(), "stream mismatch for %v", spw(ptr))
+	}
+}
+
+func TestSizeBinaryRegistered(t *testing.T) {
+	type Mixed struct {
+		Small  int8
+		When   time.Time
+		Fixed  uint64 `binary:"fixed64"`
+		Iface  tests.Interface1
+		Ifaces []tests.Interface1
+		Counts map[string]int64
+		Ptr    *tests.InterfaceFieldsStruct
+	}
+
+	cdc := NewCodec()
+	cdc.RegisterInterface((*tests.Interface1)(nil), &InterfaceOptions{AlwaysDisambiguate: true})
+	cdc.RegisterConcrete(tests.Concrete1{}, "Concrete1", nil)
+	cdc.RegisterConcrete(tests.ConcreteTypeDef{}, "ConcreteTypeDef", nil)
+	cdc.RegisterConcrete(tests.ConcreteWrappedBytes{}, "ConcreteWrappedBytes", nil)
+	cdc.RegisterConcrete(&tests.InterfaceFieldsStruct{}, "InterfaceFieldsStruct", nil)
+
+	cases := []interface{}{
+		tests.ConcreteTypeDef{0x01, 0x02},
+		tests.ConcreteWrappedBytes{Value: []byte("0123")},
+		&tests.InterfaceFieldsStruct{F1: tests.Concrete1{}, F2: tests.ConcreteTypeDef{0x03}},
+		Mixed{},
+		Mixed{
+			Small:  -3,
+			When:   time.Unix(1234567, 89).UTC(),
+			Fixed:  7,
+			Iface:  tests.ConcreteWrappedBytes{Value: make([]byte, 300)},
+			Ifaces: []tests.Interface1{tests.Concrete1{}, tests.ConcreteTypeDef{}, &tests.InterfaceFieldsStruct{}},
+			Counts: map[string]int64{"a": 1, "": 0, "ccc": -5},
+			Ptr:    &tests.InterfaceFieldsStruct{F2: tests.Concrete1{}},
+		},
+		int8(-100),
+		"hello",
+		[]string{"a", "", "bc"},
+	}
+	for i, o := range cases {
+		bz := cdc.MustMarshalBinaryBare(o)
+		size, err := cdc.SizeBinary(o)
+		require.NoError(t, err, "case %v", i)
+		assert.Equal(t, len(bz), size, "case %v: %v", i, spw(o))
+	}
+}
+
 //----------------------------------------
 // Register/interface tests
 
@@ -594,3 +680,35 @@ func randString(r fuzz.Continue) string {
 	}
 	return string(runes)
 }
+
+func TestMapEntryTypeInfo(t *testing.T) {
+	type Ledger struct {
+		Balances map[string]int64
+		Names    []string
+	}
+
+	cdc := NewCodec()
+	info, err := cdc.getTypeInfoWlock(reflect.TypeOf(Ledger{}))
+	require.NoError(t, err)
+	require.True(t, info.Fields[0].UnpackedList)
+	assert.Nil(t, info.EntryType)
+
+	minfo, err := cdc.getTypeInfoWlock(info.Fields[0].Type)
+	require.NoError(t, err)
+	require.NotNil(t, minfo.EntryType)
+	assert.Equal(t, 2, minfo.EntryType.NumField())
+	assert.Equal(t, reflect.TypeOf(""), minfo.EntryType.Field(0).Type)
+	assert.Equal(t, reflect.TypeOf(int64(0)), minfo.EntryType.Field(1).Type)
+
+	kinfo, einfo, err := cdc.getMapEntryTypeInfo(minfo)
+	require.NoError(t, err)
+	assert.Equal(t, reflect.TypeOf(""), kinfo.Type)
+	assert.Equal(t, minfo.EntryType, einfo.Type)
+	assert.Len(t, einfo.Fields, 2)
+
+	// Float keys are unsupported.
+	minfo, err = cdc.getTypeInfoWlock(reflect.TypeOf(map[float64]string{}))
+	require.NoError(t, err)
+	_, _, err = cdc.getMapEntryTypeInfo(minfo)
+	assert.Error(t, err)
+}
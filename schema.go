@@ -0,0 +1,186 @@
+package amino
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//----------------------------------------
+// Schema comparison
+
+type SchemaChangeKind uint8
+
+const (
+	SchemaTypeAdded        SchemaChangeKind = iota // Non-breaking.
+	SchemaTypeRemoved                              // Breaking.
+	SchemaFieldAdded                               // Non-breaking.
+	SchemaFieldRemoved                             // Breaking.
+	SchemaFieldNumChanged                          // Breaking.
+	SchemaFieldTypeChanged                         // Breaking.
+)
+
+func (kind SchemaChangeKind) String() string {
+	switch kind {
+	case SchemaTypeAdded:
+		return "type added"
+	case SchemaTypeRemoved:
+		return "type removed"
+	case SchemaFieldAdded:
+		return "field added"
+	case SchemaFieldRemoved:
+		return "field removed"
+	case SchemaFieldNumChanged:
+		return "field number changed"
+	case SchemaFieldTypeChanged:
+		return "field type changed"
+	default:
+		return fmt.Sprintf("SchemaChangeKind(%d)", uint8(kind))
+	}
+}
+
+// SchemaChange describes a difference between two codecs, see CompareSchemas.
+type SchemaChange struct {
+	Kind      SchemaChangeKind
+	TypeName  string // Registered name of the concrete type.
+	FieldName string // Empty for SchemaTypeAdded and SchemaTypeRemoved.
+	Old       string // The old field number or type, if changed.
+	New       string // The new field number or type, if changed.
+}
+
+// Breaking returns true if the change breaks wire compatibility.
+func (sc SchemaChange) Breaking() bool {
+	return sc.Kind != SchemaTypeAdded && sc.Kind != SchemaFieldAdded
+}
+
+func (sc SchemaChange) String() string {
+	s := fmt.Sprintf("%v: %v", sc.TypeName, sc.Kind)
+	if sc.FieldName != "" {
+		s = fmt.Sprintf("%v.%v: %v", sc.TypeName, sc.FieldName, sc.Kind)
+	}
+	if sc.Old != "" || sc.New != "" {
+		s += fmt.Sprintf(" (%v -> %v)", sc.Old, sc.New)
+	}
+	return s
+}
+
+// CompareSchemas compares the registered concrete types of cdc (the old
+// schema) with those of other (the new schema), matching types by their
+// registered names and fields by their Go names.  Only the fields of
+// registered types are compared; the fields of nested unregistered structs
+// are not.  Changes are returned in registration order.
+func (cdc *Codec) CompareSchemas(other *Codec) (changes []SchemaChange) {
+	oldInfos := cdc.getConcreteInfosRlock()
+	newInfos := other.getConcreteInfosRlock()
+
+	var newByName = make(map[string]*TypeInfo, len(newInfos))
+	for _, info := range newInfos {
+		newByName[info.Name] = info
+	}
+	var oldByName = make(map[string]*TypeInfo, len(oldInfos))
+	for _, oinfo := range oldInfos {
+		oldByName[oinfo.Name] = oinfo
+		ninfo, ok := newByName[oinfo.Name]
+		if !ok {
+			changes = append(changes, SchemaChange{Kind: SchemaTypeRemoved, TypeName: oinfo.Name})
+			continue
+		}
+		changes = append(changes, compareTypeSchemas(
+			oinfo.Name, cdc.schemaTypeInfo(oinfo), other.schemaTypeInfo(ninfo))...)
+	}
+	for _, ninfo := range newInfos {
+		if _, ok := oldByName[ninfo.Name]; !ok {
+			changes = append(changes, SchemaChange{Kind: SchemaTypeAdded, TypeName: ninfo.Name})
+		}
+	}
+	return changes
+}
+
+func compareTypeSchemas(name string, oinfo, ninfo *TypeInfo) (changes []SchemaChange) {
+	ostr, nstr := schemaTypeString(oinfo.Type, FieldOptions{}), schemaTypeString(ninfo.Type, FieldOptions{})
+	if ostr != nstr {
+		return []SchemaChange{{Kind: SchemaFieldTypeChanged, TypeName: name, Old: ostr, New: nstr}}
+	}
+	if oinfo.Type.Kind() != reflect.Struct || oinfo.Type == timeType {
+		return nil
+	}
+
+	var newFields = make(map[string]FieldInfo, len(ninfo.Fields))
+	for _, field := range ninfo.Fields {
+		newFields[field.Name] = field
+	}
+	var oldFields = make(map[string]FieldInfo, len(oinfo.Fields))
+	for _, ofield := range oinfo.Fields {
+		oldFields[ofield.Name] = ofield
+		nfield, ok := newFields[ofield.Name]
+		if !ok {
+			changes = append(changes, SchemaChange{Kind: SchemaFieldRemoved, TypeName: name, FieldName: ofield.Name})
+			continue
+		}
+		if ofield.BinFieldNum != nfield.BinFieldNum {
+			changes = append(changes, SchemaChange{Kind: SchemaFieldNumChanged, TypeName: name, FieldName: ofield.Name,
+				Old: fmt.Sprint(ofield.BinFieldNum), New: fmt.Sprint(nfield.BinFieldNum)})
+		}
+		ostr, nstr := schemaTypeString(ofield.Type, ofield.FieldOptions), schemaTypeString(nfield.Type, nfield.FieldOptions)
+		if ostr != nstr {
+			changes = append(changes, SchemaChange{Kind: SchemaFieldTypeChanged, TypeName: name, FieldName: ofield.Name,
+				Old: ostr, New: nstr})
+		}
+	}
+	for _, nfield := range ninfo.Fields {
+		if _, ok := oldFields[nfield.Name]; !ok {
+			changes = append(changes, SchemaChange{Kind: SchemaFieldAdded, TypeName: name, FieldName: nfield.Name})
+		}
+	}
+	return changes
+}
+
+func (cdc *Codec) getConcreteInfosRlock() []*TypeInfo {
+	cdc.mtx.RLock()
+	defer cdc.mtx.RUnlock()
+
+	return append([]*TypeInfo(nil), cdc.concreteInfos...)
+}
+
+// Returns the info of the type which is actually encoded for info,
+// i.e. of the repr type for amino marshalers.
+func (cdc *Codec) schemaTypeInfo(info *TypeInfo) *TypeInfo {
+	if !info.IsAminoMarshaler {
+		return info
+	}
+	rinfo, err := cdc.getTypeInfoWlock(info.AminoMarshalReprType)
+	if err != nil {
+		panic(err) // should not happen, already parsed on registration.
+	}
+	return rinfo
+}
+
+// Describes how a field of type rt is encoded, independent of Go type names.
+func schemaTypeString(rt reflect.Type, fopts FieldOptions) string {
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	switch {
+	case rt == timeType && fopts.UnixMillis:
+		return "time unixmillis"
+	case rt == timeType && fopts.DateOnly:
+		return "time dateonly"
+	case rt == timeType:
+		return "time"
+	case rt.Kind() == reflect.Array && fopts.Sparse:
+		return fmt.Sprintf("sparse [%v]%v", rt.Len(), schemaTypeString(rt.Elem(), fopts))
+	case rt.Kind() == reflect.Array:
+		return fmt.Sprintf("[%v]%v", rt.Len(), schemaTypeString(rt.Elem(), fopts))
+	case fopts.Gzip && isGzipKind(rt):
+		return schemaTypeString(rt, FieldOptions{}) + " gzip"
+	case rt.Kind() == reflect.Slice:
+		return "[]" + schemaTypeString(rt.Elem(), fopts)
+	case rt.Kind() == reflect.Map:
+		return fmt.Sprintf("map[%v]%v", schemaTypeString(rt.Key(), fopts), schemaTypeString(rt.Elem(), fopts))
+	case fopts.BinFixed64 && (rt.Kind() == reflect.Int64 || rt.Kind() == reflect.Uint64):
+		return rt.Kind().String() + " fixed64"
+	case fopts.BinFixed32 && (rt.Kind() == reflect.Int32 || rt.Kind() == reflect.Uint32):
+		return rt.Kind().String() + " fixed32"
+	default:
+		return rt.Kind().String()
+	}
+}
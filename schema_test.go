@@ -0,0 +1,56 @@
+package amino_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+type accountV1 struct {
+	Name    string
+	Nonce   uint64
+	Balance int64
+}
+
+type accountV2 struct {
+	Name     string
+	Balance  int64
+	Sequence uint64
+}
+
+type memo string
+
+func TestCompareSchemas(t *testing.T) {
+	oldCdc := amino.NewCodec()
+	oldCdc.RegisterConcrete(accountV1{}, "test/Account", nil)
+	oldCdc.RegisterConcrete(memo(""), "test/Memo", nil)
+
+	newCdc := amino.NewCodec()
+	newCdc.RegisterConcrete(accountV2{}, "test/Account", nil)
+	newCdc.RegisterConcrete(accountV1{}, "test/OldAccount", nil)
+
+	changes := oldCdc.CompareSchemas(newCdc)
+	assert.Equal(t, []amino.SchemaChange{
+		{Kind: amino.SchemaFieldRemoved, TypeName: "test/Account", FieldName: "Nonce"},
+		{Kind: amino.SchemaFieldNumChanged, TypeName: "test/Account", FieldName: "Balance", Old: "3", New: "2"},
+		{Kind: amino.SchemaFieldAdded, TypeName: "test/Account", FieldName: "Sequence"},
+		{Kind: amino.SchemaTypeRemoved, TypeName: "test/Memo"},
+		{Kind: amino.SchemaTypeAdded, TypeName: "test/OldAccount"},
+	}, changes)
+
+	var breaking []string
+	for _, change := range changes {
+		if change.Breaking() {
+			breaking = append(breaking, change.String())
+		}
+	}
+	assert.Equal(t, []string{
+		"test/Account.Nonce: field removed",
+		"test/Account.Balance: field number changed (3 -> 2)",
+		"test/Memo: type removed",
+	}, breaking)
+
+	assert.Empty(t, oldCdc.CompareSchemas(oldCdc))
+}
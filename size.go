@@ -0,0 +1,474 @@
+package amino
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+//----------------------------------------
+// SizeBinary
+
+// SizeBinary returns the number of bytes MarshalBinaryBare would encode o
+// into, without encoding it.  Values with custom encodings (e.g. `gzip`
+// fields, field codecs or registered integer codecs) are still encoded to
+// measure them, but nothing else is.
+func (cdc *Codec) SizeBinary(o interface{}) (size int, err error) {
+	if cdc.recoversPanics() {
+		defer recoverToError(&err)
+	}
+	var rv, _, isNilPtr = derefPointers(reflect.ValueOf(o))
+	if isNilPtr {
+		return 0, errors.New("SizeBinary cannot size a nil pointer")
+	}
+	info, err := cdc.getTypeInfoWlock(rv.Type())
+	if err != nil {
+		return
+	}
+	// See marshalBinaryBare() for why non-struct values are wrapped.
+	if rv.Kind() != reflect.Struct && !isStructOrRepeatedStruct(info) && !isUnpackedList(info.Type, FieldOptions{}) {
+		typ3 := typeToTyp3(info, FieldOptions{})
+		size, err = cdc.sizeFieldIfNotEmpty(1, info, FieldOptions{}, rv, false, typ3 != Typ3ByteLength)
+	} else {
+		size, err = cdc.sizeReflectBinary(info, rv, FieldOptions{BinFieldNum: 1}, true)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if info.Registered {
+		size += PrefixBytesLen
+	}
+	if cdc.indexedAny {
+		size += indexedAnyTagLen // See marshalBinaryBare().
+	}
+	return size, nil
+}
+
+// Returns the size of the value as written by encodeReflectBinary().
+// CONTRACT: rv is not a pointer
+// CONTRACT: rv is valid.
+func (cdc *Codec) sizeReflectBinary(info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool) (n int, err error) {
+	if rv.Kind() == reflect.Ptr {
+		panic(internalError("not allowed to be called with a reflect.Ptr"))
+	}
+
+	// Size the repr instead, see toReprObject().
+	if info.IsAminoMarshaler {
+		var rrv reflect.Value
+		var rinfo *TypeInfo
+		rrv, err = toReprObject(rv)
+		if err != nil {
+			return
+		}
+		rinfo, err = cdc.getTypeInfoWlock(info.AminoMarshalReprType)
+		if err != nil {
+			return
+		}
+		return cdc.sizeReflectBinary(rinfo, rrv, fopts, bare)
+	}
+
+	if info.Raw {
+		n = rv.Field(0).Len()
+		if !bare {
+			n += UvarintSize(uint64(n))
+		}
+		return
+	}
+
+	// Custom encodings are measured by encoding them.
+	if info.Type == jsonNumberType || info.IsBinaryMarshaler ||
+		(info.Type == timeType && (fopts.UnixMillis || fopts.DateOnly)) ||
+		(fopts.Gzip && isGzipKind(info.Type)) ||
+		usesIntCodec(info, fopts) {
+		return cdc.sizeByEncoding(info, rv, fopts, bare)
+	}
+
+	switch info.Type.Kind() {
+
+	//----------------------------------------
+	// Complex
+
+	case reflect.Interface:
+		return cdc.sizeReflectBinaryInterface(info, rv, fopts, bare)
+
+	case reflect.Array:
+		if info.Type.Elem().Kind() == reflect.Uint8 && !fopts.Sparse {
+			n = info.Type.Len()
+			return UvarintSize(uint64(n)) + n, nil
+		}
+		if fopts.Sparse || isMultidimensionalList(info.Type) {
+			return cdc.sizeByEncoding(info, rv, fopts, bare)
+		}
+		return cdc.sizeReflectBinaryList(info, rv, fopts, bare)
+
+	case reflect.Map:
+		return cdc.sizeReflectBinaryMap(info, rv, fopts, bare)
+
+	case reflect.Slice:
+		if info.Type.Elem().Kind() == reflect.Uint8 {
+			n = rv.Len()
+			return UvarintSize(uint64(n)) + n, nil
+		}
+		if isMultidimensionalList(info.Type) {
+			return cdc.sizeByEncoding(info, rv, fopts, bare)
+		}
+		return cdc.sizeReflectBinaryList(info, rv, fopts, bare)
+
+	case reflect.Struct:
+		return cdc.sizeReflectBinaryStruct(info, rv, fopts, bare)
+
+	//----------------------------------------
+	// Integers
+
+	case reflect.Int64:
+		if fopts.BinFixed64 {
+			return 8, nil
+		}
+		return UvarintSize(uint64(rv.Int())), nil
+
+	case reflect.Int32:
+		if fopts.BinFixed32 {
+			return 4, nil
+		}
+		return UvarintSize(uint64(rv.Int())), nil
+
+	case reflect.Int16, reflect.Int8:
+		return VarintSize(rv.Int()), nil
+
+	case reflect.Int:
+		return UvarintSize(uint64(rv.Int())), nil
+
+	case reflect.Uint64:
+		if fopts.BinFixed64 {
+			return 8, nil
+		}
+		return UvarintSize(rv.Uint()), nil
+
+	case reflect.Uint32:
+		if fopts.BinFixed32 {
+			return 4, nil
+		}
+		return UvarintSize(rv.Uint()), nil
+
+	case reflect.Uint16, reflect.Uint8, reflect.Uint:
+		return UvarintSize(rv.Uint()), nil
+
+	//----------------------------------------
+	// Misc
+
+	case reflect.Bool:
+		return 1, nil
+
+	case reflect.Float64, reflect.Float32:
+		if !fopts.Unsafe {
+			return 0, errors.New("amino float* support requires `amino:\"unsafe\"`")
+		}
+		if info.Type.Kind() == reflect.Float32 {
+			return 4, nil
+		}
+		return 8, nil
+
+	case reflect.String:
+		n = rv.Len()
+		return UvarintSize(uint64(n)) + n, nil
+
+	default:
+		panic(fmt.Sprintf("unsupported type %v", info.Type.Kind()))
+	}
+}
+
+// Returns the size of the value encoded by encodeReflectBinary(), by
+// counting the bytes written.
+func (cdc *Codec) sizeByEncoding(info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool) (n int, err error) {
+	var cw countWriter
+	err = cdc.encodeReflectBinary(&cw, info, rv, fopts, bare, encodeOptions{})
+	return int(cw.n), err
+}
+
+func (cdc *Codec) sizeReflectBinaryInterface(iinfo *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool) (n int, err error) {
+	if rv.IsNil() {
+		return 1, nil
+	}
+	var crv, _, isNilPtr = derefPointers(rv.Elem())
+	if isNilPtr {
+		// Let the encoder complain.
+		return cdc.sizeByEncoding(iinfo, rv, fopts, bare)
+	}
+	var crt = crv.Type()
+	var cinfo *TypeInfo
+	cinfo, err = cdc.getTypeInfoWlock(crt)
+	if err != nil {
+		return
+	}
+	if !cinfo.Registered {
+		var ok bool
+		if crv, cinfo, ok = cdc.toStdError(iinfo, rv); !ok {
+			return 0, ErrUnregisteredConcrete{crt}
+		}
+	}
+
+	uinfo, implied := cdc.getUniqueImplementerWlock(iinfo)
+	implied = implied && uinfo == cinfo
+	if implied {
+		// Nothing is written.
+	} else if cdc.indexedAny {
+		n += cdc.indexedAnyPrefixSize(cinfo)
+	} else {
+		if iinfo.AlwaysDisambiguate || len(iinfo.Implementers[cinfo.Prefix]) > 1 {
+			n += 1 + DisambBytesLen
+		}
+		n += PrefixBytesLen
+	}
+	// See encodeReflectBinaryInterface() for the field key.
+	isKnownType := (cinfo.Type.Kind() != reflect.Map) && (cinfo.Type.Kind() != reflect.Func)
+	if !isStructOrRepeatedStruct(cinfo) &&
+		!isPointerToStructOrToRepeatedStruct(crv, cinfo.Type) &&
+		isKnownType &&
+		fopts.BinFieldNum == 1 {
+		n += fieldKeySize(1, typeToTyp3(cinfo, FieldOptions{}))
+	}
+	var cn int
+	cn, err = cdc.sizeReflectBinary(cinfo, crv, fopts, true)
+	if err != nil {
+		return
+	}
+	if implied && cn == 0 {
+		cn = 1 // See encodeReflectBinaryInterface().
+	}
+	return prefixedSize(n+cn, bare), nil
+}
+
+func (cdc *Codec) sizeReflectBinaryList(info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool) (n int, err error) {
+	ert := info.Type.Elem()
+	einfo, err := cdc.getTypeInfoWlock(ert)
+	if err != nil {
+		return
+	}
+	var en int
+	typ3 := kindToTyp3(einfo.Type, fopts)
+	if typ3 != Typ3ByteLength {
+		// Packed form.
+		for i := 0; i < rv.Len(); i++ {
+			var erv, _, _ = derefPointersZero(rv.Index(i))
+			en, err = cdc.sizeReflectBinary(einfo, erv, fopts, false)
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		return prefixedSize(n, bare), nil
+	}
+
+	// Unpacked form, see encodeReflectBinaryList().
+	isErtStructPointer := ert.Kind() == reflect.Ptr && einfo.Type.Kind() == reflect.Struct
+	keySize := fieldKeySize(fopts.BinFieldNum, Typ3ByteLength)
+	efopts := fopts
+	efopts.BinFieldNum = 1
+	for i := 0; i < rv.Len(); i++ {
+		var erv, isDefault = isDefaultValue(rv.Index(i))
+		if isDefault {
+			if isErtStructPointer && fopts.EmptyElements {
+				return 0, errors.New("nil struct pointers not supported when empty_elements field tag is set")
+			}
+			if ert.Kind() == reflect.Interface && !fopts.EmptyElements {
+				return 0, fmt.Errorf("nil element %v of %v not supported unless empty_elements field tag is set",
+					i, info.Type)
+			}
+			en = 1
+		} else {
+			en, err = cdc.sizeReflectBinary(einfo, erv, efopts, false)
+			if err != nil && ert.Kind() == reflect.Interface {
+				return 0, fmt.Errorf("cannot encode element %v of %v: %v", i, info.Type, err)
+			} else if err != nil {
+				return
+			}
+		}
+		n += keySize + en
+	}
+	return prefixedSize(n, bare), nil
+}
+
+// The entries are sized in any order, since their order doesn't change the
+// size, see encodeReflectBinaryMap().
+func (cdc *Codec) sizeReflectBinaryMap(info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool) (n int, err error) {
+	_, einfo, err := cdc.getMapEntryTypeInfo(info)
+	if err != nil {
+		return
+	}
+	keySize := fieldKeySize(fopts.BinFieldNum, Typ3ByteLength)
+	var erv = reflect.New(einfo.Type).Elem()
+	for _, krv := range rv.MapKeys() {
+		erv.Field(0).Set(krv)
+		erv.Field(1).Set(rv.MapIndex(krv))
+		var en int
+		en, err = cdc.sizeReflectBinary(einfo, erv, FieldOptions{BinFieldNum: 1}, false)
+		if err != nil {
+			return
+		}
+		n += keySize + en
+	}
+	return prefixedSize(n, bare), nil
+}
+
+func (cdc *Codec) sizeReflectBinaryStruct(info *TypeInfo, rv reflect.Value,
+	fopts FieldOptions, bare bool) (n int, err error) {
+	switch {
+	case info.Type == timeType:
+		return cdc.sizeByEncoding(info, rv, fopts, bare)
+	case info.FixedWidth && len(info.VirtualFields) == 0 && !cdc.alwaysWriteEmpty:
+		n = sizeReflectBinaryFixedWidthStruct(info, rv)
+	default:
+		var fn int
+		for _, field := range info.Fields {
+			fn, err = cdc.sizeReflectBinaryStructField(field, rv, fopts)
+			if err != nil {
+				return
+			}
+			n += fn
+		}
+		for _, vfield := range info.VirtualFields {
+			fn, err = cdc.sizeReflectBinaryVirtualField(vfield, rv)
+			if err != nil {
+				return
+			}
+			n += fn
+		}
+	}
+	return prefixedSize(n, bare), nil
+}
+
+// Returns the size of the field as written by encodeReflectBinaryStructField().
+func (cdc *Codec) sizeReflectBinaryStructField(field FieldInfo, rv reflect.Value,
+	fopts FieldOptions) (n int, err error) {
+	if field.FieldCodec != nil {
+		buf := new(bytes.Buffer)
+		err = cdc.encodeFieldCodecField(buf, field, field.valueOf(rv))
+		return buf.Len(), err
+	}
+	ftype, frv, err := cdc.structFieldValue(field, rv, encodeOptions{})
+	if err != nil {
+		return
+	}
+	finfo, err := cdc.getTypeInfoWlock(ftype)
+	if err != nil {
+		return
+	}
+	var frvIsPtr = frv.Kind() == reflect.Ptr
+	var dfrv, omit, fieldWriteEmpty = cdc.omitsStructField(field, finfo, frv)
+	if omit {
+		return 0, nil
+	}
+	if field.UnpackedList && dfrv.Kind() == reflect.Map {
+		return cdc.sizeReflectBinaryMap(finfo, dfrv, field.FieldOptions, true)
+	} else if field.UnpackedList {
+		return cdc.sizeReflectBinaryList(finfo, dfrv, field.FieldOptions, true)
+	}
+	writeEmpty := fieldWriteEmpty || frvIsPtr
+	return cdc.sizeFieldIfNotEmpty(field.BinFieldNum, finfo, field.FieldOptions, dfrv, writeEmpty, false)
+}
+
+// Returns the size of the field as written by encodeReflectBinaryVirtualField().
+func (cdc *Codec) sizeReflectBinaryVirtualField(vfield VirtualFieldInfo, rv reflect.Value) (n int, err error) {
+	var v = vfield.Getter(rv)
+	if v == nil {
+		return
+	}
+	var vrv, isDefault = isDefaultValue(reflect.ValueOf(v))
+	if isDefault {
+		return
+	}
+	vinfo, err := cdc.getTypeInfoWlock(vrv.Type())
+	if err != nil {
+		return
+	}
+	if isUnpackedList(vinfo.Type, vfield.FieldOptions) && vrv.Kind() == reflect.Map {
+		return cdc.sizeReflectBinaryMap(vinfo, vrv, vfield.FieldOptions, true)
+	} else if isUnpackedList(vinfo.Type, vfield.FieldOptions) {
+		return cdc.sizeReflectBinaryList(vinfo, vrv, vfield.FieldOptions, true)
+	}
+	return cdc.sizeFieldIfNotEmpty(vfield.BinFieldNum, vinfo, vfield.FieldOptions, vrv, false, false)
+}
+
+// Returns the size of the field as written by writeFieldIfNotEmpty().
+func (cdc *Codec) sizeFieldIfNotEmpty(fieldNum uint32, finfo *TypeInfo, fieldOpts FieldOptions,
+	derefedVal reflect.Value, isWriteEmpty bool, bare bool) (n int, err error) {
+	n, err = cdc.sizeReflectBinary(finfo, derefedVal, fieldOpts, bare)
+	if err != nil {
+		return
+	}
+	if !isWriteEmpty && usesIntCodec(finfo, fieldOpts) {
+		// The value is length-prefixed, so its bytes don't tell.
+		if _, isDefault := isDefaultValue(derefedVal); isDefault {
+			return 0, nil
+		}
+	} else if !isWriteEmpty && n == 1 {
+		// The field is omitted if its value is written as 0x00, so encode
+		// the (single byte) value to tell.
+		buf := new(bytes.Buffer)
+		err = cdc.encodeReflectBinary(buf, finfo, derefedVal, fieldOpts, bare, encodeOptions{})
+		if err != nil {
+			return
+		}
+		if buf.Bytes()[0] == 0x00 {
+			return 0, nil
+		}
+	}
+	return fieldKeySize(fieldNum, typeToTyp3(finfo, fieldOpts)) + n, nil
+}
+
+// Returns the size of the fields as written by
+// encodeReflectBinaryFixedWidthStruct().
+func sizeReflectBinaryFixedWidthStruct(info *TypeInfo, rv reflect.Value) (n int) {
+	for _, field := range info.Fields {
+		frv := field.valueOf(rv)
+		switch field.Type.Kind() {
+		case reflect.Int64, reflect.Int32:
+			if frv.Int() != 0 || field.WriteEmpty {
+				n += len(field.binKey) + int(field.Type.Size())
+			}
+		case reflect.Uint64, reflect.Uint32:
+			if frv.Uint() != 0 || field.WriteEmpty {
+				n += len(field.binKey) + int(field.Type.Size())
+			}
+		case reflect.Float64, reflect.Float32:
+			n += len(field.binKey) + int(field.Type.Size())
+		case reflect.Bool:
+			if frv.Bool() || field.WriteEmpty {
+				n += len(field.binKey) + 1
+			}
+		default:
+			panic(internalError("should not happen"))
+		}
+	}
+	return
+}
+
+//----------------------------------------
+// Misc.
+
+// Returns true if rt is a list of lists of anything but bytes, which the
+// encoder rejects.
+func isMultidimensionalList(rt reflect.Type) bool {
+	ert := rt.Elem()
+	return (ert.Kind() == reflect.Slice || ert.Kind() == reflect.Array) &&
+		ert.Elem().Kind() != reflect.Uint8
+}
+
+// Returns the size of the field key written by encodeFieldNumberAndTyp3().
+func fieldKeySize(num uint32, typ Typ3) int {
+	return UvarintSize(uint64(num)<<3 | uint64(typ))
+}
+
+// Returns the size of n bytes with a byte-length prefix, unless bare.
+func prefixedSize(n int, bare bool) int {
+	if bare {
+		return n
+	}
+	return UvarintSize(uint64(n)) + n
+}
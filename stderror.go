@@ -0,0 +1,56 @@
+package amino
+
+import (
+	"reflect"
+)
+
+//----------------------------------------
+// Error values
+
+// Error is the concrete type which error values are encoded as, see
+// RegisterStdError.
+type Error struct {
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+// The registered name of Error.
+const stdErrorName = "amino/Error"
+
+var stdErrorType = reflect.TypeOf(Error{})
+
+// RegisterStdError registers the error interface, along with Error as a
+// concrete type named "amino/Error".  Values of interface type error whose
+// concrete type isn't registered are then encoded as an Error with the same
+// message, and so decode as an Error.
+func (cdc *Codec) RegisterStdError() {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.RegisterInterface((*error)(nil), nil)
+	cdc.RegisterConcrete(Error{}, stdErrorName, nil)
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		cdc.stdErrors = true
+	}()
+}
+
+// Returns the Error to encode in place of the value of the interface rv,
+// if its concrete type isn't registered, see RegisterStdError().
+func (cdc *Codec) toStdError(iinfo *TypeInfo, rv reflect.Value) (crv reflect.Value, cinfo *TypeInfo, ok bool) {
+	if !cdc.stdErrors || iinfo.Type != errorType {
+		return
+	}
+	cinfo, err := cdc.getTypeInfoWlock(stdErrorType)
+	if err != nil {
+		return
+	}
+	crv = reflect.ValueOf(Error{Message: rv.Interface().(error).Error()})
+	return crv, cinfo, true
+}
@@ -0,0 +1,225 @@
+package amino
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+//----------------------------------------
+// Unions
+
+// unionInfo describes a struct registered with RegisterUnion.  It is set
+// on both the kind and the payload FieldInfo.
+type unionInfo struct {
+	kindIndex    int
+	kindJSONName string
+	payloadIndex int
+	types        map[string]reflect.Type // By kind.
+	kinds        map[reflect.Type]string // By payload type.
+}
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// RegisterUnion makes the interface field named payloadField of the struct
+// type rt a discriminated union, whose concrete type is determined by the
+// string field named kindField, as given by mapping.  The payload is then
+// encoded as its bare concrete value, without prefix bytes (or a JSON type
+// name), and the kind is encoded in its place: when encoding, the kind
+// field is set from the mapping of the payload's type (or to "" if nil),
+// and when decoding, the payload is decoded into the type mapped from the
+// kind.  The mapped types must be structs, or pointers to structs, which
+// can be assigned to the payload field.
+func (cdc *Codec) RegisterUnion(rt reflect.Type, kindField, payloadField string,
+	mapping map[string]reflect.Type) {
+	if cdc.recoversPanics() {
+		defer cdc.recoverToRegistrationErr()
+	}
+	cdc.assertNotSealed()
+
+	info, err := cdc.getTypeInfoWlock(rt)
+	if err != nil {
+		panic(err)
+	}
+	if info.Type.Kind() != reflect.Struct || info.Type == timeType {
+		panic(fmt.Sprintf("RegisterUnion expects a struct, got %v", rt))
+	}
+	var u = &unionInfo{
+		kindIndex:    -1,
+		payloadIndex: -1,
+		types:        make(map[string]reflect.Type, len(mapping)),
+		kinds:        make(map[reflect.Type]string, len(mapping)),
+	}
+	var kindPos, payloadPos int
+	for i, field := range info.Fields {
+		if field.indexPath != nil && (field.Name == kindField || field.Name == payloadField) {
+			panic(fmt.Sprintf("union field %v of %v cannot be promoted from an embedded struct", field.Name, rt))
+		}
+		switch field.Name {
+		case kindField:
+			if field.Type.Kind() != reflect.String {
+				panic(fmt.Sprintf("union kind field %v of %v must be a string, got %v", kindField, rt, field.Type))
+			}
+			u.kindIndex, u.kindJSONName, kindPos = field.Index, field.JSONName, i
+		case payloadField:
+			if field.Type.Kind() != reflect.Interface {
+				panic(fmt.Sprintf("union payload field %v of %v must be an interface, got %v",
+					payloadField, rt, field.Type))
+			}
+			u.payloadIndex, payloadPos = field.Index, i
+			for kind, prt := range mapping {
+				drt := prt
+				for drt.Kind() == reflect.Ptr {
+					drt = drt.Elem()
+				}
+				if kind == "" || drt.Kind() != reflect.Struct || drt == timeType || !prt.AssignableTo(field.Type) {
+					panic(fmt.Sprintf("invalid union kind %q of type %v for field %v of %v", kind, prt, payloadField, rt))
+				}
+				if _, ok := u.kinds[prt]; ok {
+					panic(fmt.Sprintf("union type %v is mapped more than once", prt))
+				}
+				u.types[kind] = prt
+				u.kinds[prt] = kind
+			}
+		}
+	}
+	if u.kindIndex < 0 || u.payloadIndex < 0 {
+		panic(fmt.Sprintf("%v has no field %v or %v", rt, kindField, payloadField))
+	}
+
+	func() {
+		cdc.mtx.Lock()
+		defer cdc.mtx.Unlock()
+
+		info.Fields[kindPos].union = u
+		info.Fields[payloadPos].union = u
+	}()
+}
+
+// Returns true iff field is the payload of a union.
+func (field FieldInfo) isUnionPayload() bool {
+	return field.union != nil && field.Index == field.union.payloadIndex
+}
+
+// Returns the kind of the payload prv, an interface value.
+func (u *unionInfo) kindOf(prv reflect.Value) (string, error) {
+	if prv.IsNil() {
+		return "", nil
+	}
+	kind, ok := u.kinds[prv.Elem().Type()]
+	if !ok {
+		return "", fmt.Errorf("union payload type %v has no kind", prv.Elem().Type())
+	}
+	return kind, nil
+}
+
+// Returns the value of the union field of the struct rv to encode in its
+// place, i.e. the kind, or the encoded payload.
+func (cdc *Codec) unionFieldValue(field FieldInfo, rv reflect.Value,
+	eopts encodeOptions) (ftype reflect.Type, frv reflect.Value, err error) {
+	var u = field.union
+	var prv = rv.Field(u.payloadIndex)
+	if field.Index == u.kindIndex {
+		var kind string
+		kind, err = u.kindOf(prv)
+		return field.Type, reflect.ValueOf(kind).Convert(field.Type), err
+	}
+	var buf = new(bytes.Buffer)
+	if !prv.IsNil() {
+		var drv, _, isNilPtr = derefPointers(prv.Elem())
+		if !isNilPtr {
+			var cinfo *TypeInfo
+			cinfo, err = cdc.getTypeInfoWlock(drv.Type())
+			if err != nil {
+				return
+			}
+			err = cdc.encodeReflectBinary(buf, cinfo, drv, FieldOptions{BinFieldNum: 1}, true, eopts)
+		}
+	}
+	return bytesType, reflect.ValueOf(buf.Bytes()), err
+}
+
+// Decodes the union payload bz of the struct rv, after its kind was decoded.
+func (cdc *Codec) decodeUnionPayload(field FieldInfo, rv reflect.Value, bz []byte, dopts decodeOptions) (err error) {
+	var u = field.union
+	var kind = rv.Field(u.kindIndex).String()
+	if kind == "" {
+		if len(bz) > 0 {
+			err = fmt.Errorf("union field %v has a payload but no kind", field.Name)
+		}
+		return
+	}
+	prt, ok := u.types[kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %q of union field %v", kind, field.Name)
+	}
+	cinfo, err := cdc.getTypeInfoWlock(prt)
+	if err != nil {
+		return
+	}
+	var prv = cdc.newValue(prt).Elem()
+	_, err = cdc.decodeReflectBinary(bz, cinfo, prv, FieldOptions{BinFieldNum: 1}, true, dopts)
+	if err != nil {
+		return
+	}
+	rv.Field(u.payloadIndex).Set(prv)
+	return
+}
+
+// Writes the union field of the struct rv like encodeReflectJSONStruct
+// would, i.e. the kind, or the payload without its type name.
+func (cdc *Codec) encodeUnionFieldJSON(w io.Writer, field FieldInfo, rv reflect.Value) (err error) {
+	var u = field.union
+	var prv = rv.Field(u.payloadIndex)
+	if field.Index == u.kindIndex {
+		var kind string
+		kind, err = u.kindOf(prv)
+		if err != nil {
+			return
+		}
+		return invokeStdlibJSONMarshal(w, kind)
+	}
+	if prv.IsNil() {
+		return writeStr(w, `null`)
+	}
+	var drv, _, isNilPtr = derefPointers(prv.Elem())
+	if isNilPtr {
+		return writeStr(w, `null`)
+	}
+	cinfo, err := cdc.getTypeInfoWlock(drv.Type())
+	if err != nil {
+		return
+	}
+	return cdc.encodeReflectJSON(w, cinfo, drv, FieldOptions{})
+}
+
+// Decodes the union payload written by encodeUnionFieldJSON into frv, given
+// the raw JSON fields of the struct.
+func (cdc *Codec) decodeUnionPayloadJSON(bz []byte, field FieldInfo, frv reflect.Value,
+	rawMap map[string]json.RawMessage, dopts decodeOptions) (err error) {
+	var u = field.union
+	var kind string
+	if kindBz := rawMap[u.kindJSONName]; len(kindBz) > 0 {
+		err = json.Unmarshal(kindBz, &kind)
+		if err != nil {
+			return
+		}
+	}
+	prt, ok := u.types[kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %q of union field %v", kind, field.Name)
+	}
+	cinfo, err := cdc.getTypeInfoWlock(prt)
+	if err != nil {
+		return
+	}
+	var prv = cdc.newValue(prt).Elem()
+	err = cdc.decodeReflectJSON(bz, cinfo, prv, FieldOptions{}, dopts)
+	if err != nil {
+		return
+	}
+	frv.Set(prv)
+	return
+}
@@ -0,0 +1,11 @@
+package amino
+
+import (
+	"unsafe"
+)
+
+// Returns a string which shares its bytes with bz, so bz must not be
+// modified afterwards.  See SetZeroCopyStrings().
+func unsafeBytesToString(bz []byte) string {
+	return *(*string)(unsafe.Pointer(&bz))
+}
@@ -0,0 +1,70 @@
+package amino
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//----------------------------------------
+// Value cycles
+
+// Returns an error if values of the type of info contain themselves by value,
+// which would make encoding and size computation recurse forever.  Go rejects
+// such types, but they can still be declared through MarshalAmino(), e.g.
+// with a repr struct holding the type itself as a field.  The cycle is
+// followed through struct fields, non-empty arrays and repr types, but not
+// through pointers, slices, maps or interfaces, which may end it with nil or
+// empty values.  The error names each step of the cycle.
+func valueCycleError(info *TypeInfo) error {
+	if !info.IsAminoMarshaler {
+		return nil // Any cycle must pass through a repr type.
+	}
+	var rt = info.Type
+	var visited = make(map[reflect.Type]bool)
+	var path = []string{fmt.Sprintf("%v.MarshalAmino()", rt)}
+	var walk func(t reflect.Type) bool
+	walk = func(t reflect.Type) bool {
+		if t == rt {
+			return true
+		}
+		if visited[t] {
+			return false
+		}
+		visited[t] = true
+		if rm, ok := t.MethodByName("MarshalAmino"); ok {
+			path = append(path, fmt.Sprintf("%v.MarshalAmino()", t))
+			if walk(marshalAminoReprType(rm)) {
+				return true
+			}
+			path = path[:len(path)-1]
+			return false
+		}
+		if t == timeType || isBinaryMarshalerType(t) {
+			return false
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			for _, sf := range structFields(t) {
+				if sf.field.Tag.Get("json") == "-" {
+					continue
+				}
+				path = append(path, fmt.Sprintf("%v.%v", t, sf.field.Name))
+				if walk(sf.field.Type) {
+					return true
+				}
+				path = path[:len(path)-1]
+			}
+		case reflect.Array:
+			if t.Len() > 0 {
+				return walk(t.Elem())
+			}
+		}
+		return false
+	}
+	if !walk(info.AminoMarshalReprType) {
+		return nil
+	}
+	return fmt.Errorf("%v contains itself by value, through %v; use a pointer, slice or map to end the cycle",
+		rt, strings.Join(path, " -> "))
+}